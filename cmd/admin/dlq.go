@@ -0,0 +1,103 @@
+package main
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"Orion_Live/pkg/mq/dlq"
+	"Orion_Live/pkg/rabbitmq"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// runDLQ admin dlq <list|inspect|replay|purge> [flags]
+func runDLQ(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("用法: admin dlq list|inspect|replay|purge [-queue 队列名] [-n 条数] [-id 消息ID] [-yes]")
+	}
+	sub := args[0]
+	fs := flag.NewFlagSet("dlq "+sub, flag.ContinueOnError)
+	queue := fs.String("queue", "", "业务队列名，比如 "+message.QueueLike)
+	n := fs.Int("n", 10, "inspect查看/replay重放的最大条数")
+	id := fs.String("id", "", "replay时只重放这个MessageId的消息")
+	yes := fs.Bool("yes", false, "purge时必须显式确认")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if sub != "list" && !slices.Contains(message.Queues, *queue) {
+		return fmt.Errorf("-queue 必须是以下之一: %v", message.Queues)
+	}
+
+	// 连接时声明一遍拓扑，保证死信队列存在
+	retry := consumer.RetryPolicy{Delays: cfg.Consumer.RetryDelays, MaxAttempts: cfg.Consumer.MaxAttempts}
+	conn, err := rabbitmq.InitRabbitMQ(cfg.RabbitMQ, logger.Log, message.Topology(retry))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch sub {
+	case "list":
+		return dlqList(conn)
+	case "inspect":
+		return dlqInspect(conn, *queue, *n)
+	case "replay":
+		publisher := rabbitmq.NewPublisher(conn, 1, cfg.RabbitMQ.PublishTimeout)
+		replayed, err := dlq.Replay(context.Background(), conn, publisher, *queue, *n, *id)
+		fmt.Printf("已重放 %d 条消息到 %s\n", replayed, *queue)
+		return err
+	case "purge":
+		if !*yes {
+			return errors.New("purge会永久删除死信，确认请加 -yes")
+		}
+		purged, err := dlq.Purge(conn, *queue)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已从 %s 删除 %d 条消息\n", consumer.DeadLetterQueueName(*queue), purged)
+		return nil
+	}
+	return fmt.Errorf("未知的dlq子命令: %s", sub)
+}
+
+func dlqList(conn *rabbitmq.Connection) error {
+	stats, err := dlq.Stats(conn, message.Queues)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "QUEUE\tDLQ\tMESSAGES")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", s.Queue, s.DLQ, s.Messages)
+	}
+	return w.Flush()
+}
+
+func dlqInspect(conn *rabbitmq.Connection, queue string, n int) error {
+	msgs, err := dlq.Peek(conn, queue, n)
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		fmt.Printf("%s 为空\n", consumer.DeadLetterQueueName(queue))
+		return nil
+	}
+	for i, d := range msgs {
+		fmt.Printf("#%d message_id=%s attempts=%d\n", i+1, d.MessageId, consumer.Attempts(d))
+		if deadAt, ok := d.Headers[consumer.HeaderDeadAt].(time.Time); ok {
+			fmt.Printf("   dead_at: %s\n", deadAt.Format("2006-01-02 15:04:05"))
+		}
+		if lastErr, ok := d.Headers[consumer.HeaderLastError].(string); ok {
+			fmt.Printf("   error:   %s\n", lastErr)
+		}
+		fmt.Printf("   body:    %s\n", d.Body)
+	}
+	return nil
+}
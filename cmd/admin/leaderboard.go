@@ -0,0 +1,54 @@
+package main
+
+import (
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/mysql"
+	"Orion_Live/pkg/redis"
+	"errors"
+	"flag"
+	"fmt"
+
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// runLeaderboard admin leaderboard rebuild -room 直播间ID
+// Redis数据丢失或者榜单和送礼记录对不上时，按gift_records重建累计榜、每日榜、本场榜和最近几场的快照
+func runLeaderboard(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "rebuild" {
+		return errors.New("用法: admin leaderboard rebuild -room 直播间ID")
+	}
+	fs := flag.NewFlagSet("leaderboard rebuild", flag.ContinueOnError)
+	roomID := fs.Uint64("room", 0, "要重建的直播间ID")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *roomID == 0 {
+		return errors.New("rebuild 需要 -room")
+	}
+
+	db, err := mysql.InitMySQL(cfg.MySQL)
+	if err != nil {
+		return err
+	}
+	rdb, err := redis.InitRedis(cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	result, err := newLeaderboardService(cfg, db, rdb).Rebuild(*roomID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("直播间 %d 的贡献榜已重建: 累计榜 %d 人，%d 天的每日榜，%d 场直播，标记了 %d 次送礼\n",
+		*roomID, result.Contributors, result.Days, result.Sessions, result.MarkedGifts)
+	return nil
+}
+
+func newLeaderboardService(cfg *config.Config, db *gorm.DB, rdb *goredis.Client) service.LeaderboardService {
+	return service.NewLeaderboardService(repository.NewLiveRoomRepository(db, rdb), repository.NewGiftRepository(db),
+		repository.NewLeaderboardRepository(db, rdb), repository.NewUserRepository(db), cfg.Leaderboard)
+}
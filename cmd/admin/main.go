@@ -0,0 +1,61 @@
+package main
+
+import (
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// command 一个顶级子命令，比如 admin dlq ...
+type command struct {
+	usage string
+	run   func(cfg *config.Config, args []string) error
+}
+
+var commands = map[string]command{
+	"dlq":         {usage: "查看、重放、清空死信队列: dlq list|inspect|replay|purge", run: runDLQ},
+	"leaderboard": {usage: "按送礼记录重建直播间的贡献榜: leaderboard rebuild -room 直播间ID", run: runLeaderboard},
+	"reconcile":   {usage: "对账并修复Redis与MySQL中的数据: reconcile likes|golden [-dry-run] [-interval 10m]", run: runReconcile},
+	"wallet":      {usage: "充值、退还送礼、查询余额: wallet topup|refund|balance [-user 用户ID] [-amount 金币数] [-id 转账ID]", run: runWallet},
+}
+
+// 运维命令行工具：和server读取同一份配置，用法 go run ./cmd/admin <command> [subcommand] [flags]
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "未知命令: %s\n", os.Args[1])
+		printUsage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("配置加载失败: %v", err)
+	}
+	if err := logger.InitLogger(cfg.Log); err != nil {
+		log.Fatalf("日志初始化失败: %v", err)
+	}
+	if err := cmd.run(cfg, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "执行失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "用法: admin <command> [subcommand] [flags]")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"Orion_Live/internal/reconcile"
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/backoff"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mysql"
+	"Orion_Live/pkg/redis"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runReconcile admin reconcile <likes|golden> [flags]
+func runReconcile(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("用法: admin reconcile likes|golden [flags]")
+	}
+	switch args[0] {
+	case "likes":
+		return reconcileLikes(cfg, args[1:])
+	case "golden":
+		return reconcileGolden(cfg, args[1:])
+	}
+	return fmt.Errorf("未知的reconcile子命令: %s", args[0])
+}
+
+// reconcileLikes 以likes表为准修复videos.like_count和Redis中的点赞集合、点赞数
+// -interval大于0时作为常驻任务周期性执行，收到SIGINT/SIGTERM后在当前视频处理完后退出
+func reconcileLikes(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("reconcile likes", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只输出差异，不修复")
+	batch := fs.Int("batch", 100, "每批读取的视频数")
+	rate := fs.Int("rate", 200, "每秒最多检查的视频数，0不限速")
+	interval := fs.Duration("interval", 0, "大于0时每隔这么久执行一轮，否则只执行一次")
+	window := fs.Duration("in-flight-window", 10*time.Minute, "这段时间内还没被消费的点赞消息视为在途，对应视频本轮跳过")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := mysql.InitMySQL(cfg.MySQL)
+	if err != nil {
+		return err
+	}
+	rdb, err := redis.InitRedis(cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	reconciler := reconcile.NewLikeReconciler(
+		repository.NewVideoRepository(db, rdb),
+		repository.NewLikeRepository(db),
+		repository.NewOutboxRepository(db),
+		logger.Log,
+		reconcile.LikeOptions{BatchSize: *batch, Rate: *rate, DryRun: *dryRun, InFlightWindow: *window},
+	)
+	printDiff := func(d reconcile.LikeDiff) {
+		fmt.Printf("video=%d likes=%d column=%d redis_count=%d missing_in_redis=%v extra_in_redis=%v\n",
+			d.VideoID, d.Likes, d.Column, d.RedisCount, d.MissingInRedis, d.ExtraInRedis)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	for {
+		start := time.Now()
+		report, err := reconciler.Run(ctx, printDiff)
+		fmt.Printf("对账完成: scanned=%d skipped=%d inconsistent=%d repaired=%d dry_run=%t elapsed=%s\n",
+			report.Scanned, report.Skipped, report.Inconsistent, report.Repaired, *dryRun, time.Since(start).Round(time.Millisecond))
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		if err != nil {
+			if *interval <= 0 {
+				return err
+			}
+			// 常驻模式下单轮失败只记录，下一轮再试
+			logger.Log.WithError(err).Error("点赞对账失败")
+		}
+		if *interval <= 0 || !backoff.Sleep(ctx, *interval) {
+			return nil
+		}
+	}
+}
+
+// reconcileGolden 以comments表为准修复videos.golden_count，并为还在有效期内的视频重建席位哈希：
+// 有评论没席位的补上席位，没评论且不会再落库的席位归还并把预约票改为refunded
+func reconcileGolden(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("reconcile golden", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只输出差异，不修复")
+	batch := fs.Int("batch", 100, "每批读取的视频数")
+	rate := fs.Int("rate", 200, "每秒最多检查的视频数，0不限速")
+	interval := fs.Duration("interval", 0, "大于0时每隔这么久执行一轮，否则只执行一次")
+	window := fs.Duration("in-flight-window", 10*time.Minute, "这段时间内的黄金评论消息和pending预约票视为在途，不做处理")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := mysql.InitMySQL(cfg.MySQL)
+	if err != nil {
+		return err
+	}
+	rdb, err := redis.InitRedis(cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+
+	videoRepo := repository.NewVideoRepository(db, rdb)
+	ticketRepo := repository.NewGoldenTicketRepository(rdb)
+	reconciler := reconcile.NewGoldenReconciler(
+		videoRepo,
+		repository.NewCommentRepository(db),
+		ticketRepo,
+		repository.NewOutboxRepository(db),
+		service.NewGoldenRefundService(videoRepo, ticketRepo),
+		logger.Log,
+		reconcile.GoldenOptions{BatchSize: *batch, Rate: *rate, DryRun: *dryRun, InFlightWindow: *window, Window: cfg.Golden.Window},
+	)
+	printDiff := func(d reconcile.GoldenDiff) {
+		fmt.Printf("video=%d comments=%d column=%d seats_checked=%t seats=%d missing_seats=%v orphan_seats=%v\n",
+			d.VideoID, d.Comments, d.Column, d.SeatsChecked, d.Seats, d.MissingSeats, d.OrphanSeats)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	for {
+		start := time.Now()
+		report, err := reconciler.Run(ctx, printDiff)
+		fmt.Printf("对账完成: scanned=%d skipped=%d inconsistent=%d repaired=%d dry_run=%t elapsed=%s\n",
+			report.Scanned, report.Skipped, report.Inconsistent, report.Repaired, *dryRun, time.Since(start).Round(time.Millisecond))
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		if err != nil {
+			if *interval <= 0 {
+				return err
+			}
+			logger.Log.WithError(err).Error("黄金评论对账失败")
+		}
+		if *interval <= 0 || !backoff.Sleep(ctx, *interval) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/mysql"
+	"Orion_Live/pkg/redis"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// runWallet admin wallet <topup|refund|balance> [flags]
+// 还没有接入支付，充值由运营通过这个命令完成；退款用于处理投诉，把一次送礼的金币从主播退回给观众，并从贡献榜中减去
+func runWallet(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("用法: admin wallet topup -user 用户ID -amount 金币数 [-id 转账ID] | refund -id 送礼的转账ID | balance -user 用户ID")
+	}
+	sub := args[0]
+	fs := flag.NewFlagSet("wallet "+sub, flag.ContinueOnError)
+	userID := fs.Uint64("user", 0, "用户ID")
+	amount := fs.Int64("amount", 0, "充值的金币数")
+	id := fs.String("id", "", "topup时是幂等的转账ID（比如支付单号），不填则生成一个；refund时是送礼的转账ID")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	db, err := mysql.InitMySQL(cfg.MySQL)
+	if err != nil {
+		return err
+	}
+	rdb, err := redis.InitRedis(cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+	walletRepo := repository.NewWalletRepository(db, rdb)
+	uow := data.NewUnitOfWork(db, repository.NewVideoRepository(db, rdb), repository.NewCommentRepository(db))
+	walletService := service.NewWalletService(walletRepo, repository.NewGiftRepository(db), uow)
+
+	switch sub {
+	case "topup":
+		if *userID == 0 || *amount <= 0 {
+			return errors.New("topup 需要 -user 和大于0的 -amount")
+		}
+		transferID := *id
+		if transferID == "" {
+			transferID = "topup:" + message.NewID()
+		}
+		if err := walletService.TopUp(*userID, *amount, transferID); err != nil {
+			if errors.Is(err, service.ErrTransferDuplicate) {
+				fmt.Printf("转账 %s 已经执行过，没有重复充值\n", transferID)
+				return nil
+			}
+			return err
+		}
+		fmt.Printf("已给用户 %d 充值 %d 金币，转账ID %s\n", *userID, *amount, transferID)
+		return nil
+	case "refund":
+		if *id == "" {
+			return errors.New("refund 需要 -id")
+		}
+		record, err := walletService.RefundGift(*id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已把 %d 金币从主播 %d 退回给用户 %d\n", record.Amount, record.StreamerID, record.SenderID)
+		// 金币已经退回，贡献榜减不掉只提示，之后可以用 leaderboard rebuild 修正
+		if err := newLeaderboardService(cfg, db, rdb).RevokeGift(record); err != nil {
+			fmt.Printf("从贡献榜中减去这次送礼失败: %v，请执行 admin leaderboard rebuild -room %d\n", err, record.RoomID)
+		}
+		return nil
+	case "balance":
+		if *userID == 0 {
+			return errors.New("balance 需要 -user")
+		}
+		balance, err := walletRepo.Balance(*userID)
+		if err != nil {
+			return err
+		}
+		available, err := walletRepo.AvailableBalance(*userID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("用户 %d 余额 %d，冻结 %d，可用 %d\n", *userID, balance, balance-available, available)
+		return nil
+	}
+	return fmt.Errorf("未知的wallet子命令: %s", sub)
+}
@@ -2,232 +2,152 @@ package main
 
 import (
 	"Orion_Live/internal/data"
-	"Orion_Live/internal/model"
+	"Orion_Live/internal/media"
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/mqhandler"
 	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/backoff"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/lifecycle"
 	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"Orion_Live/pkg/mysql"
 	"Orion_Live/pkg/rabbitmq"
-	"encoding/json"
-	"errors"
-
-	"github.com/go-sql-driver/mysql"
-	"github.com/streadway/amqp"
-	gorm_mysql "gorm.io/driver/mysql"
-	"gorm.io/gorm"
-)
-
-const (
-	QueueGoldenComment = "orion.golden_comment.queue"
-	QueueLike          = "orion.like.queue"
+	"Orion_Live/pkg/redis"
+	"Orion_Live/pkg/storage"
+	"context"
+	"log"
 )
 
-// like消息，只需要userID，videoID 和 “赞”/“取赞”
-type LikeMessage struct {
-	UserID  uint64 `json:"user_id"`
-	VideoID uint64 `json:"video_id"`
-	Action  string `json:"action"`
-}
-
-type GoldenCommentMessage struct {
-	UserID  uint64 `json:"user_id"`
-	VideoID uint64 `json:"video_id"`
-	Content string `json:"content"`
-}
-
-// 消费者进程：连接mysql，rabbitMQ，利用mq和likeRepo进行mysql的持久化存储
+// 消费者进程：连接mysql，redis，rabbitMQ，一个进程同时消费所有队列，并把消息持久化到mysql
 func main() {
-	logger.InitLogger()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("配置加载失败: %v", err)
+	}
+	if err := logger.InitLogger(cfg.Log); err != nil {
+		log.Fatalf("日志初始化失败: %v", err)
+	}
 
 	// 连接数据库
-	dsn := "root:zhengxikun@tcp(127.0.0.1:3306)/orion_live?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(gorm_mysql.Open(dsn), &gorm.Config{})
+	db, err := mysql.InitMySQL(cfg.MySQL)
 	if err != nil {
 		logger.Log.Fatalf("消费者无法连接到数据库: %v", err)
 	}
-	// 连接RabbitMQ
-	rabbitMQConn, err := rabbitmq.InitRabbitMQ()
+	// 连接RabbitMQ，断线自动重连；消费者也声明一遍队列（含重试队列和死信队列），这样先于relay启动也不会因为队列不存在而失败
+	retry := consumer.RetryPolicy{Delays: cfg.Consumer.RetryDelays, MaxAttempts: cfg.Consumer.MaxAttempts}
+	rabbitMQConn, err := rabbitmq.InitRabbitMQ(cfg.RabbitMQ, logger.Log, message.Topology(retry))
 	if err != nil {
 		logger.Log.Fatalf("消费者无法连接到RabbitMQ: %v", err)
 	}
-	defer rabbitMQConn.Close()
-
-	// likeRepo绑定mysql库
-	likeRepo := repository.NewLikeRepository(db)
-	videoRepo := repository.NewVideoRepository(db, nil)
-	commentRepo := repository.NewCommentRepository(db)
-	uow := data.NewUnitOfWork(db, videoRepo, commentRepo)
-	// 开始消费消息
-	consumeLikes(rabbitMQConn, db, likeRepo, videoRepo)
-	consumeGoldenComments(rabbitMQConn, db, commentRepo, uow)
-}
+	// 处理失败的消息通过publisher投递到重试队列或死信交换机，确认后才Ack原消息
+	publisher := rabbitmq.NewPublisher(rabbitMQConn, cfg.RabbitMQ.ChannelPoolSize, cfg.RabbitMQ.PublishTimeout)
 
-// like消息队列消费者：1、通过mq的TCP连接创建channel 2、通过ch注册消费者 3、利用无缓冲通道持续消费like消息 4、处理消息，repo负责增/删like关系，并对mq中的消息进行安全管理
-func consumeLikes(conn *amqp.Connection, db *gorm.DB, repo repository.LikeRepository, videoRepo repository.VideoRepository) {
-	ch, err := conn.Channel()
+	// 连接Redis，落库后更新黄金评论预约票的状态、解冻送礼预扣的金币，落库失败时归还席位、退回金币；礼物事件也经Redis推送到直播间、计入贡献榜
+	redisClient, err := redis.InitRedis(cfg.Redis)
 	if err != nil {
-		logger.Log.Fatalf("无法打开Channel: %v", err)
+		logger.Log.Fatalf("消费者无法连接到Redis: %v", err)
 	}
-	defer ch.Close()
 
-	msgs, err := ch.Consume(
-		QueueLike, // queue
-		"",        // consumer
-		false,     // auto-ack: 为简单起见，我们先用自动确认
-		false,     // exclusive
-		false,     // no-local
-		false,     // no-wait
-		nil,       // args
-	)
+	videoRepo := repository.NewVideoRepository(db, redisClient)
+	commentRepo := repository.NewCommentRepository(db)
+	ticketRepo := repository.NewGoldenTicketRepository(redisClient)
+	uow := data.NewUnitOfWork(db, videoRepo, commentRepo)
+	goldenRefund := service.NewGoldenRefundService(videoRepo, ticketRepo)
+	walletRepo := repository.NewWalletRepository(db, redisClient)
+	giftRelease := service.NewGiftReleaseService(walletRepo)
+	// 礼物事件计入贡献榜，晚于下播到达时按送礼记录重写那场的快照
+	leaderboard := service.NewLeaderboardService(repository.NewLiveRoomRepository(db, redisClient), repository.NewGiftRepository(db),
+		repository.NewLeaderboardRepository(db, redisClient), repository.NewUserRepository(db), cfg.Leaderboard)
+	// 转码从存储中读取上传的原始文件，HLS和生成的封面也写回存储；本地存储时要和server共用同一个目录
+	store, err := storage.New(cfg.Storage)
 	if err != nil {
-		logger.Log.Fatalf("无法注册点赞消费者: %v", err)
+		logger.Log.Fatalf("消费者初始化存储失败: %v", err)
 	}
-	// 创建一个没有任何缓冲的bool类型通道
-	forever := make(chan bool)
-
-	go func() {
-		// msgs不是切片，而是通道channel，如果通道为空不会结束循环，而会“阻塞”
-		for d := range msgs {
-			logCtx := logger.Log.WithField("body", string(d.Body)).WithField("redelivered", d.Redelivered)
-			// logCtx := logger.Log.WithField("body", string(d.Body))
-			logCtx.Info("收到一条点赞消息")
-
-			var msg LikeMessage
-			if err := json.Unmarshal(d.Body, &msg); err != nil {
-				logCtx.WithError(err).Error("消息JSON解析失败")
-				// 对于无法解析的“坏消息”，应该通知mq处理失败，并直接删除
-				d.Nack(false, false)
-				continue // 处理下一条
-			}
-			// db.Transaction事务操作，必须由原始的、全局的数据库连接池(db)来发起，并且tx是一次性的
-			// 如果返回error，gorm会向数据库发送ROLLBACK指令，在tx上的操作，会被撤销
-			// 如果返回nil，gorm会向数据库发送COMMIT指令，在tx上的操作，会被写入数据库
-			err := db.Transaction(func(tx *gorm.DB) error {
-				// 在事务中，我们需要使用临时的、绑定到这个事务(tx)的repository实例
-				txLikeRepo := repository.NewLikeRepository(tx)
-				txVideoRepo := repository.NewVideoRepository(tx, nil) // 事务中不操作Redis，所以rdb传nil
-
-				if msg.Action == "like" {
-					like := &model.Like{UserID: msg.UserID, VideoID: msg.VideoID}
-					if err := txLikeRepo.Create(like); err != nil {
-						return err // 事务中，返回任何error都会导致回滚
-					}
-					if err := txVideoRepo.IncrementLikeCount(msg.VideoID); err != nil {
-						return err
-					}
-				} else if msg.Action == "unlike" {
-					if err := txLikeRepo.Delete(msg.UserID, msg.VideoID); err != nil {
-						return err
-					}
-					if err := txVideoRepo.DecrementLikeCount(msg.VideoID); err != nil {
-						return err
-					}
-				}
-				return nil // 事务成功，返回nil，GORM会自动提交(Commit)
-			})
-			opErr := err
-			// 根据数据库操作的结果，来决定如何“确认”消息
-			if opErr != nil {
-				var mysqlErr *mysql.MySQLError
-				// 用errors.As来检查错误的“根”是不是一个MySQLError
-				if errors.As(opErr, &mysqlErr) && mysqlErr.Number == 1062 {
-					// 错误号 1062 就是 "Duplicate entry"
-					logCtx.WithError(opErr).Warn("处理消息时出现重复键错误，可能是一次重复消费，消息将被确认为成功。")
-					// 这不是一个需要重试的错误，直接Ack掉
-					d.Ack(false)
-				} else {
-					// 其他类型错误，才要求重试
-					logCtx.WithError(opErr).Error("处理消息失败，将进行重试")
-					d.Nack(false, true)
-				}
-			} else {
-				// 通知mq处理失败，并将消息删除
-				d.Ack(false)
-			}
-		}
-	}()
-	logger.Log.Info(" [*] 等待点赞消息中. 按 CTRL+C 退出")
-	// 尝试从forever通道里接收一个值，但没有发送者，这会阻止main函数退出
-	<-forever
-}
-
-// 黄金评论消费者：1、通过amqp.Connection建立channel，并设置channel为消费者 2、建立轮询，读取channel 3、利用消息结构体反序列化消息，并用事务单元保证“一荣俱荣，一损俱损” 4、利用videoID找到视频，并使用ForUpadate加锁，锁住video对象，判断时间（<10min）和数量()
-func consumeGoldenComments(conn *amqp.Connection, db *gorm.DB, commentRepo repository.CommentRepository, uow data.UnitOfWork) {
-
-	ch, err := conn.Channel()
-	if err != nil {
-		logger.Log.Fatalf("无法打开Channel: %v", err)
+	ffmpeg := media.NewFFmpeg(cfg.Media.FFmpegPath, cfg.Media.FFprobePath, cfg.Media.SegmentDuration)
+	mediaProcessing := service.NewMediaProcessingService(videoRepo, repository.NewMediaRepository(db, redisClient), store, ffmpeg, cfg.Media)
+	mediaFailure := service.NewMediaFailureService(videoRepo)
+
+	// 每个队列注册一个处理器，各自有独立的goroutine池和prefetch，channel断开后自动退避重建
+	// 处理失败的消息不再立即重新入队，而是按retry_delays延迟重试，超过max_attempts进入死信队列
+	supervisor := consumer.NewSupervisor(rabbitMQConn, publisher, logger.Log, backoff.Exponential{
+		Min: cfg.Consumer.MinBackoff,
+		Max: cfg.Consumer.MaxBackoff,
+	})
+	// opts只需要填Queue、Handler和可选的OnDeadLetter，其余按配置补齐
+	register := func(opts consumer.QueueOptions) {
+		q := cfg.Consumer.For(opts.Queue)
+		opts.Prefetch = q.Prefetch
+		opts.Workers = q.Workers
+		opts.Retry = retry
+		supervisor.Register(opts)
 	}
-	defer ch.Close()
-
-	msgs, err := ch.Consume(
-		QueueGoldenComment, // queue
-		"",                 // consumer
-		false,              // auto-ack: 为简单起见，我们先用自动确认
-		false,              // exclusive
-		false,              // no-local
-		false,              // no-wait
-		nil,                // args
-	)
+	register(consumer.QueueOptions{
+		Queue:   message.QueueLike,
+		Handler: mqhandler.NewLikeHandler(db),
+	})
+	register(consumer.QueueOptions{
+		Queue:        message.QueueGoldenComment,
+		Handler:      mqhandler.NewGoldenCommentHandler(uow, commentRepo, ticketRepo),
+		OnDeadLetter: mqhandler.NewGoldenCommentDeadLetter(goldenRefund),
+	})
+	register(consumer.QueueOptions{
+		Queue:   message.QueueDanmaku,
+		Handler: mqhandler.NewDanmakuHandler(db),
+	})
+	register(consumer.QueueOptions{
+		Queue:        message.QueueGift,
+		Handler:      mqhandler.NewGiftHandler(uow, walletRepo),
+		OnDeadLetter: mqhandler.NewGiftDeadLetter(giftRelease),
+	})
+	register(consumer.QueueOptions{
+		Queue:   message.QueueGiftEvent,
+		Handler: mqhandler.NewGiftEventHandler(redisClient, leaderboard),
+	})
+	register(consumer.QueueOptions{
+		Queue:   message.QueueRedPacket,
+		Handler: mqhandler.NewRedPacketGrabHandler(uow),
+	})
+	register(consumer.QueueOptions{
+		Queue:        message.QueueMedia,
+		Handler:      mqhandler.NewMediaProcessHandler(mediaProcessing),
+		OnDeadLetter: mqhandler.NewMediaProcessDeadLetter(mediaFailure),
+	})
+	register(consumer.QueueOptions{
+		Queue:   message.QueueVideoPublished,
+		Handler: mqhandler.NewVideoPublishedHandler(videoRepo),
+	})
+
+	// 生命周期：逆序关闭时先停止消费者（取消订阅+处理完在途消息+关闭channel），再关闭MQ连接、Redis，最后关闭数据库
+	app := lifecycle.New(logger.Log, cfg.App.ShutdownTimeout)
+	sqlDB, err := db.DB()
 	if err != nil {
-		logger.Log.Fatalf("无法注册黄金评论消费者: %v", err)
+		logger.Log.Fatalf("获取数据库连接池失败: %v", err)
 	}
-	// 创建一个没有任何缓冲的bool类型通道
-	forever := make(chan bool)
-
-	go func() {
-		// msgs不是切片，而是通道channel，如果通道为空不会结束循环，而会“阻塞”
-		for d := range msgs {
-			logCtx := logger.Log.WithField("message_id", d.MessageId).WithField("redelivered", d.Redelivered)
-			logCtx.Info("收到一条黄金评论！")
-			var msgGolden GoldenCommentMessage
-			if err := json.Unmarshal([]byte(d.Body), &msgGolden); err != nil {
-				logCtx.WithError(err).Error("消息JSON解析失败")
-				// 永久性错误，Ack掉
-				d.Ack(false)
-				continue
-			}
-			logCtx = logCtx.WithField("user_id", msgGolden.UserID).WithField("video_id", msgGolden.VideoID)
-			// 使用“工作单元”来执行我们的事务性操作
-			err := uow.Execute(func(repos *data.TransactionalRepositories) error {
-
-				newComment := &model.Comment{
-					UserID:   msgGolden.UserID,
-					VideoID:  msgGolden.VideoID,
-					Content:  msgGolden.Content,
-					IsGolden: true,
-				}
-				// Create会被GORM翻译成INSERT语句，数据库在执行INSERT时是原子的，并且会对新插入的行加锁，所以无需手动加锁
-				if err := repos.CommentRepo.Create(newComment); err != nil {
-					// 这里的错误可能是“重复键”，也可能是数据库连接问题
-					return err
-				}
-				// FindByIDForUpdate时，就获取了这行数据的锁，所以无需额外加锁
-				if _, err := repos.VideoRepo.IncrementGoldenCount(newComment.VideoID); err != nil {
-					return err
-				}
-				// 函数正常返回nil，UoW会帮我们提交事务，否则回滚整个事务
-				return nil
-			})
-			// 根据数据库操作的结果，来决定如何“确认”消息
-			if err != nil {
-				var mysqlErr *mysql.MySQLError
-				// 判断是不是可被认为是“成功”的幂等性错误
-				if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
-					// 错误号 1062 就是 "Duplicate entry"
-					logCtx.WithError(err).Warn("处理消息时出现重复键错误，可能是一次重复消费，消息将被确认为成功。")
-					// 这不是一个需要重试的错误，直接Ack掉
-					d.Ack(false)
-				} else {
-					// 其他类型错误，才要求重试
-					logCtx.WithError(err).Error("处理消息失败，将进行重试")
-					d.Nack(false, true)
-				}
-			} else {
-				// 通知mq处理失败，并将消息删除
-				d.Ack(false)
-			}
-		}
-	}()
-	logger.Log.Info(" [*] 等待“黄金评论”消息中. 按 CTRL+C 退出")
-	// 尝试从forever通道里接收一个值，但没有发送者，这会阻止main函数退出
-	<-forever
+	app.Append(lifecycle.Hook{
+		Name:    "mysql",
+		OnStart: sqlDB.PingContext,
+		OnStop:  func(ctx context.Context) error { return sqlDB.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "redis",
+		OnStart: func(ctx context.Context) error { return redisClient.Ping(ctx).Err() },
+		OnStop:  func(ctx context.Context) error { return redisClient.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:   "rabbitmq",
+		OnStop: func(ctx context.Context) error { return rabbitMQConn.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "consumers",
+		OnStart: supervisor.Start,
+		OnStop:  supervisor.Stop,
+	})
+
+	logger.Log.Info(" [*] 消费者已启动. 按 CTRL+C 退出")
+	if err := app.Run(); err != nil {
+		logger.Log.Fatalf("消费者异常退出: %v", err)
+	}
+	logger.Log.Info("消费者已退出")
 }
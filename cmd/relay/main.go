@@ -0,0 +1,89 @@
+package main
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/mqhandler"
+	"Orion_Live/internal/relay"
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/lifecycle"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"Orion_Live/pkg/mysql"
+	"Orion_Live/pkg/rabbitmq"
+	"Orion_Live/pkg/redis"
+	"context"
+	"log"
+)
+
+// relay进程：轮询mysql中的outbox_messages表，把server写入的待发送消息投递到RabbitMQ
+// 可以同时运行多个实例，FOR UPDATE SKIP LOCKED保证同一条消息不会被两个实例同时投递
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("配置加载失败: %v", err)
+	}
+	if err := logger.InitLogger(cfg.Log); err != nil {
+		log.Fatalf("日志初始化失败: %v", err)
+	}
+
+	db, err := mysql.InitMySQL(cfg.MySQL)
+	if err != nil {
+		logger.Log.Fatalf("relay无法连接到数据库: %v", err)
+	}
+	// 声明队列，保证mandatory发布时有队列可以路由
+	retry := consumer.RetryPolicy{Delays: cfg.Consumer.RetryDelays, MaxAttempts: cfg.Consumer.MaxAttempts}
+	rabbitMQConn, err := rabbitmq.InitRabbitMQ(cfg.RabbitMQ, logger.Log, message.Topology(retry))
+	if err != nil {
+		logger.Log.Fatalf("relay无法连接到RabbitMQ: %v", err)
+	}
+	publisher := rabbitmq.NewPublisher(rabbitMQConn, cfg.RabbitMQ.ChannelPoolSize, cfg.RabbitMQ.PublishTimeout)
+	// 连接Redis，黄金评论、送礼消息最终投递失败时归还席位、解冻预扣的金币，转码消息投递失败时还要删除视频缓存
+	redisClient, err := redis.InitRedis(cfg.Redis)
+	if err != nil {
+		logger.Log.Fatalf("relay无法连接到Redis: %v", err)
+	}
+	goldenRefund := service.NewGoldenRefundService(
+		repository.NewVideoRepository(db, redisClient),
+		repository.NewGoldenTicketRepository(redisClient),
+	)
+	giftRelease := service.NewGiftReleaseService(repository.NewWalletRepository(db, redisClient))
+	mediaFailure := service.NewMediaFailureService(repository.NewVideoRepository(db, redisClient))
+	outboxRelay := relay.New(db, publisher, cfg.Outbox, logger.Log)
+	outboxRelay.OnFailed(message.QueueGoldenComment, mqhandler.NewGoldenCommentPublishFailed(goldenRefund))
+	outboxRelay.OnFailed(message.QueueGift, mqhandler.NewGiftPublishFailed(giftRelease))
+	outboxRelay.OnFailed(message.QueueMedia, mqhandler.NewMediaProcessPublishFailed(mediaFailure))
+
+	// 生命周期：逆序关闭时先停止relay（提交正在投递的一批），再关闭MQ连接、Redis，最后关闭数据库
+	app := lifecycle.New(logger.Log, cfg.App.ShutdownTimeout)
+	sqlDB, err := db.DB()
+	if err != nil {
+		logger.Log.Fatalf("获取数据库连接池失败: %v", err)
+	}
+	app.Append(lifecycle.Hook{
+		Name:    "mysql",
+		OnStart: sqlDB.PingContext,
+		OnStop:  func(ctx context.Context) error { return sqlDB.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "redis",
+		OnStart: func(ctx context.Context) error { return redisClient.Ping(ctx).Err() },
+		OnStop:  func(ctx context.Context) error { return redisClient.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:   "rabbitmq",
+		OnStop: func(ctx context.Context) error { return rabbitMQConn.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "relay",
+		OnStart: outboxRelay.Start,
+		OnStop:  outboxRelay.Stop,
+	})
+
+	logger.Log.Info(" [*] outbox relay已启动. 按 CTRL+C 退出")
+	if err := app.Run(); err != nil {
+		logger.Log.Fatalf("relay异常退出: %v", err)
+	}
+	logger.Log.Info("relay已退出")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/scheduler"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/lifecycle"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mysql"
+	"Orion_Live/pkg/redis"
+	"Orion_Live/pkg/storage"
+	"context"
+	"log"
+)
+
+// scheduler进程：定时任务，比如退还过期红包、清理过期的分片上传、发布到点的定时视频、清理已经投递的outbox消息和消费记录
+// 可以同时运行多个实例，每个任务都是幂等的
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("配置加载失败: %v", err)
+	}
+	if err := logger.InitLogger(cfg.Log); err != nil {
+		log.Fatalf("日志初始化失败: %v", err)
+	}
+
+	db, err := mysql.InitMySQL(cfg.MySQL)
+	if err != nil {
+		logger.Log.Fatalf("scheduler无法连接到数据库: %v", err)
+	}
+	redisClient, err := redis.InitRedis(cfg.Redis)
+	if err != nil {
+		logger.Log.Fatalf("scheduler无法连接到Redis: %v", err)
+	}
+
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		logger.Log.Fatalf("scheduler初始化存储失败: %v", err)
+	}
+
+	videoRepo := repository.NewVideoRepository(db, redisClient)
+	uow := data.NewUnitOfWork(db, videoRepo, repository.NewCommentRepository(db))
+	redPacketRefund := service.NewRedPacketRefundService(repository.NewRedPacketRepository(db, redisClient), uow)
+	uploads := service.NewUploadService(repository.NewMediaRepository(db, redisClient), store, cfg.Upload)
+	videoPublish := service.NewVideoPublishService(videoRepo, uow)
+	messageCleanup := service.NewMessageCleanupService(repository.NewOutboxRepository(db), repository.NewInboxRepository(db), cfg.Outbox)
+
+	jobs := scheduler.New(logger.Log)
+	jobs.Add(scheduler.Job{
+		Name:     "red_packet_refund",
+		Interval: cfg.RedPacket.RefundInterval,
+		Run:      redPacketRefund.RefundExpired,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "upload_cleanup",
+		Interval: cfg.Upload.CleanupInterval,
+		Run:      uploads.CleanupExpired,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "video_publish",
+		Interval: cfg.Publish.Interval,
+		Run:      videoPublish.PublishDue,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "message_cleanup",
+		Interval: cfg.Outbox.CleanupInterval,
+		Run:      messageCleanup.Cleanup,
+	})
+
+	// 生命周期：逆序关闭时先等正在执行的任务结束，再关闭Redis和数据库
+	app := lifecycle.New(logger.Log, cfg.App.ShutdownTimeout)
+	sqlDB, err := db.DB()
+	if err != nil {
+		logger.Log.Fatalf("获取数据库连接池失败: %v", err)
+	}
+	app.Append(lifecycle.Hook{
+		Name:    "mysql",
+		OnStart: sqlDB.PingContext,
+		OnStop:  func(ctx context.Context) error { return sqlDB.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "redis",
+		OnStart: func(ctx context.Context) error { return redisClient.Ping(ctx).Err() },
+		OnStop:  func(ctx context.Context) error { return redisClient.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "scheduler",
+		OnStart: jobs.Start,
+		OnStop:  jobs.Stop,
+	})
+
+	logger.Log.Info(" [*] scheduler已启动. 按 CTRL+C 退出")
+	if err := app.Run(); err != nil {
+		logger.Log.Fatalf("scheduler异常退出: %v", err)
+	}
+	logger.Log.Info("scheduler已退出")
+}
@@ -4,6 +4,8 @@ package main
 
 import (
 	"Orion_Live/internal/model" // 👈 确保路径正确
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/mysql"
 	"fmt"
 	"log"
 	"math/rand"
@@ -11,8 +13,6 @@ import (
 
 	"github.com/go-faker/faker/v4"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -20,9 +20,12 @@ func main() {
 	fmt.Println("🚀 开始填充测试数据...")
 
 	// --- 1. 连接数据库 ---
-	// 注意：这里的DSN需要和你server/main.go中的保持一致
-	dsn := "root:zhengxikun@tcp(127.0.0.1:3306)/orion_live?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// 和server/main.go读取同一份配置，保证连的是同一个库
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("❌ 配置加载失败: %v", err)
+	}
+	db, err := mysql.InitMySQL(cfg.MySQL)
 	if err != nil {
 		log.Fatalf("❌ 无法连接到数据库: %v", err)
 	}
@@ -96,8 +99,8 @@ func main() {
 		}).Create(&like)
 	}
 	fmt.Printf("✅ 成功创建(或尝试创建) %d 个随机点赞!\n", likeCount)
-	// 注意：这里的点赞数还没有同步到videos表的like_count字段，这是一个很好的练习！
-	// 你可以尝试写一段SQL，在seeder的最后，去批量更新所有视频的点赞数。
+	// 注意：这里的点赞数还没有同步到videos表的like_count字段，也没有写入Redis
+	// 填充完后执行 go run ./cmd/admin reconcile likes，以likes表为准同步videos.like_count和Redis中的点赞数据
 
 	fmt.Println("🎉🎉🎉 所有测试数据填充完毕! 🎉🎉🎉")
 }
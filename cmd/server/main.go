@@ -3,55 +3,54 @@ package main
 import (
 	"Orion_Live/internal/data"
 	"Orion_Live/internal/handler"
+	"Orion_Live/internal/live"
 	"Orion_Live/internal/model"
 	"Orion_Live/internal/repository"
 	"Orion_Live/internal/router"
 	"Orion_Live/internal/service"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/cursor"
+	"Orion_Live/pkg/lifecycle"
 	"Orion_Live/pkg/logger"
-	"Orion_Live/pkg/rabbitmq"
+	"Orion_Live/pkg/mysql"
 	"Orion_Live/pkg/redis"
+	"Orion_Live/pkg/storage"
+	"context"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 
-	"github.com/joho/godotenv"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
 func main() {
-	// 加载.env文件
-	err := godotenv.Load()
+	// 加载配置：configs/*.yaml < .env < 环境变量
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf(".env文件加载失败")
+		log.Fatalf("配置加载失败: %v", err)
 	}
 	// 初始化logger
-	logger.InitLogger()
+	if err := logger.InitLogger(cfg.Log); err != nil {
+		log.Fatalf("日志初始化失败: %v", err)
+	}
+	logger.Log.WithField("env", cfg.App.Env).Info("配置加载成功")
 
 	// 初始化Redis
-	redisClient, err := redis.InitRedis()
+	redisClient, err := redis.InitRedis(cfg.Redis)
 	if err != nil {
 		logger.Log.Fatalf("无法连接到Redis: %v", err)
 	}
 	logger.Log.Info("Redis连接成功")
 
-	// 初始化RabbitMQ
-	rabbitMQConn, err := rabbitmq.InitRabbitMQ()
-	if err != nil {
-		logger.Log.Fatalf("无法连接到RabbitMQ: %v", err)
-	}
-	defer rabbitMQConn.Close() // 确保程序退出时关闭连接
-	logger.Log.Info("RabbitMQ连接成功")
-
-	// 数据源名称，用户名:密码@网络协议(地址:端口号)/数据库名?charset=字符集&parseTime=是否解析时间&loc=时区
-	dsn := "root:zhengxikun@tcp(127.0.0.1:3306)/orion_live?charset=utf8mb4&parseTime=True&loc=Local"
-	// 这个mysql包是gorm的第三方承包商，mysql.Open()后还是只能执行原始SQL语句，gorm.Open()后可以执行gorm的简化语句，但要注意性能
-	// db.Debug()/db.Raw()
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// 数据源名称由配置中的mysql段拼装，db.Debug()/db.Raw()
+	db, err := mysql.InitMySQL(cfg.MySQL)
 	if err != nil {
 		logger.Log.Fatalf("无法连接到数据库: %v", err)
 	}
 	logger.Log.Info("数据库连接成功")
 	// db.AutoMigrate(),没有这个表就创建,没有属性列则创建列,没有约束则增加约束;不会主动删除和修改
-	err = db.AutoMigrate(&model.User{}, &model.Video{}, &model.Like{}, &model.Comment{})
+	err = db.AutoMigrate(&model.User{}, &model.Video{}, &model.Like{}, &model.Comment{}, &model.OutboxMessage{}, &model.ConsumedMessage{}, &model.LiveRoom{}, &model.LiveSession{}, &model.Danmaku{}, &model.Wallet{}, &model.WalletLedgerEntry{}, &model.GiftRecord{}, &model.LeaderboardSnapshot{}, &model.RedPacket{}, &model.RedPacketGrab{}, &model.MediaFile{}, &model.Follow{})
 	if err != nil {
 		logger.Log.Fatalf("数据库迁移失败: %v", err)
 	}
@@ -59,29 +58,140 @@ func main() {
 	// if !db.Migrator().HasIndex(&model.Like{}, "idx_user_video") {
 	// 	db.Migrator().CreateIndex(&model.Like{}, "idx_user_video")
 	// }
+	// 加上发布时间之前的视频都是已发布的，按创建时间补上，Feed按发布时间排序；补过之后不会再有需要更新的行
+	if err := db.Model(&model.Video{}).Where("publish_at IS NULL AND publish_status = ?", model.PublishPublished).
+		Update("publish_at", gorm.Expr("created_at")).Error; err != nil {
+		logger.Log.Fatalf("补充视频发布时间失败: %v", err)
+	}
 	logger.Log.Info("数据库迁移成功")
 
+	// 上传的视频和封面存在本机目录或S3兼容的对象存储中
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		logger.Log.Fatalf("初始化存储失败: %v", err)
+	}
+	logger.Log.WithField("backend", cfg.Storage.Backend).Info("存储初始化成功")
+
 	userRepo := repository.NewUserRepository(db)
 	videoRepo := repository.NewVideoRepository(db, redisClient)
 	commentRepo := repository.NewCommentRepository(db)
+	// 点赞、黄金评论等异步消息先写入outbox表，由relay进程投递到RabbitMQ
+	outboxRepo := repository.NewOutboxRepository(db)
+	ticketRepo := repository.NewGoldenTicketRepository(redisClient)
+	liveRoomRepo := repository.NewLiveRoomRepository(db, redisClient)
+	livePresenceRepo := repository.NewLivePresenceRepository(redisClient)
+	walletRepo := repository.NewWalletRepository(db, redisClient)
+	giftRepo := repository.NewGiftRepository(db)
+	leaderboardRepo := repository.NewLeaderboardRepository(db, redisClient)
+	redPacketRepo := repository.NewRedPacketRepository(db, redisClient)
+	danmakuRepo := repository.NewDanmakuRepository(db)
+	mediaRepo := repository.NewMediaRepository(db, redisClient)
+	followRepo := repository.NewFollowRepository(db)
 
 	uow := data.NewUnitOfWork(db, videoRepo, commentRepo)
+	// Feed和评论列表的分页游标带签名，客户端改不了里面的位置
+	cursors := cursor.NewCodec(cfg.CursorSecret())
+
+	userService := service.NewUserService(userRepo, cfg.JWT)
+	uploadService := service.NewUploadService(mediaRepo, store, cfg.Upload)
+	videoService := service.NewVideoService(videoRepo, liveRoomRepo, danmakuRepo, mediaRepo, followRepo, uow, cfg.Publish, cursors)
+	likeService := service.NewLikeService(videoRepo, outboxRepo, followRepo)
+	commentService := service.NewCommentService(commentRepo, videoRepo, followRepo, uow, redisClient, outboxRepo, ticketRepo, cfg.Golden, cursors)
+	liveRoomService := service.NewLiveRoomService(liveRoomRepo, livePresenceRepo, leaderboardRepo, videoRepo, cfg.Live, cfg.Leaderboard)
+	livePresenceService := service.NewLivePresenceService(liveRoomRepo, livePresenceRepo, cfg.Live)
+	followService := service.NewFollowService(followRepo, userRepo)
+	walletService := service.NewWalletService(walletRepo, giftRepo, uow)
+	// 送礼先在Redis中冻结金币，消费者写完流水后解冻，落库后的礼物事件再由消费者推送到直播间
+	giftService := service.NewGiftService(liveRoomRepo, walletRepo, outboxRepo, cfg.Gift)
+	// 贡献榜由消费者处理礼物事件时累加，server只负责查询
+	leaderboardService := service.NewLeaderboardService(liveRoomRepo, giftRepo, leaderboardRepo, userRepo, cfg.Leaderboard)
+	presenceSweeper := live.NewPresenceSweeper(cfg.Live.PresenceSweepInterval, livePresenceService.SweepStale, logger.Log)
+	// 弹幕经Redis Pub/Sub推送给所有实例上的观众，攒批后写入outbox异步落库
+	danmakuHub := live.NewHub(redisClient, cfg.Danmaku, logger.Log)
+	danmakuBatcher := live.NewDanmakuBatcher(cfg.Danmaku, service.NewDanmakuOutboxWriter(outboxRepo), logger.Log)
+	danmakuService := service.NewDanmakuService(liveRoomRepo, danmakuHub, danmakuBatcher, cfg.Danmaku)
+	// 红包发出时就扣款并拆好份额，观众抢红包只走Redis，抢到的份额由消费者入账，过期退还由scheduler负责
+	redPacketService := service.NewRedPacketService(liveRoomRepo, walletRepo, redPacketRepo, outboxRepo, uow, danmakuHub, cfg.RedPacket)
 
-	userService := service.NewUserService(userRepo)
-	videoService := service.NewVideoService(videoRepo)
-	likeService := service.NewLikeService(videoRepo, rabbitMQConn)
-	commentService := service.NewCommentService(commentRepo, videoRepo, uow, redisClient, rabbitMQConn)
+	// 视频和HLS的播放地址带签名、会过期，由/media接口校验后从存储中读取
+	signer := storage.NewSigner(store, cfg.PlaybackSecret(), cfg.Playback.TTL, cfg.Playback.BaseURL)
 
 	userHandler := handler.NewUserHandler(userService)
-	videoHandler := handler.NewVideoHandler(videoService)
+	videoHandler := handler.NewVideoHandler(videoService, signer)
 	likeHandler := handler.NewLikeHandler(likeService)
-	commentHandler := handler.NewCommentHandler(commentService, commentRepo, videoRepo)
+	commentHandler := handler.NewCommentHandler(commentService, commentRepo)
+	liveRoomHandler := handler.NewLiveRoomHandler(liveRoomService, livePresenceService, cfg.Live.CallbackToken)
+	walletHandler := handler.NewWalletHandler(walletService)
+	giftHandler := handler.NewGiftHandler(giftService)
+	leaderboardHandler := handler.NewLeaderboardHandler(leaderboardService)
+	redPacketHandler := handler.NewRedPacketHandler(redPacketService)
+	uploadHandler := handler.NewUploadHandler(uploadService, signer, max(cfg.Upload.MaxVideoSize, cfg.Upload.MaxCoverSize), cfg.Upload.BodyTimeout)
+	mediaHandler := handler.NewMediaHandler(store, signer, cfg.Playback.WriteTimeout)
+	followHandler := handler.NewFollowHandler(followService)
+	danmakuHandler := handler.NewDanmakuHandler(danmakuHub, danmakuService, liveRoomService, livePresenceService, cfg.Danmaku.MinInterval)
+
+	r := router.SetupRouter(cfg.JWT.Secret, userHandler, videoHandler, likeHandler, commentHandler, liveRoomHandler, danmakuHandler, walletHandler, giftHandler, leaderboardHandler, redPacketHandler, uploadHandler, mediaHandler, followHandler)
+	srv := &http.Server{
+		Addr:         cfg.Server.Addr(),
+		Handler:      r,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
 
-	r := router.SetupRouter(userHandler, videoHandler, likeHandler, commentHandler)
-	logger.Log.Println("服务器将在: 8080端口启动")
+	// 生命周期：按注册顺序启动，按逆序关闭。先停HTTP（排空在途请求），再断开WebSocket连接、刷完待落库的弹幕，然后关Redis，最后关数据库连接池
+	app := lifecycle.New(logger.Log, cfg.App.ShutdownTimeout)
+	sqlDB, err := db.DB()
+	if err != nil {
+		logger.Log.Fatalf("获取数据库连接池失败: %v", err)
+	}
+	app.Append(lifecycle.Hook{
+		Name:    "mysql",
+		OnStart: sqlDB.PingContext,
+		OnStop:  func(ctx context.Context) error { return sqlDB.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "redis",
+		OnStart: func(ctx context.Context) error { return redisClient.Ping(ctx).Err() },
+		OnStop:  func(ctx context.Context) error { return redisClient.Close() },
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "presence_sweeper",
+		OnStart: presenceSweeper.Start,
+		OnStop:  presenceSweeper.Stop,
+	})
+	app.Append(lifecycle.Hook{
+		Name:    "danmaku_batcher",
+		OnStart: danmakuBatcher.Start,
+		OnStop:  danmakuBatcher.Stop,
+	})
+	// Shutdown不管已经升级的WebSocket连接，由hub负责关闭
+	app.Append(lifecycle.Hook{
+		Name:    "danmaku_hub",
+		OnStart: danmakuHub.Start,
+		OnStop:  danmakuHub.Stop,
+	})
+	app.Append(lifecycle.Hook{
+		Name: "http",
+		OnStart: func(ctx context.Context) error {
+			// 先同步监听端口，端口被占用之类的错误可以直接让启动失败
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			logger.Log.Printf("服务器成功在: %s 启动", srv.Addr)
+			go func() {
+				if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					app.Fail(err)
+				}
+			}()
+			return nil
+		},
+		// Shutdown先关闭监听，再等待所有在途请求处理完，超过截止时间则返回ctx错误
+		OnStop: srv.Shutdown,
+	})
 
-	if err := r.Run(":8080"); err != nil {
-		logger.Log.Fatalf("服务器启动失败: %v", err)
+	if err := app.Run(); err != nil {
+		logger.Log.Fatalf("服务器异常退出: %v", err)
 	}
-	logger.Log.Println("服务器成功在: 8080端口启动")
+	logger.Log.Info("服务器已退出")
 }
@@ -17,6 +17,15 @@ type UnitOfWork interface {
 type TransactionalRepositories struct {
 	VideoRepo   repository.VideoRepository
 	CommentRepo repository.CommentRepository
+	// 业务数据和待投递的消息写在同一个事务里，要么都提交，要么都回滚
+	OutboxRepo repository.OutboxRepository
+	// 消费者在同一个事务里记录已处理的消息ID，用于去重
+	InboxRepo repository.InboxRepository
+	// 钱包的余额和流水、送礼记录，转账必须和它们在同一个事务里
+	WalletRepo repository.WalletRepository
+	GiftRepo   repository.GiftRepository
+	// 红包和抢到的份额，同样要和转账在一个事务里
+	RedPacketRepo repository.RedPacketRepository
 	// 如果需要，未来可以加入 UserRepo, LikeRepo 等
 }
 
@@ -46,6 +55,12 @@ func (u *gormUnitOfWork) Execute(fn func(repos *TransactionalRepositories) error
 		transactionalRepos := &TransactionalRepositories{
 			VideoRepo:   u.videoRepo.WithTx(tx),
 			CommentRepo: u.commentRepo.WithTx(tx),
+			OutboxRepo:  repository.NewOutboxRepository(tx),
+			InboxRepo:   repository.NewInboxRepository(tx),
+			WalletRepo:  repository.NewWalletRepository(tx, nil), // 事务中不操作Redis，所以rdb传nil
+			GiftRepo:    repository.NewGiftRepository(tx),
+			// 同样只用到MySQL
+			RedPacketRepo: repository.NewRedPacketRepository(tx, nil),
 		}
 		// 回调结构（Callback），回头去调用最初调用者托付给它的具体业务逻辑，并将其执行结果作为整个事务成功或失败的依据
 		return fn(transactionalRepos)
@@ -0,0 +1,30 @@
+package dto
+
+import (
+	"Orion_Live/internal/model"
+	"time"
+)
+
+// GoldenTicketResponse 黄金评论预约票的响应结构，committed时带上落库的评论
+type GoldenTicketResponse struct {
+	TicketID  string           `json:"ticket_id"`
+	Status    string           `json:"status"`
+	VideoID   uint64           `json:"video_id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Reason    string           `json:"reason,omitempty"`
+	Comment   *CommentResponse `json:"comment,omitempty"`
+}
+
+func ToGoldenTicketResponse(ticket *model.GoldenTicket, comment *model.Comment) *GoldenTicketResponse {
+	resp := &GoldenTicketResponse{
+		TicketID:  ticket.ID,
+		Status:    ticket.Status,
+		VideoID:   ticket.VideoID,
+		CreatedAt: ticket.CreatedAt,
+		Reason:    ticket.Reason,
+	}
+	if comment != nil {
+		resp.Comment = ToCommentResponse(comment)
+	}
+	return resp
+}
@@ -0,0 +1,115 @@
+package dto
+
+import (
+	"Orion_Live/internal/model"
+	"time"
+)
+
+// LiveRoomResponse 直播间的公开信息，不包含推流密钥
+type LiveRoomResponse struct {
+	ID            uint64     `json:"id"`
+	Title         string     `json:"title"`
+	CoverURL      string     `json:"cover_url"`
+	Status        string     `json:"status"`
+	PlayURL       string     `json:"play_url"`
+	LiveStartedAt *time.Time `json:"live_started_at,omitempty"`
+	// 在线观众数，只有直播中的房间有
+	OnlineCount int64 `json:"online_count"`
+	Streamer    struct {
+		ID       uint64 `json:"id"`
+		Username string `json:"username"`
+	} `json:"streamer"`
+}
+
+// LiveRoomOwnerResponse 只返回给主播本人，带上推流地址和密钥
+type LiveRoomOwnerResponse struct {
+	LiveRoomResponse
+	StreamKey string `json:"stream_key"`
+	// OBS中“服务器”一栏
+	PublishURL string `json:"publish_url"`
+	// OBS中“串流密钥”一栏
+	StreamName string `json:"stream_name"`
+}
+
+func ToLiveRoomResponse(room *model.LiveRoom, playURL string) LiveRoomResponse {
+	resp := LiveRoomResponse{
+		ID:       room.ID,
+		Title:    room.Title,
+		CoverURL: room.CoverURL,
+		Status:   room.Status,
+		PlayURL:  playURL,
+	}
+	if room.Status == model.LiveRoomLive {
+		resp.LiveStartedAt = room.LiveStartedAt
+	}
+	resp.Streamer.ID = room.StreamerID
+	resp.Streamer.Username = room.Streamer.Username
+	return resp
+}
+
+func ToLiveRoomOwnerResponse(room *model.LiveRoom, playURL, publishURL, streamName string) LiveRoomOwnerResponse {
+	return LiveRoomOwnerResponse{
+		LiveRoomResponse: ToLiveRoomResponse(room, playURL),
+		StreamKey:        room.StreamKey,
+		PublishURL:       publishURL,
+		StreamName:       streamName,
+	}
+}
+
+// LiveSessionResponse 一场直播的观众统计，正在进行的场次EndedAt为空，峰值和独立观众在下播后才写入
+type LiveSessionResponse struct {
+	ID            uint64     `json:"id"`
+	Title         string     `json:"title"`
+	StartedAt     time.Time  `json:"started_at"`
+	EndedAt       *time.Time `json:"ended_at,omitempty"`
+	PeakViewers   int64      `json:"peak_viewers"`
+	UniqueViewers int64      `json:"unique_viewers"`
+}
+
+// LiveCurrentStats 正在进行的这场直播的实时数据
+type LiveCurrentStats struct {
+	SessionID     uint64 `json:"session_id"`
+	Online        int64  `json:"online"`
+	PeakViewers   int64  `json:"peak_viewers"`
+	UniqueViewers int64  `json:"unique_viewers"`
+}
+
+// LiveRoomStatsResponse 主播的观众统计，current只在直播中时返回
+type LiveRoomStatsResponse struct {
+	RoomID   uint64                `json:"room_id"`
+	Status   string                `json:"status"`
+	Current  *LiveCurrentStats     `json:"current,omitempty"`
+	Sessions []LiveSessionResponse `json:"sessions"`
+}
+
+func ToLiveSessionResponse(session *model.LiveSession) LiveSessionResponse {
+	return LiveSessionResponse{
+		ID:            session.ID,
+		Title:         session.Title,
+		StartedAt:     session.StartedAt,
+		EndedAt:       session.EndedAt,
+		PeakViewers:   session.PeakViewers,
+		UniqueViewers: session.UniqueViewers,
+	}
+}
+
+// LeaderboardEntryResponse 贡献榜上的一个名次，rank为0表示不在榜上
+type LeaderboardEntryResponse struct {
+	Rank     int64  `json:"rank"`
+	UserID   uint64 `json:"user_id"`
+	Username string `json:"username"`
+	Amount   int64  `json:"amount"`
+}
+
+// LeaderboardResponse 贡献榜的一页，mine是当前用户自己的名次
+// snapshot为true时是已经下播的场次，只保留了前若干名
+type LeaderboardResponse struct {
+	Scope     string                     `json:"scope"`
+	RoomID    uint64                     `json:"room_id"`
+	SessionID uint64                     `json:"session_id,omitempty"`
+	Date      string                     `json:"date,omitempty"`
+	Total     int64                      `json:"total"`
+	Snapshot  bool                       `json:"snapshot"`
+	Items     []LeaderboardEntryResponse `json:"items"`
+	Mine      LeaderboardEntryResponse   `json:"mine"`
+}
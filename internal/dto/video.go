@@ -16,17 +16,70 @@ type VideoResponse struct {
 		ID       uint64 `json:"id"`
 		Username string `json:"username"`
 	} `json:"author"`
+	// 和响应头中的ETag相同，修改视频时放在If-Match里
+	Version uint64 `json:"version"`
+	// public、unlisted、followers或private
+	Visibility string `json:"visibility"`
+	// draft、scheduled或published，scheduled的publish_at是定时发布的时间，published的是实际发布时间
+	PublishStatus string     `json:"publish_status"`
+	PublishAt     *time.Time `json:"publish_at"`
+	// 发布后先是uploaded，转码完成后变成ready，才有hls_url、时长和分辨率
+	ProcessingStatus string `json:"processing_status"`
+	HLSURL           string `json:"hls_url,omitempty"`
+	DurationMS       int64  `json:"duration_ms,omitempty"`
+	Width            int    `json:"width,omitempty"`
+	Height           int    `json:"height,omitempty"`
+	// 直播回放才有，GetVideoByID时带上弹幕时间轴
+	LiveSessionID *uint64         `json:"live_session_id,omitempty"`
+	Replay        *ReplayResponse `json:"replay,omitempty"`
+}
+
+// ReplayResponse 回放对应的那场直播和弹幕时间轴
+type ReplayResponse struct {
+	RoomID    uint64     `json:"room_id"`
+	SessionID uint64     `json:"session_id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+	// 按offset_ms升序，播放到对应进度时显示
+	Danmaku []ReplayDanmakuResponse `json:"danmaku"`
+}
+
+// ReplayDanmakuResponse offset_ms是弹幕相对开播时间的毫秒数，也就是在回放视频中出现的位置
+type ReplayDanmakuResponse struct {
+	OffsetMS int64  `json:"offset_ms"`
+	UserID   uint64 `json:"user_id"`
+	Content  string `json:"content"`
+}
+
+// PlaybackSigner 把存储中的地址换成带签名、会过期的播放地址，其他地址（比如直播回放的录像）原样返回
+type PlaybackSigner interface {
+	SignURL(rawURL string) string
 }
 
 // ToVideoResponse 是一个转换函数，把DB模型转换为API响应模型，并且正确利用preload返回的数据，增强返回数据的健壮性
-func ToVideoResponse(video *model.Video) VideoResponse {
+// 播放地址每次都重新签名；还没转码完成的视频不给播放地址，存储中的原始文件不能被直接引用
+func ToVideoResponse(video *model.Video, signer PlaybackSigner) VideoResponse {
 	resp := VideoResponse{
 		ID:          video.ID,
 		CreatedAt:   video.CreatedAt,
 		Title:       video.Title,
 		Description: video.Description,
-		VideoURL:    video.VideoURL,
 		CoverURL:    video.CoverURL,
+
+		Visibility:    video.Visibility,
+		PublishStatus: video.PublishStatus,
+		PublishAt:     video.PublishAt,
+
+		Version:          video.Version,
+		ProcessingStatus: video.ProcessingStatus,
+		DurationMS:       video.DurationMS,
+		Width:            video.Width,
+		Height:           video.Height,
+		LiveSessionID:    video.LiveSessionID,
+	}
+	if video.ProcessingStatus == model.VideoReady {
+		resp.VideoURL = signer.SignURL(video.VideoURL)
+		resp.HLSURL = signer.SignURL(video.HLSURL)
 	}
 	// 检查Author是否被成功preload
 	if video.Author.ID != 0 {
@@ -38,3 +91,59 @@ func ToVideoResponse(video *model.Video) VideoResponse {
 	}
 	return resp
 }
+
+// ToReplayResponse 录制和推流同时开始，弹幕的发送时间减去开播时间就是它在回放中的位置；开播前的弹幕（理论上没有）放在开头
+func ToReplayResponse(session *model.LiveSession, danmakus []model.Danmaku) *ReplayResponse {
+	resp := &ReplayResponse{
+		RoomID:    session.RoomID,
+		SessionID: session.ID,
+		StartedAt: session.StartedAt,
+		EndedAt:   session.EndedAt,
+		Danmaku:   make([]ReplayDanmakuResponse, 0, len(danmakus)),
+	}
+	for _, d := range danmakus {
+		offset := d.SentAt.Sub(session.StartedAt).Milliseconds()
+		if offset < 0 {
+			offset = 0
+		}
+		resp.Danmaku = append(resp.Danmaku, ReplayDanmakuResponse{OffsetMS: offset, UserID: d.UserID, Content: d.Content})
+	}
+	return resp
+}
+
+// MediaFileResponse 上传完成的文件，发布视频时传它的id
+type MediaFileResponse struct {
+	ID          uint64    `json:"id"`
+	Kind        string    `json:"kind"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Hash        string    `json:"sha256"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ToMediaFileResponse 上传者拿到的也是签名地址，可以用来预览
+func ToMediaFileResponse(file *model.MediaFile, signer PlaybackSigner) MediaFileResponse {
+	return MediaFileResponse{
+		ID:          file.ID,
+		Kind:        file.Kind,
+		Size:        file.Size,
+		ContentType: file.ContentType,
+		Hash:        file.Hash,
+		URL:         signer.SignURL(file.URL),
+		CreatedAt:   file.CreatedAt,
+	}
+}
+
+// UploadSessionResponse 分片上传的进度，received是已经收到的分片序号，断线重连后只需要补传其余的
+type UploadSessionResponse struct {
+	UploadID  string    `json:"upload_id"`
+	Kind      string    `json:"kind"`
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunk_size"`
+	Chunks    int       `json:"chunks"`
+	Received  []int     `json:"received"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// 合并完成后的文件ID，完成前为0
+	FileID uint64 `json:"file_id"`
+}
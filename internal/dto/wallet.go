@@ -0,0 +1,88 @@
+package dto
+
+import (
+	"Orion_Live/internal/model"
+	"time"
+)
+
+// LedgerEntryResponse 一条流水，Amount为负表示支出
+type LedgerEntryResponse struct {
+	ID             uint64    `json:"id"`
+	TransferID     string    `json:"transfer_id"`
+	Type           string    `json:"type"`
+	Amount         int64     `json:"amount"`
+	BalanceAfter   int64     `json:"balance_after"`
+	CounterpartyID uint64    `json:"counterparty_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func ToLedgerEntryResponse(entry *model.WalletLedgerEntry) LedgerEntryResponse {
+	return LedgerEntryResponse{
+		ID:             entry.ID,
+		TransferID:     entry.TransferID,
+		Type:           entry.Type,
+		Amount:         entry.Amount,
+		BalanceAfter:   entry.BalanceAfter,
+		CounterpartyID: entry.CounterpartyID,
+		CreatedAt:      entry.CreatedAt,
+	}
+}
+
+// GiftItemResponse 礼物价目表中的一项，价格单位是金币
+type GiftItemResponse struct {
+	ID    uint64 `json:"id"`
+	Name  string `json:"name"`
+	Price int64  `json:"price"`
+}
+
+// GiftReceiptResponse 送礼受理结果，balance是预扣之后的可用余额
+type GiftReceiptResponse struct {
+	TransferID string `json:"transfer_id"`
+	Status     string `json:"status"`
+	Amount     int64  `json:"amount"`
+	Balance    int64  `json:"balance"`
+	Duplicate  bool   `json:"duplicate"`
+}
+
+// RedPacketResponse 红包详情，grabbed_*是已经入账的部分，可能稍微落后于实际抢到的
+type RedPacketResponse struct {
+	ID             string                  `json:"id"`
+	RoomID         uint64                  `json:"room_id"`
+	SenderID       uint64                  `json:"sender_id"`
+	Amount         int64                   `json:"amount"`
+	Count          int                     `json:"count"`
+	GrabbedAmount  int64                   `json:"grabbed_amount"`
+	GrabbedCount   int                     `json:"grabbed_count"`
+	RefundedAmount int64                   `json:"refunded_amount"`
+	Status         string                  `json:"status"`
+	ExpiresAt      time.Time               `json:"expires_at"`
+	CreatedAt      time.Time               `json:"created_at"`
+	Grabs          []RedPacketGrabResponse `json:"grabs,omitempty"`
+}
+
+// RedPacketGrabResponse 抢到的一份
+type RedPacketGrabResponse struct {
+	UserID    uint64    `json:"user_id"`
+	Amount    int64     `json:"amount"`
+	GrabbedAt time.Time `json:"grabbed_at"`
+}
+
+func ToRedPacketResponse(packet *model.RedPacket, grabs []model.RedPacketGrab) RedPacketResponse {
+	resp := RedPacketResponse{
+		ID:             packet.ID,
+		RoomID:         packet.RoomID,
+		SenderID:       packet.SenderID,
+		Amount:         packet.Amount,
+		Count:          packet.Count,
+		GrabbedAmount:  packet.GrabbedAmount,
+		GrabbedCount:   packet.GrabbedCount,
+		RefundedAmount: packet.RefundedAmount,
+		Status:         packet.Status,
+		ExpiresAt:      packet.ExpiresAt,
+		CreatedAt:      packet.CreatedAt,
+	}
+	for _, g := range grabs {
+		resp.Grabs = append(resp.Grabs, RedPacketGrabResponse{UserID: g.UserID, Amount: g.Amount, GrabbedAt: g.CreatedAt})
+	}
+	return resp
+}
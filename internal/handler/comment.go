@@ -5,6 +5,7 @@ import (
 	"Orion_Live/internal/repository"
 	"Orion_Live/internal/service"
 	"Orion_Live/pkg/logger"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -15,6 +16,7 @@ type CommentHandler interface {
 	CreateCommentForVideo(c *gin.Context)
 	CreateReplyForComment(c *gin.Context)
 	CreateGoldenForVideo(c *gin.Context)
+	GetGoldenTicket(c *gin.Context)
 
 	GetComments(c *gin.Context)
 }
@@ -22,14 +24,12 @@ type CommentHandler interface {
 type commentHandler struct {
 	CommentService service.CommentService
 	CommentRepo    repository.CommentRepository
-	VideoRepo      repository.VideoRepository
 }
 
-func NewCommentHandler(commentService service.CommentService, commentRepo repository.CommentRepository, videoRepo repository.VideoRepository) CommentHandler {
+func NewCommentHandler(commentService service.CommentService, commentRepo repository.CommentRepository) CommentHandler {
 	return &commentHandler{
 		CommentService: commentService,
 		CommentRepo:    commentRepo,
-		VideoRepo:      videoRepo,
 	}
 }
 
@@ -37,7 +37,7 @@ type CreateCommentRequest struct {
 	Content string `json:"content" binding:"required"`
 }
 
-// 视频评论：1、解析URL中的videoID参数，并判断视频是否存在 2、解析URL的Body，进行Content格式匹配 3、获取context中的userID（jwt） 4、创建评论并返回状态
+// 视频评论：1、解析URL中的videoID参数 2、解析URL的Body，进行Content格式匹配 3、获取context中的userID（jwt） 4、创建评论并返回状态，视频是否存在、能不能看到由service层判断
 func (h *commentHandler) CreateCommentForVideo(c *gin.Context) {
 	// URL解析参数获得string格式
 	videoIDstr := c.Param("video_id")
@@ -47,11 +47,6 @@ func (h *commentHandler) CreateCommentForVideo(c *gin.Context) {
 		sendErrorResponse(c, http.StatusBadRequest, "无效的视频ID")
 		return
 	}
-	_, err = h.VideoRepo.FindByID(videoID)
-	if err != nil {
-		sendErrorResponse(c, http.StatusNotFound, "视频不存在")
-		return
-	}
 
 	var req CreateCommentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -74,6 +69,10 @@ func (h *commentHandler) CreateCommentForVideo(c *gin.Context) {
 	logCtx := logger.Log.WithField("user_id", userID).WithField("video_id", videoID)
 	logCtx.Info("开始创建一级评论")
 	comment, err := h.CommentService.CreateComment(userID, videoID, req.Content)
+	if errors.Is(err, service.ErrVideoNotFound) {
+		sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+		return
+	}
 	if err != nil {
 		logCtx.WithError(err).Error("创建一级评论失败")
 		sendErrorResponse(c, http.StatusInternalServerError, "评论失败") // 500
@@ -125,6 +124,10 @@ func (h *commentHandler) CreateReplyForComment(c *gin.Context) {
 	logCtx.Info("开始创建二级评论")
 	// 创建回复，加了父评论的信息
 	reply, err := h.CommentService.CreateReply(userID, parentComment, req.Content)
+	if errors.Is(err, service.ErrVideoNotFound) {
+		sendErrorResponse(c, http.StatusNotFound, "回复的评论不存在") // 404
+		return
+	}
 	if err != nil {
 		logCtx.WithError(err).Error("创建二级评论失败")
 		sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
@@ -139,7 +142,7 @@ func (h *commentHandler) CreateReplyForComment(c *gin.Context) {
 	})
 }
 
-// 创建黄金评论：1、检查URL的video_id参数，以及video存在性 2、URL的Body参数嵌入，并从context提取userID 3、service层创建黄金评论，并返回 4.dto层处理返回的评论结构体，返回响应
+// 创建黄金评论：1、检查URL的video_id参数 2、URL的Body参数嵌入，并从context提取userID 3、service层抢席位并返回预约票 4、返回202，客户端凭票ID轮询结果
 func (h *commentHandler) CreateGoldenForVideo(c *gin.Context) {
 	// 解析参数
 	videoID, err := strconv.ParseUint(c.Param("video_id"), 10, 64)
@@ -147,12 +150,6 @@ func (h *commentHandler) CreateGoldenForVideo(c *gin.Context) {
 		sendErrorResponse(c, http.StatusBadRequest, "无效的视频ID") // 400
 		return
 	}
-	// 查找videoID的存在性
-	_, err = h.VideoRepo.FindByID(videoID)
-	if err != nil {
-		sendErrorResponse(c, http.StatusNotFound, "视频不存在")
-		return
-	}
 	var req CreateCommentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		logger.Log.WithError(err).Error("评论参数解析失败")
@@ -173,23 +170,59 @@ func (h *commentHandler) CreateGoldenForVideo(c *gin.Context) {
 	// 正式进入业务前，将logger格式整理好
 	logCtx := logger.Log.WithField("user_id", userID).WithField("video_id", videoID)
 	logCtx.Info("开始创建黄金评论！")
-	comment, err := h.CommentService.CreateGoldenComment(userID, videoID, req.Content)
+	ticket, err := h.CommentService.CreateGoldenComment(userID, videoID, req.Content)
 	if err != nil {
-		logCtx.WithError(err).Error("创建黄金评论失败")
-		sendErrorResponse(c, http.StatusInternalServerError, "评论失败") // 500
+		switch {
+		case errors.Is(err, service.ErrVideoNotFound):
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+		case errors.Is(err, service.ErrGoldenWindowClosed), errors.Is(err, service.ErrGoldenNotOpen):
+			logCtx.WithError(err).Info("黄金评论已截止")
+			sendErrorResponse(c, http.StatusForbidden, err.Error()) // 403
+		case errors.Is(err, service.ErrGoldenSeatTaken), errors.Is(err, service.ErrGoldenSeatsFull):
+			logCtx.WithError(err).Info("黄金评论席位被拒绝")
+			sendErrorResponse(c, http.StatusConflict, err.Error()) // 409
+		default:
+			logCtx.WithError(err).Error("创建黄金评论失败")
+			sendErrorResponse(c, http.StatusInternalServerError, "评论失败") // 500
+		}
 		return
 	}
-	commentResponse := dto.ToCommentResponse(comment)
-	// 业务成功，打上返回的comment的ID
-	logCtx.WithField("comment_id", comment.ID).Info("黄金评论创建成功")
-	c.JSON(http.StatusCreated, gin.H{ //201
-		"message": "评论成功",
-		"data":    commentResponse, // MVP阶段可以直接返回model，未来换成DTO
+	// 席位已抢到，评论还在异步落库，打上预约票ID
+	logCtx.WithField("ticket_id", ticket.ID).Info("黄金评论席位抢占成功")
+	c.JSON(http.StatusAccepted, gin.H{ //202
+		"message": "已抢到黄金评论席位，评论正在发布",
+		"data":    dto.ToGoldenTicketResponse(ticket, nil),
 	})
+}
 
+// 查询黄金评论预约票：1、从context提取userID 2、service层查询票据，只能查自己的票 3、committed时返回最终的评论
+func (h *commentHandler) GetGoldenTicket(c *gin.Context) {
+	ticketID := c.Param("ticket_id")
+	userIDFloat, exists := c.Get("userID")
+	if !exists {
+		sendErrorResponse(c, http.StatusUnauthorized, "用户未认证") // 401
+		return
+	}
+	userID := uint64(userIDFloat.(float64))
+
+	ticket, comment, err := h.CommentService.GetGoldenTicket(userID, ticketID)
+	if err != nil {
+		if errors.Is(err, service.ErrGoldenTicketNotFound) {
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+			return
+		}
+		logger.Log.WithError(err).WithField("ticket_id", ticketID).Error("查询黄金评论预约票失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "查询失败") // 500
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "查询成功",
+		"data":    dto.ToGoldenTicketResponse(ticket, comment),
+	})
 }
 
-// 获取一个视频的所有评论 1、提取URL中videoID参数，并确认存在 2、从查询参数获取分页信息，并提供默认值 3、通过service获取所有一级二级评论 4.dto层挂载二级评论，返回结果
+// 获取一个视频的评论 1、提取URL中videoID参数，没登录时viewerID为0 2、从查询参数获取游标和limit，没传时从最新的开始、用默认条数
+// 3、通过service获取一页一级评论和它们的二级评论 4.dto层挂载二级评论，返回结果，has_more时客户端用next_cursor请求下一页
 func (h *commentHandler) GetComments(c *gin.Context) {
 	// 解析参数
 	videoID, err := strconv.ParseUint(c.Param("video_id"), 10, 64)
@@ -197,26 +230,32 @@ func (h *commentHandler) GetComments(c *gin.Context) {
 		sendErrorResponse(c, http.StatusBadRequest, "无效的视频ID") // 400
 		return
 	}
-	// 查找videoID的存在性
-	_, err = h.VideoRepo.FindByID(videoID)
-	if err != nil {
-		sendErrorResponse(c, http.StatusNotFound, "视频不存在")
+	limit, ok := queryLimit(c)
+	if !ok {
 		return
 	}
-	// 在URL的查询参数里（?后面的部分）找page这个键，没找到就返回默认值“1”
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 
-	// 调用Service获取所有一级评论和二级评论
-	parentComments, replyMap, err := h.CommentService.GetComments(videoID, page, pageSize)
+	// 调用Service获取一页一级评论和二级评论
+	page, err := h.CommentService.GetComments(optionalUserID(c), videoID, c.Query("cursor"), limit)
+	if errors.Is(err, service.ErrVideoNotFound) {
+		sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCursor) {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+		return
+	}
 	if err != nil {
+		logger.Log.WithError(err).WithField("video_id", videoID).Error("获取评论列表失败")
 		sendErrorResponse(c, http.StatusInternalServerError, "获取评论列表失败") // 500
 		return
 	}
-	response := dto.ToCommentResponses(parentComments, replyMap)
+	response := dto.ToCommentResponses(page.Comments, page.Replies)
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "获取评论列表成功",
-		"data":    response,
+		"message":     "获取评论列表成功",
+		"data":        response,
+		"next_cursor": page.NextCursor,
+		"has_more":    page.HasMore,
 	})
 }
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"Orion_Live/internal/live"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type DanmakuHandler interface {
+	// 直播间的WebSocket连接，收发弹幕
+	ServeWS(c *gin.Context)
+}
+
+type danmakuHandler struct {
+	Hub                 *live.Hub
+	DanmakuService      service.DanmakuService
+	LiveRoomService     service.LiveRoomService
+	LivePresenceService service.LivePresenceService
+	minInterval         time.Duration
+	upgrader            websocket.Upgrader
+}
+
+func NewDanmakuHandler(hub *live.Hub, danmakuService service.DanmakuService, liveRoomService service.LiveRoomService, livePresenceService service.LivePresenceService, minInterval time.Duration) DanmakuHandler {
+	return &danmakuHandler{
+		Hub:                 hub,
+		DanmakuService:      danmakuService,
+		LiveRoomService:     liveRoomService,
+		LivePresenceService: livePresenceService,
+		minInterval:         minInterval,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// 身份靠URL上的token而不是cookie，跨域页面拿不到别人的token，所以不限制Origin
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// clientMessage 客户端发来的消息：弹幕，或者每隔heartbeat_interval一次的心跳
+type clientMessage struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// 直播间WebSocket：1、解析room_id并确认直播间存在 2、从context提取用户（WebSocketAuthMiddleware） 3、升级连接并加入直播间，阻塞到连接断开
+// 连接上的每条弹幕：限制发送频率，交给service校验、推送和落库，失败原因只发给发送者本人
+// 连接建立和每次心跳都会刷新观众的在线状态，心跳时回复在线人数，连接断开时观众离开直播间
+func (h *danmakuHandler) ServeWS(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("room_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的直播间ID") // 400
+		return
+	}
+	if _, err := h.LiveRoomService.GetRoom(roomID); err != nil {
+		if errors.Is(err, service.ErrLiveRoomNotFound) {
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+			return
+		}
+		sendErrorResponse(c, http.StatusInternalServerError, "查找直播间失败") // 500
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	username, _ := c.Get("username")
+	usernameStr, _ := username.(string)
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade失败时已经写好了错误响应
+		logger.Log.WithError(err).WithField("room_id", roomID).Warn("WebSocket升级失败")
+		return
+	}
+	logCtx := logger.Log.WithField("room_id", roomID).WithField("user_id", userID)
+	logCtx.Info("观众进入直播间")
+
+	// 按连接计数，同一个用户开了多个页面时，最后一个页面关闭才不再算在线
+	if err := h.LivePresenceService.Join(roomID, userID); err != nil {
+		logCtx.WithError(err).Warn("记录观众连接失败")
+	}
+	defer func() {
+		if err := h.LivePresenceService.Leave(roomID, userID); err != nil {
+			logCtx.WithError(err).Warn("移除在线观众失败")
+		}
+	}()
+	// 连接建立时先记一次心跳，客户端之后每隔heartbeat_interval发一次
+	if _, err := h.LivePresenceService.Heartbeat(roomID, userID); err != nil && !errors.Is(err, service.ErrRoomNotLive) {
+		logCtx.WithError(err).Warn("记录观众心跳失败")
+	}
+	var lastSent time.Time
+	h.Hub.Serve(conn, roomID, userID, usernameStr, func(client *live.Client, data []byte) {
+		var msg clientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			client.SendEvent(live.Event{Type: live.EventError, Data: "无效的消息"})
+			return
+		}
+		switch msg.Type {
+		case live.MessageHeartbeat:
+			h.heartbeat(client, roomID, userID)
+			return
+		case live.EventDanmaku:
+		default:
+			client.SendEvent(live.Event{Type: live.EventError, Data: "无效的消息"})
+			return
+		}
+		if time.Since(lastSent) < h.minInterval {
+			client.SendEvent(live.Event{Type: live.EventError, Data: "发送太频繁，请稍后再试"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := h.DanmakuService.Send(ctx, roomID, userID, usernameStr, msg.Content); err != nil {
+			switch {
+			case errors.Is(err, service.ErrDanmakuEmpty), errors.Is(err, service.ErrDanmakuTooLong), errors.Is(err, service.ErrRoomNotLive):
+				client.SendEvent(live.Event{Type: live.EventError, Data: err.Error()})
+			default:
+				logCtx.WithError(err).Error("发送弹幕失败")
+				client.SendEvent(live.Event{Type: live.EventError, Data: "发送失败"})
+			}
+			return
+		}
+		lastSent = time.Now()
+	})
+	logCtx.Info("观众离开直播间")
+}
+
+// heartbeat 刷新观众的在线状态，把直播间的在线人数回复给这个连接；直播间没在直播时不算在线，也不报错
+func (h *danmakuHandler) heartbeat(client *live.Client, roomID, userID uint64) {
+	online, err := h.LivePresenceService.Heartbeat(roomID, userID)
+	if err != nil {
+		if !errors.Is(err, service.ErrRoomNotLive) {
+			logger.Log.WithError(err).WithField("room_id", roomID).Warn("记录观众心跳失败")
+		}
+		return
+	}
+	client.SendEvent(live.Event{Type: live.EventOnline, Data: gin.H{
+		"online_count":       online,
+		"heartbeat_interval": int(h.LivePresenceService.HeartbeatInterval().Seconds()),
+	}})
+}
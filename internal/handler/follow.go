@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FollowHandler interface {
+	// POST/DELETE /users/:user_id/follow，关注和取消关注都是幂等的
+	Follow(c *gin.Context)
+	Unfollow(c *gin.Context)
+}
+
+type followHandler struct {
+	FollowService service.FollowService
+}
+
+func NewFollowHandler(followService service.FollowService) FollowHandler {
+	return &followHandler{FollowService: followService}
+}
+
+func (h *followHandler) Follow(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	followeeID, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的用户ID") // 400
+		return
+	}
+	logCtx := logger.Log.WithField("user_id", userID).WithField("followee_id", followeeID)
+	if err := h.FollowService.Follow(userID, followeeID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrFollowSelf):
+			sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+		case errors.Is(err, service.ErrUserNotFound):
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+		default:
+			logCtx.WithError(err).Error("关注失败")
+			sendErrorResponse(c, http.StatusInternalServerError, "关注失败") // 500
+		}
+		return
+	}
+	logCtx.Info("关注成功")
+	c.JSON(http.StatusOK, gin.H{"message": "关注成功"})
+}
+
+func (h *followHandler) Unfollow(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	followeeID, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的用户ID") // 400
+		return
+	}
+	logCtx := logger.Log.WithField("user_id", userID).WithField("followee_id", followeeID)
+	if err := h.FollowService.Unfollow(userID, followeeID); err != nil {
+		logCtx.WithError(err).Error("取消关注失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "取消关注失败") // 500
+		return
+	}
+	logCtx.Info("取消关注成功")
+	c.JSON(http.StatusOK, gin.H{"message": "已取消关注"})
+}
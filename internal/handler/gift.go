@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"Orion_Live/internal/dto"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GiftHandler interface {
+	// 礼物价目表
+	ListGifts(c *gin.Context)
+	// 在直播间送礼
+	SendGift(c *gin.Context)
+}
+
+type giftHandler struct {
+	GiftService service.GiftService
+}
+
+func NewGiftHandler(giftService service.GiftService) GiftHandler {
+	return &giftHandler{GiftService: giftService}
+}
+
+type SendGiftRequest struct {
+	GiftID uint64 `json:"gift_id" binding:"required"`
+	Count  int    `json:"count" binding:"required"`
+	// 客户端为每次送礼生成的唯一ID（比如UUID），超时重试时带上同一个，不会重复扣款
+	RequestID string `json:"request_id" binding:"required"`
+}
+
+func (h *giftHandler) ListGifts(c *gin.Context) {
+	items := h.GiftService.Catalog()
+	response := make([]dto.GiftItemResponse, 0, len(items))
+	for _, item := range items {
+		response = append(response, dto.GiftItemResponse{ID: item.ID, Name: item.Name, Price: item.Price})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": response})
+}
+
+// 送礼：1、解析room_id和参数 2、从context提取用户 3、service层预扣金币并写入outbox 4、返回202，礼物落库后推送到直播间
+func (h *giftHandler) SendGift(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("room_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的直播间ID") // 400
+		return
+	}
+	var req SendGiftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的参数") // 400
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	username := c.GetString("username")
+
+	logCtx := logger.Log.WithField("user_id", userID).WithField("room_id", roomID).WithField("gift_id", req.GiftID)
+	receipt, err := h.GiftService.Send(userID, username, roomID, req.GiftID, req.Count, req.RequestID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrGiftNotFound), errors.Is(err, service.ErrGiftCountInvalid), errors.Is(err, service.ErrRequestIDInvalid), errors.Is(err, service.ErrGiftToSelf):
+			sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+		case errors.Is(err, service.ErrLiveRoomNotFound):
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+		case errors.Is(err, service.ErrRoomNotLive), errors.Is(err, service.ErrGiftTransferRefunded):
+			sendErrorResponse(c, http.StatusConflict, err.Error()) // 409
+		case errors.Is(err, service.ErrInsufficientBalance):
+			sendErrorResponse(c, http.StatusPaymentRequired, err.Error()) // 402
+		default:
+			logCtx.WithError(err).Error("送礼失败")
+			sendErrorResponse(c, http.StatusInternalServerError, "送礼失败") // 500
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{ // 202
+		"message": "礼物已送出",
+		"data": dto.GiftReceiptResponse{
+			TransferID: receipt.TransferID,
+			Status:     receipt.Status,
+			Amount:     receipt.Amount,
+			Balance:    receipt.Balance,
+			Duplicate:  receipt.Duplicate,
+		},
+	})
+}
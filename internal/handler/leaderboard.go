@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"Orion_Live/internal/dto"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LeaderboardHandler interface {
+	// 直播间的送礼贡献榜
+	GetLeaderboard(c *gin.Context)
+}
+
+type leaderboardHandler struct {
+	LeaderboardService service.LeaderboardService
+}
+
+func NewLeaderboardHandler(leaderboardService service.LeaderboardService) LeaderboardHandler {
+	return &leaderboardHandler{LeaderboardService: leaderboardService}
+}
+
+// 查询贡献榜：1、解析room_id和scope（session/daily/total，默认session）、session_id、date、分页参数 2、从context提取用户
+// 3、service层取这一页和当前用户自己的名次
+func (h *leaderboardHandler) GetLeaderboard(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("room_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的直播间ID") // 400
+		return
+	}
+	var sessionID uint64
+	if raw := c.Query("session_id"); raw != "" {
+		if sessionID, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			sendErrorResponse(c, http.StatusBadRequest, "无效的直播场次ID") // 400
+			return
+		}
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	board, err := h.LeaderboardService.Ranking(service.LeaderboardQuery{
+		RoomID:    roomID,
+		Scope:     c.DefaultQuery("scope", model.LeaderboardSession),
+		SessionID: sessionID,
+		Date:      c.Query("date"),
+		UserID:    userID,
+		Page:      page,
+		PageSize:  pageSize,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrLeaderboardScopeInvalid), errors.Is(err, service.ErrLeaderboardDateInvalid):
+			sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+		case errors.Is(err, service.ErrLiveRoomNotFound), errors.Is(err, service.ErrLiveSessionNotFound):
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+		default:
+			logger.Log.WithError(err).WithField("room_id", roomID).Error("获取贡献榜失败")
+			sendErrorResponse(c, http.StatusInternalServerError, "获取贡献榜失败") // 500
+		}
+		return
+	}
+	response := dto.LeaderboardResponse{
+		Scope:     board.Scope,
+		RoomID:    board.RoomID,
+		SessionID: board.SessionID,
+		Date:      board.Date,
+		Total:     board.Total,
+		Snapshot:  board.Snapshot,
+		Items:     make([]dto.LeaderboardEntryResponse, 0, len(board.Entries)),
+		Mine:      dto.LeaderboardEntryResponse(board.Mine),
+	}
+	for _, e := range board.Entries {
+		response.Items = append(response.Items, dto.LeaderboardEntryResponse(e))
+	}
+	c.JSON(http.StatusOK, gin.H{"data": response})
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"Orion_Live/internal/service"
 	"Orion_Live/pkg/logger"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -46,6 +47,11 @@ func (h *likeHandler) LikeVideo(c *gin.Context) {
 	err = h.LikeService.LikeVideo(userID, videoID)
 	if err != nil {
 		logCtx.WithError(err).Error("点赞失败")
+		if errors.Is(err, service.ErrVideoNotFound) {
+			// 看不到的视频（草稿、私密等）也当作不存在
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+			return
+		}
 		// 这里的 err 是 service 层返回的业务逻辑错误，可以安全地展示给用户
 		sendErrorResponse(c, http.StatusBadRequest, err.Error())
 		return
@@ -76,6 +82,10 @@ func (h *likeHandler) UnlikeVideo(c *gin.Context) {
 	err = h.LikeService.UnlikeVideo(userID, videoID)
 	if err != nil {
 		logCtx.WithError(err).Error("取消点赞失败")
+		if errors.Is(err, service.ErrVideoNotFound) {
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+			return
+		}
 		sendErrorResponse(c, http.StatusBadRequest, err.Error())
 		return
 	}
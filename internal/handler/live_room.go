@@ -0,0 +1,356 @@
+package handler
+
+import (
+	"Orion_Live/internal/dto"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LiveRoomHandler interface {
+	CreateRoom(c *gin.Context)
+	GetMyRoom(c *gin.Context)
+	RotateStreamKey(c *gin.Context)
+	StartBroadcast(c *gin.Context)
+	EndBroadcast(c *gin.Context)
+	GetStats(c *gin.Context)
+
+	GetRoom(c *gin.Context)
+	ListLive(c *gin.Context)
+	// 观众心跳，用于统计在线人数；连着弹幕WebSocket的观众不需要单独调用
+	Heartbeat(c *gin.Context)
+
+	// RTMP服务器的回调
+	OnPublish(c *gin.Context)
+	OnDone(c *gin.Context)
+	OnRecordDone(c *gin.Context)
+}
+
+type liveRoomHandler struct {
+	LiveRoomService     service.LiveRoomService
+	LivePresenceService service.LivePresenceService
+	callbackToken       string
+}
+
+func NewLiveRoomHandler(liveRoomService service.LiveRoomService, livePresenceService service.LivePresenceService, callbackToken string) LiveRoomHandler {
+	return &liveRoomHandler{LiveRoomService: liveRoomService, LivePresenceService: livePresenceService, callbackToken: callbackToken}
+}
+
+type CreateLiveRoomRequest struct {
+	Title string `json:"title" binding:"required"`
+}
+
+// 创建直播间：1、解析标题和userID 2、service层创建直播间并生成推流密钥 3、把推流地址和密钥返回给主播
+func (h *liveRoomHandler) CreateRoom(c *gin.Context) {
+	var req CreateLiveRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Log.WithError(err).Error("创建直播间参数解析失败")
+		sendErrorResponse(c, http.StatusBadRequest, "无效的参数") // 400
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	logCtx := logger.Log.WithField("user_id", userID)
+	room, err := h.LiveRoomService.CreateRoom(userID, req.Title)
+	if err != nil {
+		if errors.Is(err, service.ErrLiveRoomExists) {
+			sendErrorResponse(c, http.StatusConflict, err.Error()) // 409
+			return
+		}
+		logCtx.WithError(err).Error("创建直播间失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "创建直播间失败") // 500
+		return
+	}
+	logCtx.WithField("room_id", room.ID).Info("直播间创建成功")
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "直播间创建成功",
+		"data":    h.ownerResponse(room),
+	})
+}
+
+func (h *liveRoomHandler) GetMyRoom(c *gin.Context) {
+	h.ownerAction(c, "获取直播间", h.LiveRoomService.GetMyRoom)
+}
+
+func (h *liveRoomHandler) RotateStreamKey(c *gin.Context) {
+	h.ownerAction(c, "重新生成推流密钥", h.LiveRoomService.RotateStreamKey)
+}
+
+func (h *liveRoomHandler) StartBroadcast(c *gin.Context) {
+	h.ownerAction(c, "开播", h.LiveRoomService.StartBroadcast)
+}
+
+func (h *liveRoomHandler) EndBroadcast(c *gin.Context) {
+	h.ownerAction(c, "下播", h.LiveRoomService.EndBroadcast)
+}
+
+// ownerAction 主播对自己直播间的操作：1、从context提取userID 2、执行操作 3、没有直播间返回404，成功返回带推流密钥的直播间
+func (h *liveRoomHandler) ownerAction(c *gin.Context, action string, fn func(streamerID uint64) (*model.LiveRoom, error)) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	logCtx := logger.Log.WithField("user_id", userID).WithField("action", action)
+	room, err := fn(userID)
+	if err != nil {
+		if errors.Is(err, service.ErrLiveRoomNotFound) {
+			sendErrorResponse(c, http.StatusNotFound, "您还没有创建直播间") // 404
+			return
+		}
+		logCtx.WithError(err).Error("直播间操作失败")
+		sendErrorResponse(c, http.StatusInternalServerError, action+"失败") // 500
+		return
+	}
+	logCtx.WithField("room_id", room.ID).WithField("status", room.Status).Info("直播间操作成功")
+	c.JSON(http.StatusOK, gin.H{
+		"message": action + "成功",
+		"data":    h.ownerResponse(room),
+	})
+}
+
+func (h *liveRoomHandler) ownerResponse(room *model.LiveRoom) dto.LiveRoomOwnerResponse {
+	publishURL, streamName := h.LiveRoomService.PublishAddress(room)
+	return dto.ToLiveRoomOwnerResponse(room, h.LiveRoomService.PlayURL(room), publishURL, streamName)
+}
+
+// 主播的观众统计：1、从context提取userID 2、service层读取实时数据和最近的场次 3、没有直播间返回404
+func (h *liveRoomHandler) GetStats(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	stats, err := h.LiveRoomService.GetStats(userID)
+	if err != nil {
+		if errors.Is(err, service.ErrLiveRoomNotFound) {
+			sendErrorResponse(c, http.StatusNotFound, "您还没有创建直播间") // 404
+			return
+		}
+		logger.Log.WithError(err).WithField("user_id", userID).Error("获取直播统计失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "获取直播统计失败") // 500
+		return
+	}
+	response := dto.LiveRoomStatsResponse{
+		RoomID:   stats.Room.ID,
+		Status:   stats.Room.Status,
+		Sessions: make([]dto.LiveSessionResponse, 0, len(stats.Sessions)),
+	}
+	if stats.Room.Status == model.LiveRoomLive {
+		response.Current = &dto.LiveCurrentStats{
+			SessionID:     stats.Room.SessionID,
+			Online:        stats.Online,
+			PeakViewers:   stats.PeakViewers,
+			UniqueViewers: stats.UniqueViewers,
+		}
+	}
+	for i := range stats.Sessions {
+		response.Sessions = append(response.Sessions, dto.ToLiveSessionResponse(&stats.Sessions[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"data": response})
+}
+
+// onlineCounts 直播间列表附带的在线人数，Redis出错时只记录日志，人数显示为0
+func (h *liveRoomHandler) onlineCounts(rooms []model.LiveRoom) map[uint64]int64 {
+	ids := make([]uint64, 0, len(rooms))
+	for i := range rooms {
+		if rooms[i].Status == model.LiveRoomLive {
+			ids = append(ids, rooms[i].ID)
+		}
+	}
+	counts, err := h.LivePresenceService.OnlineCounts(ids)
+	if err != nil {
+		logger.Log.WithError(err).Warn("获取直播间在线人数失败")
+		return map[uint64]int64{}
+	}
+	return counts
+}
+
+func (h *liveRoomHandler) GetRoom(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("room_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的直播间ID") // 400
+		return
+	}
+	room, err := h.LiveRoomService.GetRoom(roomID)
+	if err != nil {
+		if errors.Is(err, service.ErrLiveRoomNotFound) {
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+			return
+		}
+		logger.Log.WithError(err).WithField("room_id", roomID).Error("查找直播间失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "查找直播间失败") // 500
+		return
+	}
+	resp := dto.ToLiveRoomResponse(room, h.LiveRoomService.PlayURL(room))
+	resp.OnlineCount = h.onlineCounts([]model.LiveRoom{*room})[room.ID]
+	c.JSON(http.StatusOK, gin.H{"data": resp})
+}
+
+// 获取正在直播的直播间列表，按开播时间倒序分页
+func (h *liveRoomHandler) ListLive(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	rooms, err := h.LiveRoomService.ListLive(page, pageSize)
+	if err != nil {
+		logger.Log.WithError(err).Error("获取直播列表失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "获取直播列表失败") // 500
+		return
+	}
+	counts := h.onlineCounts(rooms)
+	response := make([]dto.LiveRoomResponse, 0, len(rooms))
+	for i := range rooms {
+		resp := dto.ToLiveRoomResponse(&rooms[i], h.LiveRoomService.PlayURL(&rooms[i]))
+		resp.OnlineCount = counts[rooms[i].ID]
+		response = append(response, resp)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取直播列表成功",
+		"data":    response,
+	})
+}
+
+// 观众心跳：1、解析room_id和userID 2、记录心跳 3、返回在线人数和下一次心跳的间隔（秒）
+func (h *liveRoomHandler) Heartbeat(c *gin.Context) {
+	roomID, err := strconv.ParseUint(c.Param("room_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的直播间ID") // 400
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	online, err := h.LivePresenceService.Heartbeat(roomID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrLiveRoomNotFound):
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+		case errors.Is(err, service.ErrRoomNotLive):
+			sendErrorResponse(c, http.StatusConflict, err.Error()) // 409
+		default:
+			logger.Log.WithError(err).WithField("room_id", roomID).Error("记录观众心跳失败")
+			sendErrorResponse(c, http.StatusInternalServerError, "心跳失败") // 500
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"online_count":       online,
+			"heartbeat_interval": int(h.LivePresenceService.HeartbeatInterval().Seconds()),
+		},
+	})
+}
+
+// rtmpCallback RTMP服务器回调中我们关心的字段：流名就是房间ID，推流地址上的?key=是推流密钥，Path只有录制完成的回调才有
+type rtmpCallback struct {
+	Stream string
+	Key    string
+	Path   string
+}
+
+// parseRTMPCallback 兼容两种RTMP服务器：nginx-rtmp以表单提交，流名在name字段，推流地址上的参数也作为表单字段一起提交，录制文件在path字段；
+// SRS以JSON提交，流名在stream字段，推流地址上的参数原样放在param字段（"?key=..."），录制文件在file字段，可能是相对cwd的路径
+func parseRTMPCallback(c *gin.Context) (rtmpCallback, error) {
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		var body struct {
+			Stream string `json:"stream"`
+			Param  string `json:"param"`
+			File   string `json:"file"`
+			Cwd    string `json:"cwd"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			return rtmpCallback{}, err
+		}
+		params, _ := url.ParseQuery(strings.TrimPrefix(body.Param, "?"))
+		file := body.File
+		if file != "" && !path.IsAbs(file) && body.Cwd != "" {
+			file = path.Join(body.Cwd, file)
+		}
+		return rtmpCallback{Stream: body.Stream, Key: params.Get("key"), Path: file}, nil
+	}
+	return rtmpCallback{Stream: c.PostForm("name"), Key: c.PostForm("key"), Path: c.PostForm("path")}, nil
+}
+
+// rtmpAllowed 回调地址必须带上和callback_token相同的?token=；没有配置callback_token时拒绝所有回调，不能让外部随意开播/下播
+func (h *liveRoomHandler) rtmpAllowed(c *gin.Context) bool {
+	if h.callbackToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(h.callbackToken)) == 1
+}
+
+// 推流鉴权回调：1、校验回调token 2、解析流名（房间ID）和推流密钥 3、密钥正确则开播
+// nginx-rtmp把2xx当作允许推流，SRS要求返回200且code为0；其他状态码两者都会断开推流
+func (h *liveRoomHandler) OnPublish(c *gin.Context) {
+	h.rtmpCallback(c, "on_publish", func(cb rtmpCallback, roomID uint64) error {
+		return h.LiveRoomService.OnPublish(roomID, cb.Key)
+	})
+}
+
+// 推流结束回调：下播，房间不存在或没在直播时也返回成功，避免RTMP服务器反复重试
+func (h *liveRoomHandler) OnDone(c *gin.Context) {
+	h.rtmpCallback(c, "on_done", func(cb rtmpCallback, roomID uint64) error {
+		err := h.LiveRoomService.OnDone(roomID)
+		if errors.Is(err, service.ErrLiveRoomNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// 录制完成回调（nginx-rtmp的on_record_done，SRS的on_dvr）：生成直播回放
+// 录制文件路径不对是配置问题，重试也不会成功，和房间不存在一样记录日志后返回成功
+func (h *liveRoomHandler) OnRecordDone(c *gin.Context) {
+	h.rtmpCallback(c, "on_record_done", func(cb rtmpCallback, roomID uint64) error {
+		_, err := h.LiveRoomService.OnRecordDone(roomID, cb.Path)
+		if errors.Is(err, service.ErrLiveRoomNotFound) || errors.Is(err, service.ErrLiveSessionNotFound) || errors.Is(err, service.ErrRecordPathInvalid) {
+			logger.Log.WithError(err).WithField("room_id", roomID).WithField("path", cb.Path).Warn("录制文件没有生成回放")
+			return nil
+		}
+		return err
+	})
+}
+
+func (h *liveRoomHandler) rtmpCallback(c *gin.Context, call string, fn func(cb rtmpCallback, roomID uint64) error) {
+	logCtx := logger.Log.WithField("call", call).WithField("ip", c.ClientIP())
+	if !h.rtmpAllowed(c) {
+		logCtx.Warn("RTMP回调token不正确")
+		sendErrorResponse(c, http.StatusForbidden, "forbidden") // 403
+		return
+	}
+	cb, err := parseRTMPCallback(c)
+	if err != nil {
+		logCtx.WithError(err).Warn("RTMP回调参数解析失败")
+		sendErrorResponse(c, http.StatusBadRequest, "无效的参数") // 400
+		return
+	}
+	logCtx = logCtx.WithField("stream", cb.Stream)
+	roomID, err := strconv.ParseUint(cb.Stream, 10, 64)
+	if err != nil {
+		logCtx.Warn("RTMP流名不是直播间ID")
+		sendErrorResponse(c, http.StatusForbidden, service.ErrStreamKeyInvalid.Error()) // 403
+		return
+	}
+	if err := fn(cb, roomID); err != nil {
+		if errors.Is(err, service.ErrStreamKeyInvalid) {
+			logCtx.Warn("推流密钥无效，拒绝推流")
+			sendErrorResponse(c, http.StatusForbidden, err.Error()) // 403
+			return
+		}
+		logCtx.WithError(err).Error("处理RTMP回调失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "处理失败") // 500
+		return
+	}
+	logCtx.Info("RTMP回调处理成功")
+	c.JSON(http.StatusOK, gin.H{"code": 0})
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/storage"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MediaHandler interface {
+	// ServeMedia GET/HEAD /media/*key，从存储中读取文件，支持Range断点续传和拖动进度
+	ServeMedia(c *gin.Context)
+}
+
+// 不需要签名就能访问的key前缀，封面要能被直接引用，视频文件和HLS必须用签名地址
+var publicMediaPrefixes = []string{"covers/"}
+
+type mediaHandler struct {
+	Store  storage.Backend
+	Signer *storage.Signer
+	// 返回文件的写超时，代替server全局的write_timeout，下载整个MP4、长的Range响应都要在这段时间内传完
+	writeTimeout time.Duration
+}
+
+func NewMediaHandler(store storage.Backend, signer *storage.Signer, writeTimeout time.Duration) MediaHandler {
+	return &mediaHandler{Store: store, Signer: signer, writeTimeout: writeTimeout}
+}
+
+// 读取文件：1、签名地址校验签名和过期时间，其他地址只能访问封面 2、Stat拿到大小、类型和版本
+// 3、延长写超时，http.ServeContent处理Range、If-Range、If-None-Match、If-Modified-Since，返回206/304/416
+func (h *mediaHandler) ServeMedia(c *gin.Context) {
+	p := strings.TrimPrefix(c.Param("key"), "/")
+	key := p
+	signed := storage.IsSigned(p)
+	if signed {
+		var err error
+		key, err = h.Signer.Verify(p, time.Now())
+		if errors.Is(err, storage.ErrSignatureExpired) {
+			sendErrorResponse(c, http.StatusForbidden, "播放地址已过期，请刷新后重试") // 403
+			return
+		}
+		if err != nil {
+			sendErrorResponse(c, http.StatusForbidden, "播放地址无效") // 403
+			return
+		}
+	} else if !isPublicMedia(key) {
+		sendErrorResponse(c, http.StatusForbidden, "播放地址无效") // 403
+		return
+	}
+
+	ctx := c.Request.Context()
+	info, err := h.Store.Stat(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		sendErrorResponse(c, http.StatusNotFound, "文件不存在") // 404
+		return
+	}
+	if err != nil {
+		logger.Log.WithError(err).WithField("key", key).Error("读取文件信息失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "读取文件失败") // 500
+		return
+	}
+	f, err := h.Store.Open(ctx, key)
+	if err != nil {
+		logger.Log.WithError(err).WithField("key", key).Error("打开文件失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "读取文件失败") // 500
+		return
+	}
+	defer f.Close()
+
+	header := c.Writer.Header()
+	if info.ContentType != "" {
+		header.Set("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		header.Set("ETag", `"`+strings.Trim(info.ETag, `"`)+`"`)
+	}
+	if signed {
+		// 签名地址是发给某个用户的，共享缓存（CDN、代理）不要缓存
+		header.Set("Cache-Control", "private")
+	}
+	extendDeadline(c, 0, h.writeTimeout)
+	http.ServeContent(c.Writer, c.Request, "", info.ModTime, f)
+}
+
+func isPublicMedia(key string) bool {
+	for _, prefix := range publicMediaPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"Orion_Live/internal/dto"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RedPacketHandler interface {
+	// 主播在自己的直播间发红包
+	SendRedPacket(c *gin.Context)
+	// 抢红包
+	GrabRedPacket(c *gin.Context)
+	// 红包详情和手气
+	GetRedPacket(c *gin.Context)
+}
+
+type redPacketHandler struct {
+	RedPacketService service.RedPacketService
+}
+
+func NewRedPacketHandler(redPacketService service.RedPacketService) RedPacketHandler {
+	return &redPacketHandler{RedPacketService: redPacketService}
+}
+
+type SendRedPacketRequest struct {
+	Amount int64 `json:"amount" binding:"required"`
+	Count  int   `json:"count" binding:"required"`
+	// 客户端为每个红包生成的唯一ID，超时重试时带上同一个，不会重复扣款
+	RequestID string `json:"request_id" binding:"required"`
+}
+
+// 发红包：1、解析参数 2、从context提取主播 3、service层扣款、拆分份额并推送到直播间 4、返回201
+func (h *redPacketHandler) SendRedPacket(c *gin.Context) {
+	var req SendRedPacketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的参数") // 400
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	receipt, err := h.RedPacketService.Send(userID, req.Amount, req.Count, req.RequestID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrRedPacketAmountInvalid), errors.Is(err, service.ErrRequestIDInvalid):
+			sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+		case errors.Is(err, service.ErrLiveRoomNotFound):
+			sendErrorResponse(c, http.StatusNotFound, "您还没有创建直播间") // 404
+		case errors.Is(err, service.ErrRoomNotLive):
+			sendErrorResponse(c, http.StatusConflict, err.Error()) // 409
+		case errors.Is(err, service.ErrInsufficientBalance):
+			sendErrorResponse(c, http.StatusPaymentRequired, err.Error()) // 402
+		default:
+			logger.Log.WithError(err).WithField("user_id", userID).Error("发红包失败")
+			sendErrorResponse(c, http.StatusInternalServerError, "发红包失败") // 500
+		}
+		return
+	}
+	status := http.StatusCreated
+	if receipt.Duplicate {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{
+		"message":   "红包已发出",
+		"duplicate": receipt.Duplicate,
+		"data":      dto.ToRedPacketResponse(receipt.Packet, nil),
+	})
+}
+
+// 抢红包：只走Redis，抢到的金币稍后入账
+func (h *redPacketHandler) GrabRedPacket(c *gin.Context) {
+	packetID := c.Param("packet_id")
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	result, err := h.RedPacketService.Grab(packetID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrRedPacketNotFound):
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+		case errors.Is(err, service.ErrRedPacketEmpty):
+			sendErrorResponse(c, http.StatusConflict, err.Error()) // 409
+		case errors.Is(err, service.ErrRedPacketExpired):
+			sendErrorResponse(c, http.StatusGone, err.Error()) // 410
+		default:
+			logger.Log.WithError(err).WithField("packet_id", packetID).WithField("user_id", userID).Error("抢红包失败")
+			sendErrorResponse(c, http.StatusInternalServerError, "抢红包失败") // 500
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"packet_id": result.PacketID,
+		"amount":    result.Amount,
+		"duplicate": result.Duplicate,
+	}})
+}
+
+func (h *redPacketHandler) GetRedPacket(c *gin.Context) {
+	packetID := c.Param("packet_id")
+	packet, grabs, err := h.RedPacketService.Get(packetID)
+	if err != nil {
+		if errors.Is(err, service.ErrRedPacketNotFound) {
+			sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+			return
+		}
+		logger.Log.WithError(err).WithField("packet_id", packetID).Error("获取红包失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "获取红包失败") // 500
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": dto.ToRedPacketResponse(packet, grabs)})
+}
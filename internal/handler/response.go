@@ -1,6 +1,14 @@
 package handler
 
-import "github.com/gin-gonic/gin"
+import (
+	"Orion_Live/pkg/logger"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
 
 // ErrorResponse 定义了标准的API错误响应结构
 type ErrorResponse struct {
@@ -11,3 +19,60 @@ type ErrorResponse struct {
 func sendErrorResponse(c *gin.Context, code int, message string) {
 	c.AbortWithStatusJSON(code, ErrorResponse{Error: message})
 }
+
+// currentUserID 从context中取出AuthMiddleware放入的用户ID，jwt.MapClaims中的数字会被解析为float64
+// 没有时已经返回了401，调用方直接return即可
+func currentUserID(c *gin.Context) (uint64, bool) {
+	userIDFloat, exists := c.Get("userID")
+	if !exists {
+		sendErrorResponse(c, http.StatusUnauthorized, "用户未认证")
+		return 0, false
+	}
+	userID, ok := userIDFloat.(float64)
+	if !ok {
+		sendErrorResponse(c, http.StatusUnauthorized, "用户未认证")
+		return 0, false
+	}
+	return uint64(userID), true
+}
+
+// optionalUserID 不登录也能访问的接口中，OptionalAuthMiddleware放入的用户ID，没有登录时为0
+func optionalUserID(c *gin.Context) uint64 {
+	userIDFloat, _ := c.Get("userID")
+	if userID, ok := userIDFloat.(float64); ok {
+		return uint64(userID)
+	}
+	return 0
+}
+
+// queryLimit 解析列表接口的?limit=，没传时返回0，由service使用默认值，超过上限时由service按上限处理
+// 不是数字时已经返回了400，调用方直接return即可
+func queryLimit(c *gin.Context) (int, bool) {
+	raw := c.Query("limit")
+	if raw == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的limit") // 400
+		return 0, false
+	}
+	return limit, true
+}
+
+// extendDeadline 放宽这一个请求的读、写超时：server.read_timeout/write_timeout是按普通API设置的，
+// 大文件的上传和下载要单独延长，否则传到一半连接就被断开；为0的不修改，底层连接不支持时（比如测试）忽略
+func extendDeadline(c *gin.Context, read, write time.Duration) {
+	rc := http.NewResponseController(c.Writer)
+	now := time.Now()
+	if read > 0 {
+		if err := rc.SetReadDeadline(now.Add(read)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			logger.Log.WithError(err).Warn("延长读超时失败")
+		}
+	}
+	if write > 0 {
+		if err := rc.SetWriteDeadline(now.Add(write)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			logger.Log.WithError(err).Warn("延长写超时失败")
+		}
+	}
+}
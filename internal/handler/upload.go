@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"Orion_Live/internal/dto"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UploadHandler interface {
+	// 一次性上传（multipart），适合封面和小视频
+	Upload(c *gin.Context)
+	// 分片上传：创建 → 逐片上传 → 完成，中途断开后查询进度，只补传缺少的分片
+	CreateUpload(c *gin.Context)
+	GetUpload(c *gin.Context)
+	UploadChunk(c *gin.Context)
+	CompleteUpload(c *gin.Context)
+}
+
+type uploadHandler struct {
+	UploadService service.UploadService
+	Signer        dto.PlaybackSigner
+	// 一次性上传的请求体上限，比文件大小上限多留出multipart表单本身的开销
+	maxRequestSize int64
+	// 上传请求读取请求体、返回响应的超时，代替server全局的read_timeout/write_timeout
+	bodyTimeout time.Duration
+}
+
+func NewUploadHandler(uploadService service.UploadService, signer dto.PlaybackSigner, maxFileSize int64, bodyTimeout time.Duration) UploadHandler {
+	return &uploadHandler{UploadService: uploadService, Signer: signer, maxRequestSize: maxFileSize + 1<<20, bodyTimeout: bodyTimeout}
+}
+
+// 一次性上传：1、限制请求体大小，解析表单中的kind和file 2、service层校验格式、计算哈希去重并写入存储 3、返回文件ID
+func (h *uploadHandler) Upload(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	extendDeadline(c, h.bodyTimeout, h.bodyTimeout)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxRequestSize)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			sendErrorResponse(c, http.StatusRequestEntityTooLarge, service.ErrFileTooLarge.Error()) // 413
+			return
+		}
+		sendErrorResponse(c, http.StatusBadRequest, "缺少文件") // 400
+		return
+	}
+	kind := c.DefaultPostForm("kind", model.MediaVideo)
+	f, err := header.Open()
+	if err != nil {
+		logger.Log.WithError(err).Error("打开上传的文件失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "上传失败") // 500
+		return
+	}
+	defer f.Close()
+	file, err := h.UploadService.Upload(userID, kind, f, header.Size)
+	if err != nil {
+		h.sendUploadError(c, userID, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "上传成功",
+		"data":    dto.ToMediaFileResponse(file, h.Signer),
+	})
+}
+
+type CreateUploadRequest struct {
+	Kind string `json:"kind" binding:"required"`
+	Size int64  `json:"size" binding:"required"`
+}
+
+func (h *uploadHandler) CreateUpload(c *gin.Context) {
+	var req CreateUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的参数") // 400
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	session, err := h.UploadService.CreateUpload(userID, req.Kind, req.Size)
+	if err != nil {
+		h.sendUploadError(c, userID, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": toUploadSessionResponse(session)})
+}
+
+func (h *uploadHandler) GetUpload(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	session, err := h.UploadService.GetUpload(userID, c.Param("upload_id"))
+	if err != nil {
+		h.sendUploadError(c, userID, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": toUploadSessionResponse(session)})
+}
+
+// 上传分片：请求体就是分片的原始字节，Content-Length必须等于这一片的大小
+func (h *uploadHandler) UploadChunk(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, service.ErrChunkInvalid.Error()) // 400
+		return
+	}
+	if c.Request.ContentLength <= 0 {
+		sendErrorResponse(c, http.StatusLengthRequired, "缺少Content-Length") // 411
+		return
+	}
+	extendDeadline(c, h.bodyTimeout, h.bodyTimeout)
+	err = h.UploadService.UploadChunk(userID, c.Param("upload_id"), index, c.Request.Body, c.Request.ContentLength)
+	if err != nil {
+		h.sendUploadError(c, userID, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *uploadHandler) CompleteUpload(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	file, err := h.UploadService.CompleteUpload(userID, c.Param("upload_id"))
+	if err != nil {
+		h.sendUploadError(c, userID, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "上传成功",
+		"data":    dto.ToMediaFileResponse(file, h.Signer),
+	})
+}
+
+func (h *uploadHandler) sendUploadError(c *gin.Context, userID uint64, err error) {
+	switch {
+	case errors.Is(err, service.ErrMediaKindInvalid), errors.Is(err, service.ErrFileEmpty),
+		errors.Is(err, service.ErrChunkInvalid), errors.Is(err, service.ErrFileSizeMismatch):
+		sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+	case errors.Is(err, service.ErrUploadNotFound):
+		sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+	case errors.Is(err, service.ErrUploadIncomplete):
+		sendErrorResponse(c, http.StatusConflict, err.Error()) // 409
+	case errors.Is(err, service.ErrFileTooLarge):
+		sendErrorResponse(c, http.StatusRequestEntityTooLarge, err.Error()) // 413
+	case errors.Is(err, service.ErrFileTypeInvalid):
+		sendErrorResponse(c, http.StatusUnsupportedMediaType, err.Error()) // 415
+	default:
+		logger.Log.WithError(err).WithField("user_id", userID).Error("上传失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "上传失败") // 500
+	}
+}
+
+func toUploadSessionResponse(session *repository.UploadSession) dto.UploadSessionResponse {
+	received := session.Received
+	if received == nil {
+		received = []int{}
+	}
+	return dto.UploadSessionResponse{
+		UploadID:  session.ID,
+		Kind:      session.Kind,
+		Size:      session.Size,
+		ChunkSize: session.ChunkSize,
+		Chunks:    session.Chunks,
+		Received:  received,
+		ExpiresAt: session.ExpiresAt,
+		FileID:    session.FileID,
+	}
+}
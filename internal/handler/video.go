@@ -4,8 +4,11 @@ import (
 	"Orion_Live/internal/dto"
 	"Orion_Live/internal/service"
 	"Orion_Live/pkg/logger"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,19 +18,32 @@ type VideoHandler interface {
 
 	GetVideoByID(c *gin.Context)
 	GetFeed(c *gin.Context)
+
+	// 作者修改、删除自己的视频，修改必须带If-Match
+	UpdateVideo(c *gin.Context)
+	DeleteVideo(c *gin.Context)
 }
 
 type videoHandler struct {
 	VideoService service.VideoService
+	// 播放地址带签名、会过期，防止盗链
+	Signer dto.PlaybackSigner
 }
 
-func NewVideoHandler(videoService service.VideoService) VideoHandler {
-	return &videoHandler{VideoService: videoService}
+func NewVideoHandler(videoService service.VideoService, signer dto.PlaybackSigner) VideoHandler {
+	return &videoHandler{VideoService: videoService, Signer: signer}
 }
 
 type CreateVideoRequest struct {
 	Title       string `json:"title" binding:"required"`
 	Description string `json:"description"`
+	// 先通过上传接口拿到文件ID，封面可以不传
+	VideoFileID uint64 `json:"video_file_id" binding:"required"`
+	CoverFileID uint64 `json:"cover_file_id"`
+	// 都不传就是公开、立即发布；publish_status为draft时存为草稿，传了publish_at就是定时发布（RFC3339）
+	Visibility    *string    `json:"visibility"`
+	PublishStatus *string    `json:"publish_status"`
+	PublishAt     *time.Time `json:"publish_at"`
 }
 
 // 创建视频：1、提取URL的Body和context中的userID 2、service层发布视频 3、将返回的视频结构通过dto传回
@@ -50,8 +66,16 @@ func (h *videoHandler) CreateVideo(c *gin.Context) {
 	logCtx := logger.Log.WithField("author_id", authorID)
 	logCtx.Info("开始处理发布视频请求")
 
-	video, err := h.VideoService.CreateVideo(authorID, req.Title, req.Description)
+	video, err := h.VideoService.CreateVideo(authorID, req.Title, req.Description, req.VideoFileID, req.CoverFileID, service.VideoPublishing{
+		Visibility: req.Visibility,
+		Status:     req.PublishStatus,
+		PublishAt:  req.PublishAt,
+	})
 	if err != nil {
+		if errors.Is(err, service.ErrMediaFileNotFound) || isPublishingError(err) {
+			sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+			return
+		}
 		logCtx.WithError(err).Error("发布视频业务处理失败")
 		sendErrorResponse(c, http.StatusInternalServerError, "发布视频失败")
 		return
@@ -60,15 +84,17 @@ func (h *videoHandler) CreateVideo(c *gin.Context) {
 	logCtx.WithField("video_id", video.ID).Info("视频发布成功")
 
 	// 使用DTO转换函数，来构建一个干净、安全的响应
-	response := dto.ToVideoResponse(video)
+	response := dto.ToVideoResponse(video, h.Signer)
+	c.Header("ETag", videoETag(video.Version))
 
 	c.JSON(http.StatusCreated, gin.H{ // 使用201 Created状态码，更符合RESTful规范
-		"message": "视频发布成功",
+		"message": "视频发布成功，转码完成后就能观看",
 		"data":    response,
 	})
 
 }
 
+// 查找视频：没登录也能看，带了token时作者能看到自己的草稿、私密视频，粉丝能看到仅粉丝可见的视频
 func (h *videoHandler) GetVideoByID(c *gin.Context) {
 	videoIDstr := c.Param("video_id")
 	videoID, err := strconv.ParseUint(videoIDstr, 10, 64)
@@ -78,7 +104,7 @@ func (h *videoHandler) GetVideoByID(c *gin.Context) {
 	}
 	logCtx := logger.Log.WithField("video_id", videoID)
 	logCtx.Info("开始处理查找视频请求")
-	video, err := h.VideoService.GetVideoByID(videoID)
+	video, err := h.VideoService.GetVideoByID(optionalUserID(c), videoID)
 	if err != nil {
 		// GetVideoByID 失败通常意味着资源不存在
 		logCtx.WithError(err).Warn("查找视频失败")
@@ -86,18 +112,35 @@ func (h *videoHandler) GetVideoByID(c *gin.Context) {
 		return
 	}
 
-	response := dto.ToVideoResponse(video)
+	response := dto.ToVideoResponse(video, h.Signer)
+	c.Header("ETag", videoETag(video.Version))
+	// 直播回放带上弹幕时间轴，读取失败时仍然返回视频本身
+	session, danmakus, err := h.VideoService.GetReplay(video)
+	if err != nil {
+		logCtx.WithError(err).Error("获取回放弹幕失败")
+	} else if session != nil {
+		response.Replay = dto.ToReplayResponse(session, danmakus)
+	}
 	c.JSON(http.StatusOK, gin.H{"data": response})
 }
 
 // 可以无限向下滑动、不断出现新内容的主界面，就是最典型的Feed流，就是视频的元数据
-// 获取视频Feed流：1、将请求附上用户IP，进行问题溯源 2、通过service层请求Feed流 3.dto层借助视频响应结构正确安全地返回大量Feed流
+// 获取视频Feed流：1、将请求附上用户IP，进行问题溯源 2、带着?cursor=和?limit=通过service层请求一页Feed流
+// 3.dto层借助视频响应结构正确安全地返回，has_more时客户端用next_cursor请求下一页
 func (h *videoHandler) GetFeed(c *gin.Context) {
 	// 攻击溯源，用户分析，问题排查
 	logCtx := logger.Log.WithField("ip", c.ClientIP())
 	logCtx.Info("开始处理获取Feed流请求")
 
-	videos, err := h.VideoService.GetFeed(20)
+	limit, ok := queryLimit(c)
+	if !ok {
+		return
+	}
+	page, err := h.VideoService.GetFeed(c.Query("cursor"), limit)
+	if errors.Is(err, service.ErrInvalidCursor) {
+		sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+		return
+	}
 	if err != nil {
 		logCtx.WithError(err).Error("获取Feed流业务处理失败")
 		sendErrorResponse(c, http.StatusInternalServerError, "获取视频流失败")
@@ -105,14 +148,147 @@ func (h *videoHandler) GetFeed(c *gin.Context) {
 	}
 
 	// 将数据库模型列表转换为API响应模型列表
-	var response []dto.VideoResponse
-	for _, video := range videos {
-		response = append(response, dto.ToVideoResponse(&video))
+	response := make([]dto.VideoResponse, 0, len(page.Videos))
+	for _, video := range page.Videos {
+		response = append(response, dto.ToVideoResponse(&video, h.Signer))
 	}
 
-	logCtx.WithField("count", len(response)).Info("成功获取Feed流")
+	logCtx.WithField("count", len(response)).WithField("has_more", page.HasMore).Info("成功获取Feed流")
 	c.JSON(http.StatusOK, gin.H{
-		"message": "成功获取视频流",
-		"data":    response,
+		"message":     "成功获取视频流",
+		"data":        response,
+		"next_cursor": page.NextCursor,
+		"has_more":    page.HasMore,
+	})
+}
+
+// UpdateVideoRequest 只修改传了的字段
+type UpdateVideoRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	CoverFileID *uint64 `json:"cover_file_id"`
+	// publish_status改为published立即发布，draft改回草稿；只传publish_at就是改为定时发布
+	Visibility    *string    `json:"visibility"`
+	PublishStatus *string    `json:"publish_status"`
+	PublishAt     *time.Time `json:"publish_at"`
+}
+
+// 修改视频：1、解析视频ID、请求体和If-Match 2、service层检查作者和版本后更新，删除缓存 3、返回修改后的视频和新的ETag
+func (h *videoHandler) UpdateVideo(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	videoID, err := strconv.ParseUint(c.Param("video_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的视频ID") // 400
+		return
+	}
+	var req UpdateVideoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的参数") // 400
+		return
+	}
+	if req.Title == nil && req.Description == nil && req.CoverFileID == nil && req.Visibility == nil && req.PublishStatus == nil && req.PublishAt == nil {
+		sendErrorResponse(c, http.StatusBadRequest, "没有要修改的字段") // 400
+		return
+	}
+	version, valid := parseIfMatch(c.GetHeader("If-Match"))
+	if !valid {
+		sendErrorResponse(c, http.StatusPreconditionFailed, service.ErrVideoVersionConflict.Error()) // 412
+		return
+	}
+	// 没带If-Match或者是*，都可能覆盖别人的修改
+	if version == nil {
+		sendErrorResponse(c, http.StatusPreconditionRequired, "修改视频需要在If-Match中带上读到的ETag") // 428
+		return
+	}
+	logCtx := logger.Log.WithField("video_id", videoID).WithField("user_id", userID)
+
+	video, err := h.VideoService.UpdateVideo(userID, videoID, *version, service.VideoUpdate{
+		Title:       req.Title,
+		Description: req.Description,
+		CoverFileID: req.CoverFileID,
+		VideoPublishing: service.VideoPublishing{
+			Visibility: req.Visibility,
+			Status:     req.PublishStatus,
+			PublishAt:  req.PublishAt,
+		},
 	})
+	if err != nil {
+		h.sendVideoMutationError(c, videoID, err)
+		return
+	}
+	logCtx.WithField("version", video.Version).Info("视频已修改")
+	c.Header("ETag", videoETag(video.Version))
+	c.JSON(http.StatusOK, gin.H{"data": dto.ToVideoResponse(video, h.Signer)})
+}
+
+// 删除视频：If-Match可选，带了具体的ETag就检查版本
+func (h *videoHandler) DeleteVideo(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	videoID, err := strconv.ParseUint(c.Param("video_id"), 10, 64)
+	if err != nil {
+		sendErrorResponse(c, http.StatusBadRequest, "无效的视频ID") // 400
+		return
+	}
+	version, valid := parseIfMatch(c.GetHeader("If-Match"))
+	if !valid {
+		sendErrorResponse(c, http.StatusPreconditionFailed, service.ErrVideoVersionConflict.Error()) // 412
+		return
+	}
+	logCtx := logger.Log.WithField("video_id", videoID).WithField("user_id", userID)
+	if err := h.VideoService.DeleteVideo(userID, videoID, version); err != nil {
+		h.sendVideoMutationError(c, videoID, err)
+		return
+	}
+	logCtx.Info("视频已删除")
+	c.Status(http.StatusNoContent)
+}
+
+func (h *videoHandler) sendVideoMutationError(c *gin.Context, videoID uint64, err error) {
+	switch {
+	case errors.Is(err, service.ErrVideoNotFound):
+		sendErrorResponse(c, http.StatusNotFound, err.Error()) // 404
+	case errors.Is(err, service.ErrVideoNotAuthor):
+		sendErrorResponse(c, http.StatusForbidden, err.Error()) // 403
+	case errors.Is(err, service.ErrVideoVersionConflict):
+		sendErrorResponse(c, http.StatusPreconditionFailed, err.Error()) // 412
+	case errors.Is(err, service.ErrVideoTitleEmpty), errors.Is(err, service.ErrMediaFileNotFound), isPublishingError(err):
+		sendErrorResponse(c, http.StatusBadRequest, err.Error()) // 400
+	default:
+		logger.Log.WithError(err).WithField("video_id", videoID).Error("修改或删除视频失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "系统错误") // 500
+	}
+}
+
+// isPublishingError 可见范围、发布状态或发布时间不合法
+func isPublishingError(err error) bool {
+	return errors.Is(err, service.ErrVideoVisibilityInvalid) || errors.Is(err, service.ErrPublishStatusInvalid) || errors.Is(err, service.ErrPublishAtInvalid)
+}
+
+// videoETag 视频的版本号作为ETag
+func videoETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseIfMatch 解析If-Match中的版本号，接受"3"和W/"3"；没有这个头或者是*时返回nil，表示不检查版本
+// 不是合法的版本号（包括多个ETag）时valid为false，按版本不一致处理
+func parseIfMatch(header string) (version *uint64, valid bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, true
+	}
+	tag := strings.TrimPrefix(header, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return nil, false
+	}
+	v, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &v, true
 }
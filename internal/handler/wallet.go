@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"Orion_Live/internal/dto"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WalletHandler interface {
+	GetWallet(c *gin.Context)
+	GetLedger(c *gin.Context)
+}
+
+type walletHandler struct {
+	WalletService service.WalletService
+}
+
+func NewWalletHandler(walletService service.WalletService) WalletHandler {
+	return &walletHandler{WalletService: walletService}
+}
+
+// 查询钱包：返回可用余额，送出但还没落库的礼物已经扣除
+func (h *walletHandler) GetWallet(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	balance, err := h.WalletService.Balance(userID)
+	if err != nil {
+		logger.Log.WithError(err).WithField("user_id", userID).Error("查询余额失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "查询余额失败") // 500
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"balance": balance}})
+}
+
+// 查询流水：按时间倒序分页
+func (h *walletHandler) GetLedger(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	entries, err := h.WalletService.Ledger(userID, page, pageSize)
+	if err != nil {
+		logger.Log.WithError(err).WithField("user_id", userID).Error("查询流水失败")
+		sendErrorResponse(c, http.StatusInternalServerError, "查询流水失败") // 500
+		return
+	}
+	response := make([]dto.LedgerEntryResponse, 0, len(entries))
+	for i := range entries {
+		response = append(response, dto.ToLedgerEntryResponse(&entries[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"data": response})
+}
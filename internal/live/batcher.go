@@ -0,0 +1,110 @@
+package live
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/pkg/config"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DanmakuBatcher 把弹幕攒成批次交给flush（写outbox），攒够BatchSize条或每隔FlushInterval刷一次
+// 弹幕是尽力而为的数据：队列满了直接丢弃，flush失败只记录日志，不影响实时推送
+type DanmakuBatcher struct {
+	cfg   config.DanmakuConfig
+	flush func(items []message.DanmakuMessage) error
+	log   *logrus.Logger
+
+	in     chan message.DanmakuMessage
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewDanmakuBatcher(cfg config.DanmakuConfig, flush func(items []message.DanmakuMessage) error, log *logrus.Logger) *DanmakuBatcher {
+	return &DanmakuBatcher{
+		cfg:   cfg,
+		flush: flush,
+		log:   log,
+		// 留出几批的余量，flush慢的时候不至于立刻丢弃
+		in:   make(chan message.DanmakuMessage, cfg.BatchSize*4),
+		done: make(chan struct{}),
+	}
+}
+
+// Add 放入待落库队列，不阻塞，队列满了返回false
+func (b *DanmakuBatcher) Add(item message.DanmakuMessage) bool {
+	select {
+	case b.in <- item:
+		return true
+	default:
+		b.log.WithField("room_id", item.RoomID).Warn("弹幕落库队列已满，丢弃一条弹幕")
+		return false
+	}
+}
+
+func (b *DanmakuBatcher) Start(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.run(runCtx)
+	return nil
+}
+
+// Stop 停止攒批，把队列里剩下的弹幕刷完再返回；应该在所有WebSocket连接关闭之后调用
+func (b *DanmakuBatcher) Stop(ctx context.Context) error {
+	if b.cancel == nil {
+		return nil
+	}
+	b.cancel()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *DanmakuBatcher) run(ctx context.Context) {
+	defer close(b.done)
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]message.DanmakuMessage, 0, b.cfg.BatchSize)
+	for {
+		select {
+		case item := <-b.in:
+			batch = append(batch, item)
+			if len(batch) >= b.cfg.BatchSize {
+				batch = b.write(batch)
+			}
+		case <-ticker.C:
+			batch = b.write(batch)
+		case <-ctx.Done():
+			// 把已经进入队列的弹幕取完
+			for {
+				select {
+				case item := <-b.in:
+					batch = append(batch, item)
+					if len(batch) >= b.cfg.BatchSize {
+						batch = b.write(batch)
+					}
+				default:
+					b.write(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// write 刷一批，返回清空后可以复用的切片
+func (b *DanmakuBatcher) write(batch []message.DanmakuMessage) []message.DanmakuMessage {
+	if len(batch) == 0 {
+		return batch
+	}
+	// flush可能异步持有切片，交出去一份拷贝
+	items := append([]message.DanmakuMessage(nil), batch...)
+	if err := b.flush(items); err != nil {
+		b.log.WithError(err).WithField("count", len(items)).Error("弹幕写入outbox失败，本批弹幕不会落库")
+	}
+	return batch[:0]
+}
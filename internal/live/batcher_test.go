@@ -0,0 +1,73 @@
+package live
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/pkg/config"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDanmakuBatcherFlushesFullBatchesAndRestOnStop(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]message.DanmakuMessage
+	flush := func(items []message.DanmakuMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, items)
+		return nil
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	// FlushInterval足够长，只靠BatchSize和Stop触发
+	b := NewDanmakuBatcher(config.DanmakuConfig{BatchSize: 2, FlushInterval: time.Hour}, flush, log)
+	if err := b.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if !b.Add(message.DanmakuMessage{RoomID: 1, SentAt: int64(i)}) {
+			t.Fatalf("第%d条弹幕被丢弃", i)
+		}
+	}
+	if err := b.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []int{2, 2, 1}
+	if len(batches) != len(want) {
+		t.Fatalf("flush了%d批, want %d", len(batches), len(want))
+	}
+	var next int64 = 1
+	for i, batch := range batches {
+		if len(batch) != want[i] {
+			t.Errorf("第%d批有%d条, want %d", i, len(batch), want[i])
+		}
+		for _, item := range batch {
+			if item.SentAt != next {
+				t.Errorf("弹幕顺序错乱: got %d, want %d", item.SentAt, next)
+			}
+			next++
+		}
+	}
+}
+
+func TestDanmakuBatcherDropsWhenQueueFull(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	// 不Start，没有人取队列
+	b := NewDanmakuBatcher(config.DanmakuConfig{BatchSize: 1, FlushInterval: time.Hour}, nil, log)
+	for i := 0; i < 4; i++ {
+		if !b.Add(message.DanmakuMessage{}) {
+			t.Fatalf("队列容量内的第%d条被丢弃", i)
+		}
+	}
+	if b.Add(message.DanmakuMessage{}) {
+		t.Error("队列已满时Add应该返回false")
+	}
+}
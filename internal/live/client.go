@@ -0,0 +1,128 @@
+package live
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 客户端发来的单条消息的最大字节数，弹幕本身有长度限制，这里只是防止恶意的大消息
+const maxMessageSize = 4096
+
+// Client 一个WebSocket连接：读goroutine处理客户端发来的消息，写goroutine负责推送和心跳
+// 推送都经过有界的send缓冲，写goroutine是唯一调用conn写方法的地方（WriteControl除外，它是并发安全的）
+type Client struct {
+	RoomID   uint64
+	UserID   uint64
+	Username string
+
+	hub  *Hub
+	conn *websocket.Conn
+	send chan []byte
+
+	// close后关闭，通知写goroutine发送关闭帧并退出
+	done       chan struct{}
+	closeOnce  sync.Once
+	closeCode  int
+	closeText  string
+	writerDone chan struct{}
+}
+
+func newClient(h *Hub, conn *websocket.Conn, roomID, userID uint64, username string) *Client {
+	return &Client{
+		RoomID:     roomID,
+		UserID:     userID,
+		Username:   username,
+		hub:        h,
+		conn:       conn,
+		send:       make(chan []byte, h.cfg.SendBuffer),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+}
+
+// Send 把消息放入写缓冲，不阻塞；缓冲满了说明客户端读得太慢，断开连接并返回false
+func (c *Client) Send(payload []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- payload:
+		return true
+	default:
+		c.close(websocket.CloseTryAgainLater, "消息积压过多，请重新连接")
+		return false
+	}
+}
+
+// SendEvent 只发给这一个连接，比如弹幕发送失败的提示
+func (c *Client) SendEvent(event Event) bool {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return false
+	}
+	return c.Send(payload)
+}
+
+// close 只有第一次调用生效，关闭码和原因会在关闭帧里发给客户端
+func (c *Client) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// readPump 读取客户端消息，直到连接出错、对方关闭或者超过pong_timeout没有收到任何数据
+func (c *Client) readPump(onMessage func(c *Client, data []byte)) {
+	pongTimeout := c.hub.cfg.PongTimeout
+	c.conn.SetReadLimit(maxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		onMessage(c, data)
+	}
+}
+
+// writePump 推送写缓冲中的消息并定时发送ping；close后发送关闭帧，然后关闭底层连接，读goroutine随之退出
+func (c *Client) writePump() {
+	writeTimeout := c.hub.cfg.WriteTimeout
+	ticker := time.NewTicker(c.hub.cfg.PongTimeout * 9 / 10)
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+		close(c.writerDone)
+	}()
+	for {
+		select {
+		case payload := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				msg := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+				_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeTimeout))
+			}
+			return
+		}
+	}
+}
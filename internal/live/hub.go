@@ -0,0 +1,207 @@
+package live
+
+import (
+	"Orion_Live/pkg/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// 直播间频道的前缀，完整频道名是 live:room:{房间ID}
+const roomChannelPrefix = "live:room:"
+
+// 推送给客户端的事件类型
+const (
+	EventDanmaku = "danmaku"
+	EventError   = "error"
+	// 回复客户端心跳，带上直播间的在线人数
+	EventOnline = "online"
+	// 礼物落库后由消费者推送
+	EventGift = "gift"
+	// 主播发出红包
+	EventRedPacket = "red_packet"
+)
+
+// 客户端发来的消息类型，弹幕复用EventDanmaku
+const MessageHeartbeat = "heartbeat"
+
+// Event 服务端推送给WebSocket客户端的消息
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// RoomChannel 直播间的Redis Pub/Sub频道
+func RoomChannel(roomID uint64) string {
+	return roomChannelPrefix + strconv.FormatUint(roomID, 10)
+}
+
+// Hub 管理本实例上所有直播间的WebSocket连接
+// 消息一律先PUBLISH到直播间的Redis频道，每个实例PSUBSCRIBE live:room:*，收到后推送给本实例上这个直播间的连接，
+// 所以连在不同实例上的观众也能互相看到弹幕；本实例没有连接的直播间，消息直接丢弃
+type Hub struct {
+	rdb *redis.Client
+	cfg config.DanmakuConfig
+	log *logrus.Logger
+
+	mu      sync.RWMutex
+	rooms   map[uint64]map[*Client]struct{}
+	stopped bool
+	// 正在服务的连接，Stop时等待它们全部关闭
+	conns sync.WaitGroup
+
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+func NewHub(rdb *redis.Client, cfg config.DanmakuConfig, log *logrus.Logger) *Hub {
+	return &Hub{
+		rdb:   rdb,
+		cfg:   cfg,
+		log:   log,
+		rooms: make(map[uint64]map[*Client]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Start 订阅所有直播间的频道，等到订阅确认后才返回，Redis不可用时启动失败
+// 连接断开后go-redis会自动重连并重新订阅，断开期间的消息会丢失，弹幕可以接受
+func (h *Hub) Start(ctx context.Context) error {
+	h.pubsub = h.rdb.PSubscribe(ctx, roomChannelPrefix+"*")
+	if _, err := h.pubsub.Receive(ctx); err != nil {
+		_ = h.pubsub.Close()
+		return fmt.Errorf("订阅直播间频道失败: %w", err)
+	}
+	go h.run()
+	return nil
+}
+
+// Stop 取消订阅，通知所有连接关闭，等待它们退出；ctx到期则不再等待
+func (h *Hub) Stop(ctx context.Context) error {
+	h.mu.Lock()
+	h.stopped = true
+	var clients []*Client
+	for _, room := range h.rooms {
+		for c := range room {
+			clients = append(clients, c)
+		}
+	}
+	h.mu.Unlock()
+
+	if h.pubsub != nil {
+		_ = h.pubsub.Close()
+		<-h.done
+	}
+	for _, c := range clients {
+		c.close(websocket.CloseGoingAway, "服务器重启，请重新连接")
+	}
+	wait := make(chan struct{})
+	go func() {
+		h.conns.Wait()
+		close(wait)
+	}()
+	select {
+	case <-wait:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hub) run() {
+	defer close(h.done)
+	for msg := range h.pubsub.Channel(redis.WithChannelSize(1024)) {
+		roomID, err := strconv.ParseUint(strings.TrimPrefix(msg.Channel, roomChannelPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		h.broadcast(roomID, []byte(msg.Payload))
+	}
+}
+
+// Publish 把事件发布到直播间的频道，所有实例上这个直播间的连接都会收到
+func (h *Hub) Publish(ctx context.Context, roomID uint64, event Event) error {
+	return PublishEvent(ctx, h.rdb, roomID, event)
+}
+
+// PublishEvent 不持有Hub的进程（比如消费者）也可以直接向直播间推送事件
+func PublishEvent(ctx context.Context, rdb *redis.Client, roomID uint64, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return rdb.Publish(ctx, RoomChannel(roomID), payload).Err()
+}
+
+// RoomSize 本实例上这个直播间的连接数
+func (h *Hub) RoomSize(roomID uint64) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[roomID])
+}
+
+// broadcast 推送给本实例上这个直播间的所有连接，写缓冲满了的连接直接断开，不阻塞其他观众
+func (h *Hub) broadcast(roomID uint64, payload []byte) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.rooms[roomID]))
+	for c := range h.rooms[roomID] {
+		clients = append(clients, c)
+	}
+	h.mu.RUnlock()
+	for _, c := range clients {
+		if !c.Send(payload) {
+			h.log.WithField("room_id", roomID).WithField("user_id", c.UserID).Warn("弹幕连接写缓冲已满，断开慢连接")
+		}
+	}
+}
+
+func (h *Hub) join(c *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopped {
+		return false
+	}
+	room, ok := h.rooms[c.RoomID]
+	if !ok {
+		room = make(map[*Client]struct{})
+		h.rooms[c.RoomID] = room
+	}
+	room[c] = struct{}{}
+	h.conns.Add(1)
+	return true
+}
+
+func (h *Hub) leave(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room := h.rooms[c.RoomID]
+	delete(room, c)
+	if len(room) == 0 {
+		delete(h.rooms, c.RoomID)
+	}
+	h.conns.Done()
+}
+
+// Serve 把升级好的WebSocket连接加入直播间，阻塞直到连接断开
+// onMessage在读goroutine中按顺序调用，同一个连接的消息不会并发处理
+func (h *Hub) Serve(conn *websocket.Conn, roomID, userID uint64, username string, onMessage func(c *Client, data []byte)) {
+	c := newClient(h, conn, roomID, userID, username)
+	if !h.join(c) {
+		c.close(websocket.CloseGoingAway, "服务器重启，请重新连接")
+		c.writePump()
+		return
+	}
+	defer h.leave(c)
+
+	go c.writePump()
+	c.readPump(onMessage)
+	c.close(websocket.CloseNormalClosure, "")
+	<-c.writerDone
+}
@@ -0,0 +1,71 @@
+package live
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PresenceSweeper 定时清扫直播间里超时没有心跳的观众
+// 心跳时只会顺带清理自己所在的直播间，观众全部异常断开（没有Leave）的直播间要靠它把在线人数降下来
+// 多个实例同时清扫是幂等的
+type PresenceSweeper struct {
+	interval time.Duration
+	sweep    func() (int64, error)
+	log      *logrus.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPresenceSweeper(interval time.Duration, sweep func() (int64, error), log *logrus.Logger) *PresenceSweeper {
+	return &PresenceSweeper{
+		interval: interval,
+		sweep:    sweep,
+		log:      log,
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *PresenceSweeper) Start(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.run(runCtx)
+	return nil
+}
+
+// Stop 等待正在进行的一轮清扫结束
+func (s *PresenceSweeper) Stop(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *PresenceSweeper) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := s.sweep()
+			if err != nil {
+				s.log.WithError(err).Error("清扫直播间过期观众失败")
+				continue
+			}
+			if removed > 0 {
+				s.log.WithField("removed", removed).Debug("已清扫直播间过期观众")
+			}
+		}
+	}
+}
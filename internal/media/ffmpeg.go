@@ -0,0 +1,124 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FFmpeg 调用本机的ffmpeg和ffprobe处理视频
+type FFmpeg struct {
+	ffmpegPath      string
+	ffprobePath     string
+	segmentDuration time.Duration
+}
+
+func NewFFmpeg(ffmpegPath, ffprobePath string, segmentDuration time.Duration) *FFmpeg {
+	return &FFmpeg{ffmpegPath: ffmpegPath, ffprobePath: ffprobePath, segmentDuration: segmentDuration}
+}
+
+// run 执行命令，失败时带上stderr的最后一段；命令正常启动但退出码非0说明文件有问题，包装成ErrInvalidMedia
+// 找不到命令、被ctx取消等其他错误原样返回，由调用方重试
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return stdout.Bytes(), nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	msg := strings.TrimSpace(stderr.String())
+	if len(msg) > 200 {
+		msg = msg[len(msg)-200:]
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidMedia, filepath.Base(name), msg)
+	}
+	return nil, fmt.Errorf("执行%s失败: %w", name, err)
+}
+
+// Probe 用ffprobe读取时长和第一个视频流的分辨率
+func (f *FFmpeg) Probe(ctx context.Context, input string) (*Info, error) {
+	out, err := run(ctx, f.ffprobePath, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", input)
+	if err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("%w: ffprobe输出无法解析: %v", ErrInvalidMedia, err)
+	}
+	info := &Info{}
+	for _, s := range probe.Streams {
+		if s.CodecType == "video" && s.Width > 0 && s.Height > 0 {
+			info.Width, info.Height = s.Width, s.Height
+			break
+		}
+	}
+	if info.Width == 0 {
+		return nil, fmt.Errorf("%w: 没有视频流", ErrInvalidMedia)
+	}
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil || seconds <= 0 {
+		return nil, fmt.Errorf("%w: 无法读取时长", ErrInvalidMedia)
+	}
+	info.Duration = time.Duration(seconds * float64(time.Second))
+	return info, nil
+}
+
+// ExtractCover 截取at处的一帧保存为JPEG
+func (f *FFmpeg) ExtractCover(ctx context.Context, input, output string, at time.Duration) error {
+	_, err := run(ctx, f.ffmpegPath, "-y", "-v", "error",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64), "-i", input,
+		"-frames:v", "1", "-q:v", "2", output)
+	return err
+}
+
+// TranscodeHLS 每档清晰度转码一次，关键帧按切片时长强制对齐，各档的切片边界一致，切换清晰度时不会跳帧
+// 没有音轨的视频也能处理（-map 0:a:0?）
+func (f *FFmpeg) TranscodeHLS(ctx context.Context, input, outDir string, renditions []Rendition) error {
+	seg := f.segmentDuration.Seconds()
+	for _, r := range renditions {
+		dir := filepath.Join(outDir, r.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		_, err := run(ctx, f.ffmpegPath, "-y", "-v", "error", "-i", input,
+			"-map", "0:v:0", "-map", "0:a:0?",
+			"-vf", fmt.Sprintf("scale=%d:%d", r.Width, r.Height),
+			"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main",
+			"-b:v", fmt.Sprintf("%dk", r.VideoBitrate),
+			"-maxrate", fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+			"-bufsize", fmt.Sprintf("%dk", r.VideoBitrate*2),
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%g)", seg),
+			"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", r.AudioBitrate), "-ac", "2",
+			"-f", "hls", "-hls_time", strconv.FormatFloat(seg, 'f', -1, 64), "-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(dir, "seg_%04d.ts"),
+			filepath.Join(dir, "index.m3u8"))
+		if err != nil {
+			return fmt.Errorf("转码%s失败: %w", r.Name, err)
+		}
+	}
+	return nil
+}
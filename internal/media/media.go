@@ -0,0 +1,41 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidMedia 文件不是能处理的视频（损坏、没有视频流等），重试也不会成功
+var ErrInvalidMedia = errors.New("media: 无法识别的视频文件")
+
+// Info 探测出的视频信息
+type Info struct {
+	Duration time.Duration
+	Width    int
+	Height   int
+}
+
+// Rendition 一档HLS清晰度，转码结果放在 {输出目录}/{Name}/index.m3u8
+type Rendition struct {
+	Name         string
+	Width        int
+	Height       int
+	VideoBitrate int // kbps
+	AudioBitrate int // kbps
+}
+
+// MasterPlaylistName 多清晰度的主播放列表，和各档清晰度的目录放在一起
+const MasterPlaylistName = "master.m3u8"
+
+// MasterPlaylist 生成HLS主播放列表，播放器按带宽在各档之间切换
+func MasterPlaylist(renditions []Rendition) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, r := range renditions {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=\"%s\"\n%s/index.m3u8\n",
+			(r.VideoBitrate+r.AudioBitrate)*1000, r.Width, r.Height, r.Name, r.Name)
+	}
+	return b.String()
+}
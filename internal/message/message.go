@@ -0,0 +1,84 @@
+package message
+
+// 队列命名遵循：项目名.业务领域.实体/功能
+const (
+	QueueLike           = "orion.like.queue"
+	QueueGoldenComment  = "orion.golden_comment.queue"
+	QueueDanmaku        = "orion.danmaku.queue"
+	QueueGift           = "orion.gift.queue"
+	QueueGiftEvent      = "orion.gift_event.queue"
+	QueueRedPacket      = "orion.red_packet.queue"
+	QueueMedia          = "orion.media.queue"
+	QueueVideoPublished = "orion.video_published.queue"
+)
+
+const (
+	ActionLike   = "like"
+	ActionUnlike = "unlike"
+)
+
+// LikeMessage 点赞消息，只需要userID，videoID 和 “赞”/“取赞”
+type LikeMessage struct {
+	UserID  uint64 `json:"user_id"`
+	VideoID uint64 `json:"video_id"`
+	Action  string `json:"action"` // "like" or "unlike"
+}
+
+// GoldenCommentMessage 黄金评论消息，Redis抢到席位后投递，由消费者落库并更新预约票的状态
+type GoldenCommentMessage struct {
+	TicketID string `json:"ticket_id"`
+	UserID   uint64 `json:"user_id"`
+	VideoID  uint64 `json:"video_id"`
+	Content  string `json:"content"`
+}
+
+// DanmakuMessage 一条弹幕，SentAt为毫秒时间戳
+type DanmakuMessage struct {
+	SessionID uint64 `json:"session_id"`
+	RoomID    uint64 `json:"room_id"`
+	UserID    uint64 `json:"user_id"`
+	Content   string `json:"content"`
+	SentAt    int64  `json:"sent_at"`
+}
+
+// DanmakuBatchMessage server攒一批弹幕写一条outbox消息，消费者整批落库，弹幕量大时不至于每条一次事务
+type DanmakuBatchMessage struct {
+	Items []DanmakuMessage `json:"items"`
+}
+
+// GiftMessage 一次送礼：Redis预扣成功后投递到QueueGift，由消费者写流水；落库后原样投递到QueueGiftEvent，推送到直播间、更新排行榜
+type GiftMessage struct {
+	TransferID string `json:"transfer_id"`
+	SenderID   uint64 `json:"sender_id"`
+	SenderName string `json:"sender_name"`
+	StreamerID uint64 `json:"streamer_id"`
+	RoomID     uint64 `json:"room_id"`
+	SessionID  uint64 `json:"session_id"`
+	GiftID     uint64 `json:"gift_id"`
+	GiftName   string `json:"gift_name"`
+	Count      int    `json:"count"`
+	Amount     int64  `json:"amount"`
+	SentAt     int64  `json:"sent_at"` // 毫秒时间戳
+}
+
+// RedPacketGrabMessage 抢到一份红包：Redis中抢到后投递，由消费者把这份金币从托管的平台账户转给观众
+type RedPacketGrabMessage struct {
+	PacketID  string `json:"packet_id"`
+	UserID    uint64 `json:"user_id"`
+	Amount    int64  `json:"amount"`
+	GrabbedAt int64  `json:"grabbed_at"` // 毫秒时间戳
+}
+
+// MediaProcessMessage 发布视频后投递，由消费者转码成HLS、生成封面
+type MediaProcessMessage struct {
+	VideoID uint64 `json:"video_id"`
+}
+
+// VideoPublishedMessage 视频进入published状态时投递：立即发布、作者把草稿发布出去、scheduler发布到点的定时视频
+// 视频可能还在转码，订阅方需要时自己检查处理状态
+type VideoPublishedMessage struct {
+	VideoID     uint64 `json:"video_id"`
+	AuthorID    uint64 `json:"author_id"`
+	Visibility  string `json:"visibility"`
+	PublishedAt int64  `json:"published_at"` // 毫秒时间戳
+}
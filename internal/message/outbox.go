@@ -0,0 +1,31 @@
+package message
+
+import (
+	"Orion_Live/internal/model"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// NewID 生成一个随机的消息ID，作为amqp的MessageId，消费者靠它去重
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewOutbox 把消息体序列化成一条待投递的outbox记录，exchange为默认交换机，routingKey就是队列名
+func NewOutbox(routingKey string, v interface{}) (*model.OutboxMessage, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &model.OutboxMessage{
+		MessageID:     NewID(),
+		RoutingKey:    routingKey,
+		Payload:       payload,
+		Status:        model.OutboxStatusPending,
+		NextAttemptAt: time.Now(),
+	}, nil
+}
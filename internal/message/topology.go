@@ -0,0 +1,25 @@
+package message
+
+import (
+	"Orion_Live/pkg/mq/consumer"
+
+	"github.com/streadway/amqp"
+)
+
+// Queues 所有业务队列，admin的dlq命令也按这个列表查看死信
+var Queues = []string{QueueLike, QueueGoldenComment, QueueDanmaku, QueueGift, QueueGiftEvent, QueueRedPacket, QueueMedia, QueueVideoPublished}
+
+// Topology 返回声明所有业务队列的函数，server/relay/consumer启动时以及每次重连后都会执行，声明是幂等的
+// 每个业务队列都带有死信交换机参数、按policy.Delays声明的重试队列，以及自己的dlq
+// 所有进程必须使用相同的重试配置，否则同名队列参数不一致会声明失败
+func Topology(policy consumer.RetryPolicy) func(ch *amqp.Channel) error {
+	return func(ch *amqp.Channel) error {
+		for _, queue := range Queues {
+			// 业务队列：durable持久化，即使RabbitMQ服务器重启，这个“邮筒”本身不会消失（注：里面的信件是否消失，取决于信件本身的持久化设置）
+			if err := consumer.DeclareQueue(ch, queue, policy); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
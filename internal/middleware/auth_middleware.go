@@ -3,7 +3,6 @@ package middleware
 import (
 	"errors"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -11,8 +10,9 @@ import (
 )
 
 // 中间件工厂，改成AuthMiddleware(role string)，就能创建一个只允许特定角色的用户通过的中间件
+// secretKey来自配置中的jwt.secret，和签发token的UserService使用同一个
 // 流程：1、从http请求中取出"Authorization"字段 2、验证"Bearer [token]" 3、通过secretKey验证token有效性 4、若成功，从token中取出后续用到的用户信息，放入context
-func AuthMiddleware() gin.HandlerFunc {
+func AuthMiddleware(secretKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 拿到http协议请求头中的Authorization字段
 		authHeader := c.GetHeader("Authorization")
@@ -29,31 +29,74 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := parts[1]
-		secretKey := []byte(os.Getenv("JWT_SECRET_KEY"))
-
-		// 解析Token，返回加密前的token（Header.Payload.Signature），还附带valid判断是否有效
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// 确保签名方法是对称加密族
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("非预期的签名方法")
-			}
-			return secretKey, nil
-		})
+		authenticate(c, secretKey, parts[1])
+	}
+}
 
-		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "无效的授权令牌"})
+// WebSocketAuthMiddleware 浏览器的WebSocket API不能设置请求头，所以除了Authorization外，也接受 ?token=[token]
+// 校验逻辑和AuthMiddleware完全一样，放入context的用户信息也一样
+func WebSocketAuthMiddleware(secretKey string) gin.HandlerFunc {
+	header := AuthMiddleware(secretKey)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			header(c)
 			return
 		}
-
-		// Token验证成功！将用户信息存入Context，以便后续使用
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if ok {
-			c.Set("userID", claims["user_id"])
-			c.Set("username", claims["username"])
+		tokenString := c.Query("token")
+		if tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "请求未包含授权令牌"})
+			return
 		}
+		authenticate(c, secretKey, tokenString)
+	}
+}
 
-		// 放行，继续处理请求
+// OptionalAuthMiddleware 不登录也能访问的接口（Feed、视频详情、评论列表）用它：带了有效的token就把用户信息放入context，
+// 没带或者无效都当作没登录放行，由handler按视频的可见范围决定返回什么
+func OptionalAuthMiddleware(secretKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parts := strings.Split(c.GetHeader("Authorization"), " ")
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			if claims, ok := parseToken(secretKey, parts[1]); ok {
+				setUser(c, claims)
+			}
+		}
 		c.Next()
 	}
 }
+
+// authenticate 校验token，成功则把用户信息放入context并放行
+func authenticate(c *gin.Context, secretKey, tokenString string) {
+	claims, ok := parseToken(secretKey, tokenString)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "无效的授权令牌"})
+		return
+	}
+	// Token验证成功！将用户信息存入Context，以便后续使用
+	setUser(c, claims)
+
+	// 放行，继续处理请求
+	c.Next()
+}
+
+// parseToken 校验token的签名和有效期，返回其中的claims
+func parseToken(secretKey, tokenString string) (jwt.MapClaims, bool) {
+	// 解析Token，返回加密前的token（Header.Payload.Signature），还附带valid判断是否有效
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// 确保签名方法是对称加密族
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("非预期的签名方法")
+		}
+		return []byte(secretKey), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	return claims, ok
+}
+
+func setUser(c *gin.Context, claims jwt.MapClaims) {
+	c.Set("userID", claims["user_id"])
+	c.Set("username", claims["username"])
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Danmaku 直播间的弹幕，实时推送走Redis Pub/Sub，落库由消费者异步批量写入，用于回放
+type Danmaku struct {
+	ID uint64 `gorm:"primarykey"`
+	// 同一场直播的弹幕按发送时间回放
+	SessionID uint64    `gorm:"not null;index:idx_session_sent,priority:1"`
+	RoomID    uint64    `gorm:"not null;index"`
+	UserID    uint64    `gorm:"not null"`
+	Content   string    `gorm:"size:255;not null"`
+	SentAt    time.Time `gorm:"not null;index:idx_session_sent,priority:2"`
+	CreatedAt time.Time
+}
+
+func (Danmaku) TableName() string {
+	return "danmakus"
+}
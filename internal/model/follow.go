@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// Follow 关注关系，FollowerID关注了FolloweeID；取消关注直接删除记录，不用软删除，重新关注时唯一索引不会冲突
+type Follow struct {
+	ID         uint64 `gorm:"primarykey"`
+	FollowerID uint64 `gorm:"not null;uniqueIndex:idx_follower_followee"`
+	FolloweeID uint64 `gorm:"not null;uniqueIndex:idx_follower_followee;index"`
+	CreatedAt  time.Time
+}
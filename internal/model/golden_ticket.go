@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// 黄金评论预约票的状态：pending → committed | failed，failed → refunded（席位已归还）
+const (
+	GoldenTicketPending   = "pending"   // 已抢到席位，等待消费者落库
+	GoldenTicketCommitted = "committed" // 评论已落库，CommentID有效
+	GoldenTicketFailed    = "failed"    // 落库失败（进入死信），席位还没归还
+	GoldenTicketRefunded  = "refunded"  // 落库失败且席位已归还
+)
+
+// GoldenTicket 黄金评论的预约票，存在Redis哈希golden:ticket:{id}中，不落库
+// 抢到席位时创建，客户端凭ID轮询最终结果
+type GoldenTicket struct {
+	ID        string
+	UserID    uint64
+	VideoID   uint64
+	Status    string
+	CommentID uint64
+	Reason    string // 失败原因
+	CreatedAt time.Time
+}
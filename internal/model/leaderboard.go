@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// 贡献榜的范围
+const (
+	LeaderboardSession = "session" // 本场直播
+	LeaderboardDaily   = "daily"   // 当天，按服务器时区的自然日
+	LeaderboardTotal   = "total"   // 直播间累计
+)
+
+// LeaderboardSnapshot 下播时本场贡献榜的一个名次，实时榜单在Redis中，下播后的本场榜单从这里读
+// 同一场直播重新快照时整场删除后重写，(session_id, user_id)唯一
+type LeaderboardSnapshot struct {
+	ID        uint64 `gorm:"primarykey"`
+	SessionID uint64 `gorm:"not null;uniqueIndex:idx_session_user,priority:1;index:idx_session_rank,priority:1"`
+	UserID    uint64 `gorm:"not null;uniqueIndex:idx_session_user,priority:2"`
+	RoomID    uint64 `gorm:"not null"`
+	// rank是MySQL 8的保留字，列名用ranking
+	Rank      int64 `gorm:"column:ranking;not null;index:idx_session_rank,priority:2"`
+	Amount    int64 `gorm:"not null"` // 这场直播送出的金币
+	CreatedAt time.Time
+}
+
+func (LeaderboardSnapshot) TableName() string {
+	return "leaderboard_snapshots"
+}
@@ -0,0 +1,47 @@
+package model
+
+import "time"
+
+// 直播间状态：idle（未开播）⇄ live（直播中），开播和下播由RTMP服务器的回调或主播手动触发
+const (
+	LiveRoomIdle = "idle"
+	LiveRoomLive = "live"
+)
+
+// LiveRoom 直播间，每个主播一个；推流地址是 {publish_url}/{房间ID}?key={StreamKey}
+type LiveRoom struct {
+	BaseModel
+	StreamerID uint64 `gorm:"not null;uniqueIndex"` // 主播的用户ID
+	Title      string `gorm:"not null"`
+	CoverURL   string
+	// 推流密钥，只返回给主播本人；泄露后可以重新生成，旧密钥立即失效
+	StreamKey string `gorm:"size:64;not null;uniqueIndex"`
+	Status    string `gorm:"size:16;not null;default:idle;index"`
+	// 当前这场直播的ID，未开播时为0
+	SessionID     uint64
+	LiveStartedAt *time.Time
+	LiveEndedAt   *time.Time
+
+	Streamer User `gorm:"foreignKey:StreamerID"`
+}
+
+func (LiveRoom) TableName() string {
+	return "live_rooms"
+}
+
+// LiveSession 一场直播，开播时创建，下播时写入结束时间和观众统计
+type LiveSession struct {
+	BaseModel
+	RoomID     uint64 `gorm:"not null;index"`
+	StreamerID uint64 `gorm:"not null;index"`
+	Title      string `gorm:"not null"` // 开播时的直播间标题
+	StartedAt  time.Time
+	EndedAt    *time.Time
+	// 直播过程中记在Redis里，下播时写入；独立观众数是HyperLogLog的估算值
+	PeakViewers   int64 `gorm:"not null;default:0"`
+	UniqueViewers int64 `gorm:"not null;default:0"`
+}
+
+func (LiveSession) TableName() string {
+	return "live_sessions"
+}
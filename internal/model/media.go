@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// 上传文件的用途，决定允许的格式和大小
+const (
+	MediaVideo = "video"
+	MediaCover = "cover"
+)
+
+// MediaFile 上传完成的文件，发布视频时引用它的ID
+// 存储里的对象按内容的SHA-256命名，不同用户上传同一个文件只存一份；同一个用户重复上传返回原来的记录
+type MediaFile struct {
+	ID          uint64 `gorm:"primarykey"`
+	UploaderID  uint64 `gorm:"not null;uniqueIndex:idx_uploader_kind_hash,priority:1"`
+	Kind        string `gorm:"size:16;not null;uniqueIndex:idx_uploader_kind_hash,priority:2"`
+	Hash        string `gorm:"size:64;not null;uniqueIndex:idx_uploader_kind_hash,priority:3;index"` // 十六进制SHA-256
+	Size        int64  `gorm:"not null"`
+	ContentType string `gorm:"size:64;not null"`
+	StorageKey  string `gorm:"size:255;not null"`
+	URL         string `gorm:"size:512;not null"`
+	CreatedAt   time.Time
+}
+
+func (MediaFile) TableName() string {
+	return "media_files"
+}
@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+// outbox消息的状态
+const (
+	OutboxStatusPending = "pending" // 等待relay投递（包括投递失败、等待下次重试的）
+	OutboxStatusSent    = "sent"    // broker已确认入队
+	OutboxStatusFailed  = "failed"  // 超过最大重试次数，需要人工排查
+)
+
+// OutboxMessage 待投递到RabbitMQ的消息，和业务数据写在同一个数据库里，由relay进程轮询投递
+// 投递是“至少一次”的，MessageID会作为amqp的MessageId一起发出，消费者用它去重
+type OutboxMessage struct {
+	ID         uint64 `gorm:"primarykey"`
+	MessageID  string `gorm:"size:64;not null;uniqueIndex"`
+	Exchange   string `gorm:"size:255;not null;default:''"`
+	RoutingKey string `gorm:"size:255;not null"`
+	Payload    []byte `gorm:"type:blob;not null"`
+	// relay按(status, next_attempt_at)扫描待发送的消息，scheduler按(status, sent_at)清理已经投递的消息
+	Status        string     `gorm:"size:16;not null;index:idx_outbox_status_next;index:idx_outbox_status_sent"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_status_next"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string     `gorm:"type:text"`
+	SentAt        *time.Time `gorm:"index:idx_outbox_status_sent"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
+
+// ConsumedMessage 消费者已经处理过的消息ID，和业务数据在同一个事务中写入，重复投递时主键冲突即可识别
+type ConsumedMessage struct {
+	MessageID string    `gorm:"primarykey;size:64"`
+	Queue     string    `gorm:"size:255;not null"`
+	CreatedAt time.Time `gorm:"index"` // scheduler按写入时间清理
+}
+
+func (ConsumedMessage) TableName() string {
+	return "consumed_messages"
+}
@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+// 红包状态：active（可以抢）→ refunded（已过期，没抢完的金币已退回主播）
+const (
+	RedPacketActive   = "active"
+	RedPacketRefunded = "refunded"
+)
+
+// RedPacket 主播在直播间发的红包，发出时金币从主播转到平台账户托管，预先拆好的份额放在Redis列表里供观众抢
+// 抢到的份额由消费者异步落库，GrabbedAmount可能暂时落后于Redis
+type RedPacket struct {
+	// 随机生成的字符串ID，事务回滚后不会被复用，Redis里的份额不会对应到另一个红包上
+	ID        string `gorm:"primarykey;size:32"`
+	SenderID  uint64 `gorm:"not null;uniqueIndex:idx_sender_request,priority:1"`
+	RequestID string `gorm:"size:64;not null;uniqueIndex:idx_sender_request,priority:2"`
+	RoomID    uint64 `gorm:"not null;index"`
+	SessionID uint64 `gorm:"not null"`
+	Amount    int64  `gorm:"not null"`
+	Count     int    `gorm:"not null"`
+	// 已经落库的份额
+	GrabbedAmount int64 `gorm:"not null;default:0"`
+	GrabbedCount  int   `gorm:"not null;default:0"`
+	// 过期时退回主播的金币
+	RefundedAmount int64     `gorm:"not null;default:0"`
+	Status         string    `gorm:"size:16;not null;default:active;index:idx_status_expires,priority:1"`
+	ExpiresAt      time.Time `gorm:"not null;index:idx_status_expires,priority:2"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (RedPacket) TableName() string {
+	return "red_packets"
+}
+
+// RedPacketGrab 一个观众抢到的一份红包，(packet_id, user_id)唯一，和观众的流水在同一个事务中写入
+type RedPacketGrab struct {
+	ID        uint64 `gorm:"primarykey"`
+	PacketID  string `gorm:"size:32;not null;uniqueIndex:idx_packet_user,priority:1"`
+	UserID    uint64 `gorm:"not null;uniqueIndex:idx_packet_user,priority:2"`
+	Amount    int64  `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func (RedPacketGrab) TableName() string {
+	return "red_packet_grabs"
+}
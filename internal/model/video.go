@@ -1,5 +1,33 @@
 package model
 
+import "time"
+
+// 视频的处理状态：uploaded（已发布，等待转码）→ processing（转码中）→ ready（可以观看）/ failed（转码失败）
+// 只有ready的视频出现在Feed里、可以被查看；直播回放和之前发布的视频直接就是ready
+const (
+	VideoUploaded   = "uploaded"
+	VideoProcessing = "processing"
+	VideoReady      = "ready"
+	VideoFailed     = "failed"
+)
+
+// 可见范围：public（所有人，出现在Feed里）、unlisted（不出现在Feed里，拿到链接的人都能看）、
+// followers（只有作者的粉丝能看）、private（只有作者自己能看）
+const (
+	VisibilityPublic    = "public"
+	VisibilityUnlisted  = "unlisted"
+	VisibilityFollowers = "followers"
+	VisibilityPrivate   = "private"
+)
+
+// 发布状态：draft（草稿）、scheduled（定时发布，到publish_at后由scheduler发布）、published（已发布）
+// 发布和转码互不影响，两者都完成后作者以外的人才能看到；之前的视频和直播回放直接就是published
+const (
+	PublishDraft     = "draft"
+	PublishScheduled = "scheduled"
+	PublishPublished = "published"
+)
+
 // Video结构，视频都要有什么？比如b站的视频，up主（作者），标题，简介
 type Video struct {
 	BaseModel
@@ -8,10 +36,39 @@ type Video struct {
 	Description string // 视频简介
 	LikeCount   uint64 `gorm:"default:0"`
 	GoldenCount uint64 `gorm:"default:0"`
+	// 作者每修改一次加1，客户端修改时用If-Match带上读到的版本，不会覆盖别人的修改
+	Version uint64 `gorm:"not null;default:0"`
 
 	VideoURL string `gorm:"not null"` // 视频播放地址
 	CoverURL string `gorm:"not null"` // 视频封面地址
 
+	// 直播回放：录制自哪一场直播，普通投稿为NULL；每场直播只生成一个回放，弹幕时间轴按这场直播的开播时间对齐
+	LiveSessionID *uint64 `gorm:"uniqueIndex"`
+
+	// 上传的原始文件，转码时从存储中读取；直播回放没有
+	SourceFileID     uint64
+	ProcessingStatus string `gorm:"size:16;not null;default:ready;index"`
+	// 转码失败的原因
+	ProcessingError string `gorm:"size:255"`
+	// 转码后才有：多清晰度HLS的主播放列表，时长（毫秒）和原视频的分辨率
+	HLSURL     string
+	DurationMS int64
+	Width      int
+	Height     int
+
+	Visibility    string `gorm:"size:16;not null;default:public"`
+	PublishStatus string `gorm:"size:16;not null;default:published;index:idx_video_publish,priority:1"`
+	// 定时发布的时间，发布后是实际的发布时间，Feed按它排序；草稿为NULL
+	PublishAt *time.Time `gorm:"index:idx_video_publish,priority:2"`
+
 	// 外键AuthorID和User表的ID
 	Author User `gorm:"foreignKey:AuthorID;references:ID"`
 }
+
+// PublishTime 视频的发布时间，黄金评论的开放窗口从这时算起；没有publish_at的老数据按创建时间算
+func (v *Video) PublishTime() time.Time {
+	if v.PublishAt != nil {
+		return *v.PublishAt
+	}
+	return v.CreatedAt
+}
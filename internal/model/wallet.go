@@ -0,0 +1,86 @@
+package model
+
+import "time"
+
+// 平台账户：充值的对手方、红包的托管方，只记分录不记余额（不建wallets行），避免所有充值争抢同一行的锁
+const PlatformAccountID uint64 = 0
+
+// 流水类型
+const (
+	LedgerTopUp        = "topup"         // 充值，平台账户 → 用户
+	LedgerGiftSent     = "gift_sent"     // 送出礼物，观众 → 主播
+	LedgerGiftReceived = "gift_received" // 收到礼物
+	LedgerRefund       = "refund"        // 礼物退款，主播 → 观众，双方的分录都是这个类型
+	// 红包的金币先从主播转到平台账户托管，抢到的份额再从平台账户转给观众，过期没抢完的转回主播
+	LedgerRedPacketSend   = "redpacket_send"
+	LedgerRedPacketGrab   = "redpacket_grab"
+	LedgerRedPacketRefund = "redpacket_refund"
+)
+
+// Wallet 用户的金币钱包，余额只能通过一笔转账（两条流水）修改，改动前必须SELECT ... FOR UPDATE锁住
+type Wallet struct {
+	UserID    uint64 `gorm:"primarykey;autoIncrement:false"`
+	Balance   int64  `gorm:"not null;default:0"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (Wallet) TableName() string {
+	return "wallets"
+}
+
+// WalletLedgerEntry 复式记账的一条分录：每笔转账写两条，付款方Amount为负、收款方为正，同一TransferID的Amount之和为0
+// (transfer_id, user_id)唯一，同一笔转账重复执行时插入失败，整个事务回滚，实现幂等
+type WalletLedgerEntry struct {
+	ID         uint64 `gorm:"primarykey"`
+	TransferID string `gorm:"size:96;not null;uniqueIndex:idx_transfer_user,priority:1"`
+	// 二级索引隐含主键，按user_id查流水时可以直接按id倒序
+	UserID uint64 `gorm:"not null;uniqueIndex:idx_transfer_user,priority:2;index"`
+	// 对手方，充值时是平台账户
+	CounterpartyID uint64 `gorm:"not null"`
+	Type           string `gorm:"size:16;not null"`
+	Amount         int64  `gorm:"not null"`
+	// 这条分录之后的余额，平台账户不记余额，为0
+	BalanceAfter int64 `gorm:"not null"`
+	CreatedAt    time.Time
+}
+
+func (WalletLedgerEntry) TableName() string {
+	return "wallet_ledger_entries"
+}
+
+// GiftRecord 一次送礼，和双方的流水在同一个事务中写入，排行榜按它重建
+type GiftRecord struct {
+	ID         uint64 `gorm:"primarykey"`
+	TransferID string `gorm:"size:96;not null;uniqueIndex"`
+	SenderID   uint64 `gorm:"not null;index"`
+	StreamerID uint64 `gorm:"not null;index"`
+	RoomID     uint64 `gorm:"not null;index:idx_room_created,priority:1"`
+	SessionID  uint64 `gorm:"not null;index"`
+	GiftID     uint64 `gorm:"not null"`
+	Count      int    `gorm:"not null"`
+	Amount     int64  `gorm:"not null"` // 总价，金币
+	// 退款后写入，退过款的礼物不再计入排行榜
+	RefundedAt *time.Time
+	CreatedAt  time.Time `gorm:"index:idx_room_created,priority:2"`
+}
+
+func (GiftRecord) TableName() string {
+	return "gift_records"
+}
+
+// 送礼预扣记录的状态：pending → committed | refunded
+const (
+	GiftTransferPending   = "pending"   // Redis已预扣，等待消费者落库
+	GiftTransferCommitted = "committed" // 流水已落库
+	GiftTransferRefunded  = "refunded"  // 落库失败，预扣的金币已退回Redis余额
+)
+
+// GiftTransfer 送礼在Redis中的预扣记录，存在哈希wallet:transfer:{id}中，不落库；同时是request_id的去重记录
+type GiftTransfer struct {
+	ID        string
+	SenderID  uint64
+	Amount    int64
+	Status    string
+	CreatedAt time.Time
+}
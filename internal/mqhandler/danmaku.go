@@ -0,0 +1,53 @@
+package mqhandler
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+	"gorm.io/gorm"
+)
+
+// NewDanmakuHandler 弹幕批次处理器：1、反序列化DanmakuBatchMessage 2、在事务中记录消息ID并整批插入弹幕 3、重复消费产生的重复键错误视为成功
+func NewDanmakuHandler(db *gorm.DB) consumer.Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var msg message.DanmakuBatchMessage
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			return consumer.Permanent(fmt.Errorf("消息JSON解析失败: %w", err))
+		}
+		logCtx := logger.Log.WithField("message_id", d.MessageId).WithField("count", len(msg.Items)).WithField("redelivered", d.Redelivered)
+		logCtx.Debug("收到一批弹幕")
+
+		items := make([]model.Danmaku, 0, len(msg.Items))
+		for _, it := range msg.Items {
+			items = append(items, model.Danmaku{
+				SessionID: it.SessionID,
+				RoomID:    it.RoomID,
+				UserID:    it.UserID,
+				Content:   it.Content,
+				SentAt:    time.UnixMilli(it.SentAt),
+			})
+		}
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// 弹幕没有业务唯一键，整批靠消息ID去重
+			if d.MessageId != "" {
+				if err := repository.NewInboxRepository(tx).MarkConsumed(d.MessageId, message.QueueDanmaku); err != nil {
+					return err
+				}
+			}
+			return repository.NewDanmakuRepository(tx).CreateBatch(items)
+		})
+		if err != nil && repository.IsDuplicateEntry(err) {
+			logCtx.WithError(err).Warn("这批弹幕已经落库，可能是一次重复消费，消息将被确认为成功。")
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,165 @@
+package mqhandler
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/live"
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/streadway/amqp"
+)
+
+// NewGiftHandler 送礼处理器：1、反序列化消息，预扣已经退回的直接丢弃 2、利用“工作单元”在同一事务中记录消息ID、执行观众 → 主播的转账（FOR UPDATE检查余额）、
+// 写入送礼记录和礼物事件的outbox 3、重复键错误视为已经落库 4、落库后解冻Redis中的预扣；解冻失败返回错误，重试时走重复消费的分支再解冻一次
+// MySQL余额不足是永久错误，直接进入死信，由死信回调解冻
+func NewGiftHandler(uow data.UnitOfWork, walletRepo repository.WalletRepository) consumer.Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var msg message.GiftMessage
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			return consumer.Permanent(fmt.Errorf("消息JSON解析失败: %w", err))
+		}
+		logCtx := logger.Log.WithField("message_id", d.MessageId).WithField("transfer_id", msg.TransferID).WithField("redelivered", d.Redelivered)
+		logCtx.Info("收到一条送礼消息")
+
+		transfer, err := walletRepo.GetTransfer(msg.TransferID)
+		if err != nil {
+			return fmt.Errorf("查询预扣记录失败: %w", err)
+		}
+		if transfer != nil && transfer.Status == model.GiftTransferRefunded {
+			logCtx.Warn("预扣的金币已退回，丢弃这次送礼")
+			return nil
+		}
+
+		err = uow.Execute(func(repos *data.TransactionalRepositories) error {
+			if d.MessageId != "" {
+				if err := repos.InboxRepo.MarkConsumed(d.MessageId, message.QueueGift); err != nil {
+					return err
+				}
+			}
+			if err := repos.WalletRepo.Transfer(repository.Transfer{
+				TransferID: msg.TransferID,
+				FromUserID: msg.SenderID,
+				ToUserID:   msg.StreamerID,
+				Amount:     msg.Amount,
+				FromType:   model.LedgerGiftSent,
+				ToType:     model.LedgerGiftReceived,
+			}); err != nil {
+				return err
+			}
+			if err := repos.GiftRepo.Create(&model.GiftRecord{
+				TransferID: msg.TransferID,
+				SenderID:   msg.SenderID,
+				StreamerID: msg.StreamerID,
+				RoomID:     msg.RoomID,
+				SessionID:  msg.SessionID,
+				GiftID:     msg.GiftID,
+				Count:      msg.Count,
+				Amount:     msg.Amount,
+				CreatedAt:  time.UnixMilli(msg.SentAt),
+			}); err != nil {
+				return err
+			}
+			// 礼物事件和流水在同一个事务里，落库了就一定会推送到直播间和排行榜
+			event, err := message.NewOutbox(message.QueueGiftEvent, msg)
+			if err != nil {
+				return err
+			}
+			return repos.OutboxRepo.Create(event)
+		})
+		switch {
+		case errors.Is(err, repository.ErrInsufficientBalance):
+			return consumer.Permanent(err)
+		case err != nil && repository.IsDuplicateEntry(err):
+			logCtx.WithError(err).Warn("处理消息时出现重复键错误，可能是一次重复消费，消息将被确认为成功。")
+		case err != nil:
+			return err
+		}
+
+		if err := walletRepo.CommitTransfer(msg.TransferID, msg.SenderID); err != nil {
+			if errors.Is(err, repository.ErrTransferRefunded) {
+				// 检查之后、落库之前预扣被退回了：流水已经扣过款，Redis里的冻结也解除了，可用余额以MySQL为准，仍然是对的
+				logCtx.Error("送礼已落库，但预扣记录已被退回")
+				return nil
+			}
+			return fmt.Errorf("解冻预扣的金币失败: %w", err)
+		}
+		logCtx.WithField("amount", msg.Amount).Info("送礼已落库")
+		return nil
+	}
+}
+
+// NewGiftDeadLetter 送礼最终落库失败（比如MySQL余额不足）时解冻预扣的金币
+func NewGiftDeadLetter(release service.GiftReleaseService) func(ctx context.Context, d amqp.Delivery, err error) {
+	return func(ctx context.Context, d amqp.Delivery, err error) {
+		var msg message.GiftMessage
+		if json.Unmarshal(d.Body, &msg) != nil || msg.TransferID == "" {
+			return
+		}
+		if releaseErr := release.Release(msg.TransferID, msg.SenderID, err.Error()); releaseErr != nil {
+			logger.Log.WithError(releaseErr).WithField("transfer_id", msg.TransferID).Error("解冻预扣的金币失败")
+		}
+	}
+}
+
+// NewGiftPublishFailed relay投递送礼消息最终失败时解冻预扣的金币，消息没进过队列，消费者不会再处理它
+func NewGiftPublishFailed(release service.GiftReleaseService) func(msg *model.OutboxMessage) {
+	return func(outboxMsg *model.OutboxMessage) {
+		var msg message.GiftMessage
+		if json.Unmarshal(outboxMsg.Payload, &msg) != nil || msg.TransferID == "" {
+			return
+		}
+		if err := release.Release(msg.TransferID, msg.SenderID, "送礼消息投递失败"); err != nil {
+			logger.Log.WithError(err).WithField("transfer_id", msg.TransferID).Error("解冻预扣的金币失败")
+		}
+	}
+}
+
+// GiftEvent 推送给直播间观众的礼物
+type GiftEvent struct {
+	RoomID     uint64 `json:"room_id"`
+	SenderID   uint64 `json:"sender_id"`
+	SenderName string `json:"sender_name"`
+	GiftID     uint64 `json:"gift_id"`
+	GiftName   string `json:"gift_name"`
+	Count      int    `json:"count"`
+	Amount     int64  `json:"amount"`
+	SentAt     int64  `json:"sent_at"`
+}
+
+// NewGiftEventHandler 礼物事件处理器：1、计入贡献榜，同一次送礼只计一次 2、推送到直播间的Redis频道，连在任意server实例上的观众都能看到
+// 先计入贡献榜：推送失败重试时榜单不会重复累加；推送是“至少一次”的，重复消费时观众可能看到两次礼物特效，不影响金币
+func NewGiftEventHandler(rdb *redis.Client, leaderboard service.LeaderboardService) consumer.Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var msg message.GiftMessage
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			return consumer.Permanent(fmt.Errorf("消息JSON解析失败: %w", err))
+		}
+		if err := leaderboard.RecordGift(msg); err != nil {
+			return fmt.Errorf("计入贡献榜失败: %w", err)
+		}
+		event := live.Event{Type: live.EventGift, Data: GiftEvent{
+			RoomID:     msg.RoomID,
+			SenderID:   msg.SenderID,
+			SenderName: msg.SenderName,
+			GiftID:     msg.GiftID,
+			GiftName:   msg.GiftName,
+			Count:      msg.Count,
+			Amount:     msg.Amount,
+			SentAt:     msg.SentAt,
+		}}
+		if err := live.PublishEvent(ctx, rdb, msg.RoomID, event); err != nil {
+			return fmt.Errorf("推送礼物到直播间失败: %w", err)
+		}
+		return nil
+	}
+}
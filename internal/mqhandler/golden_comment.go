@@ -0,0 +1,123 @@
+package mqhandler
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// NewGoldenCommentHandler 黄金评论处理器：1、反序列化消息 2、利用“工作单元”在同一事务中记录消息ID、插入评论并增加videos.golden_count 3、重复键错误视为成功
+// 4、落库后把预约票改为committed；改票失败返回错误，重试时走重复消费的分支，按(video_id, user_id)找回评论再改一次
+// 席位已经归还（票是refunded）的消息直接丢弃，否则评论数会超过席位数
+func NewGoldenCommentHandler(uow data.UnitOfWork, commentRepo repository.CommentRepository, ticketRepo repository.GoldenTicketRepository) consumer.Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		logCtx := logger.Log.WithField("message_id", d.MessageId).WithField("redelivered", d.Redelivered)
+		logCtx.Info("收到一条黄金评论！")
+
+		var msg message.GoldenCommentMessage
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			return consumer.Permanent(fmt.Errorf("消息JSON解析失败: %w", err))
+		}
+		logCtx = logCtx.WithField("user_id", msg.UserID).WithField("video_id", msg.VideoID).WithField("ticket_id", msg.TicketID)
+		if msg.TicketID != "" {
+			ticket, err := ticketRepo.Get(msg.TicketID)
+			if err != nil {
+				return fmt.Errorf("查询预约票失败: %w", err)
+			}
+			if ticket != nil && ticket.Status == model.GoldenTicketRefunded {
+				logCtx.Warn("预约票的席位已归还，丢弃这条黄金评论")
+				return nil
+			}
+		}
+
+		var commentID uint64
+		err := uow.Execute(func(repos *data.TransactionalRepositories) error {
+			// 评论表没有唯一约束，重复投递全靠消息ID去重
+			if d.MessageId != "" {
+				if err := repos.InboxRepo.MarkConsumed(d.MessageId, message.QueueGoldenComment); err != nil {
+					return err
+				}
+			}
+			newComment := &model.Comment{
+				UserID:   msg.UserID,
+				VideoID:  msg.VideoID,
+				Content:  msg.Content,
+				IsGolden: true,
+			}
+			// Create会被GORM翻译成INSERT语句，数据库在执行INSERT时是原子的，并且会对新插入的行加锁，所以无需手动加锁
+			if err := repos.CommentRepo.Create(newComment); err != nil {
+				// 这里的错误可能是“重复键”，也可能是数据库连接问题
+				return err
+			}
+			// UPDATE时就获取了这行数据的锁，所以无需额外加锁
+			if _, err := repos.VideoRepo.IncrementGoldenCount(newComment.VideoID); err != nil {
+				return err
+			}
+			commentID = newComment.ID
+			// 函数正常返回nil，UoW会帮我们提交事务，否则回滚整个事务
+			return nil
+		})
+		if err != nil && repository.IsDuplicateEntry(err) {
+			logCtx.WithError(err).Warn("处理消息时出现重复键错误，可能是一次重复消费，消息将被确认为成功。")
+			existing, findErr := commentRepo.FindGoldenByUser(msg.VideoID, msg.UserID)
+			if findErr != nil {
+				return findErr
+			}
+			commentID = existing.ID
+		} else if err != nil {
+			return err
+		}
+
+		// 老版本的消息没有票ID
+		if msg.TicketID == "" {
+			return nil
+		}
+		if err := ticketRepo.MarkCommitted(msg.TicketID, commentID); err != nil {
+			if errors.Is(err, repository.ErrTicketTransition) {
+				// 检查之后、落库之前席位被归还了，评论已经落库，留给对账任务处理
+				logCtx.WithField("comment_id", commentID).Error("黄金评论已落库，但预约票已被退还")
+				return nil
+			}
+			return fmt.Errorf("更新预约票状态失败: %w", err)
+		}
+		logCtx.WithField("comment_id", commentID).Info("黄金评论已落库")
+		return nil
+	}
+}
+
+// NewGoldenCommentDeadLetter 黄金评论最终落库失败时归还席位，预约票走到refunded，客户端轮询时能看到失败原因
+func NewGoldenCommentDeadLetter(refund service.GoldenRefundService) func(ctx context.Context, d amqp.Delivery, err error) {
+	return func(ctx context.Context, d amqp.Delivery, err error) {
+		var msg message.GoldenCommentMessage
+		if json.Unmarshal(d.Body, &msg) != nil || msg.TicketID == "" {
+			return
+		}
+		// 归还失败时票停在failed，由对账任务补上
+		if refundErr := refund.Refund(msg.TicketID, msg.VideoID, msg.UserID, "评论发布失败"); refundErr != nil {
+			logger.Log.WithError(refundErr).WithField("ticket_id", msg.TicketID).Error("归还黄金评论席位失败")
+		}
+	}
+}
+
+// NewGoldenCommentPublishFailed relay投递黄金评论消息最终失败（outbox标记为failed）时归还席位，消息没进过队列，消费者不会再处理它
+func NewGoldenCommentPublishFailed(refund service.GoldenRefundService) func(msg *model.OutboxMessage) {
+	return func(outboxMsg *model.OutboxMessage) {
+		var msg message.GoldenCommentMessage
+		if json.Unmarshal(outboxMsg.Payload, &msg) != nil || msg.TicketID == "" {
+			return
+		}
+		if err := refund.Refund(msg.TicketID, msg.VideoID, msg.UserID, "评论消息投递失败"); err != nil {
+			logger.Log.WithError(err).WithField("ticket_id", msg.TicketID).Error("归还黄金评论席位失败")
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package mqhandler
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/streadway/amqp"
+	"gorm.io/gorm"
+)
+
+// NewLikeHandler 点赞消息处理器：1、反序列化LikeMessage 2、在事务中记录消息ID，增/删like关系并同步videos.like_count 3、重复消费产生的重复键错误视为成功
+func NewLikeHandler(db *gorm.DB) consumer.Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		logCtx := logger.Log.WithField("body", string(d.Body)).WithField("redelivered", d.Redelivered)
+		logCtx.Info("收到一条点赞消息")
+
+		var msg message.LikeMessage
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			// 对于无法解析的“坏消息”，重试也没用，直接丢弃
+			return consumer.Permanent(fmt.Errorf("消息JSON解析失败: %w", err))
+		}
+		// db.Transaction事务操作，必须由原始的、全局的数据库连接池(db)来发起，并且tx是一次性的
+		// 如果返回error，gorm会向数据库发送ROLLBACK指令，在tx上的操作，会被撤销
+		// 如果返回nil，gorm会向数据库发送COMMIT指令，在tx上的操作，会被写入数据库
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// 在事务中，我们需要使用临时的、绑定到这个事务(tx)的repository实例
+			txLikeRepo := repository.NewLikeRepository(tx)
+			txVideoRepo := repository.NewVideoRepository(tx, nil) // 事务中不操作Redis，所以rdb传nil
+			// outbox是“至少一次”投递，先记录消息ID，重复的消息在这里就会因主键冲突而回滚
+			if d.MessageId != "" {
+				if err := repository.NewInboxRepository(tx).MarkConsumed(d.MessageId, message.QueueLike); err != nil {
+					return err
+				}
+			}
+
+			switch msg.Action {
+			case message.ActionLike:
+				like := &model.Like{UserID: msg.UserID, VideoID: msg.VideoID}
+				if err := txLikeRepo.Create(like); err != nil {
+					return err // 事务中，返回任何error都会导致回滚
+				}
+				return txVideoRepo.IncrementLikeCount(msg.VideoID)
+			case message.ActionUnlike:
+				deleted, err := txLikeRepo.Delete(msg.UserID, msg.VideoID)
+				if err != nil {
+					return err
+				}
+				// 没有删掉任何行说明已经取消过了，不能再减一次like_count
+				if !deleted {
+					return nil
+				}
+				return txVideoRepo.DecrementLikeCount(msg.VideoID)
+			}
+			return nil // 事务成功，返回nil，GORM会自动提交(Commit)
+		})
+		if err != nil && repository.IsDuplicateEntry(err) {
+			logCtx.WithError(err).Warn("处理消息时出现重复键错误，可能是一次重复消费，消息将被确认为成功。")
+			return nil
+		}
+		return err
+	}
+}
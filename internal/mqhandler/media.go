@@ -0,0 +1,59 @@
+package mqhandler
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/service"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// NewMediaProcessHandler 转码处理器：视频是否已经处理过以数据库中的状态为准，不需要inbox去重
+// 视频不存在时重试也不会成功，直接进入死信；ffmpeg失败、存储出错等按重试策略重试
+func NewMediaProcessHandler(svc service.MediaProcessingService) consumer.Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var msg message.MediaProcessMessage
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			return consumer.Permanent(fmt.Errorf("消息JSON解析失败: %w", err))
+		}
+		logCtx := logger.Log.WithField("message_id", d.MessageId).WithField("video_id", msg.VideoID).WithField("redelivered", d.Redelivered)
+		logCtx.Info("开始处理视频转码")
+		err := svc.Process(msg.VideoID)
+		if errors.Is(err, service.ErrVideoNotFound) {
+			return consumer.Permanent(err)
+		}
+		return err
+	}
+}
+
+// NewMediaProcessDeadLetter 重试用尽后把视频标记为failed，否则它会一直停在processing
+func NewMediaProcessDeadLetter(failure service.MediaFailureService) func(ctx context.Context, d amqp.Delivery, err error) {
+	return func(ctx context.Context, d amqp.Delivery, err error) {
+		var msg message.MediaProcessMessage
+		if json.Unmarshal(d.Body, &msg) != nil || msg.VideoID == 0 {
+			return
+		}
+		if markErr := failure.MarkFailed(msg.VideoID, err.Error()); markErr != nil {
+			logger.Log.WithError(markErr).WithField("video_id", msg.VideoID).Error("标记视频转码失败时出错")
+		}
+	}
+}
+
+// NewMediaProcessPublishFailed relay投递转码消息最终失败时把视频标记为failed，消息没进过队列，视频不会被转码
+func NewMediaProcessPublishFailed(failure service.MediaFailureService) func(msg *model.OutboxMessage) {
+	return func(outboxMsg *model.OutboxMessage) {
+		var msg message.MediaProcessMessage
+		if json.Unmarshal(outboxMsg.Payload, &msg) != nil || msg.VideoID == 0 {
+			return
+		}
+		if err := failure.MarkFailed(msg.VideoID, "转码消息投递失败"); err != nil {
+			logger.Log.WithError(err).WithField("video_id", msg.VideoID).Error("标记视频转码失败时出错")
+		}
+	}
+}
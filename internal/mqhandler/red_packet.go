@@ -0,0 +1,75 @@
+package mqhandler
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// errRedPacketMissing 红包在MySQL中不存在，重试也不会成功
+var errRedPacketMissing = errors.New("红包不存在")
+
+// NewRedPacketGrabHandler 抢红包处理器：1、反序列化消息 2、利用“工作单元”在同一事务中记录消息ID、写入抢到的份额、
+// 执行平台账户 → 观众的转账、累加红包已入账的金额 3、重复键错误视为已经入账
+// 份额在抢的时候已经从Redis中扣掉了，这里失败只能重试，进入死信后用 admin dlq replay 重放
+func NewRedPacketGrabHandler(uow data.UnitOfWork) consumer.Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var msg message.RedPacketGrabMessage
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			return consumer.Permanent(fmt.Errorf("消息JSON解析失败: %w", err))
+		}
+		logCtx := logger.Log.WithField("message_id", d.MessageId).WithField("packet_id", msg.PacketID).WithField("user_id", msg.UserID).WithField("redelivered", d.Redelivered)
+
+		err := uow.Execute(func(repos *data.TransactionalRepositories) error {
+			if d.MessageId != "" {
+				if err := repos.InboxRepo.MarkConsumed(d.MessageId, message.QueueRedPacket); err != nil {
+					return err
+				}
+			}
+			if err := repos.RedPacketRepo.CreateGrab(&model.RedPacketGrab{
+				PacketID:  msg.PacketID,
+				UserID:    msg.UserID,
+				Amount:    msg.Amount,
+				CreatedAt: time.UnixMilli(msg.GrabbedAt),
+			}); err != nil {
+				return err
+			}
+			found, err := repos.RedPacketRepo.AddGrabbed(msg.PacketID, msg.Amount)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return errRedPacketMissing
+			}
+			return repos.WalletRepo.Transfer(repository.Transfer{
+				TransferID: fmt.Sprintf("redpacket:%s:%d", msg.PacketID, msg.UserID),
+				FromUserID: model.PlatformAccountID,
+				ToUserID:   msg.UserID,
+				Amount:     msg.Amount,
+				FromType:   model.LedgerRedPacketGrab,
+				ToType:     model.LedgerRedPacketGrab,
+			})
+		})
+		switch {
+		case errors.Is(err, errRedPacketMissing):
+			return consumer.Permanent(err)
+		case err != nil && repository.IsDuplicateEntry(err):
+			logCtx.WithError(err).Warn("处理消息时出现重复键错误，可能是一次重复消费，消息将被确认为成功。")
+		case err != nil:
+			return err
+		default:
+			logCtx.WithField("amount", msg.Amount).Info("抢到的红包已入账")
+		}
+		return nil
+	}
+}
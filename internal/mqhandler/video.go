@@ -0,0 +1,33 @@
+package mqhandler
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/mq/consumer"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// NewVideoPublishedHandler 视频发布事件：删除视频缓存，否则缓存里还是草稿或定时状态，过期前别人都看不到
+// 删除缓存是幂等的，不需要inbox去重；以后给粉丝推送新视频也挂在这个队列上
+func NewVideoPublishedHandler(videoRepo repository.VideoRepository) consumer.Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var msg message.VideoPublishedMessage
+		if err := json.Unmarshal(d.Body, &msg); err != nil {
+			return consumer.Permanent(fmt.Errorf("消息JSON解析失败: %w", err))
+		}
+		if err := videoRepo.DeleteVideoCache(msg.VideoID); err != nil {
+			return err
+		}
+		logger.Log.WithField("message_id", d.MessageId).
+			WithField("video_id", msg.VideoID).
+			WithField("author_id", msg.AuthorID).
+			WithField("visibility", msg.Visibility).
+			Info("视频已发布")
+		return nil
+	}
+}
@@ -0,0 +1,250 @@
+package reconcile
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/internal/service"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 对账时给缺席位的用户补上的席位值，没有对应的预约票
+const restoredSeatTicket = "reconciled"
+
+// GoldenOptions 黄金评论对账的参数
+type GoldenOptions struct {
+	BatchSize int
+	// 每秒最多检查多少个视频，0表示不限速
+	Rate   int
+	DryRun bool
+	// 这段时间内写入、还没被消费的黄金评论消息视为在途，对应的视频本轮跳过；
+	// 创建超过这么久还是pending、又没有评论的预约票视为丢失，席位会被归还
+	InFlightWindow time.Duration
+	// 黄金评论的开放时长，和server的golden.window一致；席位哈希在窗口结束一天后过期，之后只校验videos.golden_count
+	Window time.Duration
+}
+
+// GoldenDiff 一个视频的黄金评论数据差异，comments表是权威数据
+type GoldenDiff struct {
+	VideoID  uint64
+	Comments int    // comments表中的黄金评论数
+	Column   uint64 // videos.golden_count
+	// 席位哈希是否还在有效期内、参与了比较
+	SeatsChecked bool
+	Seats        int      // 席位哈希中的席位数
+	MissingSeats []uint64 // 有黄金评论、没有席位的用户
+	// 没有黄金评论、预约票也不会再落库的席位，用户ID → 预约票ID
+	OrphanSeats map[uint64]string
+}
+
+// Consistent 数据是否一致；还在等待落库的席位不算差异
+func (d GoldenDiff) Consistent() bool {
+	return uint64(d.Comments) == d.Column && len(d.MissingSeats) == 0 && len(d.OrphanSeats) == 0
+}
+
+// GoldenReconciler 以comments表为准，修复videos.golden_count和video:golden_seats:{id}席位哈希
+type GoldenReconciler struct {
+	videoRepo   repository.VideoRepository
+	commentRepo repository.CommentRepository
+	ticketRepo  repository.GoldenTicketRepository
+	outboxRepo  repository.OutboxRepository
+	refund      service.GoldenRefundService
+	log         *logrus.Logger
+	opts        GoldenOptions
+}
+
+func NewGoldenReconciler(videoRepo repository.VideoRepository, commentRepo repository.CommentRepository, ticketRepo repository.GoldenTicketRepository, outboxRepo repository.OutboxRepository, refund service.GoldenRefundService, log *logrus.Logger, opts GoldenOptions) *GoldenReconciler {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	return &GoldenReconciler{
+		videoRepo:   videoRepo,
+		commentRepo: commentRepo,
+		ticketRepo:  ticketRepo,
+		outboxRepo:  outboxRepo,
+		refund:      refund,
+		log:         log,
+		opts:        opts,
+	}
+}
+
+// Run 跑一轮对账，流程和点赞对账一样：分批读取视频、跳过有在途消息的视频、比较、回调onDiff、非DryRun则修复
+func (r *GoldenReconciler) Run(ctx context.Context, onDiff func(GoldenDiff)) (Report, error) {
+	var report Report
+	var tick <-chan time.Time
+	if r.opts.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(r.opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var lastID uint64
+	for {
+		videos, err := r.videoRepo.ListIDsAfter(lastID, r.opts.BatchSize)
+		if err != nil {
+			return report, err
+		}
+		if len(videos) == 0 {
+			return report, nil
+		}
+		lastID = videos[len(videos)-1].ID
+
+		ids := make([]uint64, len(videos))
+		for i, v := range videos {
+			ids[i] = v.ID
+		}
+		inFlight, err := r.outboxRepo.InFlightVideoIDs(message.QueueGoldenComment, ids, time.Now().Add(-r.opts.InFlightWindow))
+		if err != nil {
+			return report, err
+		}
+
+		for _, video := range videos {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return report, ctx.Err()
+				}
+			} else if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			report.Scanned++
+			if inFlight[video.ID] {
+				report.Skipped++
+				continue
+			}
+			diff, err := r.check(video)
+			if err != nil {
+				return report, err
+			}
+			if diff.Consistent() {
+				continue
+			}
+			report.Inconsistent++
+			if onDiff != nil {
+				onDiff(diff)
+			}
+			if r.opts.DryRun {
+				continue
+			}
+			if err := r.repair(video, diff); err != nil {
+				return report, err
+			}
+			report.Repaired++
+		}
+	}
+}
+
+// check 先读席位再读评论：对账期间新抢到的席位还没有评论，会按预约票状态判断，不会被误删
+func (r *GoldenReconciler) check(video model.Video) (GoldenDiff, error) {
+	diff := GoldenDiff{VideoID: video.ID, Column: video.GoldenCount}
+	windowEnd := video.PublishTime().Add(r.opts.Window)
+	diff.SeatsChecked = time.Now().Before(windowEnd.Add(24 * time.Hour))
+
+	var seats map[uint64]string
+	if diff.SeatsChecked {
+		var err error
+		if seats, err = r.videoRepo.GetGoldenSeats(video.ID); err != nil {
+			return diff, err
+		}
+		diff.Seats = len(seats)
+	}
+	// 条数和SyncGoldenCount的COUNT(*)比较，否则同一个用户有多条黄金评论的视频每次都会被判为不一致；去重的用户只用来比较席位
+	comments, err := r.commentRepo.CountGolden(video.ID)
+	if err != nil {
+		return diff, err
+	}
+	diff.Comments = int(comments)
+	if !diff.SeatsChecked {
+		return diff, nil
+	}
+	users, err := r.commentRepo.ListGoldenUserIDs(video.ID)
+	if err != nil {
+		return diff, err
+	}
+
+	missing, unmatched := diffGoldenSeats(users, seats)
+	diff.MissingSeats = missing
+	now := time.Now()
+	for userID, ticketID := range unmatched {
+		ticket, err := r.ticketRepo.Get(ticketID)
+		if err != nil {
+			return diff, err
+		}
+		if !seatAbandoned(ticket, now, r.opts.InFlightWindow) {
+			continue
+		}
+		if diff.OrphanSeats == nil {
+			diff.OrphanSeats = make(map[uint64]string)
+		}
+		diff.OrphanSeats[userID] = ticketID
+	}
+	return diff, nil
+}
+
+func (r *GoldenReconciler) repair(video model.Video, diff GoldenDiff) error {
+	if diff.Column != uint64(diff.Comments) {
+		if err := r.videoRepo.SyncGoldenCount(diff.VideoID); err != nil {
+			return err
+		}
+	}
+	windowEnd := video.PublishTime().Add(r.opts.Window)
+	for _, userID := range diff.MissingSeats {
+		if err := r.videoRepo.RestoreGoldenSeat(diff.VideoID, userID, restoredSeatTicket, windowEnd); err != nil {
+			return err
+		}
+	}
+	for userID, ticketID := range diff.OrphanSeats {
+		if err := r.refund.Refund(ticketID, diff.VideoID, userID, "对账发现评论未落库"); err != nil {
+			return err
+		}
+		// 票已经是refunded时Refund不会再动席位，这里按票ID补一次归还
+		if _, err := r.videoRepo.ReleaseGoldenSeat(diff.VideoID, userID, ticketID); err != nil {
+			return err
+		}
+	}
+	r.log.WithField("video_id", diff.VideoID).
+		WithField("comments", diff.Comments).
+		WithField("column", diff.Column).
+		WithField("seats", diff.Seats).
+		WithField("missing_seats", len(diff.MissingSeats)).
+		WithField("orphan_seats", len(diff.OrphanSeats)).
+		Info("黄金评论数据已修复")
+	return nil
+}
+
+// diffGoldenSeats 返回有评论没席位的用户，以及有席位没评论的席位
+func diffGoldenSeats(users []uint64, seats map[uint64]string) (missing []uint64, unmatched map[uint64]string) {
+	hasComment := make(map[uint64]bool, len(users))
+	for _, userID := range users {
+		hasComment[userID] = true
+		if _, ok := seats[userID]; !ok {
+			missing = append(missing, userID)
+		}
+	}
+	unmatched = make(map[uint64]string)
+	for userID, ticketID := range seats {
+		if !hasComment[userID] {
+			unmatched[userID] = ticketID
+		}
+	}
+	return missing, unmatched
+}
+
+// seatAbandoned 没有评论的席位是否可以归还：票已过期、已失败，或者pending超过了在途窗口
+// committed的票说明评论落库后被删除了，席位不归还
+func seatAbandoned(ticket *model.GoldenTicket, now time.Time, inFlight time.Duration) bool {
+	if ticket == nil {
+		return true
+	}
+	switch ticket.Status {
+	case model.GoldenTicketFailed, model.GoldenTicketRefunded:
+		return true
+	case model.GoldenTicketPending:
+		return now.Sub(ticket.CreatedAt) > inFlight
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package reconcile
+
+import (
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDiffGoldenSeats(t *testing.T) {
+	seats := map[uint64]string{1: "t1", 2: "t2", 4: "t4"}
+	missing, unmatched := diffGoldenSeats([]uint64{1, 2, 3}, seats)
+	if !reflect.DeepEqual(missing, []uint64{3}) {
+		t.Errorf("missing = %v, want [3]", missing)
+	}
+	if !reflect.DeepEqual(unmatched, map[uint64]string{4: "t4"}) {
+		t.Errorf("unmatched = %v, want map[4:t4]", unmatched)
+	}
+}
+
+func TestSeatAbandoned(t *testing.T) {
+	now := time.Now()
+	window := 10 * time.Minute
+	cases := []struct {
+		name   string
+		ticket *model.GoldenTicket
+		want   bool
+	}{
+		{"票已过期", nil, true},
+		{"failed", &model.GoldenTicket{Status: model.GoldenTicketFailed}, true},
+		{"refunded", &model.GoldenTicket{Status: model.GoldenTicketRefunded}, true},
+		{"committed", &model.GoldenTicket{Status: model.GoldenTicketCommitted}, false},
+		{"pending在途", &model.GoldenTicket{Status: model.GoldenTicketPending, CreatedAt: now.Add(-time.Minute)}, false},
+		{"pending超时", &model.GoldenTicket{Status: model.GoldenTicketPending, CreatedAt: now.Add(-time.Hour)}, true},
+	}
+	for _, c := range cases {
+		if got := seatAbandoned(c.ticket, now, window); got != c.want {
+			t.Errorf("%s: seatAbandoned = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// 只实现黄金评论对账用到的方法，其他方法调用到会因为内嵌的接口是nil而panic
+type goldenVideoRepo struct {
+	repository.VideoRepository
+	videos []model.Video
+	seats  map[uint64]string
+	synced int
+}
+
+func (r *goldenVideoRepo) ListIDsAfter(afterID uint64, limit int) ([]model.Video, error) {
+	var videos []model.Video
+	for _, v := range r.videos {
+		if v.ID > afterID && len(videos) < limit {
+			videos = append(videos, v)
+		}
+	}
+	return videos, nil
+}
+
+func (r *goldenVideoRepo) GetGoldenSeats(videoID uint64) (map[uint64]string, error) {
+	return r.seats, nil
+}
+
+func (r *goldenVideoRepo) SyncGoldenCount(videoID uint64) error {
+	r.synced++
+	return nil
+}
+
+type goldenCommentRepo struct {
+	repository.CommentRepository
+	userIDs []uint64 // 每条黄金评论的作者
+}
+
+func (r *goldenCommentRepo) ListGoldenUserIDs(videoID uint64) ([]uint64, error) {
+	var ids []uint64
+	seen := make(map[uint64]bool)
+	for _, id := range r.userIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (r *goldenCommentRepo) CountGolden(videoID uint64) (int64, error) {
+	return int64(len(r.userIDs)), nil
+}
+
+type goldenOutboxRepo struct {
+	repository.OutboxRepository
+}
+
+func (goldenOutboxRepo) InFlightVideoIDs(routingKey string, videoIDs []uint64, since time.Time) (map[uint64]bool, error) {
+	return nil, nil
+}
+
+// 每人一个席位之前的旧数据：用户1有两条黄金评论，golden_count按条数是3，不应被判为不一致，也不应每轮都修复
+func TestGoldenReconcileDuplicateCommenter(t *testing.T) {
+	now := time.Now()
+	videoRepo := &goldenVideoRepo{
+		videos: []model.Video{{BaseModel: model.BaseModel{ID: 1, CreatedAt: now}, GoldenCount: 3}},
+		seats:  map[uint64]string{1: "t1", 2: "t2"},
+	}
+	commentRepo := &goldenCommentRepo{userIDs: []uint64{1, 1, 2}}
+	r := NewGoldenReconciler(videoRepo, commentRepo, nil, goldenOutboxRepo{}, nil, logrus.New(), GoldenOptions{Window: 10 * time.Minute})
+
+	var diffs []GoldenDiff
+	report, err := r.Run(context.Background(), func(d GoldenDiff) { diffs = append(diffs, d) })
+	if err != nil {
+		t.Fatalf("对账失败: %v", err)
+	}
+	if report.Scanned != 1 || report.Inconsistent != 0 || report.Repaired != 0 {
+		t.Errorf("report = %+v, want 1个视频且一致", report)
+	}
+	if len(diffs) != 0 || videoRepo.synced != 0 {
+		t.Errorf("diffs = %+v, synced = %d, want 没有差异也没有修复", diffs, videoRepo.synced)
+	}
+}
@@ -0,0 +1,213 @@
+package reconcile
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LikeOptions 点赞对账的参数
+type LikeOptions struct {
+	// 每批从videos表读取的视频数
+	BatchSize int
+	// 每秒最多检查多少个视频，0表示不限速；线上跑时用它控制对MySQL和Redis的压力
+	Rate int
+	// 只输出差异，不做修复
+	DryRun bool
+	// 这段时间内写入、还没被消费的点赞消息视为在途，对应的视频本轮跳过
+	InFlightWindow time.Duration
+}
+
+// LikeDiff 一个视频的点赞数据差异，likes表是权威数据
+type LikeDiff struct {
+	VideoID        uint64
+	Likes          int      // likes表中的点赞数
+	Column         uint64   // videos.like_count
+	RedisCount     uint64   // video:like_counts中的点赞数
+	MissingInRedis []uint64 // likes表有、Redis集合里没有的用户
+	ExtraInRedis   []uint64 // Redis集合里有、likes表没有的用户
+}
+
+// Consistent 三处数据是否一致
+func (d LikeDiff) Consistent() bool {
+	return uint64(d.Likes) == d.Column && uint64(d.Likes) == d.RedisCount &&
+		len(d.MissingInRedis) == 0 && len(d.ExtraInRedis) == 0
+}
+
+// Report 一轮对账的统计
+type Report struct {
+	Scanned      int // 检查过的视频数
+	Skipped      int // 有在途消息而跳过的视频数
+	Inconsistent int // 发现不一致的视频数
+	Repaired     int // 已修复的视频数
+}
+
+// LikeReconciler 以likes表为准，修复videos.like_count、video:likers:{id}集合和video:like_counts哈希
+type LikeReconciler struct {
+	videoRepo  repository.VideoRepository
+	likeRepo   repository.LikeRepository
+	outboxRepo repository.OutboxRepository
+	log        *logrus.Logger
+	opts       LikeOptions
+}
+
+func NewLikeReconciler(videoRepo repository.VideoRepository, likeRepo repository.LikeRepository, outboxRepo repository.OutboxRepository, log *logrus.Logger, opts LikeOptions) *LikeReconciler {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	return &LikeReconciler{
+		videoRepo:  videoRepo,
+		likeRepo:   likeRepo,
+		outboxRepo: outboxRepo,
+		log:        log,
+		opts:       opts,
+	}
+}
+
+// Run 跑一轮对账：1、按id分批读取视频 2、跳过有在途点赞消息的视频 3、逐个比较三处数据 4、不一致时回调onDiff，非DryRun则修复
+func (r *LikeReconciler) Run(ctx context.Context, onDiff func(LikeDiff)) (Report, error) {
+	var report Report
+	var tick <-chan time.Time
+	if r.opts.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(r.opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var lastID uint64
+	for {
+		videos, err := r.videoRepo.ListIDsAfter(lastID, r.opts.BatchSize)
+		if err != nil {
+			return report, err
+		}
+		if len(videos) == 0 {
+			return report, nil
+		}
+		lastID = videos[len(videos)-1].ID
+
+		ids := make([]uint64, len(videos))
+		for i, v := range videos {
+			ids[i] = v.ID
+		}
+		inFlight, err := r.outboxRepo.InFlightVideoIDs(message.QueueLike, ids, time.Now().Add(-r.opts.InFlightWindow))
+		if err != nil {
+			return report, err
+		}
+
+		for _, video := range videos {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return report, ctx.Err()
+				}
+			} else if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			report.Scanned++
+			if inFlight[video.ID] {
+				report.Skipped++
+				continue
+			}
+			diff, err := r.check(video)
+			if err != nil {
+				return report, err
+			}
+			if diff.Consistent() {
+				continue
+			}
+			report.Inconsistent++
+			if onDiff != nil {
+				onDiff(diff)
+			}
+			if r.opts.DryRun {
+				continue
+			}
+			if err := r.repair(diff); err != nil {
+				return report, err
+			}
+			report.Repaired++
+		}
+	}
+}
+
+// check 先读Redis再读MySQL：对账期间的新点赞会先出现在Redis里，这样不会被当成多余数据删掉
+// 点赞数太多、没有全部缓存在Redis的视频，只比较点赞数，不比较集合
+func (r *LikeReconciler) check(video model.Video) (LikeDiff, error) {
+	// 读点赞数会触发冷启动加载，所以要在读集合之前
+	redisCount, err := r.videoRepo.GetVideoLikeCount(video.ID)
+	if err != nil {
+		return LikeDiff{}, err
+	}
+	fullyCached, err := r.videoRepo.LikersFullyCached(video.ID)
+	if err != nil {
+		return LikeDiff{}, err
+	}
+	var redisLikers []uint64
+	if fullyCached {
+		if redisLikers, err = r.videoRepo.GetVideoLikers(video.ID); err != nil {
+			return LikeDiff{}, err
+		}
+	}
+	dbLikers, err := r.likeRepo.ListUserIDsByVideo(video.ID)
+	if err != nil {
+		return LikeDiff{}, err
+	}
+	var missing, extra []uint64
+	if fullyCached {
+		missing, extra = diffLikers(dbLikers, redisLikers)
+	}
+	return LikeDiff{
+		VideoID:        video.ID,
+		Likes:          len(dbLikers),
+		Column:         video.LikeCount,
+		RedisCount:     redisCount,
+		MissingInRedis: missing,
+		ExtraInRedis:   extra,
+	}, nil
+}
+
+func (r *LikeReconciler) repair(diff LikeDiff) error {
+	if diff.Column != uint64(diff.Likes) {
+		if err := r.videoRepo.SyncLikeCount(diff.VideoID); err != nil {
+			return err
+		}
+	}
+	countDelta := int64(diff.Likes) - int64(diff.RedisCount)
+	if err := r.videoRepo.RepairVideoLikes(diff.VideoID, diff.MissingInRedis, diff.ExtraInRedis, countDelta); err != nil {
+		return err
+	}
+	r.log.WithField("video_id", diff.VideoID).
+		WithField("likes", diff.Likes).
+		WithField("column", diff.Column).
+		WithField("redis_count", diff.RedisCount).
+		WithField("missing_in_redis", len(diff.MissingInRedis)).
+		WithField("extra_in_redis", len(diff.ExtraInRedis)).
+		Info("点赞数据已修复")
+	return nil
+}
+
+// diffLikers 比较两个用户集合，返回db有redis没有的，以及redis有db没有的
+func diffLikers(db, redis []uint64) (missing, extra []uint64) {
+	inRedis := make(map[uint64]bool, len(redis))
+	for _, id := range redis {
+		inRedis[id] = true
+	}
+	inDB := make(map[uint64]bool, len(db))
+	for _, id := range db {
+		inDB[id] = true
+		if !inRedis[id] {
+			missing = append(missing, id)
+		}
+	}
+	for _, id := range redis {
+		if !inDB[id] {
+			extra = append(extra, id)
+		}
+	}
+	return missing, extra
+}
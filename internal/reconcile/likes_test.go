@@ -0,0 +1,28 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLikers(t *testing.T) {
+	missing, extra := diffLikers([]uint64{1, 2, 3}, []uint64{2, 3, 4, 5})
+	if !reflect.DeepEqual(missing, []uint64{1}) {
+		t.Errorf("missing = %v, want [1]", missing)
+	}
+	if !reflect.DeepEqual(extra, []uint64{4, 5}) {
+		t.Errorf("extra = %v, want [4 5]", extra)
+	}
+}
+
+func TestLikeDiffConsistent(t *testing.T) {
+	if !(LikeDiff{Likes: 2, Column: 2, RedisCount: 2}).Consistent() {
+		t.Error("三处点赞数相同且集合无差异时应一致")
+	}
+	if (LikeDiff{Likes: 2, Column: 1, RedisCount: 2}).Consistent() {
+		t.Error("videos.like_count不同时应不一致")
+	}
+	if (LikeDiff{Likes: 2, Column: 2, RedisCount: 2, ExtraInRedis: []uint64{9}}).Consistent() {
+		t.Error("Redis集合有多余用户时应不一致")
+	}
+}
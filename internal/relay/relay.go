@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/backoff"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/rabbitmq"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"gorm.io/gorm"
+)
+
+// Relay 轮询outbox_messages表，把待发送的消息投递到RabbitMQ，broker确认后标记为sent
+// 投递和标记不是原子的：确认后、提交前进程崩溃会导致重复投递，消费者按MessageId去重
+type Relay struct {
+	db        *gorm.DB
+	repo      repository.OutboxRepository
+	publisher rabbitmq.Publisher
+	cfg       config.OutboxConfig
+	backoff   backoff.Exponential
+	log       *logrus.Logger
+	// 按routing key注册的回调，消息被标记为failed的事务提交后调用，用于归还业务资源
+	onFailed map[string]func(msg *model.OutboxMessage)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(db *gorm.DB, publisher rabbitmq.Publisher, cfg config.OutboxConfig, log *logrus.Logger) *Relay {
+	return &Relay{
+		db:        db,
+		repo:      repository.NewOutboxRepository(db),
+		publisher: publisher,
+		cfg:       cfg,
+		backoff:   backoff.Exponential{Min: cfg.MinBackoff, Max: cfg.MaxBackoff},
+		log:       log,
+		onFailed:  make(map[string]func(msg *model.OutboxMessage)),
+		done:      make(chan struct{}),
+	}
+}
+
+// OnFailed 注册某个routing key的消息最终投递失败时的回调，必须在Start之前调用
+func (r *Relay) OnFailed(routingKey string, fn func(msg *model.OutboxMessage)) {
+	r.onFailed[routingKey] = fn
+}
+
+// Start 启动后台轮询，立即返回
+func (r *Relay) Start(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.run(runCtx)
+	return nil
+}
+
+// Stop 停止轮询，等待正在处理的一批消息提交，超过ctx截止时间则放弃等待（未提交的消息下次会被重新投递）
+func (r *Relay) Stop(ctx context.Context) error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer close(r.done)
+	for {
+		n, err := r.relayBatch()
+		if err != nil {
+			r.log.WithError(err).Error("outbox投递失败")
+		}
+		// 取满了一批说明还有积压，不等待直接取下一批
+		if err == nil && n >= r.cfg.BatchSize && ctx.Err() == nil {
+			continue
+		}
+		if !backoff.Sleep(ctx, r.cfg.PollInterval) {
+			return
+		}
+	}
+}
+
+// relayBatch 在一个事务中：1、锁定一批到期的消息 2、逐条发布并等待broker确认 3、按结果标记sent/重试/failed
+// 返回本批取到的消息数；一批消息的处理不受Stop打断，避免确认后来不及标记
+// 被标记为failed的消息在事务提交后才回调OnFailed，回滚的话下一批还会再取到
+func (r *Relay) relayBatch() (int, error) {
+	var n int
+	var failed []*model.OutboxMessage
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		repo := r.repo.WithTx(tx)
+		msgs, err := repo.FetchDueForUpdate(time.Now(), r.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		n = len(msgs)
+		for i := range msgs {
+			next, err := r.relayOne(repo, &msgs[i])
+			if err != nil {
+				return err
+			}
+			if msgs[i].Status == model.OutboxStatusFailed {
+				failed = append(failed, &msgs[i])
+			}
+			if !next {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	for _, msg := range failed {
+		if fn := r.onFailed[msg.RoutingKey]; fn != nil {
+			fn(msg)
+		}
+	}
+	return n, nil
+}
+
+// relayOne 发布一条消息并记录结果，返回是否继续处理这批中后面的消息，只有写数据库失败才返回错误
+// 连接不可用之类的不确定错误，后面的消息大概率也发不出去，提前结束这批，别让每条都等满超时
+func (r *Relay) relayOne(repo repository.OutboxRepository, msg *model.OutboxMessage) (bool, error) {
+	err := r.publisher.Publish(context.Background(), msg.Exchange, msg.RoutingKey, amqp.Publishing{
+		MessageId:    msg.MessageID,
+		Timestamp:    msg.CreatedAt,
+		ContentType:  "application/json",
+		Body:         msg.Payload,
+		DeliveryMode: amqp.Persistent,
+	})
+	if err == nil {
+		return true, repo.MarkSent(msg.ID, time.Now())
+	}
+
+	next := rabbitmq.IsRejected(err)
+	attempts := msg.Attempts + 1
+	logCtx := r.log.WithError(err).
+		WithField("outbox_id", msg.ID).
+		WithField("message_id", msg.MessageID).
+		WithField("routing_key", msg.RoutingKey).
+		WithField("attempts", attempts)
+	if attempts >= r.cfg.MaxAttempts {
+		logCtx.Error("【严重】outbox消息超过最大重试次数，已标记为failed，需人工处理！")
+		msg.Status = model.OutboxStatusFailed
+		return next, repo.MarkFailed(msg.ID, attempts, err.Error())
+	}
+	nextAttemptAt := time.Now().Add(r.backoff.Duration(attempts - 1))
+	logCtx.WithField("next_attempt_at", nextAttemptAt).Warn("outbox消息投递失败，稍后重试")
+	return next, repo.MarkRetry(msg.ID, attempts, nextAttemptAt, err.Error())
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"Orion_Live/internal/model"
+	"Orion_Live/pkg/cursor"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -11,9 +12,15 @@ type CommentRepository interface {
 	Create(comment *model.Comment) error
 	FindByID(commentID uint64) (*model.Comment, error)
 	CreateInTx(tx *gorm.DB, comment *model.Comment) error
+	// 每个用户在每个视频下最多一条黄金评论，重复消费时用它找回已经落库的评论
+	FindGoldenByUser(videoID, userID uint64) (*model.Comment, error)
+	// 对账用：视频下所有发过黄金评论的用户
+	ListGoldenUserIDs(videoID uint64) ([]uint64, error)
+	// 对账用：视频下黄金评论的条数，和VideoRepository.SyncGoldenCount写入videos.golden_count的口径一致
+	CountGolden(videoID uint64) (int64, error)
 
-	// 分页获取视频的一级评论
-	GetCommentsByVideoID(videoID uint64, offset, limit int) ([]model.Comment, error)
+	// 分页获取视频的一级评论，按(created_at, id)倒序，after不为nil时从它之后开始
+	GetCommentsByVideoID(videoID uint64, after *cursor.Cursor, limit int) ([]model.Comment, error)
 	// 根据父评论ID列表，获取二级评论
 	GetRepliesByParentIDs(parentIDs []uint64) ([]model.Comment, error)
 
@@ -58,15 +65,40 @@ func (r *commentRepository) FindByID(commentID uint64) (*model.Comment, error) {
 	return &result, err
 }
 
-// 分页获取一个视频下的一级评论
-func (r *commentRepository) GetCommentsByVideoID(videoID uint64, offset, limit int) ([]model.Comment, error) {
+func (r *commentRepository) FindGoldenByUser(videoID, userID uint64) (*model.Comment, error) {
+	var result model.Comment
+	err := r.db.Where("video_id = ? AND user_id = ? AND is_golden = ?", videoID, userID, true).First(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *commentRepository) ListGoldenUserIDs(videoID uint64) ([]uint64, error) {
+	var ids []uint64
+	err := r.db.Model(&model.Comment{}).Where("video_id = ? AND is_golden = ?", videoID, true).Distinct().Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+// 按条数统计，不按用户去重：每人一个席位之前的旧数据里，同一个用户可能有多条黄金评论
+func (r *commentRepository) CountGolden(videoID uint64) (int64, error) {
+	var n int64
+	err := r.db.Model(&model.Comment{}).Where("video_id = ? AND is_golden = ?", videoID, true).Count(&n).Error
+	return n, err
+}
+
+// 分页获取一个视频下的一级评论，keyset分页：不管翻到第几页都只扫描这一页的行，OFFSET要先扫过前面所有的行
+func (r *commentRepository) GetCommentsByVideoID(videoID uint64, after *cursor.Cursor, limit int) ([]model.Comment, error) {
 	var comments []model.Comment
-	err := r.db.
+	query := r.db.
 		Preload("User"). // 预加载评论的作者信息，能一次性地把作者、被回复者等所有关联信息查询出来
-		Where("video_id = ? AND parent_id IS NULL", videoID).
-		Offset(offset).
+		Where("video_id = ? AND parent_id IS NULL", videoID)
+	if after != nil {
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", after.Time, after.Time, after.ID)
+	}
+	err := query.
 		Limit(limit).
-		Order("created_at desc").
+		Order("created_at desc, id desc").
 		Find(&comments).Error
 	return comments, err
 }
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type DanmakuRepository interface {
+	// CreateBatch 批量插入弹幕，一条INSERT写入多行
+	CreateBatch(items []model.Danmaku) error
+	// ListBySession 一场直播的弹幕，按发送时间顺序，最多limit条
+	ListBySession(sessionID uint64, limit int) ([]model.Danmaku, error)
+
+	WithTx(tx *gorm.DB) DanmakuRepository
+}
+
+type danmakuRepository struct {
+	db *gorm.DB
+}
+
+func NewDanmakuRepository(db *gorm.DB) DanmakuRepository {
+	return &danmakuRepository{db: db}
+}
+
+func (r *danmakuRepository) WithTx(tx *gorm.DB) DanmakuRepository {
+	return &danmakuRepository{db: tx}
+}
+
+func (r *danmakuRepository) CreateBatch(items []model.Danmaku) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return r.db.CreateInBatches(items, 500).Error
+}
+
+func (r *danmakuRepository) ListBySession(sessionID uint64, limit int) ([]model.Danmaku, error) {
+	var items []model.Danmaku
+	err := r.db.Where("session_id = ?", sessionID).Order("sent_at, id").Limit(limit).Find(&items).Error
+	return items, err
+}
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// IsDuplicateEntry 判断是不是可被认为是“成功”的幂等性错误，错误号 1062 就是 "Duplicate entry"
+func IsDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	// 用errors.As来检查错误的“根”是不是一个MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FollowRepository interface {
+	// Follow 返回是否新增了关注，已经关注过时返回false
+	Follow(followerID, followeeID uint64) (bool, error)
+	// Unfollow 返回是否真的取消了关注，本来就没关注时返回false
+	Unfollow(followerID, followeeID uint64) (bool, error)
+	// IsFollowing followerID是否关注了followeeID，仅粉丝可见的视频按它判断
+	IsFollowing(followerID, followeeID uint64) (bool, error)
+}
+
+type followRepository struct {
+	db *gorm.DB
+}
+
+func NewFollowRepository(db *gorm.DB) FollowRepository {
+	return &followRepository{db: db}
+}
+
+// INSERT ... ON DUPLICATE KEY UPDATE id = id，重复关注由唯一索引兜底，不会报错
+func (r *followRepository) Follow(followerID, followeeID uint64) (bool, error) {
+	follow := &model.Follow{FollowerID: followerID, FolloweeID: followeeID}
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(follow)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *followRepository) Unfollow(followerID, followeeID uint64) (bool, error) {
+	res := r.db.Where("follower_id = ? AND followee_id = ?", followerID, followeeID).Delete(&model.Follow{})
+	return res.RowsAffected > 0, res.Error
+}
+
+// (follower_id, followee_id)唯一索引上的单点查询
+func (r *followRepository) IsFollowing(followerID, followeeID uint64) (bool, error) {
+	var n int64
+	err := r.db.Model(&model.Follow{}).Where("follower_id = ? AND followee_id = ?", followerID, followeeID).Limit(1).Count(&n).Error
+	return n > 0, err
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type GiftRepository interface {
+	// Create 插入送礼记录，TransferID重复时返回重复键错误
+	Create(record *model.GiftRecord) error
+	FindByTransferID(transferID string) (*model.GiftRecord, error)
+	// MarkRefunded 只有还没退过款的记录才会被更新，返回是否更新了
+	MarkRefunded(id uint64, refundedAt time.Time) (bool, error)
+	// SumBySender 按送礼人汇总没有退款的送礼金额，按金额倒序，重建排行榜用
+	SumBySender(filter GiftFilter) ([]LeaderboardEntry, error)
+	// ListTransferIDsSince 直播间since之后落库的送礼的转账ID
+	ListTransferIDsSince(roomID uint64, since time.Time) ([]string, error)
+
+	WithTx(tx *gorm.DB) GiftRepository
+}
+
+// GiftFilter 汇总送礼记录的范围，RoomID必填；SessionID为0时不限场次，Since/Until为零值时不限时间，区间左闭右开
+type GiftFilter struct {
+	RoomID    uint64
+	SessionID uint64
+	Since     time.Time
+	Until     time.Time
+}
+
+type giftRepository struct {
+	db *gorm.DB
+}
+
+func NewGiftRepository(db *gorm.DB) GiftRepository {
+	return &giftRepository{db: db}
+}
+
+func (r *giftRepository) WithTx(tx *gorm.DB) GiftRepository {
+	return &giftRepository{db: tx}
+}
+
+func (r *giftRepository) Create(record *model.GiftRecord) error {
+	return r.db.Create(record).Error
+}
+
+func (r *giftRepository) FindByTransferID(transferID string) (*model.GiftRecord, error) {
+	var record model.GiftRecord
+	if err := r.db.Where("transfer_id = ?", transferID).First(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *giftRepository) MarkRefunded(id uint64, refundedAt time.Time) (bool, error) {
+	result := r.db.Model(&model.GiftRecord{}).
+		Where("id = ? AND refunded_at IS NULL", id).
+		Update("refunded_at", refundedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *giftRepository) SumBySender(filter GiftFilter) ([]LeaderboardEntry, error) {
+	query := r.db.Model(&model.GiftRecord{}).
+		Select("sender_id AS user_id, SUM(amount) AS amount").
+		Where("room_id = ? AND refunded_at IS NULL", filter.RoomID)
+	if filter.SessionID != 0 {
+		query = query.Where("session_id = ?", filter.SessionID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	var entries []LeaderboardEntry
+	err := query.Group("sender_id").Order("amount DESC, sender_id").Scan(&entries).Error
+	return entries, err
+}
+
+func (r *giftRepository) ListTransferIDsSince(roomID uint64, since time.Time) ([]string, error) {
+	var ids []string
+	err := r.db.Model(&model.GiftRecord{}).
+		Where("room_id = ? AND created_at >= ?", roomID, since).
+		Pluck("transfer_id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 预约票在最终状态之后保留这么久，足够客户端轮询
+const goldenTicketTTL = 7 * 24 * time.Hour
+
+// ErrTicketTransition 票据当前的状态不允许这次流转，比如已经committed的票不能再变成failed
+var ErrTicketTransition = errors.New("预约票状态不允许该操作")
+
+type GoldenTicketRepository interface {
+	// Create 创建一张pending状态的票
+	Create(ticket *model.GoldenTicket) error
+	// Get 票不存在或已过期时返回nil, nil
+	Get(ticketID string) (*model.GoldenTicket, error)
+	MarkCommitted(ticketID string, commentID uint64) error
+	MarkFailed(ticketID, reason string) error
+	MarkRefunded(ticketID string) error
+}
+
+type goldenTicketRepository struct {
+	rdb *redis.Client
+}
+
+func NewGoldenTicketRepository(rdb *redis.Client) GoldenTicketRepository {
+	return &goldenTicketRepository{rdb: rdb}
+}
+
+func (r *goldenTicketRepository) key(ticketID string) string {
+	return fmt.Sprintf("golden:ticket:%s", ticketID)
+}
+
+func (r *goldenTicketRepository) Create(ticket *model.GoldenTicket) error {
+	ctx := context.Background()
+	key := r.key(ticket.ID)
+	pipe := r.rdb.TxPipeline()
+	pipe.HSet(ctx, key,
+		"user_id", ticket.UserID,
+		"video_id", ticket.VideoID,
+		"status", model.GoldenTicketPending,
+		"created_at", ticket.CreatedAt.UnixMilli(),
+	)
+	pipe.Expire(ctx, key, goldenTicketTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *goldenTicketRepository) Get(ticketID string) (*model.GoldenTicket, error) {
+	vals, err := r.rdb.HGetAll(context.Background(), r.key(ticketID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	ticket := &model.GoldenTicket{
+		ID:     ticketID,
+		Status: vals["status"],
+		Reason: vals["reason"],
+	}
+	ticket.UserID, _ = strconv.ParseUint(vals["user_id"], 10, 64)
+	ticket.VideoID, _ = strconv.ParseUint(vals["video_id"], 10, 64)
+	ticket.CommentID, _ = strconv.ParseUint(vals["comment_id"], 10, 64)
+	if ms, err := strconv.ParseInt(vals["created_at"], 10, 64); err == nil {
+		ticket.CreatedAt = time.UnixMilli(ms)
+	}
+	return ticket, nil
+}
+
+// transitionTicketScript 只有当前状态在允许的来源状态中才流转，保证状态机单调前进
+// KEYS[1]: 票据哈希 ARGV[1]: 目标状态 ARGV[2]: 允许的来源状态，逗号分隔 ARGV[3]/ARGV[4]: 额外写入的字段和值
+// 返回1成功；0状态不允许；-1票不存在；目标状态和当前状态相同时视为成功，重复消费不会报错
+var transitionTicketScript = redis.NewScript(`
+local status = redis.call("HGET", KEYS[1], "status")
+if not status then
+	return -1
+end
+if status == ARGV[1] then
+	return 1
+end
+for from in string.gmatch(ARGV[2], "[^,]+") do
+	if status == from then
+		redis.call("HSET", KEYS[1], "status", ARGV[1])
+		if ARGV[3] ~= "" then
+			redis.call("HSET", KEYS[1], ARGV[3], ARGV[4])
+		end
+		return 1
+	end
+end
+return 0
+`)
+
+func (r *goldenTicketRepository) transition(ticketID, to, from, field, value string) error {
+	res, err := transitionTicketScript.Run(context.Background(), r.rdb, []string{r.key(ticketID)}, to, from, field, value).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case 1:
+		return nil
+	case -1:
+		// 票已过期（或Redis数据丢失），没有可以更新的状态，不影响评论本身
+		return nil
+	}
+	return ErrTicketTransition
+}
+
+// 死信被重放后也可能落库成功，所以failed也可以流转到committed
+func (r *goldenTicketRepository) MarkCommitted(ticketID string, commentID uint64) error {
+	return r.transition(ticketID, model.GoldenTicketCommitted, model.GoldenTicketPending+","+model.GoldenTicketFailed, "comment_id", strconv.FormatUint(commentID, 10))
+}
+
+func (r *goldenTicketRepository) MarkFailed(ticketID, reason string) error {
+	return r.transition(ticketID, model.GoldenTicketFailed, model.GoldenTicketPending, "reason", reason)
+}
+
+func (r *goldenTicketRepository) MarkRefunded(ticketID string) error {
+	return r.transition(ticketID, model.GoldenTicketRefunded, model.GoldenTicketFailed, "", "")
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InboxRepository 记录消费者已经处理过的消息，配合outbox的“至少一次”投递实现幂等消费
+type InboxRepository interface {
+	// MarkConsumed 必须和业务写入在同一个事务中调用，消息已处理过时返回重复键错误
+	MarkConsumed(messageID, queue string) error
+	// DeleteBefore 删除最多limit条早于before的消费记录，返回删除的条数；删掉之后同一条消息再投递过来会被重复处理
+	DeleteBefore(before time.Time, limit int) (int64, error)
+}
+
+type inboxRepository struct {
+	db *gorm.DB
+}
+
+func NewInboxRepository(db *gorm.DB) InboxRepository {
+	return &inboxRepository{db: db}
+}
+
+func (r *inboxRepository) MarkConsumed(messageID, queue string) error {
+	return r.db.Create(&model.ConsumedMessage{MessageID: messageID, Queue: queue}).Error
+}
+
+func (r *inboxRepository) DeleteBefore(before time.Time, limit int) (int64, error) {
+	res := r.db.Exec("DELETE FROM consumed_messages WHERE created_at < ? ORDER BY created_at LIMIT ?", before, limit)
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+const (
+	// LeaderboardSessionTTL 本场榜单在Redis中的保留时间，每次送礼续期；下播时已经写入快照，之后就用不到了
+	LeaderboardSessionTTL = 48 * time.Hour
+	// LeaderboardCountedTTL 一次送礼“已计入榜单”标记的保留时间，要长过礼物事件可能的重试和重复投递
+	LeaderboardCountedTTL = 7 * 24 * time.Hour
+)
+
+// SessionLeaderboard 一场直播的贡献榜
+func SessionLeaderboard(sessionID uint64) string {
+	return fmt.Sprintf("leaderboard:session:%d", sessionID)
+}
+
+// DailyLeaderboard 直播间某一天的贡献榜，day按服务器时区取日期
+func DailyLeaderboard(roomID uint64, day time.Time) string {
+	return fmt.Sprintf("leaderboard:daily:%d:%s", roomID, day.Format("20060102"))
+}
+
+// TotalLeaderboard 直播间的累计贡献榜，不过期
+func TotalLeaderboard(roomID uint64) string {
+	return fmt.Sprintf("leaderboard:total:%d", roomID)
+}
+
+// LeaderboardEntry 榜单上的一个名次
+type LeaderboardEntry struct {
+	UserID uint64
+	Amount int64
+	// 从1开始，不在榜上时为0
+	Rank int64
+}
+
+// LeaderboardGift 计入榜单的一次送礼
+type LeaderboardGift struct {
+	TransferID string
+	SenderID   uint64
+	RoomID     uint64
+	SessionID  uint64
+	Amount     int64
+	SentAt     time.Time
+}
+
+// LeaderboardRepository 直播间的送礼贡献榜
+// 实时榜单在Redis中：每个榜单一个ZSET，成员是用户ID，分数是送出的金币；下播后的本场榜单写入MySQL快照
+// Redis中的榜单都可以按gift_records重建，丢了不影响金币
+type LeaderboardRepository interface {
+	// --- Redis：实时榜单 ---
+
+	// AddGift 把一次送礼同时计入本场、当天、累计三个榜单；同一个TransferID只计一次，返回这次是否计入了
+	AddGift(g LeaderboardGift, dailyTTL time.Duration) (bool, error)
+	// RevokeGift 退款后从三个榜单中减去，减到0的用户从榜单移除；之后才到达的这次送礼不会再计入，返回这次是否减去了
+	RevokeGift(g LeaderboardGift) (bool, error)
+	// Top 分页获取榜单，返回这一页和上榜人数
+	Top(board string, offset, limit int) ([]LeaderboardEntry, int64, error)
+	// Rank 用户在榜单中的名次，不在榜上时Rank为0
+	Rank(board string, userID uint64) (LeaderboardEntry, error)
+	// Replace 用重建的结果整个替换一个榜单，ttl为0时不过期
+	Replace(board string, entries []LeaderboardEntry, ttl time.Duration) error
+	// MarkCounted 标记这些送礼已经计入榜单（重建时用），之后到达的礼物事件不会再加一次；已经退款的标记不会被覆盖
+	MarkCounted(transferIDs []string) error
+
+	// --- MySQL：下播后本场榜单的快照 ---
+
+	// SnapshotSession 把Redis中本场榜单的前limit名写入MySQL，替换这场直播之前的快照，返回写入的名次数
+	SnapshotSession(roomID, sessionID uint64, limit int) (int, error)
+	// SaveSnapshot 按entries的顺序重新编排名次，替换这场直播的快照
+	SaveSnapshot(roomID, sessionID uint64, entries []LeaderboardEntry) error
+	// ListSnapshot 分页获取快照，返回这一页和快照中的人数
+	ListSnapshot(sessionID uint64, offset, limit int) ([]LeaderboardEntry, int64, error)
+	// SnapshotRank 用户在快照中的名次，不在快照里时Rank为0
+	SnapshotRank(sessionID, userID uint64) (LeaderboardEntry, error)
+}
+
+type leaderboardRepository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewLeaderboardRepository(db *gorm.DB, rdb *redis.Client) LeaderboardRepository {
+	return &leaderboardRepository{db: db, rdb: rdb}
+}
+
+func (r *leaderboardRepository) keyCounted(transferID string) string {
+	return fmt.Sprintf("leaderboard:gift:%s", transferID)
+}
+
+func (r *leaderboardRepository) giftKeys(g LeaderboardGift) []string {
+	return []string{
+		r.keyCounted(g.TransferID),
+		SessionLeaderboard(g.SessionID),
+		DailyLeaderboard(g.RoomID, g.SentAt),
+		TotalLeaderboard(g.RoomID),
+	}
+}
+
+// addGiftScript 计入标记和三个榜单的累加在一个脚本里完成，礼物事件重复消费时不会重复计入
+// KEYS[1]: 计入标记 KEYS[2]: 本场榜 KEYS[3]: 当天榜 KEYS[4]: 累计榜
+// ARGV[1]: 金额 ARGV[2]: 送礼人ID ARGV[3]: 标记保留秒数 ARGV[4]: 本场榜保留秒数 ARGV[5]: 当天榜保留秒数
+// 返回1计入，0已经计入过或已经退款
+var addGiftScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], "counted", "NX", "EX", ARGV[3]) then
+	return 0
+end
+redis.call("ZINCRBY", KEYS[2], ARGV[1], ARGV[2])
+redis.call("EXPIRE", KEYS[2], ARGV[4])
+redis.call("ZINCRBY", KEYS[3], ARGV[1], ARGV[2])
+redis.call("EXPIRE", KEYS[3], ARGV[5])
+redis.call("ZINCRBY", KEYS[4], ARGV[1], ARGV[2])
+return 1
+`)
+
+func (r *leaderboardRepository) AddGift(g LeaderboardGift, dailyTTL time.Duration) (bool, error) {
+	res, err := addGiftScript.Run(context.Background(), r.rdb, r.giftKeys(g),
+		g.Amount, g.SenderID, int(LeaderboardCountedTTL.Seconds()), int(LeaderboardSessionTTL.Seconds()), int(dailyTTL.Seconds())).Int()
+	return res == 1, err
+}
+
+// revokeGiftScript 标记为已退款并从三个榜单中减去，已经过期的榜单不会被重新创建
+// 标记不存在时（计入太久已经过期，或者礼物事件还没到）也会减去：前者是常见情况，后者会让榜单少算，可以重建修正
+// KEYS同addGiftScript ARGV[1]: 金额 ARGV[2]: 送礼人ID ARGV[3]: 标记保留秒数
+// 返回1减去，0已经退过
+var revokeGiftScript = redis.NewScript(`
+local state = redis.call("GET", KEYS[1])
+if state == "revoked" then
+	return 0
+end
+redis.call("SET", KEYS[1], "revoked", "EX", ARGV[3])
+for i = 2, 4 do
+	if redis.call("EXISTS", KEYS[i]) == 1 then
+		local score = tonumber(redis.call("ZINCRBY", KEYS[i], -tonumber(ARGV[1]), ARGV[2]))
+		if score <= 0 then
+			redis.call("ZREM", KEYS[i], ARGV[2])
+		end
+	end
+end
+return 1
+`)
+
+func (r *leaderboardRepository) RevokeGift(g LeaderboardGift) (bool, error) {
+	res, err := revokeGiftScript.Run(context.Background(), r.rdb, r.giftKeys(g),
+		g.Amount, g.SenderID, int(LeaderboardCountedTTL.Seconds())).Int()
+	return res == 1, err
+}
+
+func (r *leaderboardRepository) Top(board string, offset, limit int) ([]LeaderboardEntry, int64, error) {
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	members := pipe.ZRevRangeWithScores(ctx, board, int64(offset), int64(offset+limit-1))
+	card := pipe.ZCard(ctx, board)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+	entries := make([]LeaderboardEntry, 0, len(members.Val()))
+	for i, z := range members.Val() {
+		userID, err := strconv.ParseUint(fmt.Sprint(z.Member), 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, LeaderboardEntry{UserID: userID, Amount: int64(z.Score), Rank: int64(offset + i + 1)})
+	}
+	return entries, card.Val(), nil
+}
+
+func (r *leaderboardRepository) Rank(board string, userID uint64) (LeaderboardEntry, error) {
+	ctx := context.Background()
+	member := strconv.FormatUint(userID, 10)
+	pipe := r.rdb.Pipeline()
+	rank := pipe.ZRevRank(ctx, board, member)
+	score := pipe.ZScore(ctx, board, member)
+	entry := LeaderboardEntry{UserID: userID}
+	if _, err := pipe.Exec(ctx); err != nil {
+		if err == redis.Nil {
+			return entry, nil // 不在榜上
+		}
+		return entry, err
+	}
+	entry.Rank = rank.Val() + 1
+	entry.Amount = int64(score.Val())
+	return entry, nil
+}
+
+func (r *leaderboardRepository) Replace(board string, entries []LeaderboardEntry, ttl time.Duration) error {
+	ctx := context.Background()
+	if len(entries) == 0 {
+		return r.rdb.Del(ctx, board).Err()
+	}
+	// 先写到临时键再RENAME，替换过程中读榜单的请求看到的要么是旧榜单要么是新榜单
+	tmp := board + ":rebuild"
+	members := make([]*redis.Z, 0, len(entries))
+	for _, e := range entries {
+		members = append(members, &redis.Z{Score: float64(e.Amount), Member: e.UserID})
+	}
+	pipe := r.rdb.TxPipeline()
+	pipe.Del(ctx, tmp)
+	pipe.ZAdd(ctx, tmp, members...)
+	pipe.Rename(ctx, tmp, board)
+	if ttl > 0 {
+		pipe.Expire(ctx, board, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *leaderboardRepository) MarkCounted(transferIDs []string) error {
+	ctx := context.Background()
+	const batch = 500
+	for start := 0; start < len(transferIDs); start += batch {
+		end := start + batch
+		if end > len(transferIDs) {
+			end = len(transferIDs)
+		}
+		pipe := r.rdb.Pipeline()
+		for _, id := range transferIDs[start:end] {
+			// 和addGiftScript写入的值一样；已经是revoked的不覆盖
+			pipe.SetNX(ctx, r.keyCounted(id), "counted", LeaderboardCountedTTL)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *leaderboardRepository) SnapshotSession(roomID, sessionID uint64, limit int) (int, error) {
+	entries, _, err := r.Top(SessionLeaderboard(sessionID), 0, limit)
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), r.SaveSnapshot(roomID, sessionID, entries)
+}
+
+func (r *leaderboardRepository) SaveSnapshot(roomID, sessionID uint64, entries []LeaderboardEntry) error {
+	rows := make([]model.LeaderboardSnapshot, 0, len(entries))
+	for i, e := range entries {
+		rows = append(rows, model.LeaderboardSnapshot{
+			SessionID: sessionID,
+			UserID:    e.UserID,
+			RoomID:    roomID,
+			Rank:      int64(i + 1),
+			Amount:    e.Amount,
+		})
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", sessionID).Delete(&model.LeaderboardSnapshot{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rows, 200).Error
+	})
+}
+
+func (r *leaderboardRepository) ListSnapshot(sessionID uint64, offset, limit int) ([]LeaderboardEntry, int64, error) {
+	var total int64
+	if err := r.db.Model(&model.LeaderboardSnapshot{}).Where("session_id = ?", sessionID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []model.LeaderboardSnapshot
+	if err := r.db.Where("session_id = ?", sessionID).Order("ranking").Offset(offset).Limit(limit).Find(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+	entries := make([]LeaderboardEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, LeaderboardEntry{UserID: row.UserID, Amount: row.Amount, Rank: row.Rank})
+	}
+	return entries, total, nil
+}
+
+func (r *leaderboardRepository) SnapshotRank(sessionID, userID uint64) (LeaderboardEntry, error) {
+	entry := LeaderboardEntry{UserID: userID}
+	var row model.LeaderboardSnapshot
+	err := r.db.Where("session_id = ? AND user_id = ?", sessionID, userID).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return entry, nil
+	}
+	if err != nil {
+		return entry, err
+	}
+	entry.Amount, entry.Rank = row.Amount, row.Rank
+	return entry, nil
+}
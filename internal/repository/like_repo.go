@@ -9,7 +9,10 @@ import (
 
 type LikeRepository interface {
 	Create(like *model.Like) error
-	Delete(userID, videoID uint64) error
+	// Delete 返回是否真的删除了一行，重复的取消点赞消息不会删除任何数据
+	Delete(userID, videoID uint64) (bool, error)
+	// ListUserIDsByVideo 返回给视频点过赞的所有用户ID，likes表是点赞关系的权威数据
+	ListUserIDsByVideo(videoID uint64) ([]uint64, error)
 }
 
 type likeRepository struct {
@@ -34,7 +37,7 @@ func (r *likeRepository) Create(like *model.Like) error {
 	return nil
 }
 
-func (r *likeRepository) Delete(userID, videoID uint64) error {
+func (r *likeRepository) Delete(userID, videoID uint64) (bool, error) {
 
 	// logger.Log.Infof("准备从MySQL删除点赞记录: UserID=%d, VideoID=%d", userID, videoID)
 	// gorm简直就是dogShit，排查了将近两个小时的错误，结果就真是gorm的“翻译”错误
@@ -43,10 +46,16 @@ func (r *likeRepository) Delete(userID, videoID uint64) error {
 	result := r.db.Exec("DELETE FROM likes WHERE user_id = ? AND video_id = ?", userID, videoID)
 	if result.Error != nil {
 		logger.Log.WithError(result.Error).Error("MySQL删除操作失败")
-		return result.Error
+		return false, result.Error
 	}
 
 	// logger.Log.Infof("MySQL删除操作完成，影响行数: %d", result.RowsAffected)
 
-	return nil
+	return result.RowsAffected > 0, nil
+}
+
+func (r *likeRepository) ListUserIDsByVideo(videoID uint64) ([]uint64, error) {
+	var userIDs []uint64
+	err := r.db.Model(&model.Like{}).Where("video_id = ?", videoID).Pluck("user_id", &userIDs).Error
+	return userIDs, err
 }
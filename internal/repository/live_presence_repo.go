@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 一场直播的独立观众和峰值在Redis中的保留时间，每次心跳都会续期；正常下播时写入MySQL后立即删除，只有下播回调丢失时才靠它过期
+const liveSessionStatsTTL = 48 * time.Hour
+
+// 有观众在线的直播间集合，清扫过期观众时只遍历这些直播间
+const keyPresenceRooms = "live:presence_rooms"
+
+// LivePresenceRepository 直播间观众在线状态，全部在Redis中
+// 每个直播间一个ZSET，成员是用户ID，分数是最后一次心跳的毫秒时间戳，超过ttl没有心跳就不再算在线；
+// 每场直播一个HyperLogLog估算独立观众数（误差约0.81%），以及一个峰值在线人数
+type LivePresenceRepository interface {
+	// Heartbeat 记录一次心跳并顺带清理这个直播间的过期观众，返回当前在线人数
+	Heartbeat(roomID, sessionID, userID uint64, now time.Time, ttl time.Duration) (int64, error)
+	// Join 观众打开一个WebSocket连接，连接数加1
+	Join(roomID, userID uint64) error
+	// Leave 观众关闭一个WebSocket连接，连接数减1；这个用户在这个直播间的连接都关闭了才不再算在线，不用等到过期
+	Leave(roomID, userID uint64) error
+	// OnlineCounts 批量获取直播间的在线人数，只统计ttl内有心跳的观众
+	OnlineCounts(roomIDs []uint64, now time.Time, ttl time.Duration) (map[uint64]int64, error)
+	// SessionStats 一场直播的独立观众数和峰值在线人数
+	SessionStats(sessionID uint64) (uniqueViewers, peakViewers int64, err error)
+	// ClearRoom 下播后清空直播间的在线观众和这场直播的统计
+	ClearRoom(roomID, sessionID uint64) error
+	// SweepStale 清扫所有直播间中过期的观众，返回清扫掉的人数
+	SweepStale(now time.Time, ttl time.Duration) (int64, error)
+}
+
+type livePresenceRepository struct {
+	rdb *redis.Client
+}
+
+func NewLivePresenceRepository(rdb *redis.Client) LivePresenceRepository {
+	return &livePresenceRepository{rdb: rdb}
+}
+
+func (r *livePresenceRepository) keyPresence(roomID uint64) string {
+	return fmt.Sprintf("live:presence:%d", roomID)
+}
+
+// 每个直播间一个哈希，用户ID → 这个用户打开的WebSocket连接数，同一个用户开了多个页面时关掉其中一个不会让他离线
+func (r *livePresenceRepository) keyConnections(roomID uint64) string {
+	return fmt.Sprintf("live:presence_conns:%d", roomID)
+}
+
+func (r *livePresenceRepository) keyUniqueViewers(sessionID uint64) string {
+	return fmt.Sprintf("live:session_uv:%d", sessionID)
+}
+
+func (r *livePresenceRepository) keyPeakViewers(sessionID uint64) string {
+	return fmt.Sprintf("live:session_peak:%d", sessionID)
+}
+
+// heartbeatScript 刷新心跳、清理过期观众、计入独立观众、更新峰值在一个脚本里完成，峰值不会因为并发心跳被改小
+// KEYS[1]: 在线观众ZSET KEYS[2]: 独立观众HLL KEYS[3]: 峰值 KEYS[4]: 有观众的直播间集合
+// ARGV[1]: 当前毫秒时间戳 ARGV[2]: 用户ID ARGV[3]: 过期界限（毫秒时间戳） ARGV[4]: 统计的保留秒数 ARGV[5]: 房间ID
+// 返回当前在线人数
+var heartbeatScript = redis.NewScript(`
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", "(" .. ARGV[3])
+redis.call("SADD", KEYS[4], ARGV[5])
+redis.call("PFADD", KEYS[2], ARGV[2])
+redis.call("EXPIRE", KEYS[2], ARGV[4])
+local online = redis.call("ZCARD", KEYS[1])
+local peak = tonumber(redis.call("GET", KEYS[3]) or "0")
+if online > peak then
+	peak = online
+end
+redis.call("SET", KEYS[3], peak, "EX", ARGV[4])
+return online
+`)
+
+func (r *livePresenceRepository) Heartbeat(roomID, sessionID, userID uint64, now time.Time, ttl time.Duration) (int64, error) {
+	keys := []string{r.keyPresence(roomID), r.keyUniqueViewers(sessionID), r.keyPeakViewers(sessionID), keyPresenceRooms}
+	return heartbeatScript.Run(context.Background(), r.rdb, keys,
+		now.UnixMilli(), userID, now.Add(-ttl).UnixMilli(), int(liveSessionStatsTTL.Seconds()), roomID).Int64()
+}
+
+// 连接数的哈希每次有人进入都续期；server崩溃没来得及Leave的连接数会一直偏大，这些用户退回到按心跳超时离线
+func (r *livePresenceRepository) Join(roomID, userID uint64) error {
+	ctx := context.Background()
+	key := r.keyConnections(roomID)
+	pipe := r.rdb.TxPipeline()
+	pipe.HIncrBy(ctx, key, strconv.FormatUint(userID, 10), 1)
+	pipe.Expire(ctx, key, liveSessionStatsTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// leaveScript 连接数减1，减到0时删除计数并移出在线观众；下播时不清空连接数，下播后才关闭的连接照样减
+// KEYS[1]: 连接数哈希 KEYS[2]: 在线观众ZSET ARGV[1]: 用户ID
+// 返回这个用户剩余的连接数
+var leaveScript = redis.NewScript(`
+local n = redis.call("HINCRBY", KEYS[1], ARGV[1], -1)
+if n <= 0 then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+	redis.call("ZREM", KEYS[2], ARGV[1])
+	return 0
+end
+return n
+`)
+
+func (r *livePresenceRepository) Leave(roomID, userID uint64) error {
+	keys := []string{r.keyConnections(roomID), r.keyPresence(roomID)}
+	return leaveScript.Run(context.Background(), r.rdb, keys, userID).Err()
+}
+
+func (r *livePresenceRepository) OnlineCounts(roomIDs []uint64, now time.Time, ttl time.Duration) (map[uint64]int64, error) {
+	counts := make(map[uint64]int64, len(roomIDs))
+	if len(roomIDs) == 0 {
+		return counts, nil
+	}
+	ctx := context.Background()
+	min := strconv.FormatInt(now.Add(-ttl).UnixMilli(), 10)
+	pipe := r.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, len(roomIDs))
+	for i, id := range roomIDs {
+		cmds[i] = pipe.ZCount(ctx, r.keyPresence(id), min, "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, id := range roomIDs {
+		counts[id] = cmds[i].Val()
+	}
+	return counts, nil
+}
+
+func (r *livePresenceRepository) SessionStats(sessionID uint64) (int64, int64, error) {
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	uv := pipe.PFCount(ctx, r.keyUniqueViewers(sessionID))
+	peak := pipe.Get(ctx, r.keyPeakViewers(sessionID))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+	peakViewers, _ := peak.Int64() // 没有人看过时键不存在，峰值为0
+	return uv.Val(), peakViewers, nil
+}
+
+func (r *livePresenceRepository) ClearRoom(roomID, sessionID uint64) error {
+	ctx := context.Background()
+	pipe := r.rdb.TxPipeline()
+	pipe.Del(ctx, r.keyPresence(roomID), r.keyUniqueViewers(sessionID), r.keyPeakViewers(sessionID))
+	pipe.SRem(ctx, keyPresenceRooms, roomID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// sweepRoomScript 删除过期观众，直播间没人了就从集合中移除，和并发的心跳不会互相覆盖
+// KEYS[1]: 在线观众ZSET KEYS[2]: 有观众的直播间集合 ARGV[1]: 过期界限（毫秒时间戳） ARGV[2]: 房间ID
+// 返回删除的人数
+var sweepRoomScript = redis.NewScript(`
+local removed = redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", "(" .. ARGV[1])
+if redis.call("ZCARD", KEYS[1]) == 0 then
+	redis.call("SREM", KEYS[2], ARGV[2])
+end
+return removed
+`)
+
+func (r *livePresenceRepository) SweepStale(now time.Time, ttl time.Duration) (int64, error) {
+	ctx := context.Background()
+	rooms, err := r.rdb.SMembers(ctx, keyPresenceRooms).Result()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := now.Add(-ttl).UnixMilli()
+	var total int64
+	for _, room := range rooms {
+		roomID, err := strconv.ParseUint(room, 10, 64)
+		if err != nil {
+			continue
+		}
+		removed, err := sweepRoomScript.Run(ctx, r.rdb, []string{r.keyPresence(roomID), keyPresenceRooms}, cutoff, roomID).Int64()
+		if err != nil {
+			return total, err
+		}
+		total += removed
+	}
+	return total, nil
+}
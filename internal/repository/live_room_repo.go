@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LiveRoomRepository interface {
+	Create(room *model.LiveRoom) error
+	FindByID(roomID uint64) (*model.LiveRoom, error)
+	// 主播自己的直播间，没有时返回gorm.ErrRecordNotFound
+	FindByStreamer(streamerID uint64) (*model.LiveRoom, error)
+	UpdateStreamKey(roomID uint64, streamKey string) error
+	// 分页获取正在直播的直播间，按开播时间倒序
+	ListLive(offset, limit int) ([]model.LiveRoom, error)
+
+	// StartSession 开播：只有idle状态的直播间才会被改为live并创建一场直播，返回是否真的开播了
+	// 已经在直播时返回false和当前这场直播，重复的on_publish回调不会创建新的场次
+	StartSession(roomID uint64, startedAt time.Time) (*model.LiveSession, bool, error)
+	// EndSession 下播：只有live状态的直播间才会改回idle并写入这场直播的结束时间，返回结束的场次，没在直播时返回nil
+	EndSession(roomID uint64, endedAt time.Time) (*model.LiveSession, error)
+	FindSessionByID(sessionID uint64) (*model.LiveSession, error)
+	// UpdateSessionStats 下播后写入这场直播的峰值在线人数和独立观众数
+	UpdateSessionStats(sessionID uint64, peakViewers, uniqueViewers int64) error
+	// ListSessions 直播间最近的直播场次，按开播时间倒序
+	ListSessions(roomID uint64, limit int) ([]model.LiveSession, error)
+	// FindSessionAt 在at时刻（前后放宽tolerance）正在进行的直播场次，有多场时取开播最晚的一场
+	FindSessionAt(roomID uint64, at time.Time, tolerance time.Duration) (*model.LiveSession, error)
+	// CurrentSessionID 直播间当前这场直播的ID，没在直播时返回0；发弹幕、送礼物等高频路径用它，优先读Redis
+	CurrentSessionID(roomID uint64) (uint64, error)
+}
+
+// 缓存未命中时从MySQL回填的有效期；开播、下播会直接改写缓存，回填只是兜底，所以设得很短
+const liveSessionFillTTL = 10 * time.Second
+
+type liveRoomRepository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewLiveRoomRepository(db *gorm.DB, rdb *redis.Client) LiveRoomRepository {
+	return &liveRoomRepository{db: db, rdb: rdb}
+}
+
+func (r *liveRoomRepository) keySession(roomID uint64) string {
+	return fmt.Sprintf("live:room_session:%d", roomID)
+}
+
+func (r *liveRoomRepository) Create(room *model.LiveRoom) error {
+	return r.db.Create(room).Error
+}
+
+func (r *liveRoomRepository) FindByID(roomID uint64) (*model.LiveRoom, error) {
+	var room model.LiveRoom
+	if err := r.db.Preload("Streamer").First(&room, roomID).Error; err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (r *liveRoomRepository) FindByStreamer(streamerID uint64) (*model.LiveRoom, error) {
+	var room model.LiveRoom
+	if err := r.db.Preload("Streamer").Where("streamer_id = ?", streamerID).First(&room).Error; err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (r *liveRoomRepository) UpdateStreamKey(roomID uint64, streamKey string) error {
+	return r.db.Model(&model.LiveRoom{}).Where("id = ?", roomID).Update("stream_key", streamKey).Error
+}
+
+func (r *liveRoomRepository) ListLive(offset, limit int) ([]model.LiveRoom, error) {
+	var rooms []model.LiveRoom
+	err := r.db.Preload("Streamer").
+		Where("status = ?", model.LiveRoomLive).
+		Order("live_started_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&rooms).Error
+	return rooms, err
+}
+
+// 在一个事务中：1、锁住直播间 2、已经在直播则返回当前场次 3、创建场次并把直播间改为live
+func (r *liveRoomRepository) StartSession(roomID uint64, startedAt time.Time) (*model.LiveSession, bool, error) {
+	var session model.LiveSession
+	started := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var locked model.LiveRoom
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, roomID).Error; err != nil {
+			return err
+		}
+		if locked.Status == model.LiveRoomLive {
+			return tx.First(&session, locked.SessionID).Error
+		}
+		session = model.LiveSession{
+			RoomID:     locked.ID,
+			StreamerID: locked.StreamerID,
+			Title:      locked.Title,
+			StartedAt:  startedAt,
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		started = true
+		return tx.Model(&model.LiveRoom{}).Where("id = ?", locked.ID).Updates(map[string]interface{}{
+			"status":          model.LiveRoomLive,
+			"session_id":      session.ID,
+			"live_started_at": startedAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	// 缓存写失败只会让读取方回源MySQL，不影响开播
+	_ = r.rdb.Set(context.Background(), r.keySession(roomID), session.ID, 0).Err()
+	return &session, started, nil
+}
+
+func (r *liveRoomRepository) EndSession(roomID uint64, endedAt time.Time) (*model.LiveSession, error) {
+	var session *model.LiveSession
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var locked model.LiveRoom
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, roomID).Error; err != nil {
+			return err
+		}
+		if locked.Status != model.LiveRoomLive {
+			return nil
+		}
+		if err := tx.Model(&model.LiveRoom{}).Where("id = ?", roomID).Updates(map[string]interface{}{
+			"status":        model.LiveRoomIdle,
+			"session_id":    0,
+			"live_ended_at": endedAt,
+		}).Error; err != nil {
+			return err
+		}
+		var s model.LiveSession
+		if err := tx.First(&s, locked.SessionID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&s).Update("ended_at", endedAt).Error; err != nil {
+			return err
+		}
+		s.EndedAt = &endedAt
+		session = &s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	_ = r.rdb.Del(context.Background(), r.keySession(roomID)).Err()
+	return session, nil
+}
+
+func (r *liveRoomRepository) FindSessionByID(sessionID uint64) (*model.LiveSession, error) {
+	var session model.LiveSession
+	if err := r.db.First(&session, sessionID).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *liveRoomRepository) UpdateSessionStats(sessionID uint64, peakViewers, uniqueViewers int64) error {
+	return r.db.Model(&model.LiveSession{}).Where("id = ?", sessionID).Updates(map[string]interface{}{
+		"peak_viewers":   peakViewers,
+		"unique_viewers": uniqueViewers,
+	}).Error
+}
+
+func (r *liveRoomRepository) ListSessions(roomID uint64, limit int) ([]model.LiveSession, error) {
+	var sessions []model.LiveSession
+	err := r.db.Where("room_id = ?", roomID).Order("started_at DESC").Limit(limit).Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *liveRoomRepository) FindSessionAt(roomID uint64, at time.Time, tolerance time.Duration) (*model.LiveSession, error) {
+	var session model.LiveSession
+	err := r.db.Where("room_id = ? AND started_at <= ? AND (ended_at IS NULL OR ended_at >= ?)", roomID, at.Add(tolerance), at.Add(-tolerance)).
+		Order("started_at DESC").First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *liveRoomRepository) CurrentSessionID(roomID uint64) (uint64, error) {
+	ctx := context.Background()
+	val, err := r.rdb.Get(ctx, r.keySession(roomID)).Result()
+	if err == nil {
+		return strconv.ParseUint(val, 10, 64)
+	}
+	if err != redis.Nil {
+		return 0, err
+	}
+	var room model.LiveRoom
+	if err := r.db.Select("id", "session_id").First(&room, roomID).Error; err != nil {
+		return 0, err
+	}
+	_ = r.rdb.Set(ctx, r.keySession(roomID), room.SessionID, liveSessionFillTTL).Err()
+	return room.SessionID, nil
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 所有还没完成的分片上传，score是过期的毫秒时间戳，member是 {上传ID}:{分片数}，过期后scheduler按分片数删除已经上传的分片
+const keyUploadExpiring = "upload:expiring"
+
+// UploadSession 分片上传会话，保存在Redis中，过期自动删除
+type UploadSession struct {
+	ID        string
+	UserID    uint64
+	Kind      string
+	Size      int64
+	ChunkSize int64
+	Chunks    int
+	ExpiresAt time.Time
+	// 已经收到的分片序号，升序
+	Received []int
+	// 合并完成后的文件ID，完成前为0
+	FileID uint64
+}
+
+// ExpiredUpload 过期没有完成的分片上传
+type ExpiredUpload struct {
+	ID     string
+	Chunks int
+}
+
+type MediaRepository interface {
+	// --- MySQL：上传完成的文件 ---
+
+	Create(file *model.MediaFile) error
+	FindByID(id uint64) (*model.MediaFile, error)
+	// FindByUploaderHash 同一个用户上传过的同一个文件，没有时返回gorm.ErrRecordNotFound
+	FindByUploaderHash(uploaderID uint64, kind, hash string) (*model.MediaFile, error)
+	// FindByHash 任何人上传过的同一个文件，用来复用存储中的对象
+	FindByHash(hash string) (*model.MediaFile, error)
+
+	// --- Redis：分片上传会话 ---
+
+	CreateUpload(session *UploadSession) error
+	// GetUpload 上传会话和已经收到的分片，不存在或已过期时返回nil
+	GetUpload(id string) (*UploadSession, error)
+	// AddChunk 记下一个已经写入存储的分片
+	AddChunk(id string, index int, expiresAt time.Time) error
+	// CompleteUpload 记下合并后的文件ID，之后重复完成返回同一个文件；会话保留到过期，不再需要清理分片
+	CompleteUpload(id string, chunks int, fileID uint64) error
+	// ListExpiredUploads 已经过期但还没完成的上传
+	ListExpiredUploads(now time.Time, limit int) ([]ExpiredUpload, error)
+	// RemoveExpiredUpload 分片清理完后从待清理列表中删除
+	RemoveExpiredUpload(upload ExpiredUpload) error
+}
+
+type mediaRepository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewMediaRepository(db *gorm.DB, rdb *redis.Client) MediaRepository {
+	return &mediaRepository{db: db, rdb: rdb}
+}
+
+func (r *mediaRepository) keyUpload(id string) string {
+	return fmt.Sprintf("upload:%s", id)
+}
+
+func (r *mediaRepository) keyUploadChunks(id string) string {
+	return fmt.Sprintf("upload:%s:chunks", id)
+}
+
+func (r *mediaRepository) expiringMember(id string, chunks int) string {
+	return fmt.Sprintf("%s:%d", id, chunks)
+}
+
+func (r *mediaRepository) Create(file *model.MediaFile) error {
+	return r.db.Create(file).Error
+}
+
+func (r *mediaRepository) FindByID(id uint64) (*model.MediaFile, error) {
+	var file model.MediaFile
+	if err := r.db.First(&file, id).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *mediaRepository) FindByUploaderHash(uploaderID uint64, kind, hash string) (*model.MediaFile, error) {
+	var file model.MediaFile
+	if err := r.db.Where("uploader_id = ? AND kind = ? AND hash = ?", uploaderID, kind, hash).First(&file).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *mediaRepository) FindByHash(hash string) (*model.MediaFile, error) {
+	var file model.MediaFile
+	if err := r.db.Where("hash = ?", hash).First(&file).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *mediaRepository) CreateUpload(session *UploadSession) error {
+	ctx := context.Background()
+	key := r.keyUpload(session.ID)
+	pipe := r.rdb.TxPipeline()
+	pipe.HSet(ctx, key,
+		"user_id", session.UserID,
+		"kind", session.Kind,
+		"size", session.Size,
+		"chunk_size", session.ChunkSize,
+		"chunks", session.Chunks,
+		"expires_at", session.ExpiresAt.UnixMilli(),
+	)
+	pipe.PExpireAt(ctx, key, session.ExpiresAt)
+	pipe.ZAdd(ctx, keyUploadExpiring, &redis.Z{Score: float64(session.ExpiresAt.UnixMilli()), Member: r.expiringMember(session.ID, session.Chunks)})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *mediaRepository) GetUpload(id string) (*UploadSession, error) {
+	ctx := context.Background()
+	pipe := r.rdb.Pipeline()
+	metaCmd := pipe.HGetAll(ctx, r.keyUpload(id))
+	chunksCmd := pipe.SMembers(ctx, r.keyUploadChunks(id))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	meta := metaCmd.Val()
+	if len(meta) == 0 {
+		return nil, nil
+	}
+	session := &UploadSession{ID: id, Kind: meta["kind"]}
+	session.UserID, _ = strconv.ParseUint(meta["user_id"], 10, 64)
+	session.Size, _ = strconv.ParseInt(meta["size"], 10, 64)
+	session.ChunkSize, _ = strconv.ParseInt(meta["chunk_size"], 10, 64)
+	session.Chunks, _ = strconv.Atoi(meta["chunks"])
+	session.FileID, _ = strconv.ParseUint(meta["file_id"], 10, 64)
+	expiresAt, _ := strconv.ParseInt(meta["expires_at"], 10, 64)
+	session.ExpiresAt = time.UnixMilli(expiresAt)
+	for _, v := range chunksCmd.Val() {
+		if index, err := strconv.Atoi(v); err == nil {
+			session.Received = append(session.Received, index)
+		}
+	}
+	sort.Ints(session.Received)
+	return session, nil
+}
+
+func (r *mediaRepository) AddChunk(id string, index int, expiresAt time.Time) error {
+	ctx := context.Background()
+	key := r.keyUploadChunks(id)
+	pipe := r.rdb.TxPipeline()
+	pipe.SAdd(ctx, key, index)
+	pipe.PExpireAt(ctx, key, expiresAt)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *mediaRepository) CompleteUpload(id string, chunks int, fileID uint64) error {
+	ctx := context.Background()
+	pipe := r.rdb.TxPipeline()
+	pipe.HSet(ctx, r.keyUpload(id), "file_id", fileID)
+	pipe.Del(ctx, r.keyUploadChunks(id))
+	pipe.ZRem(ctx, keyUploadExpiring, r.expiringMember(id, chunks))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *mediaRepository) ListExpiredUploads(now time.Time, limit int) ([]ExpiredUpload, error) {
+	members, err := r.rdb.ZRangeByScore(context.Background(), keyUploadExpiring, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	uploads := make([]ExpiredUpload, 0, len(members))
+	for _, m := range members {
+		i := strings.LastIndexByte(m, ':')
+		if i < 0 {
+			continue
+		}
+		chunks, err := strconv.Atoi(m[i+1:])
+		if err != nil {
+			continue
+		}
+		uploads = append(uploads, ExpiredUpload{ID: m[:i], Chunks: chunks})
+	}
+	return uploads, nil
+}
+
+func (r *mediaRepository) RemoveExpiredUpload(upload ExpiredUpload) error {
+	return r.rdb.ZRem(context.Background(), keyUploadExpiring, r.expiringMember(upload.ID, upload.Chunks)).Err()
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OutboxRepository interface {
+	Create(msg *model.OutboxMessage) error
+	// 锁定一批已到重试时间的待发送消息，必须在事务中调用
+	FetchDueForUpdate(now time.Time, limit int) ([]model.OutboxMessage, error)
+	MarkSent(id uint64, sentAt time.Time) error
+	// 记录一次失败的投递，nextAttemptAt之前不会再被取出
+	MarkRetry(id uint64, attempts int, nextAttemptAt time.Time, lastErr string) error
+	MarkFailed(id uint64, attempts int, lastErr string) error
+	// InFlightVideoIDs 返回这些视频中since之后写入、还没被消费完的消息（待投递，或已投递但消费者还没处理）
+	InFlightVideoIDs(routingKey string, videoIDs []uint64, since time.Time) (map[uint64]bool, error)
+	// DeleteSentBefore 删除最多limit条sent_at早于before的已投递消息，返回删除的条数；failed的消息不删，留着人工排查
+	DeleteSentBefore(before time.Time, limit int) (int64, error)
+
+	WithTx(tx *gorm.DB) OutboxRepository
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+func (r *outboxRepository) WithTx(tx *gorm.DB) OutboxRepository {
+	return &outboxRepository{db: tx}
+}
+
+func (r *outboxRepository) Create(msg *model.OutboxMessage) error {
+	return r.db.Create(msg).Error
+}
+
+// SELECT ... FOR UPDATE SKIP LOCKED：多个relay实例同时运行时，各自拿到不同的消息，不会互相阻塞
+// 按id升序取，尽量保持写入顺序（同一用户先点赞后取消的顺序）
+func (r *outboxRepository) FetchDueForUpdate(now time.Time, limit int) ([]model.OutboxMessage, error) {
+	var msgs []model.OutboxMessage
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", model.OutboxStatusPending, now).
+		Order("id").
+		Limit(limit).
+		Find(&msgs).Error
+	return msgs, err
+}
+
+func (r *outboxRepository) MarkSent(id uint64, sentAt time.Time) error {
+	return r.db.Model(&model.OutboxMessage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":  model.OutboxStatusSent,
+		"sent_at": sentAt,
+	}).Error
+}
+
+func (r *outboxRepository) MarkRetry(id uint64, attempts int, nextAttemptAt time.Time, lastErr string) error {
+	return r.db.Model(&model.OutboxMessage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": nextAttemptAt,
+		"last_error":      lastErr,
+	}).Error
+}
+
+func (r *outboxRepository) MarkFailed(id uint64, attempts int, lastErr string) error {
+	return r.db.Model(&model.OutboxMessage{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":     model.OutboxStatusFailed,
+		"attempts":   attempts,
+		"last_error": lastErr,
+	}).Error
+}
+
+// 已投递但还没被消费的消息，在consumed_messages中找不到对应的消息ID；failed的消息不算在途
+// 进入dlq的消息同样没有消费记录，所以只看since之后的消息，太久没被消费的当作已经丢失
+// 消息体是JSON，用JSON_EXTRACT取出video_id，只用于对账这种低频场景
+func (r *outboxRepository) InFlightVideoIDs(routingKey string, videoIDs []uint64, since time.Time) (map[uint64]bool, error) {
+	inFlight := make(map[uint64]bool)
+	if len(videoIDs) == 0 {
+		return inFlight, nil
+	}
+	var ids []uint64
+	err := r.db.Table("outbox_messages AS o").
+		Joins("LEFT JOIN consumed_messages AS c ON c.message_id = o.message_id").
+		Where("o.routing_key = ? AND o.status <> ? AND o.created_at >= ? AND c.message_id IS NULL", routingKey, model.OutboxStatusFailed, since).
+		Where("CAST(JSON_EXTRACT(CAST(o.payload AS CHAR), '$.video_id') AS UNSIGNED) IN ?", videoIDs).
+		Distinct().
+		Pluck("CAST(JSON_EXTRACT(CAST(o.payload AS CHAR), '$.video_id') AS UNSIGNED)", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		inFlight[id] = true
+	}
+	return inFlight, nil
+}
+
+// 每次只删limit条，避免一个大事务长时间锁住relay正在扫描的表
+func (r *outboxRepository) DeleteSentBefore(before time.Time, limit int) (int64, error) {
+	res := r.db.Exec("DELETE FROM outbox_messages WHERE status = ? AND sent_at < ? ORDER BY sent_at LIMIT ?", model.OutboxStatusSent, before, limit)
+	return res.RowsAffected, res.Error
+}
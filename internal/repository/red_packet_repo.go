@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 红包过期后Redis中的数据再保留多久：退还过期红包时要从这里读出没抢完的份额，scheduler停了一段时间也能补上
+const redPacketKeepAfterExpire = 24 * time.Hour
+
+// 抢红包的结果
+const (
+	RedPacketGrabOK        = 1
+	RedPacketGrabDuplicate = 2 // 已经抢到过，返回的是当时抢到的金额
+	RedPacketGrabEmpty     = 3 // 已经抢完了
+	RedPacketGrabNotFound  = 4
+	RedPacketGrabExpired   = 5
+)
+
+type RedPacketRepository interface {
+	// --- MySQL：红包、抢到的份额，和金币流水一起写入 ---
+
+	Create(packet *model.RedPacket) error
+	FindByID(id string) (*model.RedPacket, error)
+	// FindByRequest 同一个主播的同一个request_id只会发出一个红包
+	FindByRequest(senderID uint64, requestID string) (*model.RedPacket, error)
+	// CreateGrab 插入抢到的一份，重复时返回重复键错误
+	CreateGrab(grab *model.RedPacketGrab) error
+	// AddGrabbed 累加已经落库的份额，返回红包是否存在
+	AddGrabbed(id string, amount int64) (bool, error)
+	// MarkRefunded 只有active状态的红包才会被更新，返回是否更新了
+	MarkRefunded(id string, refundedAmount int64) (bool, error)
+	// ListGrabs 红包已经落库的份额，按金额倒序
+	ListGrabs(id string) ([]model.RedPacketGrab, error)
+	// ListExpired 已经过期但还没退还的红包，按过期时间顺序
+	ListExpired(now time.Time, limit int) ([]model.RedPacket, error)
+
+	// --- Redis：预先拆好的份额和抢到的人 ---
+
+	// Publish 把拆好的份额放入Redis，之后观众才能抢
+	Publish(packet *model.RedPacket, shares []int64) error
+	// Grab 原子地检查是否过期、是否抢过，弹出一份并记下这个用户，返回抢红包的结果和金额
+	Grab(id string, userID uint64, now time.Time) (int, int64, error)
+	// ReleaseGrab 抢到后没能投递落库消息时把这一份放回去，返回是否放回了（红包已经过期时不会）
+	ReleaseGrab(id string, userID uint64, amount int64) (bool, error)
+	// Expire 停止抢红包并取出没抢完的金额；重复调用返回同一个金额，Redis中没有这个红包时found为false
+	Expire(id string) (remaining int64, found bool, err error)
+
+	WithTx(tx *gorm.DB) RedPacketRepository
+}
+
+type redPacketRepository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewRedPacketRepository(db *gorm.DB, rdb *redis.Client) RedPacketRepository {
+	return &redPacketRepository{db: db, rdb: rdb}
+}
+
+func (r *redPacketRepository) WithTx(tx *gorm.DB) RedPacketRepository {
+	return &redPacketRepository{db: tx, rdb: r.rdb}
+}
+
+func (r *redPacketRepository) keyMeta(id string) string {
+	return fmt.Sprintf("redpacket:%s", id)
+}
+
+func (r *redPacketRepository) keyShares(id string) string {
+	return fmt.Sprintf("redpacket:shares:%s", id)
+}
+
+func (r *redPacketRepository) keyGrabbed(id string) string {
+	return fmt.Sprintf("redpacket:grabbed:%s", id)
+}
+
+func (r *redPacketRepository) Create(packet *model.RedPacket) error {
+	return r.db.Create(packet).Error
+}
+
+func (r *redPacketRepository) FindByID(id string) (*model.RedPacket, error) {
+	var packet model.RedPacket
+	if err := r.db.Where("id = ?", id).First(&packet).Error; err != nil {
+		return nil, err
+	}
+	return &packet, nil
+}
+
+func (r *redPacketRepository) FindByRequest(senderID uint64, requestID string) (*model.RedPacket, error) {
+	var packet model.RedPacket
+	if err := r.db.Where("sender_id = ? AND request_id = ?", senderID, requestID).First(&packet).Error; err != nil {
+		return nil, err
+	}
+	return &packet, nil
+}
+
+func (r *redPacketRepository) CreateGrab(grab *model.RedPacketGrab) error {
+	return r.db.Create(grab).Error
+}
+
+func (r *redPacketRepository) AddGrabbed(id string, amount int64) (bool, error) {
+	result := r.db.Model(&model.RedPacket{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"grabbed_amount": gorm.Expr("grabbed_amount + ?", amount),
+		"grabbed_count":  gorm.Expr("grabbed_count + 1"),
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *redPacketRepository) MarkRefunded(id string, refundedAmount int64) (bool, error) {
+	result := r.db.Model(&model.RedPacket{}).
+		Where("id = ? AND status = ?", id, model.RedPacketActive).
+		Updates(map[string]interface{}{
+			"status":          model.RedPacketRefunded,
+			"refunded_amount": refundedAmount,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *redPacketRepository) ListGrabs(id string) ([]model.RedPacketGrab, error) {
+	var grabs []model.RedPacketGrab
+	err := r.db.Where("packet_id = ?", id).Order("amount DESC, id").Find(&grabs).Error
+	return grabs, err
+}
+
+func (r *redPacketRepository) ListExpired(now time.Time, limit int) ([]model.RedPacket, error) {
+	var packets []model.RedPacket
+	err := r.db.Where("status = ? AND expires_at <= ?", model.RedPacketActive, now).
+		Order("expires_at").Limit(limit).Find(&packets).Error
+	return packets, err
+}
+
+func (r *redPacketRepository) Publish(packet *model.RedPacket, shares []int64) error {
+	ctx := context.Background()
+	ttl := time.Until(packet.ExpiresAt) + redPacketKeepAfterExpire
+	values := make([]interface{}, 0, len(shares))
+	for _, share := range shares {
+		values = append(values, share)
+	}
+	pipe := r.rdb.TxPipeline()
+	pipe.HSet(ctx, r.keyMeta(packet.ID), "status", "active", "expires_at", packet.ExpiresAt.UnixMilli())
+	pipe.Expire(ctx, r.keyMeta(packet.ID), ttl)
+	pipe.RPush(ctx, r.keyShares(packet.ID), values...)
+	pipe.Expire(ctx, r.keyShares(packet.ID), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// grabScript 每人一份、不超发在一个脚本里完成：先查这个用户是否抢过，再检查过期，最后LPOP一份
+// KEYS[1]: 红包哈希 KEYS[2]: 份额列表 KEYS[3]: 抢到的人（用户ID → 金额） ARGV[1]: 用户ID ARGV[2]: 当前毫秒时间戳
+// 返回{结果, 金额}，结果的含义见RedPacketGrab*常量
+var grabScript = redis.NewScript(`
+local meta = redis.call("HMGET", KEYS[1], "status", "expires_at")
+if not meta[1] then
+	return {4, 0}
+end
+local got = redis.call("HGET", KEYS[3], ARGV[1])
+if got then
+	return {2, tonumber(got)}
+end
+if meta[1] ~= "active" or tonumber(ARGV[2]) >= tonumber(meta[2]) then
+	return {5, 0}
+end
+local share = redis.call("LPOP", KEYS[2])
+if not share then
+	return {3, 0}
+end
+redis.call("HSET", KEYS[3], ARGV[1], share)
+redis.call("PEXPIRE", KEYS[3], redis.call("PTTL", KEYS[1]))
+return {1, tonumber(share)}
+`)
+
+func (r *redPacketRepository) Grab(id string, userID uint64, now time.Time) (int, int64, error) {
+	keys := []string{r.keyMeta(id), r.keyShares(id), r.keyGrabbed(id)}
+	res, err := grabScript.Run(context.Background(), r.rdb, keys, userID, now.UnixMilli()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(res[0]), res[1], nil
+}
+
+// releaseGrabScript 删除这个用户抢到的记录，红包还在active时把这一份放回列表
+// KEYS同grabScript ARGV[1]: 用户ID ARGV[2]: 金额
+// 返回1放回了，0红包已经过期或者这个用户没有抢到这个金额
+var releaseGrabScript = redis.NewScript(`
+if redis.call("HGET", KEYS[3], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HDEL", KEYS[3], ARGV[1])
+if redis.call("HGET", KEYS[1], "status") ~= "active" then
+	return 0
+end
+redis.call("LPUSH", KEYS[2], ARGV[2])
+return 1
+`)
+
+func (r *redPacketRepository) ReleaseGrab(id string, userID uint64, amount int64) (bool, error) {
+	keys := []string{r.keyMeta(id), r.keyShares(id), r.keyGrabbed(id)}
+	res, err := releaseGrabScript.Run(context.Background(), r.rdb, keys, userID, amount).Int()
+	return res == 1, err
+}
+
+// expireScript active → expired，把剩下的份额加起来记在refund字段并删除列表，之后的抢红包都返回过期
+// KEYS[1]: 红包哈希 KEYS[2]: 份额列表
+// 返回没抢完的金额，红包不存在时返回-1
+var expireScript = redis.NewScript(`
+local status = redis.call("HGET", KEYS[1], "status")
+if not status then
+	return -1
+end
+if status == "active" then
+	local remaining = 0
+	for _, share in ipairs(redis.call("LRANGE", KEYS[2], 0, -1)) do
+		remaining = remaining + tonumber(share)
+	end
+	redis.call("DEL", KEYS[2])
+	redis.call("HSET", KEYS[1], "status", "expired", "refund", remaining)
+	return remaining
+end
+return tonumber(redis.call("HGET", KEYS[1], "refund"))
+`)
+
+func (r *redPacketRepository) Expire(id string) (int64, bool, error) {
+	res, err := expireScript.Run(context.Background(), r.rdb, []string{r.keyMeta(id), r.keyShares(id)}).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	if res < 0 {
+		return 0, false, nil
+	}
+	return res, true, nil
+}
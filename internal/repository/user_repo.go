@@ -10,6 +10,8 @@ import (
 type UserRepository interface {
 	Create(user *model.User) error
 	FindByUsername(username string) (*model.User, error)
+	// 批量查找用户，不存在的ID直接跳过
+	FindByIDs(ids []uint64) ([]model.User, error)
 }
 
 // 数据库接口封装
@@ -36,3 +38,13 @@ func (r *userRepository) FindByUsername(username string) (*model.User, error) {
 	}
 	return &result, err
 }
+
+// 批量查找用户，排行榜等列表用它补上用户名
+func (r *userRepository) FindByIDs(ids []uint64) ([]model.User, error) {
+	var users []model.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := r.db.Select("id", "username").Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"Orion_Live/internal/model"
+	"Orion_Live/pkg/cursor"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -10,58 +11,145 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
 const (
-	keyVideoLikeCountHash   = "video:like_counts"
-	keyVideoGoldenCountHash = "video:golden_counts"
-	keyVideoLikersSet       = "video:likers"
+	keyVideoLikeCountHash = "video:like_counts"
+	keyVideoLikersSet     = "video:likers"
+	// 点赞集合是否已经从likes表加载过，值为likesModeRedis或likesModeDB
+	keyVideoLikesHydrated = "video:likes_hydrated"
+	keyVideoLikesLock     = "video:likes_hydrate_lock"
+)
+
+// 点赞集合的加载方式
+const (
+	likesModeRedis = "redis" // 全部点赞用户都在Redis集合里
+	likesModeDB    = "db"    // 集合太大没有加载，Redis里只有最近的点赞，没命中时回源likes表的唯一索引
+)
+
+const (
+	// 加载后的集合和标记都有过期时间，冷门视频的大集合不会一直占着内存，过期后再按需加载
+	likesHydrateTTL = 24 * time.Hour
+	// 点赞数超过这个值的视频不把用户全部加载进Redis
+	likesMaxHydrateSize = 100000
+	// 其他进程正在加载时，最多等这么久，超时就直接查数据库
+	likesHydrateWait = time.Second
 )
 
 type VideoRepository interface {
 	Create(video *model.Video) error
-	FindLatest(limit uint64) ([]model.Video, error)
+	// ListFeed Feed中的视频，按(publish_at, id)倒序，after不为nil时从它之后开始，最多limit个
+	ListFeed(after *cursor.Cursor, limit int) ([]model.Video, error)
 	FindByID(videoID uint64) (*model.Video, error)
+	// FindByLiveSession 这场直播的回放视频，没有时返回gorm.ErrRecordNotFound
+	FindByLiveSession(sessionID uint64) (*model.Video, error)
+	// FindByIDNoCache 跳过缓存直接查数据库，处理状态要以数据库为准
+	FindByIDNoCache(videoID uint64) (*model.Video, error)
+	// MarkProcessing uploaded/processing → processing，重新投递的消息可以接着处理
+	MarkProcessing(videoID uint64) error
+	// MarkReady processing → ready，写入转码结果，返回是否更新了
+	MarkReady(videoID uint64, result VideoProcessed) (bool, error)
+	// MarkFailed uploaded/processing → failed，返回是否更新了
+	MarkFailed(videoID uint64, reason string) (bool, error)
+	// UpdateByAuthor 作者修改自己的视频，只有版本仍是version时才更新并把版本加1，返回是否更新了
+	UpdateByAuthor(videoID, authorID, version uint64, edit VideoEdit) (bool, error)
+	// DeleteByAuthor 软删除作者自己的视频，version为nil时不检查版本，返回是否删除了
+	DeleteByAuthor(videoID, authorID uint64, version *uint64) (bool, error)
+	// ListDueScheduled 定时发布时间不晚于now、还没发布的视频，按发布时间升序，最多limit个
+	ListDueScheduled(now time.Time, limit int) ([]model.Video, error)
+	// PublishScheduled scheduled → published，只有发布时间已到时才更新，publish_at改为实际发布的时间now，版本加1，返回是否更新了
+	PublishScheduled(videoID uint64, now time.Time) (bool, error)
 	// 带锁的查找
 	FindByIDForUpdate(videoID uint64) (*model.Video, error)
 	IncrementLikeCount(videoID uint64) error
 	DecrementLikeCount(videoID uint64) error
+	// 按id升序分批遍历视频，返回id大于afterID的最多limit个视频，只有id、计数、创建和发布时间
+	ListIDsAfter(afterID uint64, limit int) ([]model.Video, error)
+	// 对账用：按likes表重新计算videos.like_count
+	SyncLikeCount(videoID uint64) error
 
-	GetGoldenCount(videoID uint64) (uint64, error)
 	IncrementGoldenCount(videoID uint64) (uint64, error)
-	IncrementGoldenCount_Redis(videoID uint64) (uint64, error) // 返回增长后的计数值
-	DecrementGoldenCount_Redis(videoID uint64) error           // 用于补偿
+	// 原子地抢占一个黄金评论席位：窗口内、用户没抢过、席位没满才能成功
+	// 席位的值是预约票ID，对账时用它找到对应的票
+	GrabGoldenSeat(videoID, userID uint64, ticketID string, quota int, windowEnd time.Time) (GoldenSeatResult, error)
+	// 归还席位，只有席位仍属于这张票时才删除，返回是否真的归还了
+	ReleaseGoldenSeat(videoID, userID uint64, ticketID string) (bool, error)
+	// 对账用：读取视频的全部席位，用户ID → 预约票ID
+	GetGoldenSeats(videoID uint64) (map[uint64]string, error)
+	// 对账用：给已经落库但没有席位的用户补一个席位
+	RestoreGoldenSeat(videoID, userID uint64, ticketID string, windowEnd time.Time) error
+	// 对账用：按comments表重新计算videos.golden_count
+	SyncGoldenCount(videoID uint64) error
 
 	GetVideoCache(videoID uint64) (*model.Video, error)
 	SetVideoCache(video *model.Video) error
+	DeleteVideoCache(videoID uint64) error
+	// DeleteVideoKeys 视频删除后清理它在Redis中的所有数据：视频缓存、点赞集合和点赞数、黄金评论席位
+	DeleteVideoKeys(videoID uint64) error
 
 	// Redis的所有值（Value）都是二进制安全的字符串
 	AddVideoLike(videoID, userID uint64) error
 	RemoveVideoLike(videoID, userID uint64) error
 	GetVideoLikeCount(videoID uint64) (uint64, error)
 	IsUserLikeVideo(videoID, userID uint64) (bool, error)
+	// 对账用：点赞用户是否全部缓存在Redis集合中（点赞数太多的视频只缓存最近的点赞）
+	LikersFullyCached(videoID uint64) (bool, error)
+	// 对账用：读取Redis中视频的点赞用户集合
+	GetVideoLikers(videoID uint64) ([]uint64, error)
+	// 对账用：在一个事务管道中补齐/删除点赞用户，并把点赞数调整countDelta
+	RepairVideoLikes(videoID uint64, add, remove []uint64, countDelta int64) error
 
 	WithTx(tx *gorm.DB) VideoRepository
 }
 
+// VideoProcessed 转码的结果，CoverURL为空时保留原来的封面
+type VideoProcessed struct {
+	HLSURL     string
+	CoverURL   string
+	DurationMS int64
+	Width      int
+	Height     int
+}
+
+// VideoEdit 作者可以修改的字段，nil表示不修改
+type VideoEdit struct {
+	Title       *string
+	Description *string
+	CoverURL    *string
+	Visibility  *string
+	Publish     *VideoPublish
+}
+
+// VideoPublish 发布状态和发布时间一起修改，At为nil时清空发布时间（草稿）
+type VideoPublish struct {
+	Status string
+	At     *time.Time
+}
+
 type videoRepository struct {
 	db  *gorm.DB
 	rdb *redis.Client
+	// 同一个进程内，同一个视频的点赞集合同时只加载一次
+	hydrateGroup *singleflight.Group
 }
 
 func NewVideoRepository(db *gorm.DB, rdb *redis.Client) VideoRepository {
 	return &videoRepository{
-		db:  db,
-		rdb: rdb,
+		db:           db,
+		rdb:          rdb,
+		hydrateGroup: &singleflight.Group{},
 	}
 }
 
-// WithTx 返回一个新的、使用事务的 commentRepository 实例
+// WithTx 返回一个新的、使用事务的 videoRepository 实例，Redis客户端和点赞集合的加载组沿用原来的
 func (r *videoRepository) WithTx(tx *gorm.DB) VideoRepository {
 	return &videoRepository{
-		db: tx,
+		db:           tx,
+		rdb:          r.rdb,
+		hydrateGroup: r.hydrateGroup,
 	}
 }
 
@@ -69,12 +157,19 @@ func (r *videoRepository) Create(video *model.Video) error {
 	return r.db.Create(video).Error
 }
 
-// 按时间倒序查询最新的视频列表
-func (r *videoRepository) FindLatest(limit uint64) ([]model.Video, error) {
+// 按发布时间倒序查询Feed，keyset分页：新发布的视频排在游标前面，不会让后面的页重复或漏掉
+func (r *videoRepository) ListFeed(after *cursor.Cursor, limit int) ([]model.Video, error) {
 	var videos []model.Video
 
-	// Preload("Author")在查询视频的同时，预加载关联的作者信息,时间倒序,限制数量
-	err := r.db.Preload("Author").Order("created_at desc").Limit(int(limit)).Find(&videos).Error
+	// Preload("Author")在查询视频的同时，预加载关联的作者信息,按发布时间倒序,限制数量
+	// 只有已发布、公开、转码完成的视频出现在Feed里，走(publish_status, publish_at)索引
+	query := r.db.Preload("Author").
+		Where("publish_status = ? AND visibility = ? AND processing_status = ?", model.PublishPublished, model.VisibilityPublic, model.VideoReady)
+	if after != nil {
+		// 同一时间发布的视频按id区分，写成OR而不是(publish_at, id) < (?, ?)，MySQL才能用上索引
+		query = query.Where("publish_at < ? OR (publish_at = ? AND id < ?)", after.Time, after.Time, after.ID)
+	}
+	err := query.Order("publish_at desc, id desc").Limit(limit).Find(&videos).Error
 	if err != nil {
 		return nil, err
 	}
@@ -103,6 +198,109 @@ func (r *videoRepository) FindByID(videoID uint64) (*model.Video, error) {
 	return &dbVideo, nil
 }
 
+func (r *videoRepository) FindByLiveSession(sessionID uint64) (*model.Video, error) {
+	var video model.Video
+	if err := r.db.Where("live_session_id = ?", sessionID).First(&video).Error; err != nil {
+		return nil, err
+	}
+	return &video, nil
+}
+
+func (r *videoRepository) FindByIDNoCache(videoID uint64) (*model.Video, error) {
+	var video model.Video
+	if err := r.db.First(&video, videoID).Error; err != nil {
+		return nil, err
+	}
+	return &video, nil
+}
+
+func (r *videoRepository) MarkProcessing(videoID uint64) error {
+	return r.db.Model(&model.Video{}).
+		Where("id = ? AND processing_status IN ?", videoID, []string{model.VideoUploaded, model.VideoProcessing}).
+		Update("processing_status", model.VideoProcessing).Error
+}
+
+func (r *videoRepository) MarkReady(videoID uint64, result VideoProcessed) (bool, error) {
+	updates := map[string]interface{}{
+		"processing_status": model.VideoReady,
+		"processing_error":  "",
+		"hls_url":           result.HLSURL,
+		"duration_ms":       result.DurationMS,
+		"width":             result.Width,
+		"height":            result.Height,
+	}
+	if result.CoverURL != "" {
+		updates["cover_url"] = result.CoverURL
+	}
+	res := r.db.Model(&model.Video{}).Where("id = ? AND processing_status = ?", videoID, model.VideoProcessing).Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *videoRepository) MarkFailed(videoID uint64, reason string) (bool, error) {
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	res := r.db.Model(&model.Video{}).
+		Where("id = ? AND processing_status IN ?", videoID, []string{model.VideoUploaded, model.VideoProcessing}).
+		Updates(map[string]interface{}{
+			"processing_status": model.VideoFailed,
+			"processing_error":  reason,
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *videoRepository) UpdateByAuthor(videoID, authorID, version uint64, edit VideoEdit) (bool, error) {
+	updates := map[string]interface{}{"version": gorm.Expr("version + 1")}
+	if edit.Title != nil {
+		updates["title"] = *edit.Title
+	}
+	if edit.Description != nil {
+		updates["description"] = *edit.Description
+	}
+	if edit.CoverURL != nil {
+		updates["cover_url"] = *edit.CoverURL
+	}
+	if edit.Visibility != nil {
+		updates["visibility"] = *edit.Visibility
+	}
+	if edit.Publish != nil {
+		updates["publish_status"] = edit.Publish.Status
+		updates["publish_at"] = edit.Publish.At
+	}
+	res := r.db.Model(&model.Video{}).Where("id = ? AND author_id = ? AND version = ?", videoID, authorID, version).Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
+// gorm.DeletedAt软删除：UPDATE videos SET deleted_at = NOW() WHERE ... AND deleted_at IS NULL，之后的查询都看不到它
+func (r *videoRepository) DeleteByAuthor(videoID, authorID uint64, version *uint64) (bool, error) {
+	query := r.db.Where("id = ? AND author_id = ?", videoID, authorID)
+	if version != nil {
+		query = query.Where("version = ?", *version)
+	}
+	res := query.Delete(&model.Video{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *videoRepository) ListDueScheduled(now time.Time, limit int) ([]model.Video, error) {
+	var videos []model.Video
+	err := r.db.Where("publish_status = ? AND publish_at <= ?", model.PublishScheduled, now).Order("publish_at").Limit(limit).Find(&videos).Error
+	return videos, err
+}
+
+// 条件更新：多个scheduler实例同时发布同一个视频时只有一个成功；作者在这之前改回草稿或者改了时间也不会被发布
+// publish_at改为实际发布的时间：Feed按它排序、游标也按它翻页，scheduler晚于定时时间发布时，保留定时的时间会让视频
+// 排到已经发出去的游标后面，正在往下翻的客户端就永远看不到它了；改成now之后Feed里的排序键只增不减
+func (r *videoRepository) PublishScheduled(videoID uint64, now time.Time) (bool, error) {
+	res := r.db.Model(&model.Video{}).
+		Where("id = ? AND publish_status = ? AND publish_at <= ?", videoID, model.PublishScheduled, now).
+		Updates(map[string]interface{}{
+			"publish_status": model.PublishPublished,
+			"publish_at":     now,
+			"version":        gorm.Expr("version + 1"),
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
 func (r *videoRepository) FindByIDForUpdate(videoID uint64) (*model.Video, error) {
 	var video model.Video
 	// SELECT * FROM `videos` WHERE `id` = ? LIMIT 1 FOR UPDATE;
@@ -151,8 +349,25 @@ func (r *videoRepository) SetVideoCache(video *model.Video) error {
 	return r.rdb.Set(context.Background(), key, videoJSON, expiration).Err()
 }
 
-func (r *videoRepository) keyVideoGoldenCount(videoID uint64) string {
-	return fmt.Sprintf("video:golden_count:%d", videoID)
+// 视频信息变了之后删除缓存，下次读取时从数据库回填
+func (r *videoRepository) DeleteVideoCache(videoID uint64) error {
+	return r.rdb.Del(context.Background(), r.keyVideoInfo(videoID)).Err()
+}
+
+// 点赞数哈希是所有视频共用的，只删掉这个视频的field；还在路上的点赞消息落库时视频已经删除，不会再写回来
+func (r *videoRepository) DeleteVideoKeys(videoID uint64) error {
+	ctx := context.Background()
+	videoIDStr := strconv.FormatUint(videoID, 10)
+	pipe := r.rdb.TxPipeline()
+	pipe.Del(ctx,
+		r.keyVideoInfo(videoID),
+		keyVideoLikersSet+":"+videoIDStr,
+		fmt.Sprintf("%s:%d", keyVideoLikesHydrated, videoID),
+		r.keyVideoGoldenSeats(videoID),
+	)
+	pipe.HDel(ctx, keyVideoLikeCountHash, videoIDStr)
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
 func (r *videoRepository) IncrementLikeCount(videoID uint64) error {
@@ -165,35 +380,110 @@ func (r *videoRepository) DecrementLikeCount(videoID uint64) error {
 	return r.db.Model(&model.Video{}).Where("id = ? AND like_count > 0", videoID).UpdateColumn("like_count", gorm.Expr("like_count - ?", 1)).Error
 }
 
-// 在Redis中增加黄金评论数量
-func (r *videoRepository) IncrementGoldenCount_Redis(videoID uint64) (uint64, error) {
-	key := r.keyVideoGoldenCount(videoID)
-	// INCR本身是原子的
-	res, err := r.rdb.Incr(context.Background(), key).Result()
-	return uint64(res), err
+func (r *videoRepository) ListIDsAfter(afterID uint64, limit int) ([]model.Video, error) {
+	var videos []model.Video
+	// 只查对账需要的列，keyset分页比offset稳定，视频表再大也不会越翻越慢
+	err := r.db.Select("id", "like_count", "golden_count", "created_at", "publish_at").Where("id > ?", afterID).Order("id").Limit(limit).Find(&videos).Error
+	return videos, err
 }
 
-func (r *videoRepository) DecrementGoldenCount_Redis(videoID uint64) error {
-	key := r.keyVideoGoldenCount(videoID)
-	return r.rdb.Decr(context.Background(), key).Err()
+// 在一条UPDATE里用子查询计数，读和写之间不会插进别的点赞
+func (r *videoRepository) SyncLikeCount(videoID uint64) error {
+	return r.db.Exec("UPDATE videos SET like_count = (SELECT COUNT(*) FROM likes WHERE video_id = ?) WHERE id = ?", videoID, videoID).Error
 }
 
-func (r *videoRepository) IncrementGoldenCount(videoID uint64) (uint64, error) {
-	err := r.db.Model(&model.Video{}).Where("id = ?", videoID).UpdateColumn("golden_count", gorm.Expr("golden_count + ?", 1)).Error
-	return 0, err
+// 黄金评论席位：video:golden_seats:{videoID}哈希，field为用户ID，value为预约票ID
+func (r *videoRepository) keyVideoGoldenSeats(videoID uint64) string {
+	return fmt.Sprintf("video:golden_seats:%d", videoID)
 }
 
-func (r *videoRepository) GetGoldenCount(videoID uint64) (uint64, error) {
-	videoIDStr := strconv.FormatUint(videoID, 10)
-	// 虽然redis是“键值数据库”，但是储存后拿出来，都是字符串string，所以之后要转化
-	countStr, err := r.rdb.HGet(context.Background(), keyVideoGoldenCountHash, videoIDStr).Result()
-	if err == redis.Nil {
-		return 0, nil // 如果key或field不存在，返回0
-	} else if err != nil {
+// GoldenSeatResult 抢席位的结果
+type GoldenSeatResult int
+
+const (
+	GoldenSeatGranted      GoldenSeatResult = iota // 抢到了
+	GoldenSeatWindowClosed                         // 已经过了开放时间
+	GoldenSeatAlreadyTaken                         // 这个用户已经占了一个席位
+	GoldenSeatFull                                 // 席位已满
+)
+
+// grabGoldenSeatScript 检查窗口、去重、限额、占座在一个脚本里完成，Redis单线程执行，不会超卖
+// 用Redis服务器的时间判断窗口，不受各个server机器时钟偏差的影响
+// KEYS[1]: 席位哈希 ARGV[1]: 用户ID ARGV[2]: 席位数 ARGV[3]: 窗口结束时间（毫秒） ARGV[4]: 预约票ID
+var grabGoldenSeatScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+if now > tonumber(ARGV[3]) then
+	return 1
+end
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+	return 2
+end
+if redis.call("HLEN", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 3
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[4])
+-- 窗口关闭一天后自动清理，那时数据早已落库
+redis.call("PEXPIREAT", KEYS[1], tonumber(ARGV[3]) + 86400000)
+return 0
+`)
+
+func (r *videoRepository) GrabGoldenSeat(videoID, userID uint64, ticketID string, quota int, windowEnd time.Time) (GoldenSeatResult, error) {
+	keys := []string{r.keyVideoGoldenSeats(videoID)}
+	res, err := grabGoldenSeatScript.Run(context.Background(), r.rdb, keys, userID, quota, windowEnd.UnixMilli(), ticketID).Int()
+	if err != nil {
 		return 0, err
 	}
-	count, _ := strconv.ParseUint(countStr, 10, 64)
-	return count, nil
+	return GoldenSeatResult(res), nil
+}
+
+// releaseGoldenSeatScript 比较并删除：席位的值仍是这张票时才删除，避免删掉用户退款后重新抢到的席位
+var releaseGoldenSeatScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+func (r *videoRepository) ReleaseGoldenSeat(videoID, userID uint64, ticketID string) (bool, error) {
+	keys := []string{r.keyVideoGoldenSeats(videoID)}
+	n, err := releaseGoldenSeatScript.Run(context.Background(), r.rdb, keys, userID, ticketID).Int()
+	return n > 0, err
+}
+
+func (r *videoRepository) GetGoldenSeats(videoID uint64) (map[uint64]string, error) {
+	vals, err := r.rdb.HGetAll(context.Background(), r.keyVideoGoldenSeats(videoID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	seats := make(map[uint64]string, len(vals))
+	for userIDStr, ticketID := range vals {
+		userID, err := strconv.ParseUint(userIDStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		seats[userID] = ticketID
+	}
+	return seats, nil
+}
+
+// HSETNX：对账期间用户自己抢到了席位就不覆盖；过期时间和抢席位时一致
+func (r *videoRepository) RestoreGoldenSeat(videoID, userID uint64, ticketID string, windowEnd time.Time) error {
+	key := r.keyVideoGoldenSeats(videoID)
+	pipe := r.rdb.TxPipeline()
+	pipe.HSetNX(context.Background(), key, strconv.FormatUint(userID, 10), ticketID)
+	pipe.PExpireAt(context.Background(), key, windowEnd.Add(24*time.Hour))
+	_, err := pipe.Exec(context.Background())
+	return err
+}
+
+func (r *videoRepository) SyncGoldenCount(videoID uint64) error {
+	return r.db.Exec("UPDATE videos SET golden_count = (SELECT COUNT(*) FROM comments WHERE video_id = ? AND is_golden = ? AND deleted_at IS NULL) WHERE id = ?", videoID, true, videoID).Error
+}
+
+func (r *videoRepository) IncrementGoldenCount(videoID uint64) (uint64, error) {
+	err := r.db.Model(&model.Video{}).Where("id = ?", videoID).UpdateColumn("golden_count", gorm.Expr("golden_count + ?", 1)).Error
+	return 0, err
 }
 
 // 增加点赞记录：1、将用户ID添加到视频点赞集合 2、视频点赞数++ 3、利用r.rdb.Pipeline()保证操作的原子性
@@ -222,8 +512,11 @@ func (r *videoRepository) RemoveVideoLike(videoID, userID uint64) error {
 	return err
 }
 
-// 获取视频的点赞总数 1、利用videoID拼接成video:like_counts这个哈希结构 2、利用videoID这个field获取like_counts值
+// 获取视频的点赞总数 1、确保点赞数据已经从likes表加载 2、利用videoID这个field获取video:like_counts的值
 func (r *videoRepository) GetVideoLikeCount(videoID uint64) (uint64, error) {
+	if _, err := r.ensureLikesHydrated(videoID); err != nil {
+		return 0, err
+	}
 	videoIDStr := strconv.FormatUint(videoID, 10)
 	// 虽然redis是“键值数据库”，但是储存后拿出来，都是字符串string，所以之后要转化
 	countStr, err := r.rdb.HGet(context.Background(), keyVideoLikeCountHash, videoIDStr).Result()
@@ -236,9 +529,164 @@ func (r *videoRepository) GetVideoLikeCount(videoID uint64) (uint64, error) {
 	return count, nil
 }
 
-// 判断用户是否点赞过该视频：1、利用传来的videoID和userID，在video:likers:{videoID}这个set中找是否有userID
+// 判断用户是否点赞过该视频：1、确保点赞集合已经从likes表加载 2、在video:likers:{videoID}这个set中找是否有userID 3、大集合没命中时回源likes表
 func (r *videoRepository) IsUserLikeVideo(videoID, userID uint64) (bool, error) {
+	mode, err := r.ensureLikesHydrated(videoID)
+	if err != nil {
+		return false, err
+	}
 	videoIDStr := strconv.FormatUint(videoID, 10)
 	userIDStr := strconv.FormatUint(userID, 10)
-	return r.rdb.SIsMember(context.Background(), keyVideoLikersSet+":"+videoIDStr, userIDStr).Result()
+	liked, err := r.rdb.SIsMember(context.Background(), keyVideoLikersSet+":"+videoIDStr, userIDStr).Result()
+	if err != nil || liked || mode == likesModeRedis {
+		return liked, err
+	}
+	// likes表上有(user_id, video_id)唯一索引，单点查询很快
+	var n int64
+	err = r.db.Model(&model.Like{}).Where("user_id = ? AND video_id = ?", userID, videoID).Limit(1).Count(&n).Error
+	return n > 0, err
+}
+
+// ensureLikesHydrated 读穿透：Redis里没有加载标记时，从likes表加载点赞集合，返回加载方式
+// 进程内用singleflight合并，进程间用SET NX锁，抢不到锁的等待其他进程加载完成，等不到就本次直接查数据库
+func (r *videoRepository) ensureLikesHydrated(videoID uint64) (string, error) {
+	ctx := context.Background()
+	markerKey := fmt.Sprintf("%s:%d", keyVideoLikesHydrated, videoID)
+	mode, err := r.rdb.Get(ctx, markerKey).Result()
+	if err == nil {
+		return mode, nil
+	}
+	if err != redis.Nil {
+		return "", err
+	}
+
+	v, err, _ := r.hydrateGroup.Do(markerKey, func() (interface{}, error) {
+		lockKey := fmt.Sprintf("%s:%d", keyVideoLikesLock, videoID)
+		locked, err := r.rdb.SetNX(ctx, lockKey, 1, 10*time.Second).Result()
+		if err != nil {
+			return "", err
+		}
+		if !locked {
+			return r.waitLikesHydrated(markerKey)
+		}
+		defer r.rdb.Del(ctx, lockKey)
+		return r.hydrateLikes(videoID, markerKey)
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+func (r *videoRepository) LikersFullyCached(videoID uint64) (bool, error) {
+	mode, err := r.ensureLikesHydrated(videoID)
+	return mode == likesModeRedis, err
+}
+
+func (r *videoRepository) waitLikesHydrated(markerKey string) (string, error) {
+	deadline := time.Now().Add(likesHydrateWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		mode, err := r.rdb.Get(context.Background(), markerKey).Result()
+		if err == nil {
+			return mode, nil
+		}
+		if err != redis.Nil {
+			return "", err
+		}
+	}
+	// 等不到就当作大集合处理：Redis没命中的都回源数据库，结果仍然正确
+	return likesModeDB, nil
+}
+
+// hydrateLikesScript 加载完成后，按集合的实际大小设置点赞数，并设置集合和标记的过期时间
+// 集合比标记多活一分钟，避免标记还在、集合已经过期时把所有人都判断成没点赞
+var hydrateLikesScript = redis.NewScript(`
+if ARGV[2] == "redis" then
+	redis.call("HSET", KEYS[2], ARGV[1], redis.call("SCARD", KEYS[1]))
+	redis.call("EXPIRE", KEYS[1], tonumber(ARGV[3]) + 60)
+else
+	redis.call("HSET", KEYS[2], ARGV[1], ARGV[4])
+	redis.call("EXPIRE", KEYS[1], tonumber(ARGV[3]) + 60)
+end
+redis.call("SET", KEYS[3], ARGV[2], "EX", ARGV[3])
+return 1
+`)
+
+// hydrateLikes 从likes表加载点赞数据：1、先数点赞数，超过上限只写点赞数，不加载集合 2、分批SADD用户（只增不删，保留还没落库的新点赞）3、用脚本写点赞数和标记
+func (r *videoRepository) hydrateLikes(videoID uint64, markerKey string) (string, error) {
+	ctx := context.Background()
+	videoIDStr := strconv.FormatUint(videoID, 10)
+	setKey := keyVideoLikersSet + ":" + videoIDStr
+
+	var count int64
+	if err := r.db.Model(&model.Like{}).Where("video_id = ?", videoID).Count(&count).Error; err != nil {
+		return "", err
+	}
+	mode := likesModeRedis
+	if count > likesMaxHydrateSize {
+		mode = likesModeDB
+	} else {
+		var userIDs []uint64
+		if err := r.db.Model(&model.Like{}).Where("video_id = ?", videoID).Pluck("user_id", &userIDs).Error; err != nil {
+			return "", err
+		}
+		for start := 0; start < len(userIDs); start += 1000 {
+			end := start + 1000
+			if end > len(userIDs) {
+				end = len(userIDs)
+			}
+			if err := r.rdb.SAdd(ctx, setKey, uint64sToArgs(userIDs[start:end])...).Err(); err != nil {
+				return "", err
+			}
+		}
+	}
+	keys := []string{setKey, keyVideoLikeCountHash, markerKey}
+	ttl := int(likesHydrateTTL.Seconds())
+	if err := hydrateLikesScript.Run(ctx, r.rdb, keys, videoIDStr, mode, ttl, count).Err(); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+// 读取video:likers:{videoID}集合，用SSCAN分批读取，避免大集合的SMEMBERS阻塞Redis
+func (r *videoRepository) GetVideoLikers(videoID uint64) ([]uint64, error) {
+	key := keyVideoLikersSet + ":" + strconv.FormatUint(videoID, 10)
+	var userIDs []uint64
+	iter := r.rdb.SScan(context.Background(), key, 0, "", 1000).Iterator()
+	for iter.Next(context.Background()) {
+		userID, err := strconv.ParseUint(iter.Val(), 10, 64)
+		if err != nil {
+			continue // 不是合法的用户ID，当作脏数据跳过
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, iter.Err()
+}
+
+// 修复点赞数据：1、SADD缺少的用户 2、SREM多余的用户 3、HINCRBY调整点赞数
+// 点赞数用增量而不是直接覆盖，对账期间发生的新点赞不会被抹掉
+func (r *videoRepository) RepairVideoLikes(videoID uint64, add, remove []uint64, countDelta int64) error {
+	videoIDStr := strconv.FormatUint(videoID, 10)
+	key := keyVideoLikersSet + ":" + videoIDStr
+	pipe := r.rdb.TxPipeline()
+	if len(add) > 0 {
+		pipe.SAdd(context.Background(), key, uint64sToArgs(add)...)
+	}
+	if len(remove) > 0 {
+		pipe.SRem(context.Background(), key, uint64sToArgs(remove)...)
+	}
+	if countDelta != 0 {
+		pipe.HIncrBy(context.Background(), keyVideoLikeCountHash, videoIDStr, countDelta)
+	}
+	_, err := pipe.Exec(context.Background())
+	return err
+}
+
+func uint64sToArgs(ids []uint64) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = strconv.FormatUint(id, 10)
+	}
+	return args
 }
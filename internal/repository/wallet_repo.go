@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"Orion_Live/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInsufficientBalance 付款方的余额不足以完成这笔转账
+	ErrInsufficientBalance = errors.New("余额不足")
+	// ErrTransferRefunded 预扣记录已经refunded，不能再改为committed
+	ErrTransferRefunded = errors.New("预扣的金币已退回")
+)
+
+// Transfer 一笔转账，落库为两条流水：From扣Amount，To加Amount
+type Transfer struct {
+	// 幂等键，同一个ID只会成功执行一次
+	TransferID string
+	// From为平台账户时不检查余额（充值）
+	FromUserID uint64
+	ToUserID   uint64
+	Amount     int64
+	FromType   string
+	ToType     string
+}
+
+// 送礼预扣的结果
+const (
+	PreDeductOK           = 1
+	PreDeductDuplicate    = 2 // 同一个转账ID已经预扣过，返回的是当时的记录
+	PreDeductInsufficient = 3
+)
+
+type WalletRepository interface {
+	// --- MySQL：账户余额和复式流水，是金币的最终依据 ---
+
+	// Transfer 执行一笔转账：1、按用户ID顺序SELECT ... FOR UPDATE锁住双方的钱包（没有则创建） 2、检查付款方余额
+	// 3、更新双方余额 4、写入两条流水，TransferID重复时返回重复键错误；必须在事务中调用
+	Transfer(t Transfer) error
+	// Balance 钱包在MySQL中的余额，没有钱包时为0
+	Balance(userID uint64) (int64, error)
+	// ListEntries 用户的流水，按时间倒序
+	ListEntries(userID uint64, offset, limit int) ([]model.WalletLedgerEntry, error)
+
+	// --- Redis：还没落库的送礼预扣（冻结金额），可用余额 = MySQL余额 - 冻结金额 ---
+	// 收款方的余额直接以MySQL为准，不需要在Redis里同步加减，也就没有缓存和数据库不一致的问题
+
+	// AvailableBalance 可用余额
+	AvailableBalance(userID uint64) (int64, error)
+	// PreDeduct 读取MySQL余额，在Redis中原子地检查可用余额并冻结amount，同时创建pending状态的预扣记录；返回预扣结果和之后的可用余额
+	// 重复的transferID返回PreDeductDuplicate，不会重复冻结
+	PreDeduct(userID uint64, transferID string, amount int64, ttl time.Duration) (int, int64, error)
+	// GetTransfer 预扣记录不存在或已过期时返回nil, nil
+	GetTransfer(transferID string) (*model.GiftTransfer, error)
+	// CommitTransfer 流水落库后：预扣记录pending → committed，解冻这笔金额（MySQL余额已经扣过了）；重复调用是安全的
+	// 记录已经refunded时返回ErrTransferRefunded，说明冻结的金额已经退回，但流水也落库了
+	CommitTransfer(transferID string, fromUserID uint64) error
+	// RefundTransfer 落库失败后：预扣记录pending → refunded，解冻这笔金额；返回记录最终是否是refunded，重复调用是安全的
+	RefundTransfer(transferID string, fromUserID uint64) (bool, error)
+
+	WithTx(tx *gorm.DB) WalletRepository
+}
+
+type walletRepository struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewWalletRepository(db *gorm.DB, rdb *redis.Client) WalletRepository {
+	return &walletRepository{db: db, rdb: rdb}
+}
+
+func (r *walletRepository) WithTx(tx *gorm.DB) WalletRepository {
+	return &walletRepository{db: tx, rdb: r.rdb}
+}
+
+func (r *walletRepository) keyTransfer(transferID string) string {
+	return fmt.Sprintf("wallet:transfer:%s", transferID)
+}
+
+func (r *walletRepository) Transfer(t Transfer) error {
+	if t.Amount <= 0 {
+		return fmt.Errorf("转账金额必须大于0: %d", t.Amount)
+	}
+	userIDs := make([]uint64, 0, 2)
+	for _, id := range []uint64{t.FromUserID, t.ToUserID} {
+		if id != model.PlatformAccountID {
+			userIDs = append(userIDs, id)
+		}
+	}
+	// 固定的加锁顺序，A给B送礼和B给A送礼同时发生时不会死锁
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+	wallets := make([]model.Wallet, 0, len(userIDs))
+	for _, id := range userIDs {
+		wallets = append(wallets, model.Wallet{UserID: id})
+	}
+	// 没有钱包的先插入一行余额为0的，已存在的不动（INSERT IGNORE语义）
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&wallets).Error; err != nil {
+		return err
+	}
+	var locked []model.Wallet
+	if err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id IN ?", userIDs).
+		Order("user_id").
+		Find(&locked).Error; err != nil {
+		return err
+	}
+	balances := make(map[uint64]int64, len(locked))
+	for _, w := range locked {
+		balances[w.UserID] = w.Balance
+	}
+
+	fromEntry := model.WalletLedgerEntry{TransferID: t.TransferID, UserID: t.FromUserID, CounterpartyID: t.ToUserID, Type: t.FromType, Amount: -t.Amount}
+	toEntry := model.WalletLedgerEntry{TransferID: t.TransferID, UserID: t.ToUserID, CounterpartyID: t.FromUserID, Type: t.ToType, Amount: t.Amount}
+	if t.FromUserID != model.PlatformAccountID {
+		if balances[t.FromUserID] < t.Amount {
+			return ErrInsufficientBalance
+		}
+		fromEntry.BalanceAfter = balances[t.FromUserID] - t.Amount
+		if err := r.db.Model(&model.Wallet{}).Where("user_id = ?", t.FromUserID).
+			Update("balance", gorm.Expr("balance - ?", t.Amount)).Error; err != nil {
+			return err
+		}
+	}
+	if t.ToUserID != model.PlatformAccountID {
+		toEntry.BalanceAfter = balances[t.ToUserID] + t.Amount
+		if err := r.db.Model(&model.Wallet{}).Where("user_id = ?", t.ToUserID).
+			Update("balance", gorm.Expr("balance + ?", t.Amount)).Error; err != nil {
+			return err
+		}
+	}
+	return r.db.Create(&[]model.WalletLedgerEntry{fromEntry, toEntry}).Error
+}
+
+func (r *walletRepository) Balance(userID uint64) (int64, error) {
+	var wallet model.Wallet
+	err := r.db.Select("balance").Where("user_id = ?", userID).Take(&wallet).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return wallet.Balance, err
+}
+
+func (r *walletRepository) ListEntries(userID uint64, offset, limit int) ([]model.WalletLedgerEntry, error) {
+	var entries []model.WalletLedgerEntry
+	err := r.db.Where("user_id = ?", userID).Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+func (r *walletRepository) keyHold(userID uint64) string {
+	return fmt.Sprintf("wallet:hold:%d", userID)
+}
+
+func (r *walletRepository) AvailableBalance(userID uint64) (int64, error) {
+	balance, err := r.Balance(userID)
+	if err != nil {
+		return 0, err
+	}
+	hold, err := r.rdb.Get(context.Background(), r.keyHold(userID)).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	return balance - hold, nil
+}
+
+// preDeductScript 去重、检查可用余额、冻结、创建预扣记录在一个脚本里完成，并发送礼不会超过余额
+// KEYS[1]: 冻结金额 KEYS[2]: 预扣记录哈希 ARGV[1]: MySQL余额 ARGV[2]: 金额 ARGV[3]: 付款方ID ARGV[4]: 创建时间（毫秒） ARGV[5]: 保留秒数
+// 返回{结果, 可用余额}：结果1成功，2重复，3余额不足
+// 冻结金额和预扣记录同样的过期时间，每次送礼续期；预扣记录过期前一定已经解冻，冻结金额不会泄漏
+var preDeductScript = redis.NewScript(`
+local balance = tonumber(ARGV[1])
+local hold = tonumber(redis.call("GET", KEYS[1]) or "0")
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	return {2, balance - hold}
+end
+local amount = tonumber(ARGV[2])
+if balance - hold < amount then
+	return {3, balance - hold}
+end
+hold = redis.call("INCRBY", KEYS[1], amount)
+redis.call("EXPIRE", KEYS[1], ARGV[5])
+redis.call("HSET", KEYS[2], "status", "pending", "sender_id", ARGV[3], "amount", ARGV[2], "created_at", ARGV[4])
+redis.call("EXPIRE", KEYS[2], ARGV[5])
+return {1, balance - hold}
+`)
+
+// MySQL余额在脚本之外读取，可能刚好错过一笔并发落库的扣款，可用余额会暂时偏高；
+// 这时多出来的送礼会在消费者的FOR UPDATE余额检查中失败，进入死信后退回，不会扣成负数
+func (r *walletRepository) PreDeduct(userID uint64, transferID string, amount int64, ttl time.Duration) (int, int64, error) {
+	balance, err := r.Balance(userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	keys := []string{r.keyHold(userID), r.keyTransfer(transferID)}
+	res, err := preDeductScript.Run(context.Background(), r.rdb, keys, balance, amount, userID, time.Now().UnixMilli(), int(ttl.Seconds())).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(res[0]), res[1], nil
+}
+
+func (r *walletRepository) GetTransfer(transferID string) (*model.GiftTransfer, error) {
+	vals, err := r.rdb.HGetAll(context.Background(), r.keyTransfer(transferID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	t := &model.GiftTransfer{ID: transferID, Status: vals["status"]}
+	t.SenderID, _ = strconv.ParseUint(vals["sender_id"], 10, 64)
+	t.Amount, _ = strconv.ParseInt(vals["amount"], 10, 64)
+	if ms, err := strconv.ParseInt(vals["created_at"], 10, 64); err == nil {
+		t.CreatedAt = time.UnixMilli(ms)
+	}
+	return t, nil
+}
+
+// settleTransferScript 预扣记录从pending流转到ARGV[1]（committed或refunded）并解冻金额
+// KEYS[1]: 预扣记录 KEYS[2]: 付款方的冻结金额 ARGV[1]: 目标状态 ARGV[2]: 付款方ID
+// 返回1成功（包括已经是目标状态）；0已经是另一个最终状态；-1记录不存在、已过期或不属于这个付款方
+var settleTransferScript = redis.NewScript(`
+local t = redis.call("HMGET", KEYS[1], "status", "sender_id", "amount")
+if not t[1] or t[2] ~= ARGV[2] then
+	return -1
+end
+if t[1] == ARGV[1] then
+	return 1
+end
+if t[1] ~= "pending" then
+	return 0
+end
+redis.call("HSET", KEYS[1], "status", ARGV[1])
+if redis.call("DECRBY", KEYS[2], t[3]) <= 0 then
+	redis.call("DEL", KEYS[2])
+end
+return 1
+`)
+
+func (r *walletRepository) settle(transferID string, fromUserID uint64, status string) (int, error) {
+	keys := []string{r.keyTransfer(transferID), r.keyHold(fromUserID)}
+	return settleTransferScript.Run(context.Background(), r.rdb, keys, status, fromUserID).Int()
+}
+
+func (r *walletRepository) CommitTransfer(transferID string, fromUserID uint64) error {
+	res, err := r.settle(transferID, fromUserID, model.GiftTransferCommitted)
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrTransferRefunded
+	}
+	return nil
+}
+
+func (r *walletRepository) RefundTransfer(transferID string, fromUserID uint64) (bool, error) {
+	res, err := r.settle(transferID, fromUserID, model.GiftTransferRefunded)
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
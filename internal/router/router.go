@@ -8,18 +8,33 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRouter(userHandler handler.UserHandler, videoHandler handler.VideoHandler, likeHandler handler.LikeHandler, commentHandler handler.CommentHandler) *gin.Engine {
+func SetupRouter(jwtSecret string, userHandler handler.UserHandler, videoHandler handler.VideoHandler, likeHandler handler.LikeHandler, commentHandler handler.CommentHandler, liveRoomHandler handler.LiveRoomHandler, danmakuHandler handler.DanmakuHandler, walletHandler handler.WalletHandler, giftHandler handler.GiftHandler, leaderboardHandler handler.LeaderboardHandler, redPacketHandler handler.RedPacketHandler, uploadHandler handler.UploadHandler, mediaHandler handler.MediaHandler, followHandler handler.FollowHandler) *gin.Engine {
 	r := gin.Default()
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "pang",
 		})
 	})
+	// 存储中的文件：封面可以直接访问，视频和HLS要用签名地址；本地存储时storage.local.base_url也指向这里
+	r.GET("/media/*key", mediaHandler.ServeMedia)
+	r.HEAD("/media/*key", mediaHandler.ServeMedia)
 	apiV1 := r.Group("/api/v1")
 	{
 		apiV1.GET("/feed", videoHandler.GetFeed)
-		apiV1.GET("/videos/:video_id", videoHandler.GetVideoByID)
-		apiV1.GET("/videos/:video_id/comments", commentHandler.GetComments)
+		// 不登录也能看；带了token时按视频的可见范围判断作者本人和粉丝
+		optional := middleware.OptionalAuthMiddleware(jwtSecret)
+		apiV1.GET("/videos/:video_id", optional, videoHandler.GetVideoByID)
+		apiV1.GET("/videos/:video_id/comments", optional, commentHandler.GetComments)
+
+		apiV1.GET("/live/rooms", liveRoomHandler.ListLive)
+		apiV1.GET("/live/rooms/:room_id", liveRoomHandler.GetRoom)
+		apiV1.GET("/gifts", giftHandler.ListGifts)
+		// RTMP服务器（nginx-rtmp/SRS）的回调，不走JWT，由live.callback_token保护
+		apiV1.POST("/live/callbacks/on_publish", liveRoomHandler.OnPublish)
+		apiV1.POST("/live/callbacks/on_done", liveRoomHandler.OnDone)
+		apiV1.POST("/live/callbacks/on_record_done", liveRoomHandler.OnRecordDone)
+		// 直播间弹幕的WebSocket，握手时校验JWT，不在authorized组里是因为token可以放在URL上
+		apiV1.GET("/live/:room_id/ws", middleware.WebSocketAuthMiddleware(jwtSecret), danmakuHandler.ServeWS)
 
 		userGroup := apiV1.Group("/users")
 		{
@@ -28,10 +43,22 @@ func SetupRouter(userHandler handler.UserHandler, videoHandler handler.VideoHand
 		}
 
 		authorized := apiV1.Group("/")
-		authorized.Use(middleware.AuthMiddleware())
+		authorized.Use(middleware.AuthMiddleware(jwtSecret))
 		{
 			authorized.GET("/profile", userHandler.GetProfile)
+			// 仅粉丝可见的视频按关注关系判断
+			authorized.POST("/users/:user_id/follow", followHandler.Follow)
+			authorized.DELETE("/users/:user_id/follow", followHandler.Unfollow)
 			authorized.POST("/videos", videoHandler.CreateVideo)
+			// 只有作者可以修改、删除，修改时用If-Match带上读到的ETag
+			authorized.PATCH("/videos/:video_id", videoHandler.UpdateVideo)
+			authorized.DELETE("/videos/:video_id", videoHandler.DeleteVideo)
+			// 先上传视频和封面拿到文件ID，再发布视频；大文件用分片上传
+			authorized.POST("/uploads", uploadHandler.Upload)
+			authorized.POST("/uploads/chunked", uploadHandler.CreateUpload)
+			authorized.GET("/uploads/chunked/:upload_id", uploadHandler.GetUpload)
+			authorized.PUT("/uploads/chunked/:upload_id/chunks/:index", uploadHandler.UploadChunk)
+			authorized.POST("/uploads/chunked/:upload_id/complete", uploadHandler.CompleteUpload)
 
 			authorized.POST("/videos/:video_id/like", likeHandler.LikeVideo)
 			authorized.DELETE("/videos/:video_id/like", likeHandler.UnlikeVideo)
@@ -40,6 +67,26 @@ func SetupRouter(userHandler handler.UserHandler, videoHandler handler.VideoHand
 			authorized.POST("/comments/:comment_id/replies", commentHandler.CreateReplyForComment)
 
 			authorized.POST("/videos/:video_id/golden_comment", commentHandler.CreateGoldenForVideo)
+			authorized.GET("/golden_tickets/:ticket_id", commentHandler.GetGoldenTicket)
+
+			// 主播自己的直播间
+			authorized.POST("/live/room", liveRoomHandler.CreateRoom)
+			authorized.GET("/live/room", liveRoomHandler.GetMyRoom)
+			authorized.POST("/live/room/stream_key", liveRoomHandler.RotateStreamKey)
+			authorized.POST("/live/room/start", liveRoomHandler.StartBroadcast)
+			authorized.POST("/live/room/end", liveRoomHandler.EndBroadcast)
+			authorized.GET("/live/room/stats", liveRoomHandler.GetStats)
+			authorized.POST("/live/room/red_packets", redPacketHandler.SendRedPacket)
+			// 没有连弹幕WebSocket的观众（比如只看不聊）用它上报在线
+			authorized.POST("/live/:room_id/heartbeat", liveRoomHandler.Heartbeat)
+			authorized.POST("/live/:room_id/gifts", giftHandler.SendGift)
+			// 贡献榜，?scope=session|daily|total，带上当前用户自己的名次
+			authorized.GET("/live/:room_id/leaderboard", leaderboardHandler.GetLeaderboard)
+			authorized.POST("/red_packets/:packet_id/grab", redPacketHandler.GrabRedPacket)
+			authorized.GET("/red_packets/:packet_id", redPacketHandler.GetRedPacket)
+
+			authorized.GET("/wallet", walletHandler.GetWallet)
+			authorized.GET("/wallet/ledger", walletHandler.GetLedger)
 		}
 	}
 
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Job 一个定时任务，每隔Interval执行一次Run，返回这一轮处理的条数
+// 可以同时运行多个scheduler实例，Run必须是幂等的，不能假设只有自己在执行
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() (int, error)
+}
+
+// Scheduler 每个任务一个goroutine按各自的间隔执行，一轮没执行完不会开始下一轮
+type Scheduler struct {
+	jobs []Job
+	log  *logrus.Logger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(log *logrus.Logger) *Scheduler {
+	return &Scheduler{log: log}
+}
+
+// Add 注册一个任务，必须在Start之前调用
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+func (s *Scheduler) Start(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.run(runCtx, job)
+	}
+	return nil
+}
+
+// Stop 等待正在执行的一轮结束
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	defer s.wg.Done()
+	logCtx := s.log.WithField("job", job.Name)
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := job.Run()
+			if err != nil {
+				logCtx.WithError(err).Error("定时任务执行失败")
+				continue
+			}
+			if n > 0 {
+				logCtx.WithField("count", n).Info("定时任务执行完成")
+			}
+		}
+	}
+}
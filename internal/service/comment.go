@@ -2,45 +2,69 @@ package service
 
 import (
 	"Orion_Live/internal/data"
+	"Orion_Live/internal/message"
 	"Orion_Live/internal/model"
 	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/cursor"
 	"Orion_Live/pkg/logger"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/streadway/amqp"
 )
 
-const (
-	QueueGoldenComment = "orion.golden_comment.queue"
-)
-
-// GoldenCommentMessage 定义了黄金评论的消息结构
-type GoldenCommentMessage struct {
-	UserID  uint64 `json:"user_id"`
-	VideoID uint64 `json:"video_id"`
-	Content string `json:"content"`
-}
-
 type CommentService interface {
-	// 创建视频的一级评论
+	// 创建视频的一级评论，看不到的视频返回ErrVideoNotFound，评论、回复、黄金评论都一样
 	CreateComment(userID, videoID uint64, content string) (*model.Comment, error)
 	// 创建视频的一级评论
 	CreateReply(userID uint64, parentComment *model.Comment, content string) (*model.Comment, error)
 
-	CreateGoldenComment(userID, videoID uint64, content string) (*model.Comment, error)
-	// 获取一个视频的所有评论
-	GetComments(videoID uint64, page, pageSize int) ([]model.Comment, map[uint64][]*model.Comment, error)
+	// 抢黄金评论席位，返回pending状态的预约票，评论由消费者异步落库
+	CreateGoldenComment(userID, videoID uint64, content string) (*model.GoldenTicket, error)
+	// 查询预约票，committed时一并返回落库的评论
+	GetGoldenTicket(userID uint64, ticketID string) (*model.GoldenTicket, *model.Comment, error)
+	// 分页获取一个视频的一级评论和它们的回复，viewerID为0表示没有登录；cursor为空时从最新的开始，不合法时返回ErrInvalidCursor
+	GetComments(viewerID, videoID uint64, cursor string, limit int) (*CommentPage, error)
 }
 
+// CommentPage 一页一级评论，Replies按一级评论的ID挂载二级评论，HasMore时用NextCursor取下一页
+type CommentPage struct {
+	Comments   []model.Comment
+	Replies    map[uint64][]*model.Comment
+	NextCursor string
+	HasMore    bool
+}
+
+// 评论每页默认和最多多少条一级评论
+const (
+	commentDefaultLimit = 10
+	commentMaxLimit     = 50
+)
+
+// 抢黄金评论席位被拒绝的原因，handler据此返回不同的状态码
+var (
+	ErrGoldenWindowClosed = errors.New("黄金评论已截止")
+	// 草稿和定时发布的视频，发布后才开放
+	ErrGoldenNotOpen   = errors.New("视频还没有发布，黄金评论尚未开放")
+	ErrGoldenSeatTaken = errors.New("您已经在该视频发表过黄金评论")
+	ErrGoldenSeatsFull = errors.New("黄金评论席已满")
+	// 票不存在、已过期，或者不属于当前用户
+	ErrGoldenTicketNotFound = errors.New("预约票不存在")
+)
+
 type commentService struct {
 	commentRepo repository.CommentRepository
 	videoRepo   repository.VideoRepository
 	uow         data.UnitOfWork
 
-	rdb          *redis.Client
-	rabbitMQConn *amqp.Connection
+	rdb        *redis.Client
+	outboxRepo repository.OutboxRepository
+	ticketRepo repository.GoldenTicketRepository
+	goldenCfg  config.GoldenConfig
+	access     videoAccess
+	cursors    *cursor.Codec
 }
 
 type CommentsWithReplies struct {
@@ -48,23 +72,26 @@ type CommentsWithReplies struct {
 	ReplyMap       map[uint64][]*model.Comment
 }
 
-func NewCommentService(commentRepo repository.CommentRepository, videoRepo repository.VideoRepository, uow data.UnitOfWork, rdb *redis.Client, conn *amqp.Connection) CommentService {
-
-	ch, _ := conn.Channel()
-	defer ch.Close()
-	ch.QueueDeclare(QueueGoldenComment, true, false, false, false, nil)
-
+// 黄金评论消息先写入outbox_messages表，由relay进程投递到“orion.golden_comment.queue”
+func NewCommentService(commentRepo repository.CommentRepository, videoRepo repository.VideoRepository, followRepo repository.FollowRepository, uow data.UnitOfWork, rdb *redis.Client, outboxRepo repository.OutboxRepository, ticketRepo repository.GoldenTicketRepository, goldenCfg config.GoldenConfig, cursors *cursor.Codec) CommentService {
 	return &commentService{
-		commentRepo:  commentRepo,
-		videoRepo:    videoRepo,
-		uow:          uow,
-		rdb:          rdb,
-		rabbitMQConn: conn,
+		commentRepo: commentRepo,
+		videoRepo:   videoRepo,
+		uow:         uow,
+		rdb:         rdb,
+		outboxRepo:  outboxRepo,
+		ticketRepo:  ticketRepo,
+		goldenCfg:   goldenCfg,
+		access:      videoAccess{videoRepo: videoRepo, followRepo: followRepo},
+		cursors:     cursors,
 	}
 }
 
-// 创建一级评论：1、创建一级评论 2、利用一级评论的ID查找，Preload出User以及空的ReplyToUser
+// 创建一级评论：1、检查用户能不能看到视频 2、创建一级评论 3、利用一级评论的ID查找，Preload出User以及空的ReplyToUser
 func (s *commentService) CreateComment(userID, videoID uint64, content string) (*model.Comment, error) {
+	if _, err := s.access.find(videoID, userID); err != nil {
+		return nil, err
+	}
 	newComment := &model.Comment{
 		UserID:    userID,
 		VideoID:   videoID,
@@ -85,6 +112,9 @@ func (s *commentService) CreateReply(userID uint64, parentComment *model.Comment
 	if parentComment.ParentID != nil {
 		return nil, errors.New("不能对二级评论进行回复")
 	}
+	if _, err := s.access.find(parentComment.VideoID, userID); err != nil {
+		return nil, err
+	}
 	newReply := &model.Comment{
 		UserID:        userID,
 		VideoID:       parentComment.VideoID,
@@ -101,102 +131,135 @@ func (s *commentService) CreateReply(userID uint64, parentComment *model.Comment
 	return s.commentRepo.FindByID(newReply.ID)
 }
 
-// 创建黄金评论：1、先利用videoID在redis中抢占席位，返回现在的黄金评论数 2、判断席位数，如果超限，则返回归还席位 3、满足则构建消息，并发送消息至rabbitMQ
-func (s *commentService) CreateGoldenComment(userID, videoID uint64, content string) (*model.Comment, error) {
-
-	count, err := s.videoRepo.IncrementGoldenCount_Redis(videoID)
+// 创建黄金评论：1、用户要能看到视频，视频发布后goldenCfg.Window内才开放 2、在Redis中用Lua脚本原子地抢占席位（每人一席、总数不超过Quota）
+// 3、抢到则创建pending状态的预约票 4、构建带票ID的消息，写入outbox等待relay投递，消费者落库后把票改为committed
+func (s *commentService) CreateGoldenComment(userID, videoID uint64, content string) (*model.GoldenTicket, error) {
+	video, err := s.access.find(videoID, userID)
+	if err != nil {
+		return nil, err
+	}
+	// 作者能看到自己没发布的视频，但窗口还没开始
+	if video.PublishStatus != model.PublishPublished {
+		return nil, ErrGoldenNotOpen
+	}
+	ticket := &model.GoldenTicket{
+		ID:        message.NewID(),
+		UserID:    userID,
+		VideoID:   videoID,
+		Status:    model.GoldenTicketPending,
+		CreatedAt: time.Now(),
+	}
+	windowEnd := video.PublishTime().Add(s.goldenCfg.Window)
+	result, err := s.videoRepo.GrabGoldenSeat(videoID, userID, ticket.ID, s.goldenCfg.Quota, windowEnd)
 	if err != nil {
 		return nil, errors.New("系统繁忙，请稍后再试 (Redis错误)")
 	}
-	// 判断抢占结果
-	if count > 100 {
-		// 席位已满，需要执行“补偿”操作，把刚刚多加的那个数减回去
-		_ = s.videoRepo.DecrementGoldenCount_Redis(videoID)
-		return nil, errors.New("黄金评论席已满")
-	}
-	// 发送异步消息到 RabbitMQ
-	msg := GoldenCommentMessage{
-		UserID:  userID,
-		VideoID: videoID,
-		Content: content,
-	}
-	if err := s.publishGoldenCommentMessage(msg); err != nil {
-		// 这是最严重的错误：Redis 已经扣了库存，但消息没发出去
-		// 必须补偿Redis，并记录严重错误日志以供人工排查
-		_ = s.videoRepo.DecrementGoldenCount_Redis(videoID)
-		logger.Log.WithError(err).
-			WithField("user_id", userID).
-			WithField("video_id", videoID).
-			Error("【严重】黄金评论消息投递失败！Redis库存已扣减，需人工核对！")
+	// 判断抢占结果，被拒绝时脚本没有写入任何数据，不需要补偿
+	switch result {
+	case repository.GoldenSeatWindowClosed:
+		return nil, ErrGoldenWindowClosed
+	case repository.GoldenSeatAlreadyTaken:
+		return nil, ErrGoldenSeatTaken
+	case repository.GoldenSeatFull:
+		return nil, ErrGoldenSeatsFull
+	}
+
+	logCtx := logger.Log.WithField("user_id", userID).WithField("video_id", videoID).WithField("ticket_id", ticket.ID)
+	if err := s.ticketRepo.Create(ticket); err != nil {
+		_, _ = s.videoRepo.ReleaseGoldenSeat(videoID, userID, ticket.ID)
+		logCtx.WithError(err).Error("创建黄金评论预约票失败，Redis席位已归还")
 		return nil, errors.New("系统错误，评论失败")
 	}
 
-	// 发送成功！返回一个临时Comment对象给前端，用于“乐观UI”
-	tempComment := &model.Comment{
+	// 写入outbox，relay会保证消息“至少一次”到达消费者
+	msg := message.GoldenCommentMessage{
+		TicketID: ticket.ID,
 		UserID:   userID,
 		VideoID:  videoID,
 		Content:  content,
-		IsGolden: true,
 	}
-
-	return tempComment, nil
+	if err := s.saveGoldenCommentMessage(msg); err != nil {
+		// outbox没写进去，这条评论不会落库，把席位还回去，票直接走到refunded
+		_, _ = s.videoRepo.ReleaseGoldenSeat(videoID, userID, ticket.ID)
+		_ = s.ticketRepo.MarkFailed(ticket.ID, "消息写入失败")
+		_ = s.ticketRepo.MarkRefunded(ticket.ID)
+		logCtx.WithError(err).Error("黄金评论消息写入outbox失败，Redis席位已归还")
+		return nil, errors.New("系统错误，评论失败")
+	}
+	return ticket, nil
 }
 
-// (私有方法) publishGoldenCommentMessage - 类似 LikeService 的实现
-func (s *commentService) publishGoldenCommentMessage(msg GoldenCommentMessage) error {
-	ch, err := s.rabbitMQConn.Channel()
+// 查询预约票：1、票不存在或不属于当前用户都返回ErrGoldenTicketNotFound，不暴露别人的票 2、committed时查出落库的评论
+func (s *commentService) GetGoldenTicket(userID uint64, ticketID string) (*model.GoldenTicket, *model.Comment, error) {
+	ticket, err := s.ticketRepo.Get(ticketID)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if ticket == nil || ticket.UserID != userID {
+		return nil, nil, ErrGoldenTicketNotFound
+	}
+	if ticket.Status != model.GoldenTicketCommitted {
+		return ticket, nil, nil
+	}
+	comment, err := s.commentRepo.FindByID(ticket.CommentID)
+	if err != nil {
+		return nil, nil, err
 	}
-	defer ch.Close()
+	return ticket, comment, nil
+}
 
-	body, err := json.Marshal(msg)
+// (私有方法) saveGoldenCommentMessage - 类似 LikeService 的实现，序列化后写入outbox
+func (s *commentService) saveGoldenCommentMessage(msg message.GoldenCommentMessage) error {
+	outboxMsg, err := message.NewOutbox(message.QueueGoldenComment, msg)
 	if err != nil {
 		return err
 	}
-
-	return ch.Publish(
-		"",                 // exchange
-		QueueGoldenComment, // routing key
-		false,              // mandatory
-		false,              // immediate
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         body,
-			DeliveryMode: amqp.Persistent, // 确保消息持久化
-		})
+	return s.outboxRepo.Create(outboxMsg)
 }
 
-// 获取视频的评论列表：1、计算分页参数 2、根据videoID查询一级评论 3、根据一级评论的ID切片查询所有二级评论 4、将二级评论挂载（map）到一级评论下并返回CommentsWithReplies结构体
-func (s *commentService) GetComments(videoID uint64, page, pageSize int) ([]model.Comment, map[uint64][]*model.Comment, error) {
-	// pageSize：每页大小。page:当前页码。offset: “跳过” 多少条记录，再开始取数据。
-	offset := (page - 1) * pageSize
+// 获取视频的评论列表：1、检查能不能看到视频，解出游标 2、根据videoID查询一级评论，多查一条判断有没有下一页 3、根据一级评论的ID切片查询所有二级评论
+// 4、将二级评论挂载（map）到一级评论下，用这一页最后一条的(创建时间, ID)生成下一页的游标
+func (s *commentService) GetComments(viewerID, videoID uint64, cursorToken string, limit int) (*CommentPage, error) {
+	if _, err := s.access.find(videoID, viewerID); err != nil {
+		return nil, err
+	}
+	// 每个视频的评论是一个单独的列表，游标不能拿到别的视频上用
+	scope := fmt.Sprintf("comments:%d", videoID)
+	limit = pageLimit(limit, commentDefaultLimit, commentMaxLimit)
+	after, err := decodeCursor(s.cursors, scope, cursorToken)
+	if err != nil {
+		return nil, err
+	}
 	// 查询一级评论
-	parentComments, err := s.commentRepo.GetCommentsByVideoID(videoID, offset, pageSize)
+	parentComments, err := s.commentRepo.GetCommentsByVideoID(videoID, after, limit+1)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	if len(parentComments) == 0 {
-		return nil, nil, nil // 如果没有一级评论，直接返回空列表
+	page := &CommentPage{Replies: map[uint64][]*model.Comment{}}
+	page.Comments, page.HasMore = trimPage(parentComments, limit)
+	if len(page.Comments) == 0 {
+		return page, nil // 如果没有一级评论，直接返回空列表
+	}
+	if page.HasMore {
+		last := page.Comments[len(page.Comments)-1]
+		page.NextCursor = s.cursors.Encode(scope, cursor.Cursor{Time: last.CreatedAt, ID: last.ID})
 	}
 	// 创建切片，将每个一级评论的ID放入，方便二级评论查询
-	parentIDs := make([]uint64, 0, len(parentComments))
-	for _, pc := range parentComments {
+	parentIDs := make([]uint64, 0, len(page.Comments))
+	for _, pc := range page.Comments {
 		parentIDs = append(parentIDs, pc.ID)
 	}
 	// 一次性查询所有相关的二级评论
 	replies, err := s.commentRepo.GetRepliesByParentIDs(parentIDs)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	// 在内存中进行数据编排，将二级评论挂载到对应的一级评论上
-	replyMap := make(map[uint64][]*model.Comment)
 	for i := range replies {
 		reply := replies[i]
 		if reply.ParentID != nil {
-			replyMap[*reply.ParentID] = append(replyMap[*reply.ParentID], &reply)
+			page.Replies[*reply.ParentID] = append(page.Replies[*reply.ParentID], &reply)
 		}
 	}
-	// 将打包结果返回
-	return parentComments, replyMap, nil
+	return page, nil
 }
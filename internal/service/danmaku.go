@@ -0,0 +1,123 @@
+package service
+
+import (
+	"Orion_Live/internal/live"
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DanmakuService 直播间弹幕：校验后通过Redis Pub/Sub实时推送，同时放入批次异步落库
+type DanmakuService interface {
+	Send(ctx context.Context, roomID, userID uint64, username, content string) error
+}
+
+var (
+	ErrDanmakuEmpty   = errors.New("弹幕内容不能为空")
+	ErrDanmakuTooLong = errors.New("弹幕内容过长")
+	ErrRoomNotLive    = errors.New("直播间未开播")
+)
+
+// DanmakuEvent 推送给直播间观众的弹幕
+type DanmakuEvent struct {
+	RoomID   uint64 `json:"room_id"`
+	UserID   uint64 `json:"user_id"`
+	Username string `json:"username"`
+	Content  string `json:"content"`
+	SentAt   int64  `json:"sent_at"` // 毫秒时间戳
+}
+
+// RoomBroadcaster 向直播间的所有观众推送事件，*live.Hub满足这个接口
+type RoomBroadcaster interface {
+	Publish(ctx context.Context, roomID uint64, event live.Event) error
+}
+
+// DanmakuQueue 弹幕的落库队列，*live.DanmakuBatcher满足这个接口
+type DanmakuQueue interface {
+	Add(item message.DanmakuMessage) bool
+}
+
+type danmakuService struct {
+	roomRepo    repository.LiveRoomRepository
+	broadcaster RoomBroadcaster
+	queue       DanmakuQueue
+	cfg         config.DanmakuConfig
+}
+
+func NewDanmakuService(roomRepo repository.LiveRoomRepository, broadcaster RoomBroadcaster, queue DanmakuQueue, cfg config.DanmakuConfig) DanmakuService {
+	return &danmakuService{
+		roomRepo:    roomRepo,
+		broadcaster: broadcaster,
+		queue:       queue,
+		cfg:         cfg,
+	}
+}
+
+// NewDanmakuOutboxWriter 返回DanmakuBatcher的flush函数：一批弹幕写成一条outbox消息，由relay投递给消费者落库
+func NewDanmakuOutboxWriter(outboxRepo repository.OutboxRepository) func(items []message.DanmakuMessage) error {
+	return func(items []message.DanmakuMessage) error {
+		msg, err := message.NewOutbox(message.QueueDanmaku, message.DanmakuBatchMessage{Items: items})
+		if err != nil {
+			return err
+		}
+		return outboxRepo.Create(msg)
+	}
+}
+
+// 发送弹幕：1、清理并校验内容 2、只有直播中的房间可以发，弹幕挂在当前这场直播上 3、发布到直播间频道 4、放入落库队列
+func (s *danmakuService) Send(ctx context.Context, roomID, userID uint64, username, content string) error {
+	content, err := s.sanitize(content)
+	if err != nil {
+		return err
+	}
+	sessionID, err := s.roomRepo.CurrentSessionID(roomID)
+	if err != nil {
+		return err
+	}
+	if sessionID == 0 {
+		return ErrRoomNotLive
+	}
+	now := time.Now()
+	event := DanmakuEvent{
+		RoomID:   roomID,
+		UserID:   userID,
+		Username: username,
+		Content:  content,
+		SentAt:   now.UnixMilli(),
+	}
+	if err := s.broadcaster.Publish(ctx, roomID, live.Event{Type: live.EventDanmaku, Data: event}); err != nil {
+		return err
+	}
+	// 落库队列满了只丢弃这条的回放，观众已经看到了
+	s.queue.Add(message.DanmakuMessage{
+		SessionID: sessionID,
+		RoomID:    roomID,
+		UserID:    userID,
+		Content:   content,
+		SentAt:    event.SentAt,
+	})
+	return nil
+}
+
+// sanitize 去掉首尾空白和控制字符（换行等），按字符数而不是字节数限制长度
+func (s *danmakuService) sanitize(content string) (string, error) {
+	content = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, content))
+	if content == "" {
+		return "", ErrDanmakuEmpty
+	}
+	if utf8.RuneCountInString(content) > s.cfg.MaxLength {
+		return "", ErrDanmakuTooLong
+	}
+	return content, nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"Orion_Live/internal/repository"
+	"errors"
+)
+
+// FollowService 关注和取消关注，目前只用来判断仅粉丝可见的视频
+type FollowService interface {
+	// Follow 重复关注是安全的
+	Follow(followerID, followeeID uint64) error
+	// Unfollow 没关注过也返回nil
+	Unfollow(followerID, followeeID uint64) error
+}
+
+var (
+	ErrUserNotFound = errors.New("用户不存在")
+	ErrFollowSelf   = errors.New("不能关注自己")
+)
+
+type followService struct {
+	followRepo repository.FollowRepository
+	userRepo   repository.UserRepository
+}
+
+func NewFollowService(followRepo repository.FollowRepository, userRepo repository.UserRepository) FollowService {
+	return &followService{followRepo: followRepo, userRepo: userRepo}
+}
+
+// 关注：1、不能关注自己 2、被关注的用户必须存在 3、写入关注关系，唯一索引去重
+func (s *followService) Follow(followerID, followeeID uint64) error {
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+	users, err := s.userRepo.FindByIDs([]uint64{followeeID})
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return ErrUserNotFound
+	}
+	_, err = s.followRepo.Follow(followerID, followeeID)
+	return err
+}
+
+func (s *followService) Unfollow(followerID, followeeID uint64) error {
+	_, err := s.followRepo.Unfollow(followerID, followeeID)
+	return err
+}
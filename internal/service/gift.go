@@ -0,0 +1,146 @@
+package service
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GiftService 直播间送礼：Redis预扣金币后写入outbox，消费者在MySQL中写流水，落库后再推送到直播间
+type GiftService interface {
+	// Send 送礼，requestID由客户端生成，同一个requestID重复提交只扣一次款
+	Send(userID uint64, username string, roomID, giftID uint64, count int, requestID string) (*GiftReceipt, error)
+	// Catalog 礼物价目表
+	Catalog() []config.GiftItem
+}
+
+// GiftReceipt 送礼受理结果，礼物出现在直播间才说明已经落库
+type GiftReceipt struct {
+	TransferID string
+	Amount     int64
+	// 预扣之后的可用余额
+	Balance int64
+	// 同一个requestID已经提交过，这次没有再扣款
+	Duplicate bool
+	// 预扣记录的状态，重复提交时可能已经是committed
+	Status string
+}
+
+var (
+	ErrGiftNotFound     = errors.New("礼物不存在")
+	ErrGiftCountInvalid = errors.New("礼物数量不合法")
+	ErrGiftToSelf       = errors.New("不能给自己送礼物")
+	ErrRequestIDInvalid = errors.New("request_id 不能为空且不能超过64个字符")
+	// 同一个requestID之前的提交已经失败并退回，客户端需要换一个requestID重新送
+	ErrGiftTransferRefunded = errors.New("这次送礼已失败，金币已退回")
+)
+
+type giftService struct {
+	roomRepo   repository.LiveRoomRepository
+	walletRepo repository.WalletRepository
+	outboxRepo repository.OutboxRepository
+	giftCfg    config.GiftConfig
+}
+
+func NewGiftService(roomRepo repository.LiveRoomRepository, walletRepo repository.WalletRepository, outboxRepo repository.OutboxRepository, giftCfg config.GiftConfig) GiftService {
+	return &giftService{
+		roomRepo:   roomRepo,
+		walletRepo: walletRepo,
+		outboxRepo: outboxRepo,
+		giftCfg:    giftCfg,
+	}
+}
+
+func (s *giftService) Catalog() []config.GiftItem {
+	return s.giftCfg.Items
+}
+
+// 送礼：1、校验礼物、数量、直播间（必须在直播，不能送给自己） 2、在Redis中用Lua脚本原子地检查可用余额并冻结金币
+// 3、写入outbox，由消费者在MySQL中SELECT ... FOR UPDATE检查余额、写双方的流水，落库后解冻 4、outbox写入失败则立即解冻
+func (s *giftService) Send(userID uint64, username string, roomID, giftID uint64, count int, requestID string) (*GiftReceipt, error) {
+	if requestID == "" || len(requestID) > 64 {
+		return nil, ErrRequestIDInvalid
+	}
+	gift, ok := s.giftCfg.Find(giftID)
+	if !ok {
+		return nil, ErrGiftNotFound
+	}
+	if count <= 0 || count > s.giftCfg.MaxCount {
+		return nil, ErrGiftCountInvalid
+	}
+	room, err := s.roomRepo.FindByID(roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLiveRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if room.Status != model.LiveRoomLive {
+		return nil, ErrRoomNotLive
+	}
+	if room.StreamerID == userID {
+		return nil, ErrGiftToSelf
+	}
+
+	// 转账ID由用户和request_id决定，客户端超时重试时得到同一个ID
+	transferID := fmt.Sprintf("gift:%d:%s", userID, requestID)
+	amount := gift.Price * int64(count)
+	result, balance, err := s.walletRepo.PreDeduct(userID, transferID, amount, s.giftCfg.TransferTTL)
+	if err != nil {
+		return nil, err
+	}
+	receipt := &GiftReceipt{TransferID: transferID, Amount: amount, Balance: balance, Status: model.GiftTransferPending}
+	switch result {
+	case repository.PreDeductDuplicate:
+		transfer, err := s.walletRepo.GetTransfer(transferID)
+		if err != nil {
+			return nil, err
+		}
+		if transfer != nil {
+			if transfer.Status == model.GiftTransferRefunded {
+				return nil, ErrGiftTransferRefunded
+			}
+			receipt.Amount, receipt.Status = transfer.Amount, transfer.Status
+		}
+		receipt.Duplicate = true
+		return receipt, nil
+	case repository.PreDeductInsufficient:
+		return nil, ErrInsufficientBalance
+	}
+
+	logCtx := logger.Log.WithField("transfer_id", transferID).WithField("room_id", roomID).WithField("amount", amount)
+	msg := message.GiftMessage{
+		TransferID: transferID,
+		SenderID:   userID,
+		SenderName: username,
+		StreamerID: room.StreamerID,
+		RoomID:     room.ID,
+		SessionID:  room.SessionID,
+		GiftID:     gift.ID,
+		GiftName:   gift.Name,
+		Count:      count,
+		Amount:     amount,
+		SentAt:     time.Now().UnixMilli(),
+	}
+	outboxMsg, err := message.NewOutbox(message.QueueGift, msg)
+	if err == nil {
+		err = s.outboxRepo.Create(outboxMsg)
+	}
+	if err != nil {
+		// outbox没写进去，这次送礼不会落库，立即解冻；记录停在refunded，同一个requestID重试会被当作重复
+		if _, refundErr := s.walletRepo.RefundTransfer(transferID, userID); refundErr != nil {
+			logCtx.WithError(refundErr).Error("解冻预扣的金币失败")
+		}
+		logCtx.WithError(err).Error("送礼消息写入outbox失败，预扣的金币已解冻")
+		return nil, errors.New("系统错误，送礼失败")
+	}
+	logCtx.Info("送礼已受理")
+	return receipt, nil
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"fmt"
+)
+
+// GiftReleaseService 送礼落库失败后解冻Redis中预扣的金币，消费者的死信回调和relay投递失败共用
+type GiftReleaseService interface {
+	// Release 预扣记录pending → refunded并解冻；流水已经落库（committed）或记录已过期时什么都不做，重复调用是安全的
+	Release(transferID string, senderID uint64, reason string) error
+}
+
+type giftReleaseService struct {
+	walletRepo repository.WalletRepository
+}
+
+func NewGiftReleaseService(walletRepo repository.WalletRepository) GiftReleaseService {
+	return &giftReleaseService{walletRepo: walletRepo}
+}
+
+func (s *giftReleaseService) Release(transferID string, senderID uint64, reason string) error {
+	logCtx := logger.Log.WithField("transfer_id", transferID).WithField("sender_id", senderID)
+	refunded, err := s.walletRepo.RefundTransfer(transferID, senderID)
+	if err != nil {
+		return fmt.Errorf("解冻预扣的金币失败: %w", err)
+	}
+	if !refunded {
+		logCtx.Info("预扣记录已落库或已过期，无需解冻")
+		return nil
+	}
+	logCtx.WithField("reason", reason).Warn("送礼落库失败，预扣的金币已解冻")
+	return nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"fmt"
+)
+
+// GoldenRefundService 黄金评论落库失败后归还席位，消费者的死信回调、relay投递失败和对账任务共用
+type GoldenRefundService interface {
+	// Refund 1、预约票pending → failed 2、席位仍属于这张票时归还 3、failed → refunded
+	// 票已经committed（比如死信被重放后落库成功）时什么都不做；重复调用是安全的，中途失败可以整体重试
+	Refund(ticketID string, videoID, userID uint64, reason string) error
+}
+
+type goldenRefundService struct {
+	videoRepo  repository.VideoRepository
+	ticketRepo repository.GoldenTicketRepository
+}
+
+func NewGoldenRefundService(videoRepo repository.VideoRepository, ticketRepo repository.GoldenTicketRepository) GoldenRefundService {
+	return &goldenRefundService{videoRepo: videoRepo, ticketRepo: ticketRepo}
+}
+
+func (s *goldenRefundService) Refund(ticketID string, videoID, userID uint64, reason string) error {
+	logCtx := logger.Log.WithField("ticket_id", ticketID).WithField("video_id", videoID).WithField("user_id", userID)
+	if err := s.ticketRepo.MarkFailed(ticketID, reason); err != nil {
+		if !errors.Is(err, repository.ErrTicketTransition) {
+			return fmt.Errorf("标记预约票失败状态出错: %w", err)
+		}
+		// 票已经committed或refunded，评论已落库或席位已归还过
+		logCtx.Info("预约票已是最终状态，无需归还席位")
+		return nil
+	}
+	released, err := s.videoRepo.ReleaseGoldenSeat(videoID, userID, ticketID)
+	if err != nil {
+		return fmt.Errorf("归还黄金评论席位失败: %w", err)
+	}
+	if err := s.ticketRepo.MarkRefunded(ticketID); err != nil {
+		return fmt.Errorf("标记预约票已退还出错: %w", err)
+	}
+	logCtx.WithField("released", released).WithField("reason", reason).Warn("黄金评论落库失败，席位已归还")
+	return nil
+}
@@ -0,0 +1,379 @@
+package service
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LeaderboardService 直播间的送礼贡献榜：礼物事件的消费者实时累加，下播时把本场榜单写入MySQL快照，丢失时按送礼记录重建
+type LeaderboardService interface {
+	// Ranking 分页获取直播间的贡献榜，带上当前用户自己的名次
+	Ranking(q LeaderboardQuery) (*Leaderboard, error)
+	// RecordGift 礼物落库后计入贡献榜；礼物事件晚于下播到达时，顺带重新写入那场直播的快照
+	RecordGift(msg message.GiftMessage) error
+	// RevokeGift 送礼退款后从贡献榜中减去
+	RevokeGift(record *model.GiftRecord) error
+	// Rebuild 按送礼记录重建直播间的累计榜、保留期内的每日榜、正在进行的本场榜，以及最近几场直播的快照
+	Rebuild(roomID uint64) (*LeaderboardRebuildResult, error)
+}
+
+var (
+	ErrLeaderboardScopeInvalid = errors.New("无效的榜单范围，可选session、daily、total")
+	ErrLeaderboardDateInvalid  = errors.New("无效的日期，只能查询保留期内的每日榜")
+	ErrLiveSessionNotFound     = errors.New("直播场次不存在")
+)
+
+// 每日榜的date参数格式
+const leaderboardDateLayout = "2006-01-02"
+
+// LeaderboardQuery 查询哪个榜单
+type LeaderboardQuery struct {
+	RoomID uint64
+	Scope  string
+	// Scope为session时有效，0表示正在进行的这一场，没在直播时是最近的一场
+	SessionID uint64
+	// Scope为daily时有效，格式为2006-01-02，空表示今天
+	Date string
+	// 当前用户，同时返回这个用户自己的名次
+	UserID   uint64
+	Page     int
+	PageSize int
+}
+
+// LeaderboardRank 榜单上的一个名次，Rank为0表示不在榜上
+type LeaderboardRank struct {
+	Rank     int64
+	UserID   uint64
+	Username string
+	Amount   int64
+}
+
+// Leaderboard 榜单的一页
+type Leaderboard struct {
+	Scope     string
+	RoomID    uint64
+	SessionID uint64
+	Date      string
+	// 上榜人数
+	Total   int64
+	Entries []LeaderboardRank
+	Mine    LeaderboardRank
+	// 已经结束的场次从MySQL快照读，只有前snapshot_size名
+	Snapshot bool
+}
+
+// LeaderboardRebuildResult 一次重建的结果
+type LeaderboardRebuildResult struct {
+	// 累计榜的人数
+	Contributors int
+	Days         int
+	Sessions     int
+	// 标记为已计入、不会再被礼物事件累加的送礼数
+	MarkedGifts int
+}
+
+type leaderboardService struct {
+	roomRepo        repository.LiveRoomRepository
+	giftRepo        repository.GiftRepository
+	leaderboardRepo repository.LeaderboardRepository
+	userRepo        repository.UserRepository
+	cfg             config.LeaderboardConfig
+}
+
+func NewLeaderboardService(roomRepo repository.LiveRoomRepository, giftRepo repository.GiftRepository, leaderboardRepo repository.LeaderboardRepository, userRepo repository.UserRepository, cfg config.LeaderboardConfig) LeaderboardService {
+	return &leaderboardService{
+		roomRepo:        roomRepo,
+		giftRepo:        giftRepo,
+		leaderboardRepo: leaderboardRepo,
+		userRepo:        userRepo,
+		cfg:             cfg,
+	}
+}
+
+// dailyTTL 每日榜在Redis中的保留时间，每次送礼续期
+func (s *leaderboardService) dailyTTL() time.Duration {
+	return time.Duration(s.cfg.DailyRetentionDays) * 24 * time.Hour
+}
+
+// startOfDay 服务器时区中t所在那天的0点
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// 查询榜单：1、确认直播间存在 2、按范围找到榜单：累计榜和每日榜在Redis；本场榜正在直播时在Redis，下播后读MySQL快照
+// 3、取这一页和当前用户的名次 4、补上用户名
+func (s *leaderboardService) Ranking(q LeaderboardQuery) (*Leaderboard, error) {
+	room, err := s.roomRepo.FindByID(q.RoomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLiveRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.PageSize <= 0 || q.PageSize > 100 {
+		q.PageSize = 20
+	}
+	offset := (q.Page - 1) * q.PageSize
+	board := &Leaderboard{Scope: q.Scope, RoomID: room.ID}
+
+	var key string
+	switch q.Scope {
+	case model.LeaderboardTotal:
+		key = repository.TotalLeaderboard(room.ID)
+	case model.LeaderboardDaily:
+		day, err := s.parseDay(q.Date)
+		if err != nil {
+			return nil, err
+		}
+		board.Date = day.Format(leaderboardDateLayout)
+		key = repository.DailyLeaderboard(room.ID, day)
+	case model.LeaderboardSession:
+		session, err := s.findSession(room, q.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		board.SessionID = session.ID
+		if session.EndedAt == nil {
+			key = repository.SessionLeaderboard(session.ID)
+		} else {
+			board.Snapshot = true
+		}
+	default:
+		return nil, ErrLeaderboardScopeInvalid
+	}
+
+	var entries []repository.LeaderboardEntry
+	var mine repository.LeaderboardEntry
+	if board.Snapshot {
+		if entries, board.Total, err = s.leaderboardRepo.ListSnapshot(board.SessionID, offset, q.PageSize); err != nil {
+			return nil, err
+		}
+		mine, err = s.leaderboardRepo.SnapshotRank(board.SessionID, q.UserID)
+	} else {
+		if entries, board.Total, err = s.leaderboardRepo.Top(key, offset, q.PageSize); err != nil {
+			return nil, err
+		}
+		mine, err = s.leaderboardRepo.Rank(key, q.UserID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := s.usernames(append(entries, mine))
+	if err != nil {
+		return nil, err
+	}
+	board.Entries = make([]LeaderboardRank, 0, len(entries))
+	for _, e := range entries {
+		board.Entries = append(board.Entries, LeaderboardRank{Rank: e.Rank, UserID: e.UserID, Username: names[e.UserID], Amount: e.Amount})
+	}
+	board.Mine = LeaderboardRank{Rank: mine.Rank, UserID: mine.UserID, Username: names[mine.UserID], Amount: mine.Amount}
+	return board, nil
+}
+
+// parseDay 解析每日榜的日期，只接受保留期内的日期
+func (s *leaderboardService) parseDay(date string) (time.Time, error) {
+	today := startOfDay(time.Now())
+	if date == "" {
+		return today, nil
+	}
+	day, err := time.ParseInLocation(leaderboardDateLayout, date, time.Local)
+	if err != nil {
+		return time.Time{}, ErrLeaderboardDateInvalid
+	}
+	if day.After(today) || day.Before(today.AddDate(0, 0, 1-s.cfg.DailyRetentionDays)) {
+		return time.Time{}, ErrLeaderboardDateInvalid
+	}
+	return day, nil
+}
+
+// findSession sessionID为0时取正在进行的这一场，没在直播时取最近的一场；指定的场次必须属于这个直播间
+func (s *leaderboardService) findSession(room *model.LiveRoom, sessionID uint64) (*model.LiveSession, error) {
+	if sessionID == 0 {
+		if room.Status == model.LiveRoomLive && room.SessionID != 0 {
+			sessionID = room.SessionID
+		} else {
+			sessions, err := s.roomRepo.ListSessions(room.ID, 1)
+			if err != nil {
+				return nil, err
+			}
+			if len(sessions) == 0 {
+				return nil, ErrLiveSessionNotFound
+			}
+			return &sessions[0], nil
+		}
+	}
+	session, err := s.roomRepo.FindSessionByID(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && session.RoomID != room.ID) {
+		return nil, ErrLiveSessionNotFound
+	}
+	return session, err
+}
+
+func (s *leaderboardService) usernames(entries []repository.LeaderboardEntry) (map[uint64]string, error) {
+	ids := make([]uint64, 0, len(entries))
+	for _, e := range entries {
+		if e.UserID != 0 {
+			ids = append(ids, e.UserID)
+		}
+	}
+	users, err := s.userRepo.FindByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uint64]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Username
+	}
+	return names, nil
+}
+
+func (s *leaderboardService) RecordGift(msg message.GiftMessage) error {
+	counted, err := s.leaderboardRepo.AddGift(repository.LeaderboardGift{
+		TransferID: msg.TransferID,
+		SenderID:   msg.SenderID,
+		RoomID:     msg.RoomID,
+		SessionID:  msg.SessionID,
+		Amount:     msg.Amount,
+		SentAt:     time.UnixMilli(msg.SentAt),
+	}, s.dailyTTL())
+	if err != nil {
+		return err
+	}
+	if !counted {
+		logger.Log.WithField("transfer_id", msg.TransferID).Info("这次送礼已经计入过贡献榜")
+	}
+	// 重复消费时也检查一次：上次可能计入成功但重新快照失败了
+	return s.resnapshotIfEnded(msg.RoomID, msg.SessionID)
+}
+
+func (s *leaderboardService) RevokeGift(record *model.GiftRecord) error {
+	revoked, err := s.leaderboardRepo.RevokeGift(repository.LeaderboardGift{
+		TransferID: record.TransferID,
+		SenderID:   record.SenderID,
+		RoomID:     record.RoomID,
+		SessionID:  record.SessionID,
+		Amount:     record.Amount,
+		SentAt:     record.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		logger.Log.WithField("transfer_id", record.TransferID).Info("这次送礼已经从贡献榜中减去过")
+	}
+	return s.resnapshotIfEnded(record.RoomID, record.SessionID)
+}
+
+// resnapshotIfEnded 这场直播已经下播时，下播那一刻的快照已经过时了，按送礼记录重新写一次
+// 不用Redis中的本场榜单：它在下播48小时后就过期了，而退款可能发生在很久之后
+func (s *leaderboardService) resnapshotIfEnded(roomID, sessionID uint64) error {
+	current, err := s.roomRepo.CurrentSessionID(roomID)
+	if err != nil {
+		return err
+	}
+	if current == sessionID {
+		return nil
+	}
+	n, err := s.snapshotFromRecords(roomID, sessionID)
+	if err != nil {
+		return err
+	}
+	logger.Log.WithField("room_id", roomID).WithField("session_id", sessionID).WithField("count", n).Info("下播后贡献有变化，已重新写入本场贡献榜快照")
+	return nil
+}
+
+// snapshotFromRecords 按送礼记录汇总一场直播的贡献，前snapshot_size名写入快照
+func (s *leaderboardService) snapshotFromRecords(roomID, sessionID uint64) (int, error) {
+	entries, err := s.giftRepo.SumBySender(repository.GiftFilter{RoomID: roomID, SessionID: sessionID})
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) > s.cfg.SnapshotSize {
+		entries = entries[:s.cfg.SnapshotSize]
+	}
+	return len(entries), s.leaderboardRepo.SaveSnapshot(roomID, sessionID, entries)
+}
+
+// 重建：1、把保留期内的送礼标记为已计入，还在路上的礼物事件不会再加一次 2、累计榜按全部送礼记录汇总
+// 3、每日榜按保留期内的每一天汇总 4、最近的场次：正在进行的写回Redis，已经结束的重写快照
+// 重建过程中落库的送礼可能被多算或少算一次，在送礼少的时段执行，或者执行两次
+func (s *leaderboardService) Rebuild(roomID uint64) (*LeaderboardRebuildResult, error) {
+	if _, err := s.roomRepo.FindByID(roomID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrLiveRoomNotFound
+		}
+		return nil, err
+	}
+	now := time.Now()
+	result := &LeaderboardRebuildResult{}
+
+	transferIDs, err := s.giftRepo.ListTransferIDsSince(roomID, now.Add(-repository.LeaderboardCountedTTL))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.leaderboardRepo.MarkCounted(transferIDs); err != nil {
+		return nil, err
+	}
+	result.MarkedGifts = len(transferIDs)
+
+	total, err := s.giftRepo.SumBySender(repository.GiftFilter{RoomID: roomID})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.leaderboardRepo.Replace(repository.TotalLeaderboard(roomID), total, 0); err != nil {
+		return nil, err
+	}
+	result.Contributors = len(total)
+
+	today := startOfDay(now)
+	for i := 0; i < s.cfg.DailyRetentionDays; i++ {
+		day := today.AddDate(0, 0, -i)
+		entries, err := s.giftRepo.SumBySender(repository.GiftFilter{RoomID: roomID, Since: day, Until: day.AddDate(0, 0, 1)})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.leaderboardRepo.Replace(repository.DailyLeaderboard(roomID, day), entries, s.dailyTTL()); err != nil {
+			return nil, err
+		}
+		result.Days++
+	}
+
+	sessions, err := s.roomRepo.ListSessions(roomID, recentSessionsLimit)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.EndedAt != nil {
+			if _, err := s.snapshotFromRecords(roomID, session.ID); err != nil {
+				return nil, err
+			}
+		} else {
+			entries, err := s.giftRepo.SumBySender(repository.GiftFilter{RoomID: roomID, SessionID: session.ID})
+			if err != nil {
+				return nil, err
+			}
+			if err := s.leaderboardRepo.Replace(repository.SessionLeaderboard(session.ID), entries, repository.LeaderboardSessionTTL); err != nil {
+				return nil, err
+			}
+		}
+		result.Sessions++
+	}
+	logger.Log.WithField("room_id", roomID).
+		WithField("contributors", result.Contributors).
+		WithField("days", result.Days).
+		WithField("sessions", result.Sessions).
+		Warn("直播间贡献榜已按送礼记录重建")
+	return result, nil
+}
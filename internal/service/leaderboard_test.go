@@ -0,0 +1,31 @@
+package service
+
+import (
+	"Orion_Live/pkg/config"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeaderboardParseDay(t *testing.T) {
+	s := &leaderboardService{cfg: config.LeaderboardConfig{DailyRetentionDays: 7}}
+	today := startOfDay(time.Now())
+
+	day, err := s.parseDay("")
+	if err != nil || !day.Equal(today) {
+		t.Fatalf("空日期应为今天, got %v, %v", day, err)
+	}
+	oldest := today.AddDate(0, 0, -6).Format(leaderboardDateLayout)
+	if _, err := s.parseDay(oldest); err != nil {
+		t.Errorf("保留期内最早的一天 %s 应该可以查询: %v", oldest, err)
+	}
+	for _, date := range []string{
+		today.AddDate(0, 0, -7).Format(leaderboardDateLayout),
+		today.AddDate(0, 0, 1).Format(leaderboardDateLayout),
+		"2026/10/16",
+	} {
+		if _, err := s.parseDay(date); !errors.Is(err, ErrLeaderboardDateInvalid) {
+			t.Errorf("%q 应返回ErrLeaderboardDateInvalid, got %v", date, err)
+		}
+	}
+}
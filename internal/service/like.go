@@ -1,75 +1,40 @@
 package service
 
 import (
+	"Orion_Live/internal/message"
 	"Orion_Live/internal/repository"
-	"encoding/json"
+	"Orion_Live/pkg/logger"
 	"errors"
 
-	"github.com/streadway/amqp"
 	"gorm.io/gorm"
 )
 
-const (
-	// 遵循：项目名.业务领域.实体/功能
-	QueueLike    = "orion.like.queue" // 定义队列名称
-	ActionLike   = "like"
-	ActionUnlike = "unlike"
-)
-
-// LikeMessage 定义了我们要在MQ中传递的消息结构
-type LikeMessage struct {
-	UserID  uint64 `json:"user_id"`
-	VideoID uint64 `json:"video_id"`
-	Action  string `json:"action"` // "like" or "unlike"
-}
-
 // redis + mq
 type LikeService interface {
 	LikeVideo(userID, videoID uint64) error
 	UnlikeVideo(userID, videoID uint64) error
 }
 
-// videoRepo用于redis查重+redis插入，rabbitMQConn用于持久化（mysql）
+// videoRepo用于redis查重+redis插入，outboxRepo用于记录待投递的持久化（mysql）消息
 type likeService struct {
-	videoRepo    repository.VideoRepository
-	rabbitMQConn *amqp.Connection
+	videoRepo  repository.VideoRepository
+	outboxRepo repository.OutboxRepository
+	access     videoAccess
 }
 
-func NewLikeService(videoRepo repository.VideoRepository, rabbitMQConn *amqp.Connection) LikeService {
-	ch, err := rabbitMQConn.Channel()
-	if err != nil {
-		// 在实际项目中，这里应该有更健壮的错误处理和重试机制
-		panic("Failed to open a channel")
-	}
-	// NewLikeService执行完毕后，这个临时的Channel就被关闭了
-	defer ch.Close()
-	// 创建名叫“orion.like.queue”的邮筒，有就不用创建（幂等）
-	_, err = ch.QueueDeclare(
-		QueueLike, // name
-		true,      // durable: 队列持久化，即使RabbitMQ服务器重启，这个“邮筒”本身不会消失（注：里面的信件是否消失，取决于信件本身的持久化设置）
-		false,     // autoDelete：最后一个消费者断开连接，邮筒不会被自动拆除
-		false,     // exclusive：非独占，多个不同的连接都可以访问这个邮筒
-		false,     // noWait：同步等待，QueueDeclare会等待RabbitMQ服务器确认“邮筒建好了”之后，再继续执行后面的代码
-		nil,       // args
-	)
-	if err != nil {
-		panic("Failed to declare a queue")
-	}
-
+// 消息不直接发往RabbitMQ，而是先写入outbox_messages表，由relay进程投递到“orion.like.queue”
+func NewLikeService(videoRepo repository.VideoRepository, outboxRepo repository.OutboxRepository, followRepo repository.FollowRepository) LikeService {
 	return &likeService{
-		videoRepo:    videoRepo,
-		rabbitMQConn: rabbitMQConn,
+		videoRepo:  videoRepo,
+		outboxRepo: outboxRepo,
+		access:     videoAccess{videoRepo: videoRepo, followRepo: followRepo},
 	}
 }
 
-// 点赞视频：1、检查点赞的视频是否存在 2、检查用户是否已点赞 3、redis点赞视频 4、通过LikeMessage发布“点赞视频”消息
+// 点赞视频：1、检查点赞的视频是否存在、用户能不能看到 2、检查用户是否已点赞 3、redis点赞视频 4、把“点赞视频”消息写入outbox，由relay投递
 // 这里其实有问题，因为FindByID检查的是数据库，我们第一时间操作的是redis，如果没有限制还好，有限制就不对
 func (s *likeService) LikeVideo(userID, videoID uint64) error {
-	_, err := s.videoRepo.FindByID(videoID)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("视频不存在")
-		}
+	if _, err := s.access.find(videoID, userID); err != nil {
 		return err
 	}
 	liked, err := s.videoRepo.IsUserLikeVideo(videoID, userID)
@@ -85,17 +50,22 @@ func (s *likeService) LikeVideo(userID, videoID uint64) error {
 		return err
 	}
 
-	// 发送异步消息，通知后台去写数据库
-	msg := LikeMessage{UserID: userID, VideoID: videoID, Action: ActionLike}
-	return s.publishLikeMessage(msg)
+	// 写入outbox，relay会保证消息“至少一次”到达消费者，由消费者去写数据库
+	msg := message.LikeMessage{UserID: userID, VideoID: videoID, Action: message.ActionLike}
+	if err := s.saveLikeMessage(msg); err != nil {
+		// outbox没写进去，说明这次点赞没有留下任何持久化记录，回滚Redis即可
+		_ = s.videoRepo.RemoveVideoLike(videoID, userID)
+		return s.saveFailed(err, msg)
+	}
+	return nil
 }
 
-// 取消点赞：1、检查取赞的视频是否存在 2、检查用户是否已点赞 3、redis取消点赞视频 4、通过LikeMessage发布“取消点赞视频”消息
+// 取消点赞：1、检查取赞的视频是否存在，视频后来改成别人看不到的也可以取消 2、检查用户是否已点赞 3、redis取消点赞视频 4、把“取消点赞视频”消息写入outbox
 func (s *likeService) UnlikeVideo(userID, videoID uint64) error {
 	_, err := s.videoRepo.FindByID(videoID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("视频不存在")
+			return ErrVideoNotFound
 		}
 		return err
 	}
@@ -111,34 +81,29 @@ func (s *likeService) UnlikeVideo(userID, videoID uint64) error {
 		return err
 	}
 
-	// 发送异步消息
-	msg := LikeMessage{UserID: userID, VideoID: videoID, Action: ActionUnlike}
-	return s.publishLikeMessage(msg)
+	msg := message.LikeMessage{UserID: userID, VideoID: videoID, Action: message.ActionUnlike}
+	if err := s.saveLikeMessage(msg); err != nil {
+		_ = s.videoRepo.AddVideoLike(videoID, userID)
+		return s.saveFailed(err, msg)
+	}
+	return nil
 }
 
-// 私有方法，发送消息到RabbitMQ：1、创建channel 2、序列化LikeMessage结构体 3、发布消息
-func (s *likeService) publishLikeMessage(msg LikeMessage) error {
-	// 为每一个消息建立一个单独的channel，消息之间互不影响
-	// publishLikeMessage执行完毕后，这个临时的Channel就被关闭了
-	ch, err := s.rabbitMQConn.Channel()
-	if err != nil {
-		return err
-	}
-	defer ch.Close()
-	// 将msg结构体序列化成一段JSON格式的字节流([]byte)
-	body, err := json.Marshal(msg)
+// 记录写入失败的日志，并返回可以展示给用户的错误
+func (s *likeService) saveFailed(err error, msg message.LikeMessage) error {
+	logger.Log.WithError(err).
+		WithField("user_id", msg.UserID).
+		WithField("video_id", msg.VideoID).
+		WithField("action", msg.Action).
+		Error("点赞消息写入outbox失败，Redis已回滚")
+	return errors.New("系统繁忙，请稍后再试")
+}
+
+// 私有方法，把LikeMessage序列化后写入outbox_messages表（Redis先行的场景下，outbox就是这次操作的持久化日志）
+func (s *likeService) saveLikeMessage(msg message.LikeMessage) error {
+	outboxMsg, err := message.NewOutbox(message.QueueLike, msg)
 	if err != nil {
 		return err
 	}
-
-	return ch.Publish(
-		"",        // exchange默认交换机
-		QueueLike, // routing key “邮筒”名字 orion.like.queue
-		false,     // mandatory 高级路由功能
-		false,     // immediate 高级路由功能
-		// 信件本身
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body, //序列化的msg结构体
-		})
+	return s.outboxRepo.Create(outboxMsg)
 }
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LivePresenceService 直播间在线观众：观众定时心跳（WebSocket连接或HTTP接口），按用户去重，同一个用户开多个页面只算一人
+type LivePresenceService interface {
+	// Heartbeat 观众心跳，返回直播间当前在线人数；只有直播中的房间接受心跳，独立观众按场次统计
+	Heartbeat(roomID, userID uint64) (int64, error)
+	// Join 观众通过WebSocket进入直播间，和Leave成对调用
+	Join(roomID, userID uint64) error
+	// Leave 观众关闭WebSocket，这个用户在直播间的连接都关闭了才不再算在线
+	Leave(roomID, userID uint64) error
+	// OnlineCounts 批量获取直播间的在线人数
+	OnlineCounts(roomIDs []uint64) (map[uint64]int64, error)
+	// SweepStale 清扫所有直播间中超时没有心跳的观众，返回清扫掉的人数
+	SweepStale() (int64, error)
+	// HeartbeatInterval 客户端应该多久发一次心跳
+	HeartbeatInterval() time.Duration
+}
+
+type livePresenceService struct {
+	roomRepo     repository.LiveRoomRepository
+	presenceRepo repository.LivePresenceRepository
+	liveCfg      config.LiveConfig
+}
+
+func NewLivePresenceService(roomRepo repository.LiveRoomRepository, presenceRepo repository.LivePresenceRepository, liveCfg config.LiveConfig) LivePresenceService {
+	return &livePresenceService{roomRepo: roomRepo, presenceRepo: presenceRepo, liveCfg: liveCfg}
+}
+
+func (s *livePresenceService) Heartbeat(roomID, userID uint64) (int64, error) {
+	sessionID, err := s.roomRepo.CurrentSessionID(roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrLiveRoomNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if sessionID == 0 {
+		return 0, ErrRoomNotLive
+	}
+	return s.presenceRepo.Heartbeat(roomID, sessionID, userID, time.Now(), s.liveCfg.PresenceTTL)
+}
+
+func (s *livePresenceService) Join(roomID, userID uint64) error {
+	return s.presenceRepo.Join(roomID, userID)
+}
+
+func (s *livePresenceService) Leave(roomID, userID uint64) error {
+	return s.presenceRepo.Leave(roomID, userID)
+}
+
+func (s *livePresenceService) OnlineCounts(roomIDs []uint64) (map[uint64]int64, error) {
+	return s.presenceRepo.OnlineCounts(roomIDs, time.Now(), s.liveCfg.PresenceTTL)
+}
+
+func (s *livePresenceService) SweepStale() (int64, error) {
+	return s.presenceRepo.SweepStale(time.Now(), s.liveCfg.PresenceTTL)
+}
+
+func (s *livePresenceService) HeartbeatInterval() time.Duration {
+	return s.liveCfg.HeartbeatInterval
+}
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type LiveRoomService interface {
+	// 创建直播间，每个主播只能有一个
+	CreateRoom(streamerID uint64, title string) (*model.LiveRoom, error)
+	GetRoom(roomID uint64) (*model.LiveRoom, error)
+	// 主播自己的直播间，包含推流密钥
+	GetMyRoom(streamerID uint64) (*model.LiveRoom, error)
+	// 重新生成推流密钥，旧密钥之后的推流会被拒绝，正在进行的推流不受影响
+	RotateStreamKey(streamerID uint64) (*model.LiveRoom, error)
+	// 主播手动开播/下播，和RTMP回调是同一套状态流转
+	StartBroadcast(streamerID uint64) (*model.LiveRoom, error)
+	EndBroadcast(streamerID uint64) (*model.LiveRoom, error)
+	// 分页获取正在直播的直播间
+	ListLive(page, pageSize int) ([]model.LiveRoom, error)
+	// 主播查看自己直播间的观众统计：正在直播时的实时数据，以及最近几场直播的峰值和独立观众数
+	GetStats(streamerID uint64) (*LiveRoomStats, error)
+
+	// OnPublish RTMP服务器收到推流时回调：校验推流密钥，通过后开播
+	OnPublish(roomID uint64, streamKey string) error
+	// OnDone RTMP服务器推流断开时回调：下播
+	OnDone(roomID uint64) error
+	// OnRecordDone RTMP服务器录制完成时回调：把录制文件登记为主播的回放视频，按文件名中的录制开始时间关联到当时的那场直播
+	// 同一场直播重复回调返回已经登记的视频；没有配置回放地址时返回nil
+	OnRecordDone(roomID uint64, path string) (*model.Video, error)
+
+	// 推流地址和串流密钥，填到OBS的“服务器”和“串流密钥”里
+	PublishAddress(room *model.LiveRoom) (server, streamName string)
+	// 观众的拉流地址
+	PlayURL(room *model.LiveRoom) string
+}
+
+var (
+	ErrLiveRoomExists   = errors.New("您已经创建过直播间")
+	ErrLiveRoomNotFound = errors.New("直播间不存在")
+	// 推流密钥错误，RTMP服务器据此拒绝推流
+	ErrStreamKeyInvalid = errors.New("推流密钥无效")
+	// 录制文件不在live.record_dir下，无法生成播放地址；或者文件名中没有录制开始时间，不知道是哪一场直播
+	ErrRecordPathInvalid = errors.New("录制文件路径无效")
+)
+
+// 录制开始时间和开播时间的误差：RTMP服务器先回调开播再开始录制，两台机器的时钟也可能有偏差
+const recordSessionTolerance = time.Minute
+
+// 统计接口返回最近多少场直播
+const recentSessionsLimit = 10
+
+// LiveRoomStats 直播间的观众统计，没在直播时只有历史场次
+type LiveRoomStats struct {
+	Room *model.LiveRoom
+	// 当前这场直播的实时数据
+	Online        int64
+	PeakViewers   int64
+	UniqueViewers int64
+	// 最近的直播场次（包括正在进行的这一场），下播后才有峰值和独立观众数
+	Sessions []model.LiveSession
+}
+
+type liveRoomService struct {
+	roomRepo        repository.LiveRoomRepository
+	presenceRepo    repository.LivePresenceRepository
+	leaderboardRepo repository.LeaderboardRepository
+	videoRepo       repository.VideoRepository
+	liveCfg         config.LiveConfig
+	leaderboardCfg  config.LeaderboardConfig
+}
+
+func NewLiveRoomService(roomRepo repository.LiveRoomRepository, presenceRepo repository.LivePresenceRepository, leaderboardRepo repository.LeaderboardRepository, videoRepo repository.VideoRepository, liveCfg config.LiveConfig, leaderboardCfg config.LeaderboardConfig) LiveRoomService {
+	return &liveRoomService{
+		roomRepo:        roomRepo,
+		presenceRepo:    presenceRepo,
+		leaderboardRepo: leaderboardRepo,
+		videoRepo:       videoRepo,
+		liveCfg:         liveCfg,
+		leaderboardCfg:  leaderboardCfg,
+	}
+}
+
+// newStreamKey 32位随机十六进制字符串
+func newStreamKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 创建直播间：1、检查主播是否已有直播间 2、生成推流密钥 3、插入，唯一索引兜底并发创建
+func (s *liveRoomService) CreateRoom(streamerID uint64, title string) (*model.LiveRoom, error) {
+	if _, err := s.roomRepo.FindByStreamer(streamerID); err == nil {
+		return nil, ErrLiveRoomExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	key, err := newStreamKey()
+	if err != nil {
+		return nil, err
+	}
+	room := &model.LiveRoom{
+		StreamerID: streamerID,
+		Title:      title,
+		StreamKey:  key,
+		Status:     model.LiveRoomIdle,
+	}
+	if err := s.roomRepo.Create(room); err != nil {
+		if repository.IsDuplicateEntry(err) {
+			return nil, ErrLiveRoomExists
+		}
+		return nil, err
+	}
+	return room, nil
+}
+
+func (s *liveRoomService) GetRoom(roomID uint64) (*model.LiveRoom, error) {
+	room, err := s.roomRepo.FindByID(roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLiveRoomNotFound
+	}
+	return room, err
+}
+
+func (s *liveRoomService) GetMyRoom(streamerID uint64) (*model.LiveRoom, error) {
+	room, err := s.roomRepo.FindByStreamer(streamerID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLiveRoomNotFound
+	}
+	return room, err
+}
+
+func (s *liveRoomService) RotateStreamKey(streamerID uint64) (*model.LiveRoom, error) {
+	room, err := s.GetMyRoom(streamerID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newStreamKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.roomRepo.UpdateStreamKey(room.ID, key); err != nil {
+		return nil, err
+	}
+	room.StreamKey = key
+	logger.Log.WithField("room_id", room.ID).Info("推流密钥已重新生成")
+	return room, nil
+}
+
+func (s *liveRoomService) StartBroadcast(streamerID uint64) (*model.LiveRoom, error) {
+	room, err := s.GetMyRoom(streamerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.start(room.ID); err != nil {
+		return nil, err
+	}
+	return s.roomRepo.FindByID(room.ID)
+}
+
+func (s *liveRoomService) EndBroadcast(streamerID uint64) (*model.LiveRoom, error) {
+	room, err := s.GetMyRoom(streamerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.end(room.ID); err != nil {
+		return nil, err
+	}
+	return s.roomRepo.FindByID(room.ID)
+}
+
+func (s *liveRoomService) ListLive(page, pageSize int) ([]model.LiveRoom, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.roomRepo.ListLive((page-1)*pageSize, pageSize)
+}
+
+// 观众统计：1、找到主播的直播间 2、正在直播则从Redis读取实时在线、峰值和独立观众 3、从MySQL读取最近的场次
+func (s *liveRoomService) GetStats(streamerID uint64) (*LiveRoomStats, error) {
+	room, err := s.GetMyRoom(streamerID)
+	if err != nil {
+		return nil, err
+	}
+	stats := &LiveRoomStats{Room: room}
+	if room.Status == model.LiveRoomLive {
+		counts, err := s.presenceRepo.OnlineCounts([]uint64{room.ID}, time.Now(), s.liveCfg.PresenceTTL)
+		if err != nil {
+			return nil, err
+		}
+		stats.Online = counts[room.ID]
+		stats.UniqueViewers, stats.PeakViewers, err = s.presenceRepo.SessionStats(room.SessionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stats.Sessions, err = s.roomRepo.ListSessions(room.ID, recentSessionsLimit)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// 推流鉴权：房间不存在和密钥错误一样返回ErrStreamKeyInvalid，不让外部探测房间ID；用常量时间比较密钥
+func (s *liveRoomService) OnPublish(roomID uint64, streamKey string) error {
+	room, err := s.roomRepo.FindByID(roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrStreamKeyInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if streamKey == "" || subtle.ConstantTimeCompare([]byte(streamKey), []byte(room.StreamKey)) != 1 {
+		return ErrStreamKeyInvalid
+	}
+	return s.start(room.ID)
+}
+
+// 推流断开时不校验密钥：密钥可能在推流过程中被重新生成了，回调地址本身由callback_token保护
+func (s *liveRoomService) OnDone(roomID uint64) error {
+	err := s.end(roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrLiveRoomNotFound
+	}
+	return err
+}
+
+// 登记回放：1、录制文件路径换成播放地址 2、按文件名中的录制开始时间找到对应的那场直播 3、这场已经有回放则直接返回 4、以主播的名义创建视频，唯一索引兜底重复回调
+// 录制完成和推流断开的回调先后顺序不固定，所以不要求这场直播已经结束；弹幕在查看回放时按场次读取，晚落库的弹幕也能看到
+func (s *liveRoomService) OnRecordDone(roomID uint64, path string) (*model.Video, error) {
+	logCtx := logger.Log.WithField("room_id", roomID).WithField("path", path)
+	if s.liveCfg.RecordURL == "" {
+		logCtx.Info("没有配置live.record_url，忽略录制文件")
+		return nil, nil
+	}
+	videoURL, err := s.recordURL(path)
+	if err != nil {
+		return nil, err
+	}
+	room, err := s.roomRepo.FindByID(roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLiveRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 回调可能延迟或者重试，到达时主播也许已经开始了下一场直播，所以不能取最近的一场，要按录制开始时间找
+	startedAt, ok := recordStartTime(path)
+	if !ok {
+		logCtx.Warn("录制文件名中没有开始时间，SRS的dvr_path要带[timestamp]，nginx-rtmp要打开record_unique")
+		return nil, ErrRecordPathInvalid
+	}
+	session, err := s.roomRepo.FindSessionAt(room.ID, startedAt, recordSessionTolerance)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLiveSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if video, err := s.videoRepo.FindByLiveSession(session.ID); err == nil {
+		logCtx.WithField("video_id", video.ID).Info("这场直播已经生成过回放")
+		return video, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	now := time.Now()
+	video := &model.Video{
+		AuthorID:      room.StreamerID,
+		Title:         "【直播回放】" + session.Title,
+		Description:   session.StartedAt.Format("2006-01-02 15:04") + " 的直播录像",
+		VideoURL:      videoURL,
+		CoverURL:      room.CoverURL,
+		LiveSessionID: &session.ID,
+		// 录像由SRS直接生成，不经过转码，生成后直接公开发布
+		ProcessingStatus: model.VideoReady,
+		Visibility:       model.VisibilityPublic,
+		PublishStatus:    model.PublishPublished,
+		PublishAt:        &now,
+	}
+	if err := s.videoRepo.Create(video); err != nil {
+		if repository.IsDuplicateEntry(err) {
+			return s.videoRepo.FindByLiveSession(session.ID)
+		}
+		return nil, err
+	}
+	logCtx.WithField("session_id", session.ID).WithField("video_id", video.ID).Info("直播回放已生成")
+	return video, nil
+}
+
+// recordStartTime 录制文件名中的开始时间：SRS的dvr_path用[timestamp]时是 {流名}.{毫秒时间戳}.flv，
+// nginx-rtmp打开record_unique时是 {流名}-{秒级时间戳}.flv
+func recordStartTime(path string) (time.Time, bool) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	i := strings.LastIndexAny(name, ".-")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	ts, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil || ts <= 0 {
+		return time.Time{}, false
+	}
+	switch len(name[i+1:]) {
+	case 13:
+		return time.UnixMilli(ts), true
+	case 10:
+		return time.Unix(ts, 0), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// recordURL 录制文件必须在record_dir下，相对路径逐段转义后拼到record_url后面
+func (s *liveRoomService) recordURL(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", ErrRecordPathInvalid
+	}
+	rel, err := filepath.Rel(s.liveCfg.RecordDir, filepath.Clean(path))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrRecordPathInvalid
+	}
+	return url.JoinPath(s.liveCfg.RecordURL, strings.Split(filepath.ToSlash(rel), "/")...)
+}
+
+func (s *liveRoomService) start(roomID uint64) error {
+	session, started, err := s.roomRepo.StartSession(roomID, time.Now())
+	if err != nil {
+		return err
+	}
+	logCtx := logger.Log.WithField("room_id", roomID).WithField("session_id", session.ID)
+	if started {
+		logCtx.Info("直播间已开播")
+	} else {
+		logCtx.Info("直播间已经在直播中")
+	}
+	return nil
+}
+
+func (s *liveRoomService) end(roomID uint64) error {
+	session, err := s.roomRepo.EndSession(roomID, time.Now())
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		logger.Log.WithField("room_id", roomID).Info("直播间未在直播，忽略下播")
+		return nil
+	}
+	logCtx := logger.Log.WithField("room_id", roomID).WithField("session_id", session.ID)
+	logCtx.WithField("duration", session.EndedAt.Sub(session.StartedAt).Round(time.Second).String()).Info("直播间已下播")
+	// 下播已经生效，统计写入失败只记录日志；Redis里的统计会保留到过期，不影响下一场
+	if err := s.saveSessionStats(roomID, session); err != nil {
+		logCtx.WithError(err).Error("保存直播观众统计失败")
+	}
+	// 本场贡献榜写入MySQL，之后查这场的榜单读快照；失败了可以用 admin leaderboard rebuild 按送礼记录补上
+	if n, err := s.leaderboardRepo.SnapshotSession(roomID, session.ID, s.leaderboardCfg.SnapshotSize); err != nil {
+		logCtx.WithError(err).Error("保存本场贡献榜快照失败")
+	} else {
+		logCtx.WithField("count", n).Info("本场贡献榜快照已保存")
+	}
+	return nil
+}
+
+// saveSessionStats 把这场直播在Redis中的峰值和独立观众写入MySQL，然后清空直播间的在线观众
+func (s *liveRoomService) saveSessionStats(roomID uint64, session *model.LiveSession) error {
+	uniqueViewers, peakViewers, err := s.presenceRepo.SessionStats(session.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.roomRepo.UpdateSessionStats(session.ID, peakViewers, uniqueViewers); err != nil {
+		return err
+	}
+	session.PeakViewers, session.UniqueViewers = peakViewers, uniqueViewers
+	logger.Log.WithField("room_id", roomID).
+		WithField("session_id", session.ID).
+		WithField("peak_viewers", peakViewers).
+		WithField("unique_viewers", uniqueViewers).
+		Info("直播观众统计已保存")
+	return s.presenceRepo.ClearRoom(roomID, session.ID)
+}
+
+func (s *liveRoomService) PublishAddress(room *model.LiveRoom) (string, string) {
+	return s.liveCfg.PublishURL, fmt.Sprintf("%d?key=%s", room.ID, room.StreamKey)
+}
+
+func (s *liveRoomService) PlayURL(room *model.LiveRoom) string {
+	return fmt.Sprintf("%s/%d.m3u8", s.liveCfg.PlayURL, room.ID)
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"Orion_Live/pkg/config"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLiveRoomRecordURL(t *testing.T) {
+	s := &liveRoomService{liveCfg: config.LiveConfig{
+		RecordDir: "/usr/local/srs/objs/nginx/html",
+		RecordURL: "http://127.0.0.1:8088",
+	}}
+	got, err := s.recordURL("/usr/local/srs/objs/nginx/html/record/live/12.1760600000000.flv")
+	if err != nil || got != "http://127.0.0.1:8088/record/live/12.1760600000000.flv" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	got, err = s.recordURL("/usr/local/srs/objs/nginx/html/record/live/a b#.flv")
+	if err != nil || got != "http://127.0.0.1:8088/record/live/a%20b%23.flv" {
+		t.Errorf("文件名应该被转义, got %q, %v", got, err)
+	}
+	for _, path := range []string{
+		"/usr/local/srs/objs/nginx/html",
+		"/usr/local/srs/objs/nginx/html/../conf/srs.conf",
+		"/etc/passwd",
+		"record/live/12.flv",
+	} {
+		if _, err := s.recordURL(path); !errors.Is(err, ErrRecordPathInvalid) {
+			t.Errorf("%q 应返回ErrRecordPathInvalid, got %v", path, err)
+		}
+	}
+}
+
+func TestRecordStartTime(t *testing.T) {
+	cases := []struct {
+		path string
+		want time.Time
+		ok   bool
+	}{
+		{"/srs/record/live/12.1760600000123.flv", time.UnixMilli(1760600000123), true},
+		{"/nginx/record/12-1760600000.flv", time.Unix(1760600000, 0), true},
+		{"/nginx/record/12.flv", time.Time{}, false},
+		{"/nginx/record/12-abc.flv", time.Time{}, false},
+		{"/nginx/record/12-123.flv", time.Time{}, false},
+	}
+	for _, c := range cases {
+		got, ok := recordStartTime(c.path)
+		if ok != c.ok || !got.Equal(c.want) {
+			t.Errorf("%q: got %v, %v, want %v, %v", c.path, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"fmt"
+)
+
+// MediaFailureService 视频转码失败时把视频标记为failed，转码时、消费者的死信回调和relay投递失败共用
+type MediaFailureService interface {
+	// MarkFailed uploaded/processing → failed，已经转码完成或已经失败的视频什么都不做，重复调用是安全的
+	MarkFailed(videoID uint64, reason string) error
+}
+
+type mediaFailureService struct {
+	videoRepo repository.VideoRepository
+}
+
+func NewMediaFailureService(videoRepo repository.VideoRepository) MediaFailureService {
+	return &mediaFailureService{videoRepo: videoRepo}
+}
+
+func (s *mediaFailureService) MarkFailed(videoID uint64, reason string) error {
+	logCtx := logger.Log.WithField("video_id", videoID)
+	updated, err := s.videoRepo.MarkFailed(videoID, reason)
+	if err != nil {
+		return fmt.Errorf("标记视频转码失败时出错: %w", err)
+	}
+	if !updated {
+		logCtx.Info("视频已经处理完，无需标记失败")
+		return nil
+	}
+	if err := s.videoRepo.DeleteVideoCache(videoID); err != nil {
+		logCtx.WithError(err).Warn("删除视频缓存失败")
+	}
+	logCtx.WithField("reason", reason).Warn("视频转码失败")
+	return nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"Orion_Live/internal/media"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MediaProcessor 探测、截图和转码的具体实现，线上是media.FFmpeg，测试中换成假的
+// 文件本身有问题时返回包装了media.ErrInvalidMedia的错误，其他错误会被重试
+type MediaProcessor interface {
+	Probe(ctx context.Context, input string) (*media.Info, error)
+	ExtractCover(ctx context.Context, input, output string, at time.Duration) error
+	TranscodeHLS(ctx context.Context, input, outDir string, renditions []media.Rendition) error
+}
+
+// MediaProcessingService 消费者处理发布视频后投递的转码消息：探测时长和分辨率、转码成多清晰度的HLS，没有封面时截取一帧
+type MediaProcessingService interface {
+	// Process 处理一个视频，重复投递是安全的；视频不存在时返回ErrVideoNotFound；
+	// 视频文件有问题时把视频标记为failed并返回nil，其他错误返回给消费者重试，重试用尽后由MediaFailureService标记为failed
+	Process(videoID uint64) error
+}
+
+// 存储中HLS文件的Content-Type，播放器按它识别
+var hlsContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+}
+
+type mediaProcessingService struct {
+	videoRepo repository.VideoRepository
+	mediaRepo repository.MediaRepository
+	store     storage.Backend
+	processor MediaProcessor
+	failure   MediaFailureService
+	cfg       config.MediaConfig
+}
+
+func NewMediaProcessingService(videoRepo repository.VideoRepository, mediaRepo repository.MediaRepository, store storage.Backend, processor MediaProcessor, cfg config.MediaConfig) MediaProcessingService {
+	return &mediaProcessingService{
+		videoRepo: videoRepo,
+		mediaRepo: mediaRepo,
+		store:     store,
+		processor: processor,
+		failure:   NewMediaFailureService(videoRepo),
+		cfg:       cfg,
+	}
+}
+
+// 处理视频：1、以数据库为准，已经ready或failed的视频直接跳过 2、uploaded → processing
+// 3、下载原始文件、探测、转码、上传HLS和封面 4、processing → ready，删除视频缓存
+func (s *mediaProcessingService) Process(videoID uint64) error {
+	video, err := s.videoRepo.FindByIDNoCache(videoID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrVideoNotFound
+	}
+	if err != nil {
+		return err
+	}
+	logCtx := logger.Log.WithField("video_id", videoID)
+	if video.ProcessingStatus == model.VideoReady || video.ProcessingStatus == model.VideoFailed {
+		logCtx.WithField("status", video.ProcessingStatus).Info("视频已经处理过，跳过")
+		return nil
+	}
+	source, err := s.mediaRepo.FindByID(video.SourceFileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logCtx.Warn("视频的原始文件不存在")
+		return s.failure.MarkFailed(videoID, "原始文件不存在")
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.videoRepo.MarkProcessing(videoID); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+	result, err := s.transcode(ctx, video, source)
+	if errors.Is(err, media.ErrInvalidMedia) {
+		return s.failure.MarkFailed(videoID, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	updated, err := s.videoRepo.MarkReady(videoID, *result)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		// 处理期间被标记为failed（比如重试用尽的那次投递恰好在这之前），以那次为准
+		logCtx.Warn("视频状态已经变化，转码结果没有写入")
+		return nil
+	}
+	if err := s.videoRepo.DeleteVideoCache(videoID); err != nil {
+		logCtx.WithError(err).Warn("删除视频缓存失败")
+	}
+	logCtx.WithField("duration_ms", result.DurationMS).
+		WithField("resolution", fmt.Sprintf("%dx%d", result.Width, result.Height)).
+		WithField("elapsed", time.Since(start).String()).
+		Info("视频转码完成")
+	return nil
+}
+
+// transcode 在工作目录中完成所有处理，结束后删除工作目录；HLS放在 hls/{视频ID}/ 下，重新处理会覆盖
+func (s *mediaProcessingService) transcode(ctx context.Context, video *model.Video, source *model.MediaFile) (*repository.VideoProcessed, error) {
+	workDir, err := os.MkdirTemp(s.cfg.WorkDir, fmt.Sprintf("video-%d-*", video.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	input := filepath.Join(workDir, "source"+path.Ext(source.StorageKey))
+	if err := s.download(ctx, source.StorageKey, input); err != nil {
+		return nil, err
+	}
+	info, err := s.processor.Probe(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	renditions := pickRenditions(s.cfg.Renditions, info)
+	outDir := filepath.Join(workDir, "hls")
+	if err := s.processor.TranscodeHLS(ctx, input, outDir, renditions); err != nil {
+		return nil, err
+	}
+	master := filepath.Join(outDir, media.MasterPlaylistName)
+	if err := os.WriteFile(master, []byte(media.MasterPlaylist(renditions)), 0644); err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("hls/%d", video.ID)
+	if err := s.uploadDir(ctx, outDir, prefix); err != nil {
+		return nil, err
+	}
+	result := &repository.VideoProcessed{
+		HLSURL:     s.store.URL(prefix + "/" + media.MasterPlaylistName),
+		DurationMS: info.Duration.Milliseconds(),
+		Width:      info.Width,
+		Height:     info.Height,
+	}
+
+	if video.CoverURL == "" {
+		// 封面截不出来不影响视频本身，只是没有封面
+		cover := filepath.Join(workDir, "cover.jpg")
+		coverKey := fmt.Sprintf("covers/generated/%d.jpg", video.ID)
+		err := s.processor.ExtractCover(ctx, input, cover, coverTime(s.cfg.CoverAt, info.Duration))
+		if err == nil {
+			err = s.putFile(ctx, coverKey, cover, "image/jpeg")
+		}
+		if err != nil {
+			logger.Log.WithError(err).WithField("video_id", video.ID).Warn("截取封面失败")
+		} else {
+			result.CoverURL = s.store.URL(coverKey)
+		}
+	}
+	return result, nil
+}
+
+// download 把原始文件从存储复制到本地，ffmpeg只能读本地文件
+func (s *mediaProcessingService) download(ctx context.Context, key, dst string) error {
+	src, err := s.store.Open(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("%w: 原始文件在存储中不存在", media.ErrInvalidMedia)
+	}
+	if err != nil {
+		return fmt.Errorf("读取原始文件失败: %w", err)
+	}
+	defer src.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return fmt.Errorf("读取原始文件失败: %w", err)
+	}
+	return f.Close()
+}
+
+// uploadDir 把目录下的所有文件写入存储，key是prefix加上相对路径
+func (s *mediaProcessingService) uploadDir(ctx context.Context, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		contentType, ok := hlsContentTypes[filepath.Ext(p)]
+		if !ok {
+			contentType = "application/octet-stream"
+		}
+		return s.putFile(ctx, prefix+"/"+filepath.ToSlash(rel), p, contentType)
+	})
+}
+
+func (s *mediaProcessingService) putFile(ctx context.Context, key, p, contentType string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(ctx, key, f, stat.Size(), contentType); err != nil {
+		return fmt.Errorf("写入存储失败: %w", err)
+	}
+	return nil
+}
+
+// pickRenditions 清晰度按短边计算，竖屏视频也一样；高于原视频的档位跳过，不放大
+// 原视频比最低一档还小时按原分辨率转出一档；宽高都取偶数，libx264要求
+func pickRenditions(cfgs []config.RenditionConfig, info *media.Info) []media.Rendition {
+	short := min(info.Width, info.Height)
+	var picked []media.Rendition
+	lowest := cfgs[0]
+	for _, c := range cfgs {
+		if c.Height < lowest.Height {
+			lowest = c
+		}
+		if c.Height <= short {
+			picked = append(picked, scaleRendition(c, float64(c.Height)/float64(short), info))
+		}
+	}
+	if len(picked) == 0 {
+		picked = append(picked, scaleRendition(lowest, 1, info))
+	}
+	return picked
+}
+
+func scaleRendition(c config.RenditionConfig, scale float64, info *media.Info) media.Rendition {
+	return media.Rendition{
+		Name:         c.Name,
+		Width:        evenDimension(float64(info.Width) * scale),
+		Height:       evenDimension(float64(info.Height) * scale),
+		VideoBitrate: c.VideoBitrate,
+		AudioBitrate: c.AudioBitrate,
+	}
+}
+
+func evenDimension(v float64) int {
+	return max(int(math.Round(v/2))*2, 2)
+}
+
+// coverTime 默认截取第CoverAt秒，视频比这短时取中间一帧
+func coverTime(at, duration time.Duration) time.Duration {
+	return min(at, duration/2)
+}
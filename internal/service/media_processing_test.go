@@ -0,0 +1,173 @@
+package service
+
+import (
+	"Orion_Live/internal/media"
+	"Orion_Live/internal/model"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRenditions = []config.RenditionConfig{
+	{Name: "1080p", Height: 1080, VideoBitrate: 5000, AudioBitrate: 192},
+	{Name: "720p", Height: 720, VideoBitrate: 2800, AudioBitrate: 128},
+	{Name: "480p", Height: 480, VideoBitrate: 1400, AudioBitrate: 96},
+}
+
+func TestPickRenditions(t *testing.T) {
+	cases := []struct {
+		width, height int
+		want          string
+	}{
+		{1920, 1080, "1080p:1920x1080 720p:1280x720 480p:854x480"},
+		{1280, 720, "720p:1280x720 480p:854x480"},
+		// 竖屏按短边（宽度）计算
+		{1080, 1920, "1080p:1080x1920 720p:720x1280 480p:480x854"},
+		// 比最低一档还小，不放大
+		{640, 360, "480p:640x360"},
+		{639, 359, "480p:640x360"},
+	}
+	for _, c := range cases {
+		picked := pickRenditions(testRenditions, &media.Info{Width: c.width, Height: c.height})
+		got := make([]string, 0, len(picked))
+		for _, r := range picked {
+			got = append(got, fmt.Sprintf("%s:%dx%d", r.Name, r.Width, r.Height))
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Fatalf("%dx%d: got %q, want %q", c.width, c.height, strings.Join(got, " "), c.want)
+		}
+	}
+}
+
+func TestCoverTime(t *testing.T) {
+	if got := coverTime(time.Second, time.Minute); got != time.Second {
+		t.Fatalf("got %v", got)
+	}
+	if got := coverTime(time.Second, time.Second); got != 500*time.Millisecond {
+		t.Fatalf("短视频取中间一帧, got %v", got)
+	}
+}
+
+// fakeProcessor 不调用ffmpeg，按请求的清晰度写出播放列表和一个切片
+type fakeProcessor struct {
+	info       media.Info
+	transcoded []media.Rendition
+	coverErr   error
+}
+
+func (f *fakeProcessor) Probe(ctx context.Context, input string) (*media.Info, error) {
+	if _, err := os.Stat(input); err != nil {
+		return nil, err
+	}
+	info := f.info
+	return &info, nil
+}
+
+func (f *fakeProcessor) ExtractCover(ctx context.Context, input, output string, at time.Duration) error {
+	if f.coverErr != nil {
+		return f.coverErr
+	}
+	return os.WriteFile(output, []byte("jpeg"), 0644)
+}
+
+func (f *fakeProcessor) TranscodeHLS(ctx context.Context, input, outDir string, renditions []media.Rendition) error {
+	f.transcoded = renditions
+	for _, r := range renditions {
+		dir := filepath.Join(outDir, r.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "index.m3u8"), []byte("#EXTM3U\n"), 0644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "seg_0000.ts"), []byte("ts"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingStore 记下每个对象写入时的Content-Type，本地存储读取时按扩展名推断，看不到写入时的值
+type recordingStore struct {
+	storage.Backend
+	contentTypes map[string]string
+}
+
+func (r *recordingStore) Put(ctx context.Context, key string, rd io.Reader, size int64, contentType string) error {
+	r.contentTypes[key] = contentType
+	return r.Backend.Put(ctx, key, rd, size, contentType)
+}
+
+func TestMediaTranscode(t *testing.T) {
+	ctx := context.Background()
+	local, err := storage.NewLocal(t.TempDir(), "http://127.0.0.1:8080/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &recordingStore{Backend: local, contentTypes: map[string]string{}}
+	if err := store.Put(ctx, "videos/ab/ab12.mp4", strings.NewReader("source"), 6, "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	processor := &fakeProcessor{info: media.Info{Duration: 90 * time.Second, Width: 1280, Height: 720}}
+	s := &mediaProcessingService{
+		store:     store,
+		processor: processor,
+		cfg:       config.MediaConfig{WorkDir: t.TempDir(), CoverAt: time.Second, Renditions: testRenditions},
+	}
+
+	video := &model.Video{BaseModel: model.BaseModel{ID: 7}}
+	result, err := s.transcode(ctx, video, &model.MediaFile{StorageKey: "videos/ab/ab12.mp4"})
+	if err != nil {
+		t.Fatalf("transcode失败: %v", err)
+	}
+	if len(processor.transcoded) != 2 {
+		t.Fatalf("720p的视频应该转出2档, got %+v", processor.transcoded)
+	}
+	if result.HLSURL != "http://127.0.0.1:8080/media/hls/7/master.m3u8" || result.DurationMS != 90000 || result.Width != 1280 || result.Height != 720 {
+		t.Fatalf("result = %+v", result)
+	}
+	if result.CoverURL != "http://127.0.0.1:8080/media/covers/generated/7.jpg" {
+		t.Fatalf("没有封面时应该截取一帧, got %q", result.CoverURL)
+	}
+	for key, contentType := range map[string]string{
+		"hls/7/master.m3u8":      "application/vnd.apple.mpegurl",
+		"hls/7/720p/index.m3u8":  "application/vnd.apple.mpegurl",
+		"hls/7/480p/seg_0000.ts": "video/mp2t",
+		"covers/generated/7.jpg": "image/jpeg",
+	} {
+		if _, err := store.Stat(ctx, key); err != nil || store.contentTypes[key] != contentType {
+			t.Fatalf("%s: Content-Type %q, %v", key, store.contentTypes[key], err)
+		}
+	}
+	rc, err := store.Open(ctx, "hls/7/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, _ := io.ReadAll(rc)
+	rc.Close()
+	if !strings.Contains(string(master), "RESOLUTION=854x480") || !strings.Contains(string(master), "720p/index.m3u8") {
+		t.Fatalf("主播放列表不对:\n%s", master)
+	}
+
+	// 已经有封面的不截取；封面截取失败也不影响转码结果
+	processor.coverErr = fmt.Errorf("%w: 截图失败", media.ErrInvalidMedia)
+	video.CoverURL = "http://example.com/cover.jpg"
+	result, err = s.transcode(ctx, video, &model.MediaFile{StorageKey: "videos/ab/ab12.mp4"})
+	if err != nil || result.CoverURL != "" {
+		t.Fatalf("result = %+v, %v", result, err)
+	}
+
+	// 原始文件不在存储中，重试也不会成功
+	_, err = s.transcode(ctx, video, &model.MediaFile{StorageKey: "videos/cd/cd34.mp4"})
+	if !errors.Is(err, media.ErrInvalidMedia) {
+		t.Fatalf("got %v, want ErrInvalidMedia", err)
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"time"
+)
+
+// 每轮最多删除多少批，剩下的下一轮继续，一轮不会占用数据库太久
+const messageCleanupMaxBatches = 100
+
+// MessageCleanupService 清理outbox_messages中已经投递的消息和consumed_messages中的消费记录，由scheduler定时执行
+type MessageCleanupService interface {
+	// Cleanup 删除超过保留时间的已投递消息和消费记录，返回这一轮删除的条数；多个实例同时执行是安全的
+	Cleanup() (int, error)
+}
+
+type messageCleanupService struct {
+	outboxRepo repository.OutboxRepository
+	inboxRepo  repository.InboxRepository
+	cfg        config.OutboxConfig
+}
+
+func NewMessageCleanupService(outboxRepo repository.OutboxRepository, inboxRepo repository.InboxRepository, cfg config.OutboxConfig) MessageCleanupService {
+	return &messageCleanupService{outboxRepo: outboxRepo, inboxRepo: inboxRepo, cfg: cfg}
+}
+
+func (s *messageCleanupService) Cleanup() (int, error) {
+	now := time.Now()
+	sent, err := deleteInBatches(s.cfg.CleanupBatch, func(limit int) (int64, error) {
+		return s.outboxRepo.DeleteSentBefore(now.Add(-s.cfg.SentRetention), limit)
+	})
+	if err != nil {
+		return int(sent), err
+	}
+	consumed, err := deleteInBatches(s.cfg.CleanupBatch, func(limit int) (int64, error) {
+		return s.inboxRepo.DeleteBefore(now.Add(-s.cfg.InboxRetention), limit)
+	})
+	return int(sent + consumed), err
+}
+
+// deleteInBatches 一批一批地删，某一批不满说明已经删完了
+func deleteInBatches(batch int, del func(limit int) (int64, error)) (int64, error) {
+	var total int64
+	for i := 0; i < messageCleanupMaxBatches; i++ {
+		n, err := del(batch)
+		total += n
+		if err != nil || n < int64(batch) {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"testing"
+	"time"
+)
+
+// cleanupOutbox 内存中的已投递消息，只实现清理用到的方法
+type cleanupOutbox struct {
+	repository.OutboxRepository
+	sentAt []time.Time
+	calls  int
+}
+
+func (o *cleanupOutbox) DeleteSentBefore(before time.Time, limit int) (int64, error) {
+	o.calls++
+	var kept []time.Time
+	var n int64
+	for _, t := range o.sentAt {
+		if t.Before(before) && n < int64(limit) {
+			n++
+			continue
+		}
+		kept = append(kept, t)
+	}
+	o.sentAt = kept
+	return n, nil
+}
+
+type cleanupInbox struct {
+	repository.InboxRepository
+	consumedAt []time.Time
+}
+
+func (i *cleanupInbox) DeleteBefore(before time.Time, limit int) (int64, error) {
+	var kept []time.Time
+	var n int64
+	for _, t := range i.consumedAt {
+		if t.Before(before) && n < int64(limit) {
+			n++
+			continue
+		}
+		kept = append(kept, t)
+	}
+	i.consumedAt = kept
+	return n, nil
+}
+
+func TestMessageCleanup(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	outbox := &cleanupOutbox{sentAt: []time.Time{ago(100 * time.Hour), ago(80 * time.Hour), ago(73 * time.Hour), ago(time.Hour)}}
+	inbox := &cleanupInbox{consumedAt: []time.Time{ago(200 * time.Hour), ago(100 * time.Hour), ago(time.Minute)}}
+	s := NewMessageCleanupService(outbox, inbox, config.OutboxConfig{
+		SentRetention:  72 * time.Hour,
+		InboxRetention: 168 * time.Hour,
+		CleanupBatch:   2,
+	})
+
+	n, err := s.Cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3条过期的已投递消息分两批删完，1条过期的消费记录；保留时间内的都不动
+	if n != 4 {
+		t.Errorf("删除了 %d 条, want 4", n)
+	}
+	if len(outbox.sentAt) != 1 || outbox.calls != 2 {
+		t.Errorf("outbox剩下 %d 条、删了 %d 批, want 1条、2批", len(outbox.sentAt), outbox.calls)
+	}
+	if len(inbox.consumedAt) != 2 {
+		t.Errorf("inbox剩下 %d 条, want 2", len(inbox.consumedAt))
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"Orion_Live/pkg/cursor"
+	"errors"
+)
+
+// ErrInvalidCursor 分页游标被篡改、属于别的列表，或者根本不是游标
+var ErrInvalidCursor = errors.New("无效的游标")
+
+// pageLimit 没传或不合法时用默认值，超过上限时按上限
+func pageLimit(limit, def, max int) int {
+	if limit <= 0 {
+		return def
+	}
+	if limit > max {
+		return max
+	}
+	return limit
+}
+
+// decodeCursor 空字符串表示第一页，返回nil
+func decodeCursor(codec *cursor.Codec, scope, token string) (*cursor.Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	cur, err := codec.Decode(scope, token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cur, nil
+}
+
+// trimPage 查询时多取一条，取到了说明还有下一页，把多的那条去掉
+func trimPage[T any](items []T, limit int) ([]T, bool) {
+	if len(items) > limit {
+		return items[:limit], true
+	}
+	return items, false
+}
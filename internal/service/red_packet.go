@@ -0,0 +1,250 @@
+package service
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/live"
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RedPacketService 直播间红包：主播发红包时扣款并预先拆好份额放进Redis，观众抢红包只走Redis，抢到的份额经RabbitMQ异步入账
+type RedPacketService interface {
+	// Send 主播在自己正在直播的直播间发红包，requestID由客户端生成，重复提交只发一个
+	Send(streamerID uint64, amount int64, count int, requestID string) (*RedPacketReceipt, error)
+	// Grab 抢红包，每人最多抢到一份；抢到过的再抢返回当时的金额
+	Grab(packetID string, userID uint64) (*RedPacketGrabResult, error)
+	// Get 红包详情和已经入账的份额
+	Get(packetID string) (*model.RedPacket, []model.RedPacketGrab, error)
+}
+
+var (
+	ErrRedPacketAmountInvalid = errors.New("红包金额或份数不合法，每份至少1金币")
+	ErrRedPacketNotFound      = errors.New("红包不存在")
+	ErrRedPacketEmpty         = errors.New("红包已经被抢完了")
+	ErrRedPacketExpired       = errors.New("红包已过期")
+)
+
+// RedPacketReceipt 发红包的结果
+type RedPacketReceipt struct {
+	Packet *model.RedPacket
+	// 同一个requestID已经发过，这次没有再扣款
+	Duplicate bool
+}
+
+// RedPacketGrabResult 抢到的一份，入账是异步的，稍后出现在钱包流水里
+type RedPacketGrabResult struct {
+	PacketID string
+	Amount   int64
+	// 之前已经抢到过
+	Duplicate bool
+}
+
+// RedPacketEvent 推送给直播间观众的新红包
+type RedPacketEvent struct {
+	PacketID  string `json:"packet_id"`
+	RoomID    uint64 `json:"room_id"`
+	SenderID  uint64 `json:"sender_id"`
+	Amount    int64  `json:"amount"`
+	Count     int    `json:"count"`
+	ExpiresAt int64  `json:"expires_at"` // 毫秒时间戳
+}
+
+type redPacketService struct {
+	roomRepo      repository.LiveRoomRepository
+	walletRepo    repository.WalletRepository
+	redPacketRepo repository.RedPacketRepository
+	outboxRepo    repository.OutboxRepository
+	uow           data.UnitOfWork
+	broadcaster   RoomBroadcaster
+	cfg           config.RedPacketConfig
+}
+
+func NewRedPacketService(roomRepo repository.LiveRoomRepository, walletRepo repository.WalletRepository, redPacketRepo repository.RedPacketRepository, outboxRepo repository.OutboxRepository, uow data.UnitOfWork, broadcaster RoomBroadcaster, cfg config.RedPacketConfig) RedPacketService {
+	return &redPacketService{
+		roomRepo:      roomRepo,
+		walletRepo:    walletRepo,
+		redPacketRepo: redPacketRepo,
+		outboxRepo:    outboxRepo,
+		uow:           uow,
+		broadcaster:   broadcaster,
+		cfg:           cfg,
+	}
+}
+
+// SplitRedPacket 二倍均值法：剩下n份、m金币时，这一份在[1, 2m/n-1]中随机，最后一份拿走剩下的全部
+// 每份至少1金币，期望都是m/n，先抢后抢的期望相同；randN(n)返回[0, n)中的随机数
+func SplitRedPacket(amount int64, count int, randN func(n int64) int64) []int64 {
+	shares := make([]int64, 0, count)
+	remaining := amount
+	for n := int64(count); n > 1; n-- {
+		upper := remaining*2/n - 1
+		share := int64(1)
+		if upper > 1 {
+			share += randN(upper)
+		}
+		shares = append(shares, share)
+		remaining -= share
+	}
+	return append(shares, remaining)
+}
+
+// 发红包：1、校验金额、份数，主播的直播间必须在直播 2、同一个requestID已经发过则直接返回 3、检查可用余额（扣除还没落库的送礼）
+// 4、在一个事务中写入红包，并把金币从主播转到平台账户托管 5、拆好份额放进Redis 6、推送到直播间
+// 第5步失败时金币已经扣了，红包没人抢得到，过期后会全额退回
+func (s *redPacketService) Send(streamerID uint64, amount int64, count int, requestID string) (*RedPacketReceipt, error) {
+	if requestID == "" || len(requestID) > 64 {
+		return nil, ErrRequestIDInvalid
+	}
+	if count <= 0 || count > s.cfg.MaxCount || amount < int64(count) || amount > s.cfg.MaxAmount {
+		return nil, ErrRedPacketAmountInvalid
+	}
+	room, err := s.roomRepo.FindByStreamer(streamerID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLiveRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if room.Status != model.LiveRoomLive {
+		return nil, ErrRoomNotLive
+	}
+	if existing, err := s.redPacketRepo.FindByRequest(streamerID, requestID); err == nil {
+		return &RedPacketReceipt{Packet: existing, Duplicate: true}, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	// 托管转账只检查MySQL余额，这里先把冻结中的送礼也算上
+	available, err := s.walletRepo.AvailableBalance(streamerID)
+	if err != nil {
+		return nil, err
+	}
+	if available < amount {
+		return nil, ErrInsufficientBalance
+	}
+
+	now := time.Now()
+	packet := &model.RedPacket{
+		ID:        message.NewID(),
+		SenderID:  streamerID,
+		RequestID: requestID,
+		RoomID:    room.ID,
+		SessionID: room.SessionID,
+		Amount:    amount,
+		Count:     count,
+		Status:    model.RedPacketActive,
+		ExpiresAt: now.Add(s.cfg.TTL),
+	}
+	err = s.uow.Execute(func(repos *data.TransactionalRepositories) error {
+		if err := repos.RedPacketRepo.Create(packet); err != nil {
+			return err
+		}
+		return repos.WalletRepo.Transfer(repository.Transfer{
+			TransferID: "redpacket:" + packet.ID,
+			FromUserID: streamerID,
+			ToUserID:   model.PlatformAccountID,
+			Amount:     amount,
+			FromType:   model.LedgerRedPacketSend,
+			ToType:     model.LedgerRedPacketSend,
+		})
+	})
+	switch {
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		return nil, ErrInsufficientBalance
+	case repository.IsDuplicateEntry(err):
+		// 并发的重复提交，另一个请求已经发出去了
+		existing, findErr := s.redPacketRepo.FindByRequest(streamerID, requestID)
+		if findErr != nil {
+			return nil, findErr
+		}
+		return &RedPacketReceipt{Packet: existing, Duplicate: true}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	logCtx := logger.Log.WithField("packet_id", packet.ID).WithField("room_id", room.ID).WithField("amount", amount).WithField("count", count)
+	if err := s.redPacketRepo.Publish(packet, SplitRedPacket(amount, count, rand.Int64N)); err != nil {
+		logCtx.WithError(err).Error("红包份额写入Redis失败，金币将在过期后退回")
+		return nil, errors.New("系统错误，红包发送失败，金币将在过期后退回")
+	}
+	event := live.Event{Type: live.EventRedPacket, Data: RedPacketEvent{
+		PacketID:  packet.ID,
+		RoomID:    room.ID,
+		SenderID:  streamerID,
+		Amount:    amount,
+		Count:     count,
+		ExpiresAt: packet.ExpiresAt.UnixMilli(),
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	// 推送失败不影响红包本身，观众刷新直播间也能看到
+	if err := s.broadcaster.Publish(ctx, room.ID, event); err != nil {
+		logCtx.WithError(err).Warn("推送红包到直播间失败")
+	}
+	logCtx.Info("红包已发出")
+	return &RedPacketReceipt{Packet: packet}, nil
+}
+
+// 抢红包：1、Redis中用Lua脚本原子地检查过期、去重并弹出一份 2、写入outbox，由消费者从平台账户转给观众
+// outbox写入失败时把这一份放回去，观众可以再抢
+func (s *redPacketService) Grab(packetID string, userID uint64) (*RedPacketGrabResult, error) {
+	result, amount, err := s.redPacketRepo.Grab(packetID, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	switch result {
+	case repository.RedPacketGrabDuplicate:
+		return &RedPacketGrabResult{PacketID: packetID, Amount: amount, Duplicate: true}, nil
+	case repository.RedPacketGrabEmpty:
+		return nil, ErrRedPacketEmpty
+	case repository.RedPacketGrabNotFound:
+		return nil, ErrRedPacketNotFound
+	case repository.RedPacketGrabExpired:
+		return nil, ErrRedPacketExpired
+	}
+
+	logCtx := logger.Log.WithField("packet_id", packetID).WithField("user_id", userID).WithField("amount", amount)
+	outboxMsg, err := message.NewOutbox(message.QueueRedPacket, message.RedPacketGrabMessage{
+		PacketID:  packetID,
+		UserID:    userID,
+		Amount:    amount,
+		GrabbedAt: time.Now().UnixMilli(),
+	})
+	if err == nil {
+		err = s.outboxRepo.Create(outboxMsg)
+	}
+	if err != nil {
+		released, releaseErr := s.redPacketRepo.ReleaseGrab(packetID, userID, amount)
+		if releaseErr != nil || !released {
+			// 红包恰好过期了，这一份既没有入账也不在退还金额里，留在平台账户，需要人工处理
+			logCtx.WithError(releaseErr).Error("抢到的红包没能放回")
+		}
+		logCtx.WithError(err).Error("抢红包消息写入outbox失败")
+		return nil, errors.New("系统错误，请重试")
+	}
+	logCtx.Info("抢到红包")
+	return &RedPacketGrabResult{PacketID: packetID, Amount: amount}, nil
+}
+
+func (s *redPacketService) Get(packetID string) (*model.RedPacket, []model.RedPacketGrab, error) {
+	packet, err := s.redPacketRepo.FindByID(packetID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrRedPacketNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	grabs, err := s.redPacketRepo.ListGrabs(packetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return packet, grabs, nil
+}
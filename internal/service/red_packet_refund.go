@@ -0,0 +1,85 @@
+package service
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"fmt"
+	"time"
+)
+
+// 每轮最多退还多少个过期红包，剩下的下一轮继续
+const redPacketRefundBatch = 100
+
+// RedPacketRefundService 把过期红包没抢完的金币从平台账户退回主播，由scheduler定时执行
+type RedPacketRefundService interface {
+	// RefundExpired 退还已经过期的红包，返回这一轮退还的个数；多个实例同时执行是安全的
+	RefundExpired() (int, error)
+}
+
+type redPacketRefundService struct {
+	redPacketRepo repository.RedPacketRepository
+	uow           data.UnitOfWork
+}
+
+func NewRedPacketRefundService(redPacketRepo repository.RedPacketRepository, uow data.UnitOfWork) RedPacketRefundService {
+	return &redPacketRefundService{redPacketRepo: redPacketRepo, uow: uow}
+}
+
+func (s *redPacketRefundService) RefundExpired() (int, error) {
+	packets, err := s.redPacketRepo.ListExpired(time.Now(), redPacketRefundBatch)
+	if err != nil {
+		return 0, err
+	}
+	refunded := 0
+	for i := range packets {
+		if err := s.refund(&packets[i]); err != nil {
+			// 一个红包失败不影响其他的，下一轮重试
+			logger.Log.WithError(err).WithField("packet_id", packets[i].ID).Error("退还过期红包失败")
+			continue
+		}
+		refunded++
+	}
+	return refunded, nil
+}
+
+// 退还一个红包：1、在Redis中停止抢红包，取出没抢完的金额 2、在一个事务中把红包标记为refunded，并从平台账户转回主播
+// 退还金额以Redis剩下的份额为准，已经抢到、还在路上的份额照常入账，两者加起来正好是红包金额
+// Redis中没有这个红包（发红包时写入失败，或数据丢失）时没有人抢得到，退还全部还没入账的金额
+func (s *redPacketRefundService) refund(packet *model.RedPacket) error {
+	remaining, found, err := s.redPacketRepo.Expire(packet.ID)
+	if err != nil {
+		return fmt.Errorf("停止抢红包失败: %w", err)
+	}
+	if !found {
+		remaining = packet.Amount - packet.GrabbedAmount
+	}
+	err = s.uow.Execute(func(repos *data.TransactionalRepositories) error {
+		marked, err := repos.RedPacketRepo.MarkRefunded(packet.ID, remaining)
+		if err != nil || !marked {
+			// 另一个实例已经退还过了
+			return err
+		}
+		if remaining == 0 {
+			return nil
+		}
+		return repos.WalletRepo.Transfer(repository.Transfer{
+			TransferID: "redpacket_refund:" + packet.ID,
+			FromUserID: model.PlatformAccountID,
+			ToUserID:   packet.SenderID,
+			Amount:     remaining,
+			FromType:   model.LedgerRedPacketRefund,
+			ToType:     model.LedgerRedPacketRefund,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	logger.Log.WithField("packet_id", packet.ID).
+		WithField("sender_id", packet.SenderID).
+		WithField("refunded", remaining).
+		WithField("found_in_redis", found).
+		Info("过期红包已退还")
+	return nil
+}
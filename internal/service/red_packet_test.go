@@ -0,0 +1,33 @@
+package service
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func TestSplitRedPacket(t *testing.T) {
+	cases := []struct {
+		amount int64
+		count  int
+	}{
+		{100, 1}, {100, 10}, {10, 10}, {11, 10}, {100000, 200},
+	}
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			shares := SplitRedPacket(c.amount, c.count, rand.Int64N)
+			if len(shares) != c.count {
+				t.Fatalf("%d金币拆%d份, got %d份", c.amount, c.count, len(shares))
+			}
+			var sum int64
+			for _, share := range shares {
+				if share < 1 {
+					t.Fatalf("%d金币拆%d份出现了%d金币的份额: %v", c.amount, c.count, share, shares)
+				}
+				sum += share
+			}
+			if sum != c.amount {
+				t.Fatalf("%d金币拆%d份, 份额加起来是%d", c.amount, c.count, sum)
+			}
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/logger"
+	"Orion_Live/pkg/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UploadService 上传视频和封面：小文件一次性上传，大文件分片上传；上传完成后得到文件ID，发布视频时引用
+type UploadService interface {
+	// Upload 一次性上传，size是r的字节数
+	Upload(userID uint64, kind string, r io.Reader, size int64) (*model.MediaFile, error)
+	// CreateUpload 开始一次分片上传，分片大小由服务端决定
+	CreateUpload(userID uint64, kind string, size int64) (*repository.UploadSession, error)
+	// UploadChunk 上传一个分片，index从0开始；重复上传同一个分片会覆盖
+	UploadChunk(userID uint64, uploadID string, index int, r io.Reader, size int64) error
+	// GetUpload 上传进度，断线后据此只补传缺少的分片
+	GetUpload(userID uint64, uploadID string) (*repository.UploadSession, error)
+	// CompleteUpload 所有分片都到齐后合并成一个文件；重复调用返回同一个文件
+	CompleteUpload(userID uint64, uploadID string) (*model.MediaFile, error)
+	// CleanupExpired 删除过期没有完成的上传留下的分片，由scheduler定时执行，返回清理的上传数
+	CleanupExpired() (int, error)
+}
+
+var (
+	ErrMediaKindInvalid = errors.New("文件用途只能是video或cover")
+	ErrFileEmpty        = errors.New("文件不能为空")
+	ErrFileTooLarge     = errors.New("文件太大")
+	ErrFileTypeInvalid  = errors.New("不支持的文件格式")
+	// 实际收到的字节数和声明的大小不一致，通常是上传中断了
+	ErrFileSizeMismatch = errors.New("文件大小和声明的不一致")
+	ErrUploadNotFound   = errors.New("上传不存在或已过期")
+	ErrChunkInvalid     = errors.New("分片序号或大小不正确")
+	ErrUploadIncomplete = errors.New("还有分片没有上传")
+)
+
+// 允许的格式按文件头识别，不相信客户端给的Content-Type和扩展名；值是存储时用的扩展名
+var allowedMediaTypes = map[string]map[string]string{
+	model.MediaVideo: {
+		"video/mp4":  ".mp4",
+		"video/webm": ".webm",
+	},
+	model.MediaCover: {
+		"image/jpeg": ".jpg",
+		"image/png":  ".png",
+		"image/webp": ".webp",
+	},
+}
+
+// 每轮最多清理多少个过期上传
+const uploadCleanupBatch = 100
+
+type uploadService struct {
+	mediaRepo repository.MediaRepository
+	store     storage.Backend
+	cfg       config.UploadConfig
+}
+
+func NewUploadService(mediaRepo repository.MediaRepository, store storage.Backend, cfg config.UploadConfig) UploadService {
+	return &uploadService{mediaRepo: mediaRepo, store: store, cfg: cfg}
+}
+
+func (s *uploadService) maxSize(kind string) (int64, error) {
+	switch kind {
+	case model.MediaVideo:
+		return s.cfg.MaxVideoSize, nil
+	case model.MediaCover:
+		return s.cfg.MaxCoverSize, nil
+	default:
+		return 0, ErrMediaKindInvalid
+	}
+}
+
+func (s *uploadService) checkSize(kind string, size int64) error {
+	max, err := s.maxSize(kind)
+	if err != nil {
+		return err
+	}
+	if size <= 0 {
+		return ErrFileEmpty
+	}
+	if size > max {
+		return ErrFileTooLarge
+	}
+	return nil
+}
+
+// chunkKey 分片在存储中的位置，合并后删除
+func chunkKey(uploadID string, index int) string {
+	return fmt.Sprintf("uploads/%s/%d", uploadID, index)
+}
+
+// mediaKey 按内容哈希命名，前两位做一级目录，避免一个目录下文件太多
+func mediaKey(kind, hash, ext string) string {
+	return fmt.Sprintf("%ss/%s/%s%s", kind, hash[:2], hash, ext)
+}
+
+func (s *uploadService) Upload(userID uint64, kind string, r io.Reader, size int64) (*model.MediaFile, error) {
+	if err := s.checkSize(kind, size); err != nil {
+		return nil, err
+	}
+	return s.ingest(userID, kind, r, size)
+}
+
+// ingest 1、边写本地临时文件边计算SHA-256 2、按文件头识别格式 3、同一个用户上传过则直接返回 4、其他人上传过则复用存储中的对象，否则写入存储 5、插入文件记录
+func (s *uploadService) ingest(userID uint64, kind string, r io.Reader, size int64) (*model.MediaFile, error) {
+	tmp, err := os.CreateTemp(s.cfg.TempDir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	hasher := sha256.New()
+	// 多读一个字节，客户端发的比声明的多也能发现
+	n, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(r, size+1))
+	if err != nil {
+		return nil, err
+	}
+	if n != size {
+		return nil, ErrFileSizeMismatch
+	}
+	head := make([]byte, 512)
+	m, err := tmp.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head[:m])
+	ext, ok := allowedMediaTypes[kind][contentType]
+	if !ok {
+		return nil, ErrFileTypeInvalid
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	logCtx := logger.Log.WithField("user_id", userID).WithField("kind", kind).WithField("hash", hash).WithField("size", size)
+
+	if file, err := s.mediaRepo.FindByUploaderHash(userID, kind, hash); err == nil {
+		logCtx.WithField("file_id", file.ID).Info("重复上传，返回已有的文件")
+		return file, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	key := mediaKey(kind, hash, ext)
+	shared, err := s.mediaRepo.FindByHash(hash)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if shared != nil {
+		key = shared.StorageKey
+		logCtx.WithField("key", key).Info("存储中已有相同内容，不再重复写入")
+	} else {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := s.store.Put(context.Background(), key, tmp, size, contentType); err != nil {
+			return nil, fmt.Errorf("写入存储失败: %w", err)
+		}
+	}
+
+	file := &model.MediaFile{
+		UploaderID:  userID,
+		Kind:        kind,
+		Hash:        hash,
+		Size:        size,
+		ContentType: contentType,
+		StorageKey:  key,
+		URL:         s.store.URL(key),
+	}
+	if err := s.mediaRepo.Create(file); err != nil {
+		// 同一个用户并发上传同一个文件
+		if repository.IsDuplicateEntry(err) {
+			return s.mediaRepo.FindByUploaderHash(userID, kind, hash)
+		}
+		return nil, err
+	}
+	logCtx.WithField("file_id", file.ID).WithField("key", key).Info("文件上传完成")
+	return file, nil
+}
+
+func (s *uploadService) CreateUpload(userID uint64, kind string, size int64) (*repository.UploadSession, error) {
+	if err := s.checkSize(kind, size); err != nil {
+		return nil, err
+	}
+	session := &repository.UploadSession{
+		ID:        message.NewID(),
+		UserID:    userID,
+		Kind:      kind,
+		Size:      size,
+		ChunkSize: s.cfg.ChunkSize,
+		Chunks:    int((size + s.cfg.ChunkSize - 1) / s.cfg.ChunkSize),
+		ExpiresAt: time.Now().Add(s.cfg.SessionTTL),
+	}
+	if err := s.mediaRepo.CreateUpload(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetUpload 别人的上传和不存在一样返回ErrUploadNotFound
+func (s *uploadService) GetUpload(userID uint64, uploadID string) (*repository.UploadSession, error) {
+	session, err := s.mediaRepo.GetUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserID != userID {
+		return nil, ErrUploadNotFound
+	}
+	return session, nil
+}
+
+// chunkSize 除了最后一片，每片都是ChunkSize
+func chunkSize(session *repository.UploadSession, index int) int64 {
+	if index == session.Chunks-1 {
+		return session.Size - session.ChunkSize*int64(session.Chunks-1)
+	}
+	return session.ChunkSize
+}
+
+// 上传分片：1、校验会话、序号和这一片的大小 2、写入存储 3、记下收到了这一片；先写存储再记录，记下的分片一定存在
+func (s *uploadService) UploadChunk(userID uint64, uploadID string, index int, r io.Reader, size int64) error {
+	session, err := s.GetUpload(userID, uploadID)
+	if err != nil {
+		return err
+	}
+	if session.FileID != 0 {
+		// 已经合并完成，分片不再需要
+		return nil
+	}
+	if index < 0 || index >= session.Chunks || size != chunkSize(session, index) {
+		return ErrChunkInvalid
+	}
+	if err := s.store.Put(context.Background(), chunkKey(uploadID, index), r, size, "application/octet-stream"); err != nil {
+		return fmt.Errorf("写入分片失败: %w", err)
+	}
+	return s.mediaRepo.AddChunk(uploadID, index, session.ExpiresAt)
+}
+
+// 完成分片上传：1、检查分片是否到齐 2、按顺序把分片串成一个流，和一次性上传一样校验、去重、写入 3、记下文件ID并删除分片
+func (s *uploadService) CompleteUpload(userID uint64, uploadID string) (*model.MediaFile, error) {
+	session, err := s.GetUpload(userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if session.FileID != 0 {
+		return s.mediaRepo.FindByID(session.FileID)
+	}
+	if len(session.Received) != session.Chunks {
+		return nil, ErrUploadIncomplete
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.copyChunks(pw, session))
+	}()
+	file, err := s.ingest(userID, session.Kind, pr, session.Size)
+	// ingest提前返回时让写分片的goroutine退出
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mediaRepo.CompleteUpload(uploadID, session.Chunks, file.ID); err != nil {
+		return nil, err
+	}
+	s.deleteChunks(uploadID, session.Chunks)
+	return file, nil
+}
+
+func (s *uploadService) copyChunks(w io.Writer, session *repository.UploadSession) error {
+	for i := 0; i < session.Chunks; i++ {
+		rc, err := s.store.Open(context.Background(), chunkKey(session.ID, i))
+		if err != nil {
+			return fmt.Errorf("读取分片%d失败: %w", i, err)
+		}
+		_, err = io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteChunks 删除失败只记录日志，多出来的分片不影响结果
+func (s *uploadService) deleteChunks(uploadID string, chunks int) {
+	for i := 0; i < chunks; i++ {
+		if err := s.store.Delete(context.Background(), chunkKey(uploadID, i)); err != nil {
+			logger.Log.WithError(err).WithField("upload_id", uploadID).WithField("index", i).Warn("删除分片失败")
+		}
+	}
+}
+
+func (s *uploadService) CleanupExpired() (int, error) {
+	uploads, err := s.mediaRepo.ListExpiredUploads(time.Now(), uploadCleanupBatch)
+	if err != nil {
+		return 0, err
+	}
+	for _, upload := range uploads {
+		s.deleteChunks(upload.ID, upload.Chunks)
+		if err := s.mediaRepo.RemoveExpiredUpload(upload); err != nil {
+			return 0, err
+		}
+	}
+	return len(uploads), nil
+}
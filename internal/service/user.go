@@ -3,8 +3,8 @@ package service
 import (
 	"Orion_Live/internal/model"
 	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
 	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -21,11 +21,12 @@ type UserService interface {
 // 用户服务包装
 type userService struct {
 	userRepo repository.UserRepository
+	jwtCfg   config.JWTConfig
 }
 
 // 包装函数
-func NewUserService(userRepo repository.UserRepository) *userService {
-	return &userService{userRepo: userRepo}
+func NewUserService(userRepo repository.UserRepository, jwtCfg config.JWTConfig) *userService {
+	return &userService{userRepo: userRepo, jwtCfg: jwtCfg}
 }
 
 // 注册逻辑：1、检查是否重名 2、密码加密存储 3、创建用户表项 4、插入数据库
@@ -69,13 +70,13 @@ func (s *userService) Login(username, password string) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id":  user.ID,
 		"username": user.Username,
-		"exp":      time.Now().Add(time.Hour * 72).Unix(), // 过期时间，这里设置为72小时
-		"iat":      time.Now().Unix(),                     // 签发时间
+		"exp":      time.Now().Add(s.jwtCfg.Expire).Unix(), // 过期时间，由配置jwt.expire决定
+		"iat":      time.Now().Unix(),                      // 签发时间
 	}
 	// token加上Header，算法信息HS256，对称加密
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	secretKey := []byte(os.Getenv("JWT_SECRET_KEY"))
+	secretKey := []byte(s.jwtCfg.Secret)
 	// 对token对象中的Header和Payload进行签名，用于防伪（Header.Payload.Signature）
 	tokenString, err := token.SignedString(secretKey)
 	if err != nil {
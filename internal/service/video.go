@@ -1,64 +1,233 @@
 package service
 
 import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/message"
 	"Orion_Live/internal/model"
 	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/cursor"
+	"Orion_Live/pkg/logger"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
 )
 
 type VideoService interface {
-	CreateVideo(authorID uint64, title, description string) (*model.Video, error)
-	GetFeed(limit uint64) ([]model.Video, error)
+	// CreateVideo 用已经上传完成的文件发布视频，封面可以不传（coverFileID为0）；转码完成前视频不能被查看
+	// publishing全部为nil时公开、立即发布，也可以存为草稿或者定时发布
+	CreateVideo(authorID uint64, title, description string, videoFileID, coverFileID uint64, publishing VideoPublishing) (*model.Video, error)
+	// GetFeed 已发布、公开、转码完成的视频，按发布时间倒序；cursor为空时从最新的开始，不合法时返回ErrInvalidCursor
+	GetFeed(cursor string, limit int) (*FeedPage, error)
 
-	GetVideoByID(videoID uint64) (*model.Video, error)
+	// GetVideoByID viewerID为0表示没有登录；作者能看到自己任何状态的视频，其他人只能看到已发布、转码完成、可见范围允许的视频，
+	// 看不到的和不存在一样返回ErrVideoNotFound
+	GetVideoByID(viewerID, videoID uint64) (*model.Video, error)
+	// GetReplay 直播回放对应的那场直播和弹幕时间轴，不是回放的视频返回nil
+	GetReplay(video *model.Video) (*model.LiveSession, []model.Danmaku, error)
+
+	// UpdateVideo 作者修改标题、简介、封面、可见范围或发布方式，version是客户端读到的版本，期间被改过时返回ErrVideoVersionConflict
+	UpdateVideo(authorID, videoID, version uint64, update VideoUpdate) (*model.Video, error)
+	// DeleteVideo 作者删除自己的视频（软删除），version为nil时不检查版本
+	DeleteVideo(authorID, videoID uint64, version *uint64) error
+}
+
+// FeedPage 一页Feed，HasMore时用NextCursor取下一页
+type FeedPage struct {
+	Videos     []model.Video
+	NextCursor string
+	HasMore    bool
+}
+
+// Feed每页默认和最多多少个视频
+const (
+	feedDefaultLimit = 20
+	feedMaxLimit     = 100
+)
+
+// Feed游标的scope，评论列表的游标不能拿来翻Feed
+const feedCursorScope = "feed"
+
+// VideoUpdate 要修改的字段，nil表示不修改
+type VideoUpdate struct {
+	Title       *string
+	Description *string
+	// 换一张已经上传的封面
+	CoverFileID *uint64
+	VideoPublishing
+}
+
+// VideoPublishing 可见范围和发布方式，nil表示不修改
+type VideoPublishing struct {
+	// public、unlisted、followers或private
+	Visibility *string
+	// draft、scheduled或published；只传PublishAt时就是定时发布
+	Status    *string
+	PublishAt *time.Time
 }
 
+// 回放最多带多少条弹幕，更多的弹幕不再返回
+const replayDanmakuLimit = 10000
+
+var (
+	// 文件不存在、不是这个用户上传的、或者用途不对
+	ErrMediaFileNotFound = errors.New("上传的文件不存在")
+	ErrVideoNotFound     = errors.New("视频不存在")
+	ErrVideoNotAuthor    = errors.New("只有作者可以修改或删除视频")
+	// 客户端读到之后视频又被修改过，需要重新获取再改
+	ErrVideoVersionConflict = errors.New("视频已经被修改过，请刷新后重试")
+	ErrVideoTitleEmpty      = errors.New("标题不能为空")
+
+	ErrVideoVisibilityInvalid = errors.New("可见范围只能是public、unlisted、followers或private")
+	ErrPublishStatusInvalid   = errors.New("发布状态只能是draft、scheduled或published")
+	// 定时发布的时间要晚于现在、不超过最长期限；草稿和立即发布不能带发布时间
+	ErrPublishAtInvalid = errors.New("发布时间不合法")
+)
+
+// 删除缓存后再删一次的延迟：删除缓存和更新数据库之间，并发的读请求可能把旧数据写回缓存
+const videoCacheEvictDelay = 500 * time.Millisecond
+
 type videoService struct {
 	sf singleflight.Group
 
-	videoRepo repository.VideoRepository
+	videoRepo   repository.VideoRepository
+	roomRepo    repository.LiveRoomRepository
+	danmakuRepo repository.DanmakuRepository
+	mediaRepo   repository.MediaRepository
+	uow         data.UnitOfWork
+	access      videoAccess
+	publishCfg  config.PublishConfig
+	cursors     *cursor.Codec
 }
 
-func NewVideoService(videoRepo repository.VideoRepository) VideoService {
+func NewVideoService(videoRepo repository.VideoRepository, roomRepo repository.LiveRoomRepository, danmakuRepo repository.DanmakuRepository, mediaRepo repository.MediaRepository, followRepo repository.FollowRepository, uow data.UnitOfWork, publishCfg config.PublishConfig, cursors *cursor.Codec) VideoService {
 	return &videoService{
-		videoRepo: videoRepo,
+		videoRepo:   videoRepo,
+		roomRepo:    roomRepo,
+		danmakuRepo: danmakuRepo,
+		mediaRepo:   mediaRepo,
+		uow:         uow,
+		access:      videoAccess{videoRepo: videoRepo, followRepo: followRepo},
+		publishCfg:  publishCfg,
+		cursors:     cursors,
 	}
 }
 
-func (s *videoService) CreateVideo(authorID uint64, title, description string) (*model.Video, error) {
+// 发布视频：1、检查可见范围和发布方式，视频文件和封面必须是这个作者自己上传的，用途也要对 2、记下它们在存储中的访问地址
+// 3、在一个事务中插入uploaded状态的视频，并写入转码消息，由consumer转码成HLS，没有封面时顺便截一帧；立即发布的同时写入发布事件
+func (s *videoService) CreateVideo(authorID uint64, title, description string, videoFileID, coverFileID uint64, publishing VideoPublishing) (*model.Video, error) {
+	visibility := model.VisibilityPublic
+	if publishing.Visibility != nil {
+		if !validVisibility(*publishing.Visibility) {
+			return nil, ErrVideoVisibilityInvalid
+		}
+		visibility = *publishing.Visibility
+	}
+	now := time.Now()
+	publish, err := resolvePublish("", publishing, now, s.publishCfg.MaxDelay)
+	if err != nil {
+		return nil, err
+	}
+	if publish == nil {
+		publish = &repository.VideoPublish{Status: model.PublishPublished, At: &now}
+	}
+	videoFile, err := s.ownMediaFile(authorID, videoFileID, model.MediaVideo)
+	if err != nil {
+		return nil, err
+	}
+	coverURL := ""
+	if coverFileID != 0 {
+		coverFile, err := s.ownMediaFile(authorID, coverFileID, model.MediaCover)
+		if err != nil {
+			return nil, err
+		}
+		coverURL = coverFile.URL
+	}
 	newVideo := &model.Video{
 		AuthorID:    uint64(authorID),
 		Title:       title,
 		Description: description,
-		VideoURL:    "https://placeholder.com/video.mp4",
-		CoverURL:    "https://placeholder.com/cover.jpg",
+		VideoURL:    videoFile.URL,
+		CoverURL:    coverURL,
+
+		SourceFileID:     videoFile.ID,
+		ProcessingStatus: model.VideoUploaded,
+		Visibility:       visibility,
+		PublishStatus:    publish.Status,
+		PublishAt:        publish.At,
 	}
-	err := s.videoRepo.Create(newVideo)
+	err = s.uow.Execute(func(repos *data.TransactionalRepositories) error {
+		if err := repos.VideoRepo.Create(newVideo); err != nil {
+			return err
+		}
+		outboxMsg, err := message.NewOutbox(message.QueueMedia, message.MediaProcessMessage{VideoID: newVideo.ID})
+		if err != nil {
+			return err
+		}
+		if err := repos.OutboxRepo.Create(outboxMsg); err != nil {
+			return err
+		}
+		if newVideo.PublishStatus != model.PublishPublished {
+			return nil
+		}
+		return saveVideoPublished(repos.OutboxRepo, newVideo.ID, authorID, visibility, now)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return newVideo, nil
 }
 
-// 获取视频Feed流
-func (s *videoService) GetFeed(limit uint64) ([]model.Video, error) {
-	// 限制limit长度
-	if limit <= 0 || limit > 100 {
-		limit = 20
+func (s *videoService) ownMediaFile(userID, fileID uint64, kind string) (*model.MediaFile, error) {
+	file, err := s.mediaRepo.FindByID(fileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMediaFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file.UploaderID != userID || file.Kind != kind {
+		return nil, ErrMediaFileNotFound
+	}
+	return file, nil
+}
+
+// 获取视频Feed流：1、限制limit长度，解出游标 2、多查一条判断有没有下一页 3、用这一页最后一个视频的(发布时间, ID)生成下一页的游标
+func (s *videoService) GetFeed(cursorToken string, limit int) (*FeedPage, error) {
+	limit = pageLimit(limit, feedDefaultLimit, feedMaxLimit)
+	after, err := decodeCursor(s.cursors, feedCursorScope, cursorToken)
+	if err != nil {
+		return nil, err
 	}
-	videos, err := s.videoRepo.FindLatest(limit)
+	videos, err := s.videoRepo.ListFeed(after, limit+1)
 	if err != nil {
 		return nil, err
 	}
+	page := &FeedPage{}
+	page.Videos, page.HasMore = trimPage(videos, limit)
+	if page.HasMore {
+		last := page.Videos[len(page.Videos)-1]
+		page.NextCursor = s.cursors.Encode(feedCursorScope, cursor.Cursor{Time: last.PublishTime(), ID: last.ID})
+	}
+	return page, nil
+}
 
-	return videos, nil
+// 根据videoID查找视频：1、查找Redis缓存 2、通过SingleFlight进行数据库查找 3、按作者、发布和转码状态、可见范围检查viewerID能不能看到
+// 转码完成、发布时都会删除缓存，缓存里的状态不会一直停在转码中或定时发布
+func (s *videoService) GetVideoByID(viewerID, videoID uint64) (*model.Video, error) {
+	video, err := s.getVideo(videoID)
+	if err != nil {
+		return nil, err
+	}
+	return s.access.check(video, viewerID)
 }
 
-// 根据videoID查找视频：1、查找Redis缓存 2、通过SingleFlight进行数据库查找
-func (s *videoService) GetVideoByID(videoID uint64) (*model.Video, error) {
+func (s *videoService) getVideo(videoID uint64) (*model.Video, error) {
 
 	video, err := s.videoRepo.GetVideoCache(videoID)
 	if err == nil && video != nil {
@@ -86,3 +255,190 @@ func (s *videoService) GetVideoByID(videoID uint64) (*model.Video, error) {
 	// 虽然找到了videoID对应的视频，但返回值是interface{}结构，需要断言
 	return result.(*model.Video), nil
 }
+
+// 回放：1、按视频关联的场次找到这场直播 2、按发送时间读取这场直播的弹幕，客户端用发送时间减去开播时间对齐到视频进度
+func (s *videoService) GetReplay(video *model.Video) (*model.LiveSession, []model.Danmaku, error) {
+	if video.LiveSessionID == nil {
+		return nil, nil, nil
+	}
+	session, err := s.roomRepo.FindSessionByID(*video.LiveSessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	danmakus, err := s.danmakuRepo.ListBySession(session.ID, replayDanmakuLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, danmakus, nil
+}
+
+// 修改视频：1、从数据库读出视频，检查作者和版本 2、换封面时封面必须是作者自己上传的，检查可见范围和发布方式
+// 3、带着版本条件更新，版本加1；草稿或定时视频改为立即发布时，在同一个事务中写入发布事件 4、删除视频缓存（延迟双删），返回修改后的视频
+func (s *videoService) UpdateVideo(authorID, videoID, version uint64, update VideoUpdate) (*model.Video, error) {
+	video, err := s.ownVideo(authorID, videoID, &version)
+	if err != nil {
+		return nil, err
+	}
+	edit := repository.VideoEdit{Description: update.Description}
+	if update.Title != nil {
+		title := strings.TrimSpace(*update.Title)
+		if title == "" {
+			return nil, ErrVideoTitleEmpty
+		}
+		edit.Title = &title
+	}
+	if update.CoverFileID != nil {
+		cover, err := s.ownMediaFile(authorID, *update.CoverFileID, model.MediaCover)
+		if err != nil {
+			return nil, err
+		}
+		edit.CoverURL = &cover.URL
+	}
+	visibility := video.Visibility
+	if update.Visibility != nil {
+		if !validVisibility(*update.Visibility) {
+			return nil, ErrVideoVisibilityInvalid
+		}
+		visibility = *update.Visibility
+		edit.Visibility = &visibility
+	}
+	now := time.Now()
+	edit.Publish, err = resolvePublish(video.PublishStatus, update.VideoPublishing, now, s.publishCfg.MaxDelay)
+	if err != nil {
+		return nil, err
+	}
+	updated := false
+	err = s.uow.Execute(func(repos *data.TransactionalRepositories) error {
+		updated, err = repos.VideoRepo.UpdateByAuthor(videoID, authorID, version, edit)
+		if err != nil || !updated {
+			return err
+		}
+		if edit.Publish == nil || edit.Publish.Status != model.PublishPublished {
+			return nil
+		}
+		return saveVideoPublished(repos.OutboxRepo, videoID, authorID, visibility, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		// 检查之后、更新之前被别人改了
+		return nil, ErrVideoVersionConflict
+	}
+	s.evictVideoCache(videoID)
+	return s.videoRepo.FindByIDNoCache(videoID)
+}
+
+// 删除视频：1、检查作者和版本 2、软删除 3、清理视频缓存、点赞和黄金评论席位的Redis数据
+// 存储中的文件不删除，相同内容的文件可能被别的视频引用
+func (s *videoService) DeleteVideo(authorID, videoID uint64, version *uint64) error {
+	if _, err := s.ownVideo(authorID, videoID, version); err != nil {
+		return err
+	}
+	deleted, err := s.videoRepo.DeleteByAuthor(videoID, authorID, version)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		if version != nil {
+			return ErrVideoVersionConflict
+		}
+		// 并发的另一次删除已经删掉了
+		return ErrVideoNotFound
+	}
+	if err := s.videoRepo.DeleteVideoKeys(videoID); err != nil {
+		logger.Log.WithError(err).WithField("video_id", videoID).Error("清理视频的Redis数据失败")
+	}
+	s.evictVideoCache(videoID)
+	return nil
+}
+
+// ownVideo 以数据库为准检查作者和版本，version为nil时不检查版本
+func (s *videoService) ownVideo(authorID, videoID uint64, version *uint64) (*model.Video, error) {
+	video, err := s.videoRepo.FindByIDNoCache(videoID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrVideoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if video.AuthorID != authorID {
+		return nil, ErrVideoNotAuthor
+	}
+	if version != nil && video.Version != *version {
+		return nil, ErrVideoVersionConflict
+	}
+	return video, nil
+}
+
+// evictVideoCache 延迟双删：更新数据库后立刻删一次，过一会儿再删一次，把并发读请求写回的旧数据也删掉
+func (s *videoService) evictVideoCache(videoID uint64) {
+	logCtx := logger.Log.WithField("video_id", videoID)
+	if err := s.videoRepo.DeleteVideoCache(videoID); err != nil {
+		logCtx.WithError(err).Warn("删除视频缓存失败")
+	}
+	time.AfterFunc(videoCacheEvictDelay, func() {
+		if err := s.videoRepo.DeleteVideoCache(videoID); err != nil {
+			logCtx.WithError(err).Warn("延迟删除视频缓存失败")
+		}
+	})
+}
+
+func validVisibility(visibility string) bool {
+	switch visibility {
+	case model.VisibilityPublic, model.VisibilityUnlisted, model.VisibilityFollowers, model.VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// resolvePublish 把请求的发布方式换成要写入的状态和时间，current是视频当前的发布状态（发布新视频时为空），返回nil表示不修改
+// 已经发布的视频再“发布”不改变发布时间；已发布的视频也可以改回草稿或者定时发布，相当于先下架
+func resolvePublish(current string, p VideoPublishing, now time.Time, maxDelay time.Duration) (*repository.VideoPublish, error) {
+	var status string
+	switch {
+	case p.Status != nil:
+		status = *p.Status
+	case p.PublishAt != nil:
+		status = model.PublishScheduled
+	default:
+		return nil, nil
+	}
+	switch status {
+	case model.PublishDraft:
+		if p.PublishAt != nil {
+			return nil, ErrPublishAtInvalid
+		}
+		return &repository.VideoPublish{Status: model.PublishDraft}, nil
+	case model.PublishScheduled:
+		if p.PublishAt == nil || !p.PublishAt.After(now) || p.PublishAt.After(now.Add(maxDelay)) {
+			return nil, ErrPublishAtInvalid
+		}
+		at := *p.PublishAt
+		return &repository.VideoPublish{Status: model.PublishScheduled, At: &at}, nil
+	case model.PublishPublished:
+		if p.PublishAt != nil {
+			return nil, ErrPublishAtInvalid
+		}
+		if current == model.PublishPublished {
+			return nil, nil
+		}
+		return &repository.VideoPublish{Status: model.PublishPublished, At: &now}, nil
+	default:
+		return nil, ErrPublishStatusInvalid
+	}
+}
+
+// saveVideoPublished 写入视频发布事件，和发布状态的修改在同一个事务里
+func saveVideoPublished(outboxRepo repository.OutboxRepository, videoID, authorID uint64, visibility string, at time.Time) error {
+	outboxMsg, err := message.NewOutbox(message.QueueVideoPublished, message.VideoPublishedMessage{
+		VideoID:     videoID,
+		AuthorID:    authorID,
+		Visibility:  visibility,
+		PublishedAt: at.UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	return outboxRepo.Create(outboxMsg)
+}
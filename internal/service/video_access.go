@@ -0,0 +1,79 @@
+package service
+
+import (
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// 按视频本身的状态能得出的结论，仅粉丝可见的还要查关注关系
+type viewRule int
+
+const (
+	viewDenied viewRule = iota
+	viewAllowed
+	viewFollowersOnly
+)
+
+// videoViewRule viewerID为0表示没有登录：1、作者自己什么状态都能看 2、其他人只能看已发布、转码完成的视频 3、再按可见范围判断
+func videoViewRule(video *model.Video, viewerID uint64) viewRule {
+	if viewerID != 0 && viewerID == video.AuthorID {
+		return viewAllowed
+	}
+	if video.ProcessingStatus != model.VideoReady || video.PublishStatus != model.PublishPublished {
+		return viewDenied
+	}
+	switch video.Visibility {
+	case model.VisibilityPublic, model.VisibilityUnlisted:
+		return viewAllowed
+	case model.VisibilityFollowers:
+		if viewerID == 0 {
+			return viewDenied
+		}
+		return viewFollowersOnly
+	default:
+		return viewDenied
+	}
+}
+
+// videoAccess 查看视频、评论、点赞、黄金评论共用的可见性检查，看不到的视频和不存在一样返回ErrVideoNotFound，不暴露它的存在
+type videoAccess struct {
+	videoRepo  repository.VideoRepository
+	followRepo repository.FollowRepository
+}
+
+func (a videoAccess) canView(video *model.Video, viewerID uint64) (bool, error) {
+	switch videoViewRule(video, viewerID) {
+	case viewAllowed:
+		return true, nil
+	case viewFollowersOnly:
+		return a.followRepo.IsFollowing(viewerID, video.AuthorID)
+	default:
+		return false, nil
+	}
+}
+
+// find 读取视频（走缓存）并检查viewerID能不能看到它
+func (a videoAccess) find(videoID, viewerID uint64) (*model.Video, error) {
+	video, err := a.videoRepo.FindByID(videoID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrVideoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a.check(video, viewerID)
+}
+
+func (a videoAccess) check(video *model.Video, viewerID uint64) (*model.Video, error) {
+	ok, err := a.canView(video, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrVideoNotFound
+	}
+	return video, nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"Orion_Live/internal/model"
+	"testing"
+)
+
+func TestVideoViewRule(t *testing.T) {
+	video := func(visibility, publish, processing string) *model.Video {
+		return &model.Video{AuthorID: 1, Visibility: visibility, PublishStatus: publish, ProcessingStatus: processing}
+	}
+	ready := func(visibility string) *model.Video {
+		return video(visibility, model.PublishPublished, model.VideoReady)
+	}
+	cases := []struct {
+		name   string
+		video  *model.Video
+		viewer uint64
+		want   viewRule
+	}{
+		{"公开", ready(model.VisibilityPublic), 0, viewAllowed},
+		{"不公开的链接", ready(model.VisibilityUnlisted), 0, viewAllowed},
+		{"私密", ready(model.VisibilityPrivate), 2, viewDenied},
+		{"私密作者本人", ready(model.VisibilityPrivate), 1, viewAllowed},
+		{"仅粉丝未登录", ready(model.VisibilityFollowers), 0, viewDenied},
+		{"仅粉丝要查关注", ready(model.VisibilityFollowers), 2, viewFollowersOnly},
+		{"仅粉丝作者本人", ready(model.VisibilityFollowers), 1, viewAllowed},
+		{"草稿", video(model.VisibilityPublic, model.PublishDraft, model.VideoReady), 2, viewDenied},
+		{"定时发布", video(model.VisibilityPublic, model.PublishScheduled, model.VideoReady), 0, viewDenied},
+		{"定时发布作者本人", video(model.VisibilityPublic, model.PublishScheduled, model.VideoReady), 1, viewAllowed},
+		{"转码中", video(model.VisibilityPublic, model.PublishPublished, model.VideoProcessing), 2, viewDenied},
+		{"转码中作者本人", video(model.VisibilityPublic, model.PublishPublished, model.VideoProcessing), 1, viewAllowed},
+		{"未知的可见范围", ready("friends"), 2, viewDenied},
+	}
+	for _, c := range cases {
+		if got := videoViewRule(c.video, c.viewer); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
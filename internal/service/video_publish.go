@@ -0,0 +1,71 @@
+package service
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"time"
+)
+
+// 每轮最多发布多少个到点的视频，剩下的下一轮继续
+const videoPublishBatch = 100
+
+// VideoPublishService 把到点的定时视频发布出去，由scheduler定时执行
+type VideoPublishService interface {
+	// PublishDue 发布publish_at已到的scheduled视频，返回这一轮发布的个数；多个实例同时执行是安全的
+	PublishDue() (int, error)
+}
+
+type videoPublishService struct {
+	videoRepo repository.VideoRepository
+	uow       data.UnitOfWork
+}
+
+func NewVideoPublishService(videoRepo repository.VideoRepository, uow data.UnitOfWork) VideoPublishService {
+	return &videoPublishService{videoRepo: videoRepo, uow: uow}
+}
+
+func (s *videoPublishService) PublishDue() (int, error) {
+	now := time.Now()
+	videos, err := s.videoRepo.ListDueScheduled(now, videoPublishBatch)
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	for i := range videos {
+		ok, err := s.publish(&videos[i], now)
+		if err != nil {
+			// 一个视频失败不影响其他的，下一轮重试
+			logger.Log.WithError(err).WithField("video_id", videos[i].ID).Error("发布定时视频失败")
+			continue
+		}
+		if ok {
+			published++
+		}
+	}
+	return published, nil
+}
+
+// 发布一个视频：在一个事务中把scheduled改为published、发布时间改为now，并写入发布事件；视频缓存由消费发布事件的消费者删除
+// 条件更新没有命中说明另一个实例已经发布了，或者作者刚刚改了发布方式
+func (s *videoPublishService) publish(video *model.Video, now time.Time) (bool, error) {
+	published := false
+	err := s.uow.Execute(func(repos *data.TransactionalRepositories) error {
+		var err error
+		published, err = repos.VideoRepo.PublishScheduled(video.ID, now)
+		if err != nil || !published {
+			return err
+		}
+		return saveVideoPublished(repos.OutboxRepo, video.ID, video.AuthorID, video.Visibility, now)
+	})
+	if err != nil || !published {
+		return false, err
+	}
+	logger.Log.WithField("video_id", video.ID).
+		WithField("author_id", video.AuthorID).
+		WithField("scheduled_at", video.PublishTime()).
+		WithField("published_at", now).
+		Info("定时视频已发布")
+	return true, nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/message"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/cursor"
+	"Orion_Live/pkg/logger"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestResolvePublish(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	maxDelay := 24 * time.Hour
+	str := func(s string) *string { return &s }
+	at := func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+
+	cases := []struct {
+		name       string
+		current    string
+		p          VideoPublishing
+		wantStatus string // 空表示不修改
+		wantAt     *time.Time
+		wantErr    error
+	}{
+		{"不修改", model.PublishDraft, VideoPublishing{}, "", nil, nil},
+		{"只传时间就是定时", "", VideoPublishing{PublishAt: at(time.Hour)}, model.PublishScheduled, at(time.Hour), nil},
+		{"存为草稿", "", VideoPublishing{Status: str(model.PublishDraft)}, model.PublishDraft, nil, nil},
+		{"草稿立即发布", model.PublishDraft, VideoPublishing{Status: str(model.PublishPublished)}, model.PublishPublished, &now, nil},
+		{"已发布再发布不改时间", model.PublishPublished, VideoPublishing{Status: str(model.PublishPublished)}, "", nil, nil},
+		{"已发布改回草稿", model.PublishPublished, VideoPublishing{Status: str(model.PublishDraft)}, model.PublishDraft, nil, nil},
+		{"定时缺少时间", "", VideoPublishing{Status: str(model.PublishScheduled)}, "", nil, ErrPublishAtInvalid},
+		{"定时在过去", "", VideoPublishing{PublishAt: at(-time.Minute)}, "", nil, ErrPublishAtInvalid},
+		{"定时超过期限", "", VideoPublishing{PublishAt: at(maxDelay + time.Second)}, "", nil, ErrPublishAtInvalid},
+		{"草稿带时间", "", VideoPublishing{Status: str(model.PublishDraft), PublishAt: at(time.Hour)}, "", nil, ErrPublishAtInvalid},
+		{"未知状态", "", VideoPublishing{Status: str("hidden")}, "", nil, ErrPublishStatusInvalid},
+	}
+	for _, c := range cases {
+		got, err := resolvePublish(c.current, c.p, now, maxDelay)
+		if !errors.Is(err, c.wantErr) {
+			t.Fatalf("%s: err = %v, want %v", c.name, err, c.wantErr)
+		}
+		if c.wantStatus == "" {
+			if got != nil {
+				t.Fatalf("%s: 不应该修改, got %+v", c.name, got)
+			}
+			continue
+		}
+		if got == nil || got.Status != c.wantStatus {
+			t.Fatalf("%s: got %+v, want %s", c.name, got, c.wantStatus)
+		}
+		if (got.At == nil) != (c.wantAt == nil) || (got.At != nil && !got.At.Equal(*c.wantAt)) {
+			t.Fatalf("%s: at = %v, want %v", c.name, got.At, c.wantAt)
+		}
+	}
+}
+
+// feedVideoRepo 内存中的视频表，只实现Feed和定时发布用到的方法，语义和videoRepository的SQL一致
+type feedVideoRepo struct {
+	repository.VideoRepository
+	videos []model.Video
+}
+
+func (r *feedVideoRepo) ListFeed(after *cursor.Cursor, limit int) ([]model.Video, error) {
+	var videos []model.Video
+	for _, v := range r.videos {
+		if v.PublishStatus != model.PublishPublished {
+			continue
+		}
+		t := v.PublishTime()
+		if after != nil && !(t.Before(after.Time) || (t.Equal(after.Time) && v.ID < after.ID)) {
+			continue
+		}
+		videos = append(videos, v)
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		ti, tj := videos[i].PublishTime(), videos[j].PublishTime()
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return videos[i].ID > videos[j].ID
+	})
+	if len(videos) > limit {
+		videos = videos[:limit]
+	}
+	return videos, nil
+}
+
+func (r *feedVideoRepo) ListDueScheduled(now time.Time, limit int) ([]model.Video, error) {
+	var videos []model.Video
+	for _, v := range r.videos {
+		if v.PublishStatus == model.PublishScheduled && !v.PublishTime().After(now) {
+			videos = append(videos, v)
+		}
+	}
+	return videos, nil
+}
+
+func (r *feedVideoRepo) PublishScheduled(videoID uint64, now time.Time) (bool, error) {
+	for i := range r.videos {
+		v := &r.videos[i]
+		if v.ID == videoID && v.PublishStatus == model.PublishScheduled && !v.PublishTime().After(now) {
+			v.PublishStatus = model.PublishPublished
+			v.PublishAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type recordingOutbox struct {
+	repository.OutboxRepository
+	msgs []*model.OutboxMessage
+}
+
+func (o *recordingOutbox) Create(msg *model.OutboxMessage) error {
+	o.msgs = append(o.msgs, msg)
+	return nil
+}
+
+type fakeUnitOfWork struct {
+	repos *data.TransactionalRepositories
+}
+
+func (u fakeUnitOfWork) Execute(fn func(repos *data.TransactionalRepositories) error) error {
+	return fn(u.repos)
+}
+
+// scheduler晚了两个多小时才发布定时视频：它应该出现在Feed最前面，而不是按定时的时间排到已经发出去的游标后面
+func TestPublishDueLate(t *testing.T) {
+	if logger.Log == nil {
+		logger.Log = logrus.New()
+	}
+	base := time.Now().Add(-3 * time.Hour)
+	at := func(d time.Duration) *time.Time { t := base.Add(d); return &t }
+	repo := &feedVideoRepo{videos: []model.Video{
+		{BaseModel: model.BaseModel{ID: 1}, PublishStatus: model.PublishPublished, PublishAt: at(0)},
+		{BaseModel: model.BaseModel{ID: 2}, PublishStatus: model.PublishPublished, PublishAt: at(time.Hour)},
+		{BaseModel: model.BaseModel{ID: 3}, PublishStatus: model.PublishScheduled, PublishAt: at(30 * time.Minute)},
+	}}
+	outbox := &recordingOutbox{}
+	feed := &videoService{videoRepo: repo, cursors: cursor.NewCodec("test")}
+	publisher := NewVideoPublishService(repo, fakeUnitOfWork{repos: &data.TransactionalRepositories{VideoRepo: repo, OutboxRepo: outbox}})
+
+	first, err := feed.GetFeed("", 1)
+	if err != nil || len(first.Videos) != 1 || first.Videos[0].ID != 2 || !first.HasMore {
+		t.Fatalf("第一页 = %+v, %v", first, err)
+	}
+
+	start := time.Now()
+	if n, err := publisher.PublishDue(); err != nil || n != 1 {
+		t.Fatalf("PublishDue = %d, %v, want 1", n, err)
+	}
+	if len(outbox.msgs) != 1 {
+		t.Fatalf("发布事件 %d 条, want 1", len(outbox.msgs))
+	}
+	var msg message.VideoPublishedMessage
+	if err := json.Unmarshal(outbox.msgs[0].Payload, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.PublishedAt < start.UnixMilli() {
+		t.Errorf("发布事件的时间 = %d, want 实际发布的时间 >= %d", msg.PublishedAt, start.UnixMilli())
+	}
+
+	// 已经翻到第二页的客户端不会在后面遇到它，从头刷新时它排在最前面
+	next, err := feed.GetFeed(first.NextCursor, 10)
+	if err != nil || len(next.Videos) != 1 || next.Videos[0].ID != 1 {
+		t.Fatalf("第二页 = %+v, %v", next, err)
+	}
+	top, err := feed.GetFeed("", 10)
+	if err != nil || len(top.Videos) != 3 || top.Videos[0].ID != 3 {
+		t.Fatalf("刷新后的第一页 = %+v, %v", top, err)
+	}
+}
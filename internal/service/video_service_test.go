@@ -1,10 +1,13 @@
 package service
 
 import (
+	"Orion_Live/internal/data"
 	"Orion_Live/internal/repository"
 	"fmt"
 	"testing"
 
+	"Orion_Live/pkg/config"
+	"Orion_Live/pkg/cursor"
 	"Orion_Live/pkg/redis"
 
 	"gorm.io/driver/mysql"
@@ -14,22 +17,25 @@ import (
 
 // setupTestDB and service for benchmark
 func setupBenchmark() VideoService {
-	// 在测试中，我们也需要一个真实的数据库连接
-	dsn := "root:zhengxikun@tcp(127.0.0.1:3306)/orion_live?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+	// 在测试中，我们也需要一个真实的数据库连接；go test的工作目录是当前包，所以要指明配置目录
+	cfg, err := config.LoadWithOptions(config.Options{ConfigDir: "../../configs", EnvFile: "../../.env"})
+	if err != nil {
+		panic(fmt.Sprintf("配置加载失败: %v", err))
+	}
+	db, err := gorm.Open(mysql.Open(cfg.MySQL.DSN()), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info), // 👈 开启日志
 	})
 	if err != nil {
 		panic(fmt.Sprintf("无法连接到数据库: %v", err))
 	}
 
-	redisClient, err := redis.InitRedis()
+	redisClient, err := redis.InitRedis(cfg.Redis)
 	if err != nil {
 		panic(fmt.Sprintf("无法连接到Redis: %v", err))
 	}
 
 	videoRepo := repository.NewVideoRepository(db, redisClient)
-	videoService := NewVideoService(videoRepo) // 假设MQ暂时不用
+	videoService := NewVideoService(videoRepo, repository.NewLiveRoomRepository(db, redisClient), repository.NewDanmakuRepository(db), repository.NewMediaRepository(db, redisClient), repository.NewFollowRepository(db), data.NewUnitOfWork(db, videoRepo, repository.NewCommentRepository(db)), cfg.Publish, cursor.NewCodec(cfg.CursorSecret())) // 假设MQ暂时不用
 
 	return videoService
 }
@@ -53,7 +59,7 @@ func BenchmarkGetVideoByID_CacheBreakdown(b *testing.B) {
 		// 每个 goroutine 都会进入这个循环
 		for pb.Next() {
 			// 在这里，成百上千个goroutine会同时调用GetVideoByID
-			_, err := videoService.GetVideoByID(targetVideoID)
+			_, err := videoService.GetVideoByID(0, targetVideoID)
 			if err != nil {
 				b.Errorf("GetVideoByID failed: %v", err)
 			}
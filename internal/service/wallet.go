@@ -0,0 +1,127 @@
+package service
+
+import (
+	"Orion_Live/internal/data"
+	"Orion_Live/internal/model"
+	"Orion_Live/internal/repository"
+	"Orion_Live/pkg/logger"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WalletService 金币钱包：余额和流水以MySQL为准，每次改动都是一笔复式记账的转账
+type WalletService interface {
+	// Balance 可用余额，已经扣除了还没落库的送礼
+	Balance(userID uint64) (int64, error)
+	// Ledger 分页获取流水，按时间倒序
+	Ledger(userID uint64, page, pageSize int) ([]model.WalletLedgerEntry, error)
+	// TopUp 充值，平台账户 → 用户；transferID由调用方给出（比如支付单号），重复时返回ErrTransferDuplicate
+	TopUp(userID uint64, amount int64, transferID string) error
+	// RefundGift 退还一次已经落库的送礼，主播 → 观众，主播余额不足时失败
+	RefundGift(giftTransferID string) (*model.GiftRecord, error)
+}
+
+var (
+	ErrInsufficientBalance = errors.New("金币余额不足")
+	ErrTransferDuplicate   = errors.New("这笔转账已经处理过")
+	ErrAmountInvalid       = errors.New("金额必须大于0")
+	ErrGiftRecordNotFound  = errors.New("送礼记录不存在")
+	ErrGiftAlreadyRefunded = errors.New("这次送礼已经退过款")
+)
+
+type walletService struct {
+	walletRepo repository.WalletRepository
+	giftRepo   repository.GiftRepository
+	uow        data.UnitOfWork
+}
+
+func NewWalletService(walletRepo repository.WalletRepository, giftRepo repository.GiftRepository, uow data.UnitOfWork) WalletService {
+	return &walletService{walletRepo: walletRepo, giftRepo: giftRepo, uow: uow}
+}
+
+func (s *walletService) Balance(userID uint64) (int64, error) {
+	return s.walletRepo.AvailableBalance(userID)
+}
+
+func (s *walletService) Ledger(userID uint64, page, pageSize int) ([]model.WalletLedgerEntry, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.walletRepo.ListEntries(userID, (page-1)*pageSize, pageSize)
+}
+
+func (s *walletService) TopUp(userID uint64, amount int64, transferID string) error {
+	if amount <= 0 {
+		return ErrAmountInvalid
+	}
+	err := s.uow.Execute(func(repos *data.TransactionalRepositories) error {
+		return repos.WalletRepo.Transfer(repository.Transfer{
+			TransferID: transferID,
+			FromUserID: model.PlatformAccountID,
+			ToUserID:   userID,
+			Amount:     amount,
+			FromType:   model.LedgerTopUp,
+			ToType:     model.LedgerTopUp,
+		})
+	})
+	if repository.IsDuplicateEntry(err) {
+		return ErrTransferDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	logger.Log.WithField("user_id", userID).WithField("amount", amount).WithField("transfer_id", transferID).Info("充值成功")
+	return nil
+}
+
+// 退款：1、找到送礼记录 2、在一个事务中标记送礼记录已退款，并执行主播 → 观众的转账，转账ID是 refund:{原转账ID}
+func (s *walletService) RefundGift(giftTransferID string) (*model.GiftRecord, error) {
+	record, err := s.giftRepo.FindByTransferID(giftTransferID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGiftRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.RefundedAt != nil {
+		return nil, ErrGiftAlreadyRefunded
+	}
+	now := time.Now()
+	err = s.uow.Execute(func(repos *data.TransactionalRepositories) error {
+		marked, err := repos.GiftRepo.MarkRefunded(record.ID, now)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return ErrGiftAlreadyRefunded
+		}
+		return repos.WalletRepo.Transfer(repository.Transfer{
+			TransferID: "refund:" + record.TransferID,
+			FromUserID: record.StreamerID,
+			ToUserID:   record.SenderID,
+			Amount:     record.Amount,
+			FromType:   model.LedgerRefund,
+			ToType:     model.LedgerRefund,
+		})
+	})
+	switch {
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		return nil, ErrInsufficientBalance
+	case repository.IsDuplicateEntry(err):
+		return nil, ErrGiftAlreadyRefunded
+	case err != nil:
+		return nil, err
+	}
+	record.RefundedAt = &now
+	logger.Log.WithField("transfer_id", record.TransferID).
+		WithField("sender_id", record.SenderID).
+		WithField("streamer_id", record.StreamerID).
+		WithField("amount", record.Amount).
+		Warn("送礼已退款")
+	return record, nil
+}
@@ -0,0 +1,38 @@
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Exponential 指数退避：第n次重试等待 Min*2^n，最多不超过Max，并加上±10%的随机抖动，避免大量客户端同时重连
+type Exponential struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Duration 返回第attempt次（从0开始）重试前应该等待的时间
+func (b Exponential) Duration(attempt int) time.Duration {
+	d := b.Min
+	for i := 0; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5+1)) - d/10
+	return d + jitter
+}
+
+// Sleep 等待d，如果ctx先结束则提前返回false
+func Sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
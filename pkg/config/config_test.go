@@ -0,0 +1,114 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 优先级：config.yaml < config.{env}.yaml < .env < 环境变量
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "config.yaml"), "server:\n  port: 9000\nmysql:\n  host: base-host\n  user: base\njwt:\n  secret: from-yaml\n")
+	writeFile(t, filepath.Join(dir, "config.staging.yaml"), "mysql:\n  host: staging-host\nredis:\n  addr: staging-redis:6379\n")
+	envFile := filepath.Join(dir, ".env")
+	writeFile(t, envFile, "APP_ENV=staging\nMYSQL_USER=dotenv-user\nLIVE_CALLBACK_TOKEN=dotenv-token\nREDIS_ADDR=dotenv-redis:6379\n")
+
+	env := map[string]string{
+		"REDIS_ADDR":  "env-redis:6379",
+		"JWT_EXPIRE":  "1h",
+		"SERVER_PORT": "9100",
+	}
+	cfg, err := LoadWithOptions(Options{
+		ConfigDir: dir,
+		EnvFile:   envFile,
+		LookupEnv: func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		},
+	})
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+
+	if cfg.App.Env != EnvStaging {
+		t.Errorf("App.Env = %q, want %q", cfg.App.Env, EnvStaging)
+	}
+	if cfg.MySQL.Host != "staging-host" {
+		t.Errorf("profile文件应覆盖公共文件, MySQL.Host = %q", cfg.MySQL.Host)
+	}
+	if cfg.MySQL.User != "dotenv-user" {
+		t.Errorf(".env应覆盖配置文件, MySQL.User = %q", cfg.MySQL.User)
+	}
+	if cfg.Redis.Addr != "env-redis:6379" {
+		t.Errorf("环境变量应覆盖.env, Redis.Addr = %q", cfg.Redis.Addr)
+	}
+	if cfg.Server.Port != 9100 || cfg.JWT.Expire != time.Hour {
+		t.Errorf("环境变量类型转换错误, Port = %d, Expire = %s", cfg.Server.Port, cfg.JWT.Expire)
+	}
+	if cfg.JWT.Secret != "from-yaml" {
+		t.Errorf("未被覆盖的字段应保留, JWT.Secret = %q", cfg.JWT.Secret)
+	}
+	if cfg.MySQL.Database != "orion_live" {
+		t.Errorf("未出现的字段应保留默认值, MySQL.Database = %q", cfg.MySQL.Database)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.JWT.Secret = "short"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("dev环境不应限制密钥长度: %v", err)
+	}
+
+	cfg.App.Env = EnvProd
+	if err := cfg.Validate(); err == nil {
+		t.Error("prod环境下过短的jwt.secret应校验失败")
+	}
+	cfg.JWT.Secret = strings.Repeat("x", 32)
+	if err := cfg.Validate(); err == nil {
+		t.Error("prod环境下没有配置live.callback_token应校验失败")
+	}
+	cfg.Live.CallbackToken = "token"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("prod环境下配置齐全时不应校验失败: %v", err)
+	}
+
+	cfg = Default()
+	cfg.JWT.Secret = "x"
+	cfg.RabbitMQ.URL = "http://localhost"
+	cfg.Log.Level = "loud"
+	if err := cfg.Validate(); err == nil {
+		t.Error("不合法的rabbitmq.url和log.level应校验失败")
+	}
+
+	cfg = Default()
+	cfg.JWT.Secret = "x"
+	cfg.Outbox.InboxRetention = cfg.Consumer.RetryWindow()
+	if err := cfg.Validate(); err == nil {
+		t.Error("outbox.inbox_retention不大于消息重试的时间时应校验失败")
+	}
+
+	cfg = Default()
+	cfg.JWT.Secret = "x"
+	cfg.Live.PresenceTTL = cfg.Live.HeartbeatInterval
+	if err := cfg.Validate(); err == nil {
+		t.Error("live.presence_ttl不大于heartbeat_interval时应校验失败")
+	}
+
+	cfg = Default()
+	cfg.JWT.Secret = "x"
+	cfg.Gift.Items = append(cfg.Gift.Items, cfg.Gift.Items[0])
+	if err := cfg.Validate(); err == nil {
+		t.Error("gift.items中重复的礼物ID应校验失败")
+	}
+}
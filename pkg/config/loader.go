@@ -0,0 +1,177 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Options 控制配置从哪里加载，零值表示使用默认位置
+type Options struct {
+	// 配置文件目录，默认读取环境变量ORION_CONFIG_DIR，再默认为 "configs"
+	ConfigDir string
+	// .env文件路径，默认为 ".env"，文件不存在时直接跳过
+	EnvFile string
+	// 环境变量查找函数，默认为os.LookupEnv，测试时可以替换
+	LookupEnv func(key string) (string, bool)
+}
+
+// Load 按默认位置加载配置
+func Load() (*Config, error) {
+	return LoadWithOptions(Options{})
+}
+
+// LoadWithOptions 加载配置，优先级从低到高：1、默认值 2、configs/config.yaml 3、configs/config.{env}.yaml 4、.env文件 5、环境变量
+// 其中env（profile）由APP_ENV决定，同样遵循“环境变量 > .env > 默认dev”的优先级
+func LoadWithOptions(opts Options) (*Config, error) {
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+	if opts.EnvFile == "" {
+		opts.EnvFile = ".env"
+	}
+	// 只读取.env，不写回进程环境变量，这样真正的环境变量永远优先
+	dotenv, err := godotenv.Read(opts.EnvFile)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("读取 %s 失败: %w", opts.EnvFile, err)
+		}
+		dotenv = map[string]string{}
+	}
+	lookup := func(key string) (string, bool) {
+		if v, ok := opts.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := dotenv[key]
+		return v, ok
+	}
+	if opts.ConfigDir == "" {
+		opts.ConfigDir = "configs"
+		if dir, ok := lookup("ORION_CONFIG_DIR"); ok && dir != "" {
+			opts.ConfigDir = dir
+		}
+	}
+
+	cfg := Default()
+	if env, ok := lookup("APP_ENV"); ok && env != "" {
+		cfg.App.Env = strings.ToLower(env)
+	}
+	// 先读公共配置，再用profile配置覆盖，yaml.Unmarshal只会覆盖文件里出现的字段
+	for _, name := range []string{"config.yaml", "config." + cfg.App.Env + ".yaml"} {
+		if err := mergeFile(cfg, filepath.Join(opts.ConfigDir, name)); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), lookup); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("配置校验失败: %w", err)
+	}
+	return cfg, nil
+}
+
+// 读取一个yaml配置文件并覆盖到cfg上，文件不存在不算错误
+func mergeFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("读取配置文件 %s 失败: %w", path, err)
+	}
+	if err := yaml.Unmarshal(content, cfg); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// 递归遍历结构体，凡是带env标签并且环境变量存在的字段，都用环境变量的值覆盖
+func applyEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(fv, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		raw, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := setField(fv, raw); err != nil {
+			return fmt.Errorf("环境变量 %s=%q 解析失败: %w", key, raw, err)
+		}
+	}
+	return nil
+}
+
+func setField(fv reflect.Value, raw string) error {
+	// time.Duration的Kind也是Int64，所以要先于整数判断
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("不支持的切片类型 %s", fv.Type())
+		}
+		// 逗号分隔的字符串列表
+		parts := strings.Split(raw, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		fv.Set(reflect.ValueOf(parts))
+	default:
+		return fmt.Errorf("不支持的字段类型 %s", fv.Type())
+	}
+	return nil
+}
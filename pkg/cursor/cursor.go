@@ -0,0 +1,66 @@
+package cursor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrInvalid 游标被篡改、属于别的列表，或者根本不是游标
+var ErrInvalid = errors.New("cursor: 游标无效")
+
+// Cursor keyset分页中上一页最后一条的位置，列表按(Time, ID)倒序，下一页从它之后开始
+type Cursor struct {
+	Time time.Time
+	ID   uint64
+}
+
+const (
+	bodySize = 16 // 时间（纳秒）8字节 + ID 8字节
+	// 签名只取前16字节，游标不是长期凭证，足够防篡改
+	macSize = 16
+)
+
+// Codec 把游标编码成不透明的字符串 base64url(时间 | ID | HMAC-SHA256(secret, scope + "\n" + 时间 | ID))
+// scope区分不同的列表（Feed、某个视频的评论），一个列表的游标不能拿到另一个列表上用
+type Codec struct {
+	secret []byte
+}
+
+func NewCodec(secret string) *Codec {
+	return &Codec{secret: []byte(secret)}
+}
+
+func (c *Codec) Encode(scope string, cur Cursor) string {
+	buf := make([]byte, bodySize, bodySize+macSize)
+	binary.BigEndian.PutUint64(buf[:8], uint64(cur.Time.UnixNano()))
+	binary.BigEndian.PutUint64(buf[8:], cur.ID)
+	buf = append(buf, c.mac(scope, buf)...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Decode 校验签名后取出位置，任何一位被改过都返回ErrInvalid
+func (c *Codec) Decode(scope, token string) (Cursor, error) {
+	// Strict：多余的填充位不为0也算篡改，一个游标只有一种写法
+	buf, err := base64.RawURLEncoding.Strict().DecodeString(token)
+	if err != nil || len(buf) != bodySize+macSize {
+		return Cursor{}, ErrInvalid
+	}
+	if !hmac.Equal(buf[bodySize:], c.mac(scope, buf[:bodySize])) {
+		return Cursor{}, ErrInvalid
+	}
+	return Cursor{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(buf[:8]))),
+		ID:   binary.BigEndian.Uint64(buf[8:]),
+	}, nil
+}
+
+func (c *Codec) mac(scope string, body []byte) []byte {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write([]byte(scope + "\n"))
+	h.Write(body)
+	return h.Sum(nil)[:macSize]
+}
@@ -0,0 +1,41 @@
+package cursor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCodec(t *testing.T) {
+	c := NewCodec("secret")
+	cur := Cursor{Time: time.Date(2025, 6, 1, 12, 0, 0, 123000000, time.UTC), ID: 42}
+	token := c.Encode("feed", cur)
+
+	got, err := c.Decode("feed", token)
+	if err != nil || !got.Time.Equal(cur.Time) || got.ID != cur.ID {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+
+	// 改任何一个字符都校验失败
+	for i := range token {
+		b := []byte(token)
+		if b[i] == 'A' {
+			b[i] = 'B'
+		} else {
+			b[i] = 'A'
+		}
+		if _, err := c.Decode("feed", string(b)); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("改了第%d个字符仍然通过校验", i)
+		}
+	}
+	for name, check := range map[string]func() error{
+		"别的列表": func() error { _, err := c.Decode("comments:1", token); return err },
+		"别的密钥": func() error { _, err := NewCodec("other").Decode("feed", token); return err },
+		"不是游标": func() error { _, err := c.Decode("feed", "page-2"); return err },
+		"空字符串": func() error { _, err := c.Decode("feed", ""); return err },
+	} {
+		if err := check(); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("%s: got %v", name, err)
+		}
+	}
+}
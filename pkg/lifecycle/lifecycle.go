@@ -0,0 +1,130 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Hook 是一个受管理的组件：OnStart按注册顺序执行，OnStop按注册的逆序执行
+// 所以先注册的（数据库）最后关闭，后注册的（HTTP服务、消费者）最先停止
+type Hook struct {
+	Name    string
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+// Manager 负责按顺序启动组件、监听SIGINT/SIGTERM，并在截止时间内按逆序优雅关闭
+type Manager struct {
+	log             *logrus.Logger
+	shutdownTimeout time.Duration
+
+	mu      sync.Mutex
+	hooks   []Hook
+	started int // 已经成功启动的hook数量，Stop只关闭这些
+
+	fatalOnce sync.Once
+	fatal     chan error
+}
+
+func New(log *logrus.Logger, shutdownTimeout time.Duration) *Manager {
+	return &Manager{
+		log:             log,
+		shutdownTimeout: shutdownTimeout,
+		fatal:           make(chan error, 1),
+	}
+}
+
+// Append 注册一个组件，OnStart和OnStop都可以为nil
+func (m *Manager) Append(h Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, h)
+}
+
+// Fail 由后台运行的组件调用（比如ListenAndServe意外返回），通知Run开始关闭流程
+func (m *Manager) Fail(err error) {
+	m.fatalOnce.Do(func() {
+		m.fatal <- err
+	})
+}
+
+// Start 按注册顺序启动所有组件，任何一个失败都会把已经启动的组件逆序关闭
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]Hook(nil), m.hooks...)
+	m.mu.Unlock()
+
+	for _, h := range hooks {
+		if h.OnStart != nil {
+			if err := h.OnStart(ctx); err != nil {
+				startErr := fmt.Errorf("组件 %s 启动失败: %w", h.Name, err)
+				stopCtx, cancel := context.WithTimeout(context.Background(), m.shutdownTimeout)
+				defer cancel()
+				return errors.Join(startErr, m.Stop(stopCtx))
+			}
+		}
+		m.log.WithField("component", h.Name).Info("组件已启动")
+		m.mu.Lock()
+		m.started++
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// Stop 逆序关闭已经启动的组件，某个组件关闭失败不影响其余组件，所有错误合并返回
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]Hook(nil), m.hooks[:m.started]...)
+	m.started = 0
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if h.OnStop == nil {
+			continue
+		}
+		logCtx := m.log.WithField("component", h.Name)
+		if err := h.OnStop(ctx); err != nil {
+			logCtx.WithError(err).Error("组件关闭失败")
+			errs = append(errs, fmt.Errorf("组件 %s 关闭失败: %w", h.Name, err))
+			continue
+		}
+		logCtx.Info("组件已关闭")
+	}
+	return errors.Join(errs...)
+}
+
+// Run 启动所有组件，阻塞直到收到SIGINT/SIGTERM或某个组件调用了Fail，然后在shutdownTimeout内优雅关闭
+func (m *Manager) Run() error {
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := m.Start(sigCtx); err != nil {
+		return err
+	}
+
+	var runErr error
+	select {
+	case <-sigCtx.Done():
+		m.log.Info("收到退出信号，开始优雅关闭")
+	case runErr = <-m.fatal:
+		m.log.WithError(runErr).Error("组件运行失败，开始关闭")
+	}
+	// 第二次Ctrl+C时恢复默认行为，直接退出进程
+	stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.shutdownTimeout)
+	defer cancel()
+	if err := m.Stop(ctx); err != nil {
+		return errors.Join(runErr, err)
+	}
+	return runErr
+}
@@ -0,0 +1,294 @@
+package consumer
+
+import (
+	"Orion_Live/pkg/backoff"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Handler 处理一条消息：返回nil则Ack；返回Permanent包装的错误则进入死信队列；其他错误按RetryPolicy延迟重试
+type Handler func(ctx context.Context, d amqp.Delivery) error
+
+// ChannelOpener 能打开AMQP channel的对象，*amqp.Connection就满足这个接口
+type ChannelOpener interface {
+	Channel() (*amqp.Channel, error)
+}
+
+// QueueOptions 一个队列的消费配置
+type QueueOptions struct {
+	Queue string
+	// ch.Qos的prefetch count，broker最多同时推送这么多条未确认的消息
+	Prefetch int
+	// 并发处理消息的goroutine数量
+	Workers int
+	Handler Handler
+	// 失败重试策略，零值表示不使用重试队列：永久性错误Nack丢弃（进入死信），其他错误Nack并立即重新入队
+	Retry RetryPolicy
+	// 消息最终进入死信时回调，用于把业务状态标记为失败、归还资源等；可以为nil
+	OnDeadLetter func(ctx context.Context, d amqp.Delivery, err error)
+}
+
+// permanentError 表示重试也不会成功的错误，比如消息格式错误
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent 把错误标记为永久性错误，消息不会再被重新投递
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent 判断错误链中是否有永久性错误
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// Supervisor 管理多个队列的消费者：每个队列一个监督goroutine，channel断开后按指数退避重建，直到Stop
+type Supervisor struct {
+	conn      ChannelOpener
+	publisher Publisher // 把失败的消息投递到重试队列和死信交换机
+	log       *logrus.Logger
+	backoff   backoff.Exponential
+	queues    []QueueOptions
+
+	// Stop时被取消，通知所有监督goroutine退出
+	stopCtx context.Context
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+	// 处理消息用的ctx，只有在Stop超时后才会被取消，保证在途消息能正常处理完
+	handlerCtx    context.Context
+	cancelHandler context.CancelFunc
+}
+
+func NewSupervisor(conn ChannelOpener, publisher Publisher, log *logrus.Logger, b backoff.Exponential) *Supervisor {
+	stopCtx, stop := context.WithCancel(context.Background())
+	handlerCtx, cancelHandler := context.WithCancel(context.Background())
+	return &Supervisor{
+		conn:          conn,
+		publisher:     publisher,
+		log:           log,
+		backoff:       b,
+		stopCtx:       stopCtx,
+		stop:          stop,
+		handlerCtx:    handlerCtx,
+		cancelHandler: cancelHandler,
+	}
+}
+
+// Register 注册一个队列，必须在Start之前调用
+func (s *Supervisor) Register(opts QueueOptions) {
+	if opts.Prefetch <= 0 {
+		opts.Prefetch = 1
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	s.queues = append(s.queues, opts)
+}
+
+// Start 为每个已注册的队列启动监督goroutine，立即返回
+func (s *Supervisor) Start(ctx context.Context) error {
+	if len(s.queues) == 0 {
+		return errors.New("没有注册任何队列")
+	}
+	for _, q := range s.queues {
+		s.wg.Add(1)
+		go func(q QueueOptions) {
+			defer s.wg.Done()
+			s.supervise(q)
+		}(q)
+	}
+	return nil
+}
+
+// Stop 通知所有队列取消订阅，等待在途消息处理完毕后关闭channel；ctx到期后强制取消处理中的消息
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.stop()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancelHandler()
+		return nil
+	case <-ctx.Done():
+		s.cancelHandler()
+		return ctx.Err()
+	}
+}
+
+// 监督循环：1、打开channel并设置Qos 2、开始消费并启动Workers个goroutine 3、等待停止信号或channel断开 4、断开则退避后重建
+func (s *Supervisor) supervise(q QueueOptions) {
+	logCtx := s.log.WithField("queue", q.Queue)
+	attempt := 0
+	for {
+		restart, err := s.consumeOnce(q, &attempt)
+		if !restart {
+			return
+		}
+		wait := s.backoff.Duration(attempt)
+		attempt++
+		logCtx.WithError(err).WithField("retry_in", wait.String()).Warn("消费者channel已断开，稍后重建")
+		if !backoff.Sleep(s.stopCtx, wait) {
+			return
+		}
+	}
+}
+
+// consumeOnce 跑一轮消费，返回是否需要重建以及断开的原因
+func (s *Supervisor) consumeOnce(q QueueOptions, attempt *int) (bool, error) {
+	ch, err := s.conn.Channel()
+	if err != nil {
+		return true, fmt.Errorf("打开channel失败: %w", err)
+	}
+	if err := ch.Qos(q.Prefetch, 0, false); err != nil {
+		ch.Close()
+		return true, fmt.Errorf("设置Qos失败: %w", err)
+	}
+	tag := fmt.Sprintf("orion.%s", q.Queue)
+	msgs, err := ch.Consume(q.Queue, tag, false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return true, fmt.Errorf("注册消费者失败: %w", err)
+	}
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	// 订阅成功，重置退避次数
+	*attempt = 0
+	s.log.WithField("queue", q.Queue).WithField("workers", q.Workers).WithField("prefetch", q.Prefetch).Info("开始消费队列")
+
+	var workers sync.WaitGroup
+	for i := 0; i < q.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			// msgs在Cancel或channel关闭后会被关闭，循环随之结束
+			for d := range msgs {
+				s.handle(q, d)
+			}
+		}()
+	}
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-s.stopCtx.Done():
+		// 先取消订阅，broker不再推送；等手上的消息处理完再关闭channel，未Ack的预取消息会重新入队
+		_ = ch.Cancel(tag, false)
+		<-workersDone
+		_ = ch.Close()
+		return false, nil
+	case amqpErr := <-closed:
+		<-workersDone
+		if amqpErr == nil {
+			return true, errors.New("channel已关闭")
+		}
+		return true, amqpErr
+	case <-workersDone:
+		// 没有关闭channel，但msgs被关闭了，通常是队列被删除等原因导致broker取消了订阅
+		_ = ch.Close()
+		return true, errors.New("订阅被broker取消")
+	}
+}
+
+// 处理单条消息并根据结果确认，handler中的panic被当作永久性错误，避免坏消息反复投递打挂进程
+func (s *Supervisor) handle(q QueueOptions, d amqp.Delivery) {
+	logCtx := s.log.WithField("queue", q.Queue).WithField("redelivered", d.Redelivered)
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = Permanent(fmt.Errorf("处理消息时发生panic: %v", r))
+			}
+		}()
+		return q.Handler(s.handlerCtx, d)
+	}()
+
+	switch {
+	case err == nil:
+		if ackErr := d.Ack(false); ackErr != nil {
+			logCtx.WithError(ackErr).Error("消息Ack失败")
+		}
+	case !q.Retry.Enabled():
+		if IsPermanent(err) {
+			logCtx.WithError(err).WithField("body", string(d.Body)).Error("消息处理永久失败，丢弃")
+			s.deadLettered(q, d, err)
+			_ = d.Nack(false, false)
+			return
+		}
+		logCtx.WithError(err).Error("处理消息失败，将进行重试")
+		_ = d.Nack(false, true)
+	default:
+		s.retryOrDeadLetter(q, d, err)
+	}
+}
+
+// retryOrDeadLetter 1、失败次数+1 2、可重试且没超过次数，投递到对应延迟的重试队列 3、否则投递到死信交换机
+// 投递成功后才Ack原消息；投递失败时，重试消息Nack回原队列，死信消息Nack不重新入队，由broker按队列的死信参数转发
+func (s *Supervisor) retryOrDeadLetter(q QueueOptions, d amqp.Delivery, err error) {
+	attempts := Attempts(d) + 1
+	logCtx := s.log.WithError(err).
+		WithField("queue", q.Queue).
+		WithField("message_id", d.MessageId).
+		WithField("attempts", attempts)
+
+	if !IsPermanent(err) && attempts < q.Retry.MaxAttempts {
+		retryQueue := RetryQueueName(q.Queue, q.Retry.Delay(attempts))
+		msg := Republishing(d, amqp.Table{
+			HeaderAttempts:  int32(attempts),
+			HeaderLastError: errorHeader(err),
+		})
+		if pubErr := s.publisher.Publish(s.handlerCtx, "", retryQueue, msg); pubErr != nil {
+			logCtx.WithField("publish_error", pubErr.Error()).Error("投递到重试队列失败，消息重新入队")
+			_ = d.Nack(false, true)
+			return
+		}
+		logCtx.WithField("retry_queue", retryQueue).Warn("处理消息失败，已投递到重试队列")
+		_ = d.Ack(false)
+		return
+	}
+
+	msg := Republishing(d, amqp.Table{
+		HeaderAttempts:      int32(attempts),
+		HeaderLastError:     errorHeader(err),
+		HeaderOriginalQueue: q.Queue,
+		HeaderDeadAt:        time.Now(),
+	})
+	s.deadLettered(q, d, err)
+	if pubErr := s.publisher.Publish(s.handlerCtx, DeadLetterExchange, q.Queue, msg); pubErr != nil {
+		logCtx.WithField("publish_error", pubErr.Error()).Error("投递到死信队列失败，由broker转发死信")
+		_ = d.Nack(false, false)
+		return
+	}
+	logCtx.WithField("body", string(d.Body)).WithField("dlq", DeadLetterQueueName(q.Queue)).Error("消息处理失败，已进入死信队列")
+	_ = d.Ack(false)
+}
+
+// deadLettered 调用业务的死信回调，回调中的panic不能影响消息的确认
+func (s *Supervisor) deadLettered(q QueueOptions, d amqp.Delivery, err error) {
+	if q.OnDeadLetter == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s.log.WithField("queue", q.Queue).WithField("panic", r).Error("死信回调发生panic")
+		}
+	}()
+	q.OnDeadLetter(s.handlerCtx, d, err)
+}
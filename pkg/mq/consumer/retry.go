@@ -0,0 +1,151 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// 死信交换机，所有业务队列的死信都发到这里，再按原队列名路由到各自的dlq
+const DeadLetterExchange = "orion.dlx"
+
+// 失败重试时写入消息的headers
+const (
+	HeaderAttempts      = "x-orion-attempts"       // 已经处理失败的次数
+	HeaderLastError     = "x-orion-last-error"     // 最近一次失败的原因
+	HeaderOriginalQueue = "x-orion-original-queue" // 进入dlq前所在的业务队列
+	HeaderDeadAt        = "x-orion-dead-at"        // 进入dlq的时间
+)
+
+// 错误信息可能很长（比如SQL），写进headers前截断
+const maxErrorHeaderLen = 1024
+
+// Publisher 重新投递消息用的发布者，rabbitmq.Publisher满足这个接口
+type Publisher interface {
+	Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error
+}
+
+// RetryPolicy 一个队列的失败重试策略
+// 第n次失败后进入Delays[n-1]对应的重试队列，TTL到期后回到原队列；失败MaxAttempts次或永久性错误则进入dlq
+type RetryPolicy struct {
+	Delays      []time.Duration
+	MaxAttempts int
+}
+
+// Enabled MaxAttempts为0时不启用重试队列，失败消息直接Nack并重新入队
+func (p RetryPolicy) Enabled() bool {
+	return p.MaxAttempts > 0
+}
+
+// Delay 返回第attempts次失败后的等待时间，超过Delays长度后一直使用最后一个
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	if len(p.Delays) == 0 {
+		return 0
+	}
+	i := attempts - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(p.Delays) {
+		i = len(p.Delays) - 1
+	}
+	return p.Delays[i]
+}
+
+// 队列名遵循“项目名.业务领域.queue”，去掉.queue后缀作为重试队列和死信队列的前缀
+func queueBase(queue string) string {
+	return strings.TrimSuffix(queue, ".queue")
+}
+
+// RetryQueueName 比如 orion.like.queue 延迟5s的重试队列为 orion.like.retry.5s
+func RetryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queueBase(queue), delay)
+}
+
+// DeadLetterQueueName 比如 orion.like.queue 的死信队列为 orion.like.dlq
+func DeadLetterQueueName(queue string) string {
+	return queueBase(queue) + ".dlq"
+}
+
+// DeclareQueue 声明业务队列以及它的重试队列、死信队列：
+// 1、业务队列的死信发往DeadLetterExchange，routing key仍是业务队列名 2、dlq以业务队列名绑定到DeadLetterExchange
+// 3、每个延迟一个重试队列，消息TTL到期后通过默认交换机回到业务队列
+// 注意：已经存在的队列改变参数会导致PRECONDITION_FAILED，老环境升级时需要先删除没有死信参数的业务队列
+func DeclareQueue(ch *amqp.Channel, queue string, policy RetryPolicy) error {
+	if err := ch.ExchangeDeclare(DeadLetterExchange, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return err
+	}
+	dlq := DeadLetterQueueName(queue)
+	if _, err := ch.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(dlq, queue, DeadLetterExchange, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange": DeadLetterExchange,
+	}); err != nil {
+		return err
+	}
+	for _, delay := range policy.Delays {
+		_, err := ch.QueueDeclare(RetryQueueName(queue, delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Attempts 读取消息已经失败的次数，没有这个header说明是第一次投递
+func Attempts(d amqp.Delivery) int {
+	// 写入时是int32，但headers经过其他客户端转发后整数类型可能变化
+	switch v := d.Headers[HeaderAttempts].(type) {
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint8:
+		return int(v)
+	}
+	return 0
+}
+
+// Republishing 基于收到的消息构造一条新消息，保留消息体和属性，headers按传入的值覆盖
+func Republishing(d amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	h := amqp.Table{}
+	for k, v := range d.Headers {
+		h[k] = v
+	}
+	for k, v := range headers {
+		h[k] = v
+	}
+	return amqp.Publishing{
+		Headers:         h,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+func errorHeader(err error) string {
+	msg := err.Error()
+	if len(msg) > maxErrorHeaderLen {
+		msg = strings.ToValidUTF8(msg[:maxErrorHeaderLen], "")
+	}
+	return msg
+}
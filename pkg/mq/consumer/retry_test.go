@@ -0,0 +1,42 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Delays: []time.Duration{5 * time.Second, time.Minute}, MaxAttempts: 5}
+	cases := map[int]time.Duration{0: 5 * time.Second, 1: 5 * time.Second, 2: time.Minute, 4: time.Minute}
+	for attempts, want := range cases {
+		if got := p.Delay(attempts); got != want {
+			t.Errorf("Delay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+	if (RetryPolicy{}).Enabled() {
+		t.Error("零值RetryPolicy不应启用重试")
+	}
+}
+
+func TestQueueNames(t *testing.T) {
+	if got := RetryQueueName("orion.like.queue", 30*time.Second); got != "orion.like.retry.30s" {
+		t.Errorf("RetryQueueName = %q", got)
+	}
+	if got := DeadLetterQueueName("orion.golden_comment.queue"); got != "orion.golden_comment.dlq" {
+		t.Errorf("DeadLetterQueueName = %q", got)
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	if got := Attempts(amqp.Delivery{}); got != 0 {
+		t.Errorf("没有header时Attempts = %d, want 0", got)
+	}
+	for _, v := range []interface{}{int32(3), int64(3), int16(3)} {
+		d := amqp.Delivery{Headers: amqp.Table{HeaderAttempts: v}}
+		if got := Attempts(d); got != 3 {
+			t.Errorf("Attempts(%T) = %d, want 3", v, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package dlq
+
+import (
+	"Orion_Live/pkg/mq/consumer"
+	"context"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// Stat 一个业务队列对应的死信队列的积压情况
+type Stat struct {
+	Queue     string // 业务队列
+	DLQ       string
+	Messages  int
+	Consumers int
+}
+
+// Stats 查看每个业务队列的死信数量，QueueInspect在队列不存在时会关闭channel，所以每个队列单独开一个channel
+func Stats(conn consumer.ChannelOpener, queues []string) ([]Stat, error) {
+	stats := make([]Stat, 0, len(queues))
+	for _, queue := range queues {
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+		dlq := consumer.DeadLetterQueueName(queue)
+		q, err := ch.QueueInspect(dlq)
+		ch.Close()
+		if err != nil {
+			return nil, fmt.Errorf("查看%s失败: %w", dlq, err)
+		}
+		stats = append(stats, Stat{Queue: queue, DLQ: dlq, Messages: q.Messages, Consumers: q.Consumers})
+	}
+	return stats, nil
+}
+
+// Peek 查看死信队列头部最多limit条消息，不会把消息移出队列：
+// basic.get取出后不Ack，关闭channel时broker会把它们按原来的位置放回队列
+func Peek(conn consumer.ChannelOpener, queue string, limit int) ([]amqp.Delivery, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+	var msgs []amqp.Delivery
+	for len(msgs) < limit {
+		d, ok, err := ch.Get(consumer.DeadLetterQueueName(queue), false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		msgs = append(msgs, d)
+	}
+	return msgs, nil
+}
+
+// Replay 把死信重新投递回业务队列，失败次数清零：1、逐条basic.get 2、messageID不为空时只重放这一条，其余的不Ack，结束后放回 3、确认投递后Ack死信
+// 最多扫描调用时队列里已有的消息数，避免重放后又失败进入dlq的消息被反复扫到
+func Replay(ctx context.Context, conn consumer.ChannelOpener, publisher consumer.Publisher, queue string, limit int, messageID string) (int, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+	dlq := consumer.DeadLetterQueueName(queue)
+	q, err := ch.QueueInspect(dlq)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for scanned := 0; scanned < q.Messages && replayed < limit; scanned++ {
+		d, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+		if messageID != "" && d.MessageId != messageID {
+			continue
+		}
+		msg := consumer.Republishing(d, nil)
+		for _, h := range []string{consumer.HeaderAttempts, consumer.HeaderOriginalQueue, consumer.HeaderDeadAt, "x-death"} {
+			delete(msg.Headers, h)
+		}
+		if err := publisher.Publish(ctx, "", queue, msg); err != nil {
+			return replayed, fmt.Errorf("重放消息%s失败: %w", d.MessageId, err)
+		}
+		if err := d.Ack(false); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// Purge 清空死信队列，返回删除的消息数
+func Purge(conn consumer.ChannelOpener, queue string) (int, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+	return ch.QueuePurge(consumer.DeadLetterQueueName(queue), false)
+}
@@ -0,0 +1,26 @@
+package mysql
+
+import (
+	"Orion_Live/pkg/config"
+
+	gorm_mysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// InitMySQL 根据配置打开gorm连接，并设置底层连接池参数
+func InitMySQL(cfg config.MySQLConfig) (*gorm.DB, error) {
+	// 这个mysql包是gorm的第三方承包商，mysql.Open()后还是只能执行原始SQL语句，gorm.Open()后可以执行gorm的简化语句，但要注意性能
+	db, err := gorm.Open(gorm_mysql.Open(cfg.DSN()), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	// gorm底层还是database/sql的连接池
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	return db, nil
+}
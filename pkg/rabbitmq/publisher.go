@@ -0,0 +1,209 @@
+package rabbitmq
+
+import (
+	"Orion_Live/pkg/backoff"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrNacked broker明确拒绝了这条消息（basic.nack），消息一定没有入队
+	ErrNacked = errors.New("消息被broker拒绝(nack)")
+	// ErrReturned 消息以mandatory发布但没有匹配的队列，被broker退回，消息一定没有入队
+	ErrReturned = errors.New("消息无法路由，被broker退回")
+	// ErrUnconfirmed 在截止时间内没有拿到broker的确认，消息可能已入队也可能没有
+	ErrUnconfirmed = errors.New("消息发布结果未知，未收到broker确认")
+)
+
+// IsRejected 判断是否是“确定失败”：只有这种情况下调用方才应该做补偿
+func IsRejected(err error) bool {
+	return errors.Is(err, ErrNacked) || errors.Is(err, ErrReturned)
+}
+
+// Publisher 是业务层发布消息的唯一入口
+type Publisher interface {
+	// Publish 以mandatory方式发布一条消息，并等待broker的publisher confirm
+	// 返回nil表示broker已确认入队；返回IsRejected为true的错误表示确定失败；
+	// 连接断开等不确定的情况会等待重连并重试，直到ctx结束后返回ErrUnconfirmed（ctx没有截止时间时使用配置的publish_timeout）
+	Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error
+}
+
+// PublishJSON 把v序列化成JSON后以持久化消息发布
+func PublishJSON(ctx context.Context, p Publisher, exchange, routingKey string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, exchange, routingKey, amqp.Publishing{
+		ContentType:  "application/json",
+		Body:         body,
+		DeliveryMode: amqp.Persistent, // 确保消息持久化，broker重启也不会丢
+	})
+}
+
+// 池中的channel，都处于confirm模式；closed在channel被broker或连接关闭时收到通知
+// 一个channel同一时间只被一个发布者借用，所以收到的确认一定属于刚刚发布的那条消息
+type pooledChannel struct {
+	ch       *amqp.Channel
+	closed   chan *amqp.Error
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+func (pc *pooledChannel) alive() bool {
+	select {
+	case <-pc.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// channelPool 复用channel的发布者：sem限制同时打开的channel总数，idle存放空闲可复用的channel
+// 每条消息都同步等待确认，并发度由池大小决定
+type channelPool struct {
+	conn    *Connection
+	timeout time.Duration
+	sem     chan struct{}
+	idle    chan *pooledChannel
+}
+
+// NewPublisher 创建一个基于channel池的发布者，size为池中最多的channel数量
+func NewPublisher(conn *Connection, size int, timeout time.Duration) Publisher {
+	p := &channelPool{
+		conn:    conn,
+		timeout: timeout,
+		sem:     make(chan struct{}, size),
+		idle:    make(chan *pooledChannel, size),
+	}
+	// 重连后旧连接上的channel全部失效，直接清空
+	conn.OnReconnect(p.drain)
+	return p
+}
+
+func (p *channelPool) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		err := p.publishOnce(ctx, exchange, routingKey, msg)
+		if err == nil || IsRejected(err) {
+			// 确认成功，或者broker明确拒绝/退回，重试也没有意义
+			return err
+		}
+		lastErr = err
+		// 连接断开时等待重连；channel级别的错误（比如交换机不存在）稍等后换一个channel重试
+		// 重试可能导致重复投递，消费者需要保证幂等
+		if err := p.conn.WaitReady(ctx); err != nil {
+			return fmt.Errorf("%w: %w", ErrUnconfirmed, errors.Join(lastErr, err))
+		}
+		if !backoff.Sleep(ctx, time.Duration(attempt+1)*50*time.Millisecond) {
+			return fmt.Errorf("%w: %w", ErrUnconfirmed, errors.Join(lastErr, ctx.Err()))
+		}
+	}
+}
+
+// publishOnce 借一个channel发布并等待确认：1、mandatory发布 2、等待ack/nack 3、收到ack后检查是否有退回
+func (p *channelPool) publishOnce(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	pc, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := pc.ch.Publish(exchange, routingKey, true, false, msg); err != nil {
+		p.put(pc, false)
+		return err
+	}
+	select {
+	case confirm, ok := <-pc.confirms:
+		if !ok {
+			p.put(pc, false)
+			return errors.New("等待确认时channel已关闭")
+		}
+		// broker对不可路由的mandatory消息会先发basic.return再发basic.ack，客户端按顺序分发，所以此时退回一定已经到达
+		select {
+		case ret := <-pc.returns:
+			p.put(pc, true)
+			return fmt.Errorf("%w: %d %s", ErrReturned, ret.ReplyCode, ret.ReplyText)
+		default:
+		}
+		p.put(pc, true)
+		if !confirm.Ack {
+			return ErrNacked
+		}
+		return nil
+	case <-pc.closed:
+		p.put(pc, false)
+		return errors.New("等待确认时channel已关闭")
+	case <-ctx.Done():
+		// 这个channel上还有一个没到的确认，不能再复用，否则会错配到下一条消息上
+		p.put(pc, false)
+		return ctx.Err()
+	}
+}
+
+// get 先占用一个名额，再优先复用空闲channel，没有空闲的就新开一个
+func (p *channelPool) get(ctx context.Context) (*pooledChannel, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	for {
+		select {
+		case pc := <-p.idle:
+			if pc.alive() {
+				return pc, nil
+			}
+			continue
+		default:
+		}
+		ch, err := p.conn.Channel()
+		if err != nil {
+			<-p.sem
+			return nil, err
+		}
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			<-p.sem
+			return nil, err
+		}
+		return &pooledChannel{
+			ch:       ch,
+			closed:   ch.NotifyClose(make(chan *amqp.Error, 1)),
+			confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+			returns:  ch.NotifyReturn(make(chan amqp.Return, 1)),
+		}, nil
+	}
+}
+
+// put 归还channel，出错或已关闭的channel直接丢弃
+func (p *channelPool) put(pc *pooledChannel, healthy bool) {
+	defer func() { <-p.sem }()
+	if healthy && pc.alive() {
+		select {
+		case p.idle <- pc:
+			return
+		default:
+		}
+	}
+	_ = pc.ch.Close()
+}
+
+func (p *channelPool) drain() {
+	for {
+		select {
+		case pc := <-p.idle:
+			_ = pc.ch.Close()
+		default:
+			return
+		}
+	}
+}
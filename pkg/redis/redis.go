@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"Orion_Live/pkg/config"
 	"context"
 	"time"
 
@@ -10,11 +11,12 @@ import (
 var Ctx = context.Background()
 
 // InitRedis 初始化Redis客户端
-func InitRedis() (*redis.Client, error) {
+func InitRedis(cfg config.RedisConfig) (*redis.Client, error) {
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379", //redis运行地址
-		Password: "",
-		DB:       0, // 使用默认编号为0的数据库
+		Addr:     cfg.Addr, //redis运行地址
+		Password: cfg.Password,
+		DB:       cfg.DB,
+		PoolSize: cfg.PoolSize,
 	})
 
 	// 测试连接
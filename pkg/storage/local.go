@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localBackend 存在本机目录下，先写临时文件再rename，读到一半的请求不会看到写了一半的文件
+// 内容类型不单独保存，按扩展名推断，所以key要带上扩展名
+type localBackend struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocal(dir, baseURL string) (Backend, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("storage: 创建目录 %s 失败: %w", abs, err)
+	}
+	return &localBackend{dir: abs, baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+func (b *localBackend) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(b.dir, filepath.FromSlash(key)), nil
+}
+
+func (b *localBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("storage: %s 应写入%d字节，实际%d字节", key, size, n)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (b *localBackend) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (b *localBackend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: contentTypeByExt(filepath.Ext(path)),
+		// 和nginx一样用修改时间和大小生成ETag，文件是先写临时文件再rename的，内容变了修改时间一定会变
+		ETag:    fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		ModTime: fi.ModTime(),
+	}, nil
+}
+
+// 系统的mime表里没有HLS的类型，.ts甚至可能被当成TypeScript
+var localContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+}
+
+func contentTypeByExt(ext string) string {
+	if t, ok := localContentTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+func (b *localBackend) Delete(ctx context.Context, key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *localBackend) URL(key string) string {
+	u, err := url.JoinPath(b.baseURL, strings.Split(key, "/")...)
+	if err != nil {
+		return b.baseURL + "/" + key
+	}
+	return u
+}
+
+func (b *localBackend) KeyFromURL(rawURL string) (string, bool) {
+	return keyFromURL(b.baseURL, rawURL)
+}
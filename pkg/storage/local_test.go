@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalBackend(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewLocal(t.TempDir(), "http://127.0.0.1:8080/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "videos/ab/ab12.mp4"
+	if err := b.Put(ctx, key, strings.NewReader("hello"), 5, "video/mp4"); err != nil {
+		t.Fatalf("Put失败: %v", err)
+	}
+	info, err := b.Stat(ctx, key)
+	if err != nil || info.Size != 5 || info.ContentType != "video/mp4" || info.ETag == "" || info.ModTime.IsZero() {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+	rc, err := b.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" {
+		t.Errorf("读到 %q", data)
+	}
+	if got := b.URL(key); got != "http://127.0.0.1:8080/media/videos/ab/ab12.mp4" {
+		t.Errorf("URL = %q", got)
+	}
+
+	// 大小不一致时不留下对象，原来的内容不变
+	if err := b.Put(ctx, key, strings.NewReader("hi"), 5, "video/mp4"); err == nil {
+		t.Error("大小不一致应该返回错误")
+	}
+	if info, _ := b.Stat(ctx, key); info.Size != 5 {
+		t.Errorf("写入失败不应覆盖原来的对象, size = %d", info.Size)
+	}
+
+	if err := b.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("删除后Stat应返回ErrNotFound, got %v", err)
+	}
+	if err := b.Delete(ctx, key); err != nil {
+		t.Errorf("删除不存在的对象应返回nil, got %v", err)
+	}
+
+	for _, bad := range []string{"", "/etc/passwd", "../x", "a/../../x", "a//b", `a\b`} {
+		if err := b.Put(ctx, bad, strings.NewReader("x"), 1, ""); err == nil {
+			t.Errorf("key %q 应该被拒绝", bad)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"Orion_Live/pkg/config"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Backend S3兼容的对象存储（AWS S3、MinIO等），桶不存在时在启动时创建
+type s3Backend struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+func NewS3(cfg config.S3StorageConfig) (Backend, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("storage: 检查桶 %s 失败: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("storage: 创建桶 %s 失败: %w", cfg.Bucket, err)
+		}
+	}
+	publicURL := cfg.PublicURL
+	if publicURL == "" {
+		scheme := "http"
+		if cfg.UseSSL {
+			scheme = "https"
+		}
+		publicURL = fmt.Sprintf("%s://%s/%s", scheme, cfg.Endpoint, cfg.Bucket)
+	}
+	return &s3Backend{client: client, bucket: cfg.Bucket, publicURL: strings.TrimRight(publicURL, "/")}, nil
+}
+
+func (b *s3Backend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	// size确定时minio按size校验，读到的字节数不一致时上传失败，不会留下对象
+	_, err := b.client.PutObject(ctx, b.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (b *s3Backend) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	// GetObject是懒加载的，先Stat一次把不存在的错误提前暴露出来
+	if _, err := b.Stat(ctx, key); err != nil {
+		return nil, err
+	}
+	return b.client.GetObject(ctx, b.bucket, key, minio.GetObjectOptions{})
+}
+
+func (b *s3Backend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size, ContentType: info.ContentType, ETag: info.ETag, ModTime: info.LastModified}, nil
+}
+
+func (b *s3Backend) Delete(ctx context.Context, key string) error {
+	return b.client.RemoveObject(ctx, b.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (b *s3Backend) URL(key string) string {
+	u, err := url.JoinPath(b.publicURL, strings.Split(key, "/")...)
+	if err != nil {
+		return b.publicURL + "/" + key
+	}
+	return u
+}
+
+func (b *s3Backend) KeyFromURL(rawURL string) (string, bool) {
+	return keyFromURL(b.publicURL, rawURL)
+}
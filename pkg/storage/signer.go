@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSignatureInvalid = errors.New("storage: 签名不正确")
+	ErrSignatureExpired = errors.New("storage: 签名地址已过期")
+)
+
+// 签名地址的路径前缀，s/{过期时间}/{签名}/{key}
+const signedPrefix = "s/"
+
+// Signer 生成和校验带过期时间的播放地址 {baseURL}/s/{expires}/{sig}/{key}，sig = HMAC-SHA256(secret, scope + "\n" + expires)
+// scope一般就是key本身；HLS的播放列表用相对路径引用各档清晰度和切片，所以m3u8的scope是它所在的目录（以/结尾），
+// 同一个签名可以访问这个目录下的所有文件，浏览器按相对路径解析出来的地址天然带着签名
+type Signer struct {
+	store   Backend
+	secret  []byte
+	ttl     time.Duration
+	baseURL string
+}
+
+// NewSigner baseURL是server的/media接口对外的地址
+func NewSigner(store Backend, secret string, ttl time.Duration, baseURL string) *Signer {
+	return &Signer{store: store, secret: []byte(secret), ttl: ttl, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// SignURL 把存储中的地址换成签名地址；不是这个存储的地址（比如直播录像）和空地址原样返回
+func (s *Signer) SignURL(rawURL string) string {
+	key, ok := s.store.KeyFromURL(rawURL)
+	if !ok {
+		return rawURL
+	}
+	return s.Sign(key, time.Now())
+}
+
+// Sign key的签名地址，now+ttl之后失效
+func (s *Signer) Sign(key string, now time.Time) string {
+	expires := now.Add(s.ttl).Unix()
+	parts := append([]string{"s", strconv.FormatInt(expires, 10), s.mac(signScope(key), expires)}, strings.Split(key, "/")...)
+	u, err := url.JoinPath(s.baseURL, parts...)
+	if err != nil {
+		return s.baseURL + "/" + strings.Join(parts, "/")
+	}
+	return u
+}
+
+// IsSigned p（/media之后的部分）是不是签名地址
+func IsSigned(p string) bool {
+	return strings.HasPrefix(p, signedPrefix)
+}
+
+// Verify 校验签名地址，返回其中的key；key本身和它所在的每一级目录都可能是签名的scope
+func (s *Signer) Verify(p string, now time.Time) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(p, signedPrefix), "/", 3)
+	if !IsSigned(p) || len(parts) != 3 {
+		return "", ErrSignatureInvalid
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", ErrSignatureInvalid
+	}
+	key := parts[2]
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	valid := false
+	for scope := key; ; {
+		if hmac.Equal([]byte(parts[1]), []byte(s.mac(scope, expires))) {
+			valid = true
+			break
+		}
+		dir := path.Dir(strings.TrimSuffix(scope, "/"))
+		if dir == "." {
+			break
+		}
+		scope = dir + "/"
+	}
+	if !valid {
+		return "", ErrSignatureInvalid
+	}
+	// 先校验签名再看过期，伪造的地址不会得到“已过期”的提示
+	if now.Unix() > expires {
+		return "", ErrSignatureExpired
+	}
+	return key, nil
+}
+
+func (s *Signer) mac(scope string, expires int64) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(scope + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// signScope m3u8签整个目录，其他文件只签自己
+func signScope(key string) string {
+	if path.Ext(key) == ".m3u8" {
+		if dir := path.Dir(key); dir != "." {
+			return dir + "/"
+		}
+	}
+	return key
+}
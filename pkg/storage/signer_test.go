@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	store, err := NewLocal(t.TempDir(), "http://127.0.0.1:8080/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewSigner(store, "secret", time.Hour, "http://127.0.0.1:8080/media/")
+	now := time.Unix(1700000000, 0)
+	const base = "http://127.0.0.1:8080/media/"
+
+	signed := signer.Sign("videos/ab/ab12.mp4", now)
+	path, ok := strings.CutPrefix(signed, base)
+	if !ok || !IsSigned(path) || !strings.HasSuffix(path, "/videos/ab/ab12.mp4") {
+		t.Fatalf("签名地址 = %q", signed)
+	}
+	if key, err := signer.Verify(path, now.Add(59*time.Minute)); err != nil || key != "videos/ab/ab12.mp4" {
+		t.Fatalf("Verify = %q, %v", key, err)
+	}
+	if _, err := signer.Verify(path, now.Add(61*time.Minute)); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("过期后应返回ErrSignatureExpired, got %v", err)
+	}
+	// 换一个文件、改过期时间、换密钥都不行
+	for _, bad := range []string{
+		strings.Replace(path, "ab12.mp4", "cd34.mp4", 1),
+		strings.Replace(path, "/1700003600/", "/1800003600/", 1),
+		"s/1700003600/xxx/videos/ab/ab12.mp4",
+		"s/abc",
+	} {
+		if _, err := signer.Verify(bad, now); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%q 应该返回ErrSignatureInvalid, got %v", bad, err)
+		}
+	}
+	other := NewSigner(store, "other", time.Hour, base)
+	if _, err := other.Verify(path, now); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("换了密钥应该校验失败, got %v", err)
+	}
+
+	// m3u8的签名覆盖整个目录，按相对路径访问各档清晰度和切片
+	master, _ := strings.CutPrefix(signer.Sign("hls/7/master.m3u8", now), base)
+	dir := strings.TrimSuffix(master, "master.m3u8")
+	for _, rel := range []string{"master.m3u8", "720p/index.m3u8", "720p/seg_0000.ts"} {
+		if key, err := signer.Verify(dir+rel, now); err != nil || key != "hls/7/"+rel {
+			t.Errorf("%s: %q, %v", rel, key, err)
+		}
+	}
+	if _, err := signer.Verify(strings.Replace(dir, "/hls/7/", "/hls/8/", 1)+"master.m3u8", now); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("不能访问别的视频的HLS, got %v", err)
+	}
+	if _, err := signer.Verify(strings.Replace(dir, "/hls/7/", "/", 1)+"videos/ab/ab12.mp4", now); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("目录签名不能访问目录外的文件, got %v", err)
+	}
+
+	// 存储中的地址换成签名地址，其他地址原样返回
+	if got := signer.SignURL(store.URL("videos/ab/ab12.mp4")); !strings.HasPrefix(got, base+"s/") || !strings.HasSuffix(got, "/videos/ab/ab12.mp4") {
+		t.Errorf("SignURL = %q", got)
+	}
+	for _, raw := range []string{"", "http://127.0.0.1:8088/live/1.flv", "http://127.0.0.1:8080/media/../x"} {
+		if got := signer.SignURL(raw); got != raw {
+			t.Errorf("SignURL(%q) = %q", raw, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"Orion_Live/pkg/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound 对象不存在
+	ErrNotFound = errors.New("storage: 对象不存在")
+	// ErrInvalidKey key是绝对路径、带有..等，不会对应任何对象
+	ErrInvalidKey = errors.New("storage: 非法的key")
+)
+
+// Backend 存放上传文件的地方，key是以/分隔的相对路径，比如 videos/ab/ab12...ef.mp4
+type Backend interface {
+	// Put 写入一个对象，size是r的字节数，读到的字节数不一致时返回错误且不留下对象；已经存在时覆盖
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open 读取对象，不存在时返回ErrNotFound；可以Seek，HTTP Range请求只读需要的部分
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Stat 对象的大小、类型和版本，不存在时返回ErrNotFound
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete 删除对象，不存在时也返回nil
+	Delete(ctx context.Context, key string) error
+	// URL 对象对外的访问地址
+	URL(key string) string
+	// KeyFromURL URL的逆操作，不是这个存储的地址时返回false
+	KeyFromURL(rawURL string) (string, bool)
+}
+
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	// ETag 内容变化时跟着变，不带引号
+	ETag    string
+	ModTime time.Time
+}
+
+// New 按配置创建存储后端
+func New(cfg config.StorageConfig) (Backend, error) {
+	switch cfg.Backend {
+	case config.StorageLocal:
+		return NewLocal(cfg.Local.Dir, cfg.Local.BaseURL)
+	case config.StorageS3:
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("storage: 不支持的存储后端 %q", cfg.Backend)
+	}
+}
+
+// validKey key不能是绝对路径，也不能有空的、.或..的路径段，避免本地存储写到目录外面
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("%w %q", ErrInvalidKey, key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("%w %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+// keyFromURL baseURL下的地址去掉前缀就是key，URL按路径段转义过，这里反转义回来
+func keyFromURL(baseURL, rawURL string) (string, bool) {
+	rest, ok := strings.CutPrefix(rawURL, baseURL+"/")
+	if !ok {
+		return "", false
+	}
+	key, err := url.PathUnescape(rest)
+	if err != nil || validKey(key) != nil {
+		return "", false
+	}
+	return key, true
+}